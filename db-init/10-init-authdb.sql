@@ -16,3 +16,381 @@ CREATE TABLE IF NOT EXISTS refresh_tokens (
   expires_at TIMESTAMPTZ NOT NULL,
   revoked    BOOLEAN NOT NULL DEFAULT FALSE
 );
+
+-- смена email: ожидающее подтверждения изменение (одно на пользователя)
+CREATE TABLE IF NOT EXISTS email_changes (
+  user_id      TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  new_email    TEXT NOT NULL,
+  confirm_hash TEXT UNIQUE NOT NULL,
+  cancel_hash  TEXT UNIQUE NOT NULL,
+  expires_at   TIMESTAMPTZ NOT NULL,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- TOTP (RFC 6238): секрет хранится зашифрованным (AES-GCM)
+CREATE TABLE IF NOT EXISTS user_totp (
+  user_id      TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  secret_enc   TEXT NOT NULL,
+  confirmed    BOOLEAN NOT NULL DEFAULT FALSE,
+  last_step    BIGINT NOT NULL DEFAULT 0,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  confirmed_at TIMESTAMPTZ
+);
+
+-- вызовы mfa_required: число попыток и одноразовость (id — хэш токена вызова)
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+  id         TEXT PRIMARY KEY,
+  user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  attempts   INT NOT NULL DEFAULT 0,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  used_at    TIMESTAMPTZ
+);
+
+-- методы аутентификации исходного входа (claim amr) переживают refresh
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS amr TEXT[];
+
+-- одноразовые коды восстановления MFA (хранится только хэш)
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+  id         TEXT PRIMARY KEY,
+  user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash  TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  used_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user ON mfa_recovery_codes (user_id);
+
+-- беспарольный вход: magic link или 6-значный код по email
+CREATE TABLE IF NOT EXISTS passwordless_challenges (
+  id          TEXT PRIMARY KEY,
+  user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  method      TEXT NOT NULL CHECK (method IN ('link', 'code')),
+  secret_hash TEXT NOT NULL,
+  attempts    INT NOT NULL DEFAULT 0,
+  expires_at  TIMESTAMPTZ NOT NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  used_at     TIMESTAMPTZ
+);
+
+-- сессии (устройства) пользователя; refresh-токены ротации ссылаются на сессию
+CREATE TABLE IF NOT EXISTS sessions (
+  id           TEXT PRIMARY KEY,
+  user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  user_agent   TEXT NOT NULL DEFAULT '',
+  ip           TEXT NOT NULL DEFAULT '',
+  device_name  TEXT NOT NULL DEFAULT '',
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_id TEXT REFERENCES sessions(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens (session_id);
+
+-- роли и блокировка учётных записей
+ALTER TABLE users ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+
+-- выданные access-токены (jti) и denylist для немедленного отзыва
+CREATE TABLE IF NOT EXISTS access_tokens (
+  jti        TEXT PRIMARY KEY,
+  user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  session_id TEXT REFERENCES sessions(id) ON DELETE CASCADE,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_access_tokens_user ON access_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_jtis (
+  jti        TEXT PRIMARY KEY,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- OAuth 2.0 клиенты (секрет хранится хэшем)
+CREATE TABLE IF NOT EXISTS oauth_clients (
+  id          TEXT PRIMARY KEY,
+  name        TEXT NOT NULL DEFAULT '',
+  secret_hash TEXT NOT NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- реестр клиентов для authorization code flow
+ALTER TABLE oauth_clients ALTER COLUMN secret_hash SET DEFAULT '';
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS public        BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS redirect_uris TEXT[]  NOT NULL DEFAULT '{}';
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS scopes        TEXT[]  NOT NULL DEFAULT '{}';
+
+-- authorization codes (одноразовые, хранится только хэш; PKCE S256)
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+  code_hash      TEXT PRIMARY KEY,
+  client_id      TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  user_id        TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  session_id     TEXT REFERENCES sessions(id) ON DELETE CASCADE,
+  redirect_uri   TEXT NOT NULL,
+  scope          TEXT NOT NULL DEFAULT '',
+  code_challenge TEXT NOT NULL,
+  amr            TEXT[],
+  expires_at     TIMESTAMPTZ NOT NULL,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- refresh-токены, выданные OAuth-клиенту, привязаны к нему и к scope
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id TEXT REFERENCES oauth_clients(id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope     TEXT NOT NULL DEFAULT '';
+
+-- OpenID Connect: подтверждённость email и nonce из запроса авторизации
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '';
+
+-- сервисные учётные записи (client_credentials) и блокировка клиентов
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS service_account BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS disabled_at     TIMESTAMPTZ;
+
+-- access-токены сервисных учётных записей: user_id пуст, client_id задан
+ALTER TABLE access_tokens ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE access_tokens ADD COLUMN IF NOT EXISTS client_id TEXT REFERENCES oauth_clients(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_access_tokens_client ON access_tokens (client_id);
+
+-- персональные API-ключи: хранится префикс для поиска и хэш ключа целиком
+CREATE TABLE IF NOT EXISTS api_keys (
+  id           TEXT PRIMARY KEY,
+  user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name         TEXT NOT NULL,
+  prefix       TEXT UNIQUE NOT NULL,
+  secret_hash  TEXT NOT NULL,
+  scopes       TEXT[] NOT NULL DEFAULT '{}',
+  expires_at   TIMESTAMPTZ,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ,
+  revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id);
+
+-- device authorization grant (RFC 8628): device_code хранится хэшем
+CREATE TABLE IF NOT EXISTS device_authorizations (
+  device_code_hash TEXT PRIMARY KEY,
+  user_code        TEXT NOT NULL,
+  client_id        TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  scope            TEXT NOT NULL DEFAULT '',
+  status           TEXT NOT NULL DEFAULT 'pending',
+  user_id          TEXT REFERENCES users(id) ON DELETE CASCADE,
+  session_id       TEXT REFERENCES sessions(id) ON DELETE CASCADE,
+  amr              TEXT[],
+  interval_seconds INT NOT NULL,
+  last_polled_at   TIMESTAMPTZ,
+  expires_at       TIMESTAMPTZ NOT NULL,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_device_authorizations_user_code ON device_authorizations (user_code);
+
+-- token exchange (RFC 8693): политики клиента [{audience, scopes, ttl_seconds}]
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS token_exchange JSONB NOT NULL DEFAULT '[]';
+
+-- DPoP (RFC 9449): refresh-токен привязан к отпечатку ключа клиента
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS dpop_jkt TEXT;
+
+-- федерация: внешние OIDC-провайдеры, привязки аккаунтов и незавершённые входы
+CREATE TABLE IF NOT EXISTS identity_providers (
+  id                TEXT PRIMARY KEY,
+  name              TEXT NOT NULL,
+  issuer            TEXT NOT NULL,
+  client_id         TEXT NOT NULL,
+  client_secret_enc TEXT NOT NULL,
+  scopes            TEXT[] NOT NULL DEFAULT '{}',
+  claims            JSONB NOT NULL DEFAULT '{}',
+  disabled_at       TIMESTAMPTZ,
+  created_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS federated_identities (
+  provider_id   TEXT NOT NULL REFERENCES identity_providers(id) ON DELETE CASCADE,
+  subject       TEXT NOT NULL,
+  user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email         TEXT NOT NULL DEFAULT '',
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_login_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (provider_id, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_federated_identities_user ON federated_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS federation_states (
+  state_hash    TEXT PRIMARY KEY,
+  provider_id   TEXT NOT NULL REFERENCES identity_providers(id) ON DELETE CASCADE,
+  nonce         TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  expires_at    TIMESTAMPTZ NOT NULL
+);
+
+-- пользователи LDAP-каталогов, созданные при первом входе
+CREATE TABLE IF NOT EXISTS directory_accounts (
+  domain        TEXT NOT NULL,
+  username      TEXT NOT NULL,
+  user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  dn            TEXT NOT NULL,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_login_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (domain, username)
+);
+
+-- SCIM-провижининг: id пользователя во внешней системе и группы (= роли)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_users_external_id ON users (external_id) WHERE external_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS provisioned_groups (
+  id           TEXT PRIMARY KEY,
+  display_name TEXT UNIQUE NOT NULL,
+  external_id  TEXT,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- SAML 2.0 провайдеры хранятся вместе с OIDC: entity id в issuer,
+-- SSO-адрес и сертификаты подписи из метаданных
+ALTER TABLE identity_providers ADD COLUMN IF NOT EXISTS protocol TEXT NOT NULL DEFAULT 'oidc';
+ALTER TABLE identity_providers ADD COLUMN IF NOT EXISTS sso_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE identity_providers ADD COLUMN IF NOT EXISTS certificates TEXT[] NOT NULL DEFAULT '{}';
+
+-- связь с OrgDirectory: гражданин (citizens.id) и организации
+-- (organizations.id) пользователя; maindb - другая база, поэтому без FK
+ALTER TABLE users ADD COLUMN IF NOT EXISTS citizen_id UUID;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS org_ids INTEGER[] NOT NULL DEFAULT '{}';
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_users_citizen_id ON users (citizen_id) WHERE citizen_id IS NOT NULL;
+
+-- арендаторы (realms): строки всех таблиц принадлежат арендатору, логин,
+-- email, id клиентов, провайдеров и группы уникальны в его пределах.
+-- Выполняется один раз, пока у users нет tenant_id; существующие строки
+-- достаются арендатору 'default'
+DO $$
+DECLARE
+  t TEXT;
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+              WHERE table_name = 'users' AND column_name = 'tenant_id') THEN
+    RETURN;
+  END IF;
+
+  FOREACH t IN ARRAY ARRAY['users', 'refresh_tokens', 'email_changes', 'user_totp',
+      'mfa_challenges', 'mfa_recovery_codes', 'passwordless_challenges', 'sessions',
+      'access_tokens', 'revoked_jtis', 'oauth_clients', 'oauth_authorization_codes', 'api_keys',
+      'device_authorizations', 'identity_providers', 'federated_identities',
+      'federation_states', 'directory_accounts', 'provisioned_groups'] LOOP
+    EXECUTE format('ALTER TABLE %I ADD COLUMN tenant_id TEXT NOT NULL DEFAULT %L', t, 'default');
+  END LOOP;
+
+  ALTER TABLE users DROP CONSTRAINT users_login_key;
+  ALTER TABLE users DROP CONSTRAINT users_email_key;
+  DROP INDEX uniq_users_email_lower;
+  DROP INDEX idx_users_email_lower;
+  DROP INDEX uniq_users_citizen_id;
+  CREATE UNIQUE INDEX uniq_users_tenant_login ON users (tenant_id, login);
+  CREATE UNIQUE INDEX uniq_users_tenant_email_lower ON users (tenant_id, LOWER(email));
+  CREATE UNIQUE INDEX uniq_users_tenant_citizen_id ON users (tenant_id, citizen_id) WHERE citizen_id IS NOT NULL;
+
+  -- клиенты: составной ключ (tenant_id, id) и ссылки на него
+  ALTER TABLE oauth_authorization_codes DROP CONSTRAINT oauth_authorization_codes_client_id_fkey;
+  ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_client_id_fkey;
+  ALTER TABLE access_tokens DROP CONSTRAINT access_tokens_client_id_fkey;
+  ALTER TABLE device_authorizations DROP CONSTRAINT device_authorizations_client_id_fkey;
+  ALTER TABLE oauth_clients DROP CONSTRAINT oauth_clients_pkey;
+  ALTER TABLE oauth_clients ADD PRIMARY KEY (tenant_id, id);
+  ALTER TABLE oauth_authorization_codes ADD FOREIGN KEY (tenant_id, client_id) REFERENCES oauth_clients (tenant_id, id) ON DELETE CASCADE;
+  ALTER TABLE refresh_tokens ADD FOREIGN KEY (tenant_id, client_id) REFERENCES oauth_clients (tenant_id, id) ON DELETE CASCADE;
+  ALTER TABLE access_tokens ADD FOREIGN KEY (tenant_id, client_id) REFERENCES oauth_clients (tenant_id, id) ON DELETE CASCADE;
+  ALTER TABLE device_authorizations ADD FOREIGN KEY (tenant_id, client_id) REFERENCES oauth_clients (tenant_id, id) ON DELETE CASCADE;
+
+  -- провайдеры федерации: то же
+  ALTER TABLE federated_identities DROP CONSTRAINT federated_identities_provider_id_fkey;
+  ALTER TABLE federation_states DROP CONSTRAINT federation_states_provider_id_fkey;
+  ALTER TABLE federated_identities DROP CONSTRAINT federated_identities_pkey;
+  ALTER TABLE identity_providers DROP CONSTRAINT identity_providers_pkey;
+  ALTER TABLE identity_providers ADD PRIMARY KEY (tenant_id, id);
+  ALTER TABLE federated_identities ADD PRIMARY KEY (tenant_id, provider_id, subject);
+  ALTER TABLE federated_identities ADD FOREIGN KEY (tenant_id, provider_id) REFERENCES identity_providers (tenant_id, id) ON DELETE CASCADE;
+  ALTER TABLE federation_states ADD FOREIGN KEY (tenant_id, provider_id) REFERENCES identity_providers (tenant_id, id) ON DELETE CASCADE;
+
+  ALTER TABLE directory_accounts DROP CONSTRAINT directory_accounts_pkey;
+  ALTER TABLE directory_accounts ADD PRIMARY KEY (tenant_id, domain, username);
+
+  ALTER TABLE provisioned_groups DROP CONSTRAINT provisioned_groups_display_name_key;
+  CREATE UNIQUE INDEX uniq_provisioned_groups_tenant_name ON provisioned_groups (tenant_id, display_name);
+END $$;
+
+-- группы ролей: могут быть вложены (parent_ids), члены группы получают
+-- роли её и всех родительских групп; циклы отсекает сервис
+CREATE TABLE IF NOT EXISTS role_groups (
+  tenant_id  TEXT NOT NULL DEFAULT 'default',
+  id         TEXT NOT NULL,
+  name       TEXT NOT NULL,
+  roles      TEXT[] NOT NULL DEFAULT '{}',
+  parent_ids TEXT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (tenant_id, id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_role_groups_tenant_name ON role_groups (tenant_id, LOWER(name));
+
+CREATE TABLE IF NOT EXISTS role_group_members (
+  tenant_id  TEXT NOT NULL DEFAULT 'default',
+  group_id   TEXT NOT NULL,
+  user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (tenant_id, group_id, user_id),
+  FOREIGN KEY (tenant_id, group_id) REFERENCES role_groups (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_role_group_members_user ON role_group_members (tenant_id, user_id);
+
+-- версии политики авторизации (POST /authz/check); версии не меняются,
+-- действует последняя
+CREATE TABLE IF NOT EXISTS authz_policies (
+  tenant_id  TEXT NOT NULL DEFAULT 'default',
+  version    INTEGER NOT NULL,
+  document   JSONB NOT NULL,
+  comment    TEXT NOT NULL DEFAULT '',
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (tenant_id, version)
+);
+
+-- приглашения: регистрация по подписанному одноразовому токену из письма
+-- с заранее назначенными ролями и организациями
+CREATE TABLE IF NOT EXISTS invitations (
+  tenant_id   TEXT NOT NULL DEFAULT 'default',
+  id          TEXT NOT NULL,
+  email       TEXT NOT NULL,
+  roles       TEXT[] NOT NULL DEFAULT '{}',
+  org_ids     INTEGER[] NOT NULL DEFAULT '{}',
+  invited_by  TEXT NOT NULL,
+  expires_at  TIMESTAMPTZ NOT NULL,
+  accepted_at TIMESTAMPTZ,
+  user_id     TEXT REFERENCES users(id) ON DELETE SET NULL,
+  revoked_at  TIMESTAMPTZ,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (tenant_id, id)
+);
+
+-- поиск приглашения по email при входе через внешнего провайдера
+CREATE INDEX IF NOT EXISTS idx_invitations_email_lower ON invitations (tenant_id, (LOWER(email)));
+
+-- версии пользовательского соглашения; версии не меняются, пользователь
+-- должен принять версию не старше последней обязательной
+CREATE TABLE IF NOT EXISTS terms_versions (
+  tenant_id    TEXT NOT NULL DEFAULT 'default',
+  version      INTEGER NOT NULL,
+  title        TEXT NOT NULL,
+  content      TEXT NOT NULL DEFAULT '',
+  url          TEXT NOT NULL DEFAULT '',
+  mandatory    BOOLEAN NOT NULL DEFAULT FALSE,
+  published_by TEXT NOT NULL DEFAULT '',
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (tenant_id, version)
+);
+
+-- принятие версий соглашения: кто, какую версию, когда и с какого IP
+CREATE TABLE IF NOT EXISTS terms_acceptances (
+  tenant_id   TEXT NOT NULL DEFAULT 'default',
+  user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  version     INTEGER NOT NULL,
+  accepted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ip          TEXT NOT NULL DEFAULT '',
+  user_agent  TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY (tenant_id, user_id, version),
+  FOREIGN KEY (tenant_id, version) REFERENCES terms_versions (tenant_id, version)
+);
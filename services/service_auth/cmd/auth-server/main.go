@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"encoding/json"
 	"log"
 	"os"
 	"os/signal"
@@ -10,10 +12,15 @@ import (
 	"time"
 
 	"auth_project/internal/auth"
+	"auth_project/internal/domain"
 	event "auth_project/internal/event"
 	httptransport "auth_project/internal/http" // и этот, чтобы не путать со std net/http
 	"auth_project/internal/jwt"
+	"auth_project/internal/ldapauth"
+	"auth_project/internal/mail"
 	"auth_project/internal/password"
+	"auth_project/internal/revoke"
+	"auth_project/internal/seal"
 	"auth_project/internal/store"
 )
 
@@ -52,7 +59,24 @@ func main() {
 
 	// Hasher + JWT
 	hasher := password.Argon2idHasher{}
-	jwtSvc := jwt.New(secret, issuer, accessTTL, refreshTTL)
+	// Ключ RS256 для id_token (OpenID Connect): PEM из OIDC_SIGNING_KEY_FILE,
+	// иначе временный ключ — id_token'ы перестанут проверяться после рестарта
+	var signingKey *rsa.PrivateKey
+	if keyFile := os.Getenv("OIDC_SIGNING_KEY_FILE"); keyFile != "" {
+		key, err := jwt.LoadRSAKey(keyFile)
+		if err != nil {
+			log.Fatalf("cannot load OIDC signing key: %v", err)
+		}
+		signingKey = key
+	} else {
+		key, err := jwt.GenerateRSAKey()
+		if err != nil {
+			log.Fatalf("cannot generate OIDC signing key: %v", err)
+		}
+		signingKey = key
+		log.Printf("OIDC_SIGNING_KEY_FILE not set, using an ephemeral id_token signing key")
+	}
+	jwtSvc := jwt.New(secret, issuer, accessTTL, refreshTTL, jwt.WithRSAKey(signingKey))
 
 	// Паблишер событий
 	var publisher event.Publisher
@@ -79,11 +103,181 @@ func main() {
 		log.Printf("using stdout publisher")
 	}
 
+	// Почта
+	var mailer mail.Mailer
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			from = "no-reply@orgdirectory.local"
+		}
+		mailer = mail.NewSMTPMailer(smtpAddr, from, os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASSWORD"))
+		log.Printf("using SMTP mailer via %s", smtpAddr)
+	} else {
+		mailer = mail.StdoutMailer{}
+		log.Printf("using stdout mailer")
+	}
+	publicURL := os.Getenv("AUTH_PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
+	}
+
+	// Ключ шифрования TOTP-секретов
+	mfaKey := os.Getenv("MFA_ENCRYPTION_KEY")
+	if mfaKey == "" {
+		mfaKey = "mfa:" + secret
+		log.Printf("MFA_ENCRYPTION_KEY is not set, deriving it from AUTH_SECRET")
+	}
+	box, err := seal.New(mfaKey)
+	if err != nil {
+		log.Fatalf("failed to init secret box: %v", err)
+	}
+
+	// LDAP-каталоги по суффиксу логина: JSON-массив в AUTH_LDAP_FILE,
+	// например [{"suffix":"@corp","url":"ldaps://dc.corp:636","user_dn":"%s@corp.local","base_dn":"dc=corp,dc=local",...}]
+	var ldapDomains []auth.Option
+	if path := os.Getenv("AUTH_LDAP_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("cannot read AUTH_LDAP_FILE: %v", err)
+		}
+		var domains []struct {
+			Suffix string `json:"suffix"`
+			ldapauth.Config
+		}
+		if err := json.Unmarshal(data, &domains); err != nil {
+			log.Fatalf("cannot parse AUTH_LDAP_FILE: %v", err)
+		}
+		for _, d := range domains {
+			if !strings.HasPrefix(d.Suffix, "@") {
+				log.Fatalf("LDAP domain suffix must start with @: %q", d.Suffix)
+			}
+			a, err := ldapauth.New(d.Config)
+			if err != nil {
+				log.Fatalf("LDAP domain %s: %v", d.Suffix, err)
+			}
+			ldapDomains = append(ldapDomains, auth.WithLDAPDomain(d.Suffix, a))
+			log.Printf("routing %s logins to %s", d.Suffix, d.URL)
+		}
+	}
+
+	// Политика паролей: JSON в AUTH_PASSWORD_POLICY,
+	// например {"min_length":12,"require_digit":true,"forbid_login":true}
+	policy := auth.DefaultPasswordPolicy
+	if raw := os.Getenv("AUTH_PASSWORD_POLICY"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &policy); err != nil {
+			log.Fatalf("cannot parse AUTH_PASSWORD_POLICY: %v", err)
+		}
+	}
+
+	// Регистрация только по приглашениям: AUTH_REQUIRE_INVITE=true
+	requireInvite := os.Getenv("AUTH_REQUIRE_INVITE") == "true"
+
+	// Denylist отозванных access-токенов (jti)
+	denylist := revoke.NewDenylist(userStore)
+	if err := denylist.Load(ctx); err != nil {
+		log.Fatalf("failed to load jti denylist: %v", err)
+	}
+	go denylist.Run(ctx, time.Minute)
+
 	// Собираем сервис
-	svc := auth.New(userStore, hasher, jwtSvc, publisher)
+	opts := []auth.Option{
+		auth.WithMailer(mailer),
+		auth.WithPublicURL(publicURL),
+		auth.WithSecretBox(box),
+		auth.WithDenylist(denylist),
+		auth.WithPasswordPolicy(policy),
+		auth.WithInviteOnly(requireInvite),
+	}
+	// SCIM-провижининг (/scim/v2) включается токеном SCIM_TOKEN
+	if token := os.Getenv("SCIM_TOKEN"); token != "" {
+		opts = append(opts, auth.WithSCIMToken(token))
+	}
+	svc := auth.New(userStore, hasher, jwtSvc, publisher, append(opts, ldapDomains...)...)
+
+	// Политика авторизации (/authz/check): последняя версия из хранилища,
+	// версии других инстансов подхватываются раз в 10 секунд
+	if err := svc.LoadPolicy(ctx); err != nil {
+		log.Fatalf("failed to load authorization policy: %v", err)
+	}
+	go svc.RunPolicyReload(ctx, 10*time.Second)
+
+	// Первые администраторы: AUTH_ADMINS=login1,admin@example.com
+	for _, part := range strings.Split(os.Getenv("AUTH_ADMINS"), ",") {
+		if ident := strings.ToLower(strings.TrimSpace(part)); ident != "" {
+			if err := svc.EnsureRole(ctx, ident, domain.RoleAdmin); err != nil {
+				log.Printf("cannot grant admin to %s: %v", ident, err)
+			}
+		}
+	}
+
+	// OAuth-клиенты для introspection/revocation: AUTH_CLIENTS=id1:secret1,id2:secret2
+	var clients []auth.ClientRegistration
+	for _, part := range strings.Split(os.Getenv("AUTH_CLIENTS"), ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if ok && id != "" && secret != "" {
+			clients = append(clients, auth.ClientRegistration{ID: id, Secret: secret})
+		}
+	}
+	// Полный реестр клиентов (redirect URIs, scopes, public, token_exchange): JSON-массив в AUTH_CLIENTS_FILE
+	if path := os.Getenv("AUTH_CLIENTS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("cannot read AUTH_CLIENTS_FILE: %v", err)
+		}
+		var fromFile []auth.ClientRegistration
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			log.Fatalf("cannot parse AUTH_CLIENTS_FILE: %v", err)
+		}
+		clients = append(clients, fromFile...)
+	}
+	for _, reg := range clients {
+		if err := svc.EnsureClient(ctx, reg); err != nil {
+			log.Printf("cannot register client %s: %v", reg.ID, err)
+		}
+	}
+
+	// Арендаторы (realms): сервис выше обслуживает запросы, не попавшие
+	// ни к одному из арендаторов AUTH_TENANTS_FILE
+	tenants := []httptransport.Tenant{{ID: store.DefaultTenant, Service: svc}}
+	if path := os.Getenv("AUTH_TENANTS_FILE"); path != "" {
+		configs, err := loadTenants(path)
+		if err != nil {
+			log.Fatalf("cannot load AUTH_TENANTS_FILE: %v", err)
+		}
+		multi, ok := userStore.(store.TenantStores)
+		if !ok {
+			log.Fatalf("user store does not support tenants")
+		}
+		defaults := tenantDefaults{
+			users:          multi,
+			secret:         secret,
+			publicURL:      publicURL,
+			accessTTL:      accessTTL,
+			refreshTTL:     refreshTTL,
+			passwordPolicy: policy,
+			requireInvite:  requireInvite,
+			hasher:         hasher,
+			publisher:      publisher,
+			opts:           []auth.Option{auth.WithMailer(mailer), auth.WithSecretBox(box)},
+		}
+		secrets := map[string]string{secret: store.DefaultTenant}
+		for _, tc := range configs {
+			t, err := newTenant(ctx, tc, defaults)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if tc.Secret != "" {
+				if other, dup := secrets[tc.Secret]; dup {
+					log.Fatalf("tenant %s shares its secret with %s", tc.ID, other)
+				}
+				secrets[tc.Secret] = tc.ID
+			}
+			tenants = append(tenants, t)
+		}
+	}
 
 	// Запуск HTTP
-	if err := httptransport.Start(ctx, svc); err != nil {
+	if err := httptransport.StartTenants(ctx, tenants); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+	event "auth_project/internal/event"
+	httptransport "auth_project/internal/http"
+	"auth_project/internal/jwt"
+	"auth_project/internal/password"
+	"auth_project/internal/revoke"
+	"auth_project/internal/store"
+)
+
+// tenantIDPattern ограничивает id арендатора: он попадает в URL и в БД
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// tenantConfig — арендатор (realm) из AUTH_TENANTS_FILE. Пустые поля
+// берутся из настроек по умолчанию; secret выводится из AUTH_SECRET.
+type tenantConfig struct {
+	ID             string               `json:"id"`
+	Hosts          []string             `json:"hosts"`
+	PathPrefix     string               `json:"path_prefix"`
+	PublicURL      string               `json:"public_url"`
+	Issuer         string               `json:"issuer"`
+	Secret         string               `json:"secret"`
+	SigningKeyFile string               `json:"signing_key_file"`
+	AccessTTL      string               `json:"access_ttl"`
+	RefreshTTL     string               `json:"refresh_ttl"`
+	PasswordPolicy *auth.PasswordPolicy `json:"password_policy"`
+	SCIMToken      string               `json:"scim_token"`
+	Admins         []string             `json:"admins"`
+	RequireInvite  *bool                `json:"require_invite"`
+}
+
+// tenantDefaults — общие для арендаторов зависимости и значения по умолчанию
+type tenantDefaults struct {
+	users          store.TenantStores
+	secret         string
+	publicURL      string
+	accessTTL      time.Duration
+	refreshTTL     time.Duration
+	passwordPolicy auth.PasswordPolicy
+	requireInvite  bool
+	hasher         password.Hasher
+	publisher      event.Publisher
+	opts           []auth.Option
+}
+
+// loadTenants читает AUTH_TENANTS_FILE: JSON-массив арендаторов, например
+// [{"id":"north","hosts":["auth.north.example"],"access_ttl":"10m"},
+// {"id":"south","path_prefix":"/realms/south","password_policy":{"min_length":12}}]
+func loadTenants(path string) ([]tenantConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []tenantConfig
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	for _, tc := range out {
+		if !tenantIDPattern.MatchString(tc.ID) || tc.ID == store.DefaultTenant {
+			return nil, fmt.Errorf("invalid tenant id %q", tc.ID)
+		}
+		if len(tc.Hosts) == 0 && tc.PathPrefix == "" {
+			return nil, fmt.Errorf("tenant %s needs hosts or a path_prefix", tc.ID)
+		}
+	}
+	return out, nil
+}
+
+// newTenant собирает сервис арендатора: свои хранилище, ключи, TTL,
+// политику паролей и issuer, общие почта, шифрование и шина событий.
+func newTenant(ctx context.Context, tc tenantConfig, d tenantDefaults) (httptransport.Tenant, error) {
+	users := d.users.ForTenant(tc.ID)
+	publicURL := tc.PublicURL
+	if publicURL == "" {
+		if len(tc.Hosts) > 0 {
+			publicURL = "https://" + tc.Hosts[0] + tc.PathPrefix
+		} else {
+			publicURL = d.publicURL + tc.PathPrefix
+		}
+	}
+	if tc.PathPrefix != "" && !strings.HasSuffix(strings.TrimRight(publicURL, "/"), strings.TrimRight(tc.PathPrefix, "/")) {
+		return httptransport.Tenant{}, fmt.Errorf("tenant %s: public_url must end with path_prefix", tc.ID)
+	}
+	issuer := tc.Issuer
+	if issuer == "" {
+		issuer = strings.TrimRight(publicURL, "/")
+	}
+	secret := tc.Secret
+	if secret == "" {
+		// у каждого арендатора свой ключ: токены одного не проходят у другого
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write([]byte("tenant:" + tc.ID))
+		secret = hex.EncodeToString(mac.Sum(nil))
+	}
+	accessTTL, err := durationOr(tc.AccessTTL, d.accessTTL)
+	if err != nil {
+		return httptransport.Tenant{}, fmt.Errorf("tenant %s: access_ttl: %w", tc.ID, err)
+	}
+	refreshTTL, err := durationOr(tc.RefreshTTL, d.refreshTTL)
+	if err != nil {
+		return httptransport.Tenant{}, fmt.Errorf("tenant %s: refresh_ttl: %w", tc.ID, err)
+	}
+	var signingKey *rsa.PrivateKey
+	if tc.SigningKeyFile != "" {
+		signingKey, err = jwt.LoadRSAKey(tc.SigningKeyFile)
+	} else {
+		signingKey, err = jwt.GenerateRSAKey()
+		log.Printf("tenant %s: signing_key_file not set, using an ephemeral id_token signing key", tc.ID)
+	}
+	if err != nil {
+		return httptransport.Tenant{}, fmt.Errorf("tenant %s: signing key: %w", tc.ID, err)
+	}
+	jwtSvc := jwt.New(secret, issuer, accessTTL, refreshTTL, jwt.WithRSAKey(signingKey))
+
+	denylist := revoke.NewDenylist(users)
+	if err := denylist.Load(ctx); err != nil {
+		return httptransport.Tenant{}, fmt.Errorf("tenant %s: jti denylist: %w", tc.ID, err)
+	}
+	go denylist.Run(ctx, time.Minute)
+
+	policy := d.passwordPolicy
+	if tc.PasswordPolicy != nil {
+		policy = *tc.PasswordPolicy
+	}
+	requireInvite := d.requireInvite
+	if tc.RequireInvite != nil {
+		requireInvite = *tc.RequireInvite
+	}
+	opts := append([]auth.Option{}, d.opts...)
+	opts = append(opts,
+		auth.WithPublicURL(publicURL),
+		auth.WithDenylist(denylist),
+		auth.WithPasswordPolicy(policy),
+		auth.WithSCIMToken(tc.SCIMToken),
+		auth.WithInviteOnly(requireInvite),
+	)
+	publisher := event.TenantPublisher{Publisher: d.publisher, TenantID: tc.ID}
+	svc := auth.New(users, d.hasher, jwtSvc, publisher, opts...)
+	if err := svc.LoadPolicy(ctx); err != nil {
+		return httptransport.Tenant{}, fmt.Errorf("tenant %s: authorization policy: %w", tc.ID, err)
+	}
+	go svc.RunPolicyReload(ctx, 10*time.Second)
+	for _, ident := range tc.Admins {
+		if ident = strings.ToLower(strings.TrimSpace(ident)); ident != "" {
+			if err := svc.EnsureRole(ctx, ident, domain.RoleAdmin); err != nil {
+				log.Printf("tenant %s: cannot grant admin to %s: %v", tc.ID, ident, err)
+			}
+		}
+	}
+	log.Printf("tenant %s: issuer %s, hosts %v, path prefix %q", tc.ID, issuer, tc.Hosts, tc.PathPrefix)
+	return httptransport.Tenant{ID: tc.ID, Hosts: tc.Hosts, PathPrefix: tc.PathPrefix, Service: svc}, nil
+}
+
+// durationOr разбирает длительность вида "15m"; пустая строка — def
+func durationOr(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("must be positive")
+	}
+	return d, err
+}
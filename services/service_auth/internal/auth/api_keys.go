@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+const (
+	// apiKeyPrefix marks OrgDirectory API keys so they are recognizable
+	// in logs and by secret scanners.
+	apiKeyPrefix = "odk_"
+	// apiKeyLookupLen is the length of the stored lookup prefix.
+	apiKeyLookupLen = 12
+	// maxAPIKeys bounds the number of active keys per user.
+	maxAPIKeys = 25
+)
+
+var (
+	// ErrAPIKeyNotFound is returned for unknown keys and keys of other users.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrInvalidAPIKey is returned for unknown, revoked or expired keys.
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyScope is returned for scopes that are unknown or exceed
+	// the scope of the token creating the key.
+	ErrAPIKeyScope = errors.New("invalid api key scope")
+	// ErrClientToken is returned when a token issued to an OAuth client is
+	// used to manage the user's credentials.
+	ErrClientToken = errors.New("token was issued to an OAuth client")
+)
+
+// APIKeyScopes are the scopes an API key can be limited to, one per
+// export endpoint of OrgDirectory. A key without scopes has the full
+// rights of its owner.
+var APIKeyScopes = []string{"export:activities", "export:organizations", "export:citizens"}
+
+// IsAPIKey reports whether token looks like an API key rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// CreateAPIKey creates a named API key for the caller and returns it with
+// the plaintext key, which is shown only once. The scopes must be in
+// APIKeyScopes and within the scope of the caller's token, if it has one.
+func (s *Service) CreateAPIKey(ctx context.Context, caller *jwt.AccessClaims, name string, scopes []string, expiresAt *time.Time) (*store.APIKey, string, error) {
+	if caller.ClientID != "" {
+		return nil, "", ErrClientToken
+	}
+	userID := caller.Subject
+	scopes, err := apiKeyScopes(scopes, caller.Scope)
+	if err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", errors.New("expiry must be in the future")
+	}
+	existing, err := s.users.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(existing) >= maxAPIKeys {
+		return nil, "", errors.New("too many api keys")
+	}
+	lookup := make([]byte, apiKeyLookupLen/2)
+	if _, err := rand.Read(lookup); err != nil {
+		return nil, "", err
+	}
+	prefix := hex.EncodeToString(lookup)
+	plaintext := apiKeyPrefix + prefix + "_" + newSecret()
+	key := store.APIKey{
+		ID:         "k-" + newSecret()[:22],
+		UserID:     userID,
+		Name:       name,
+		Prefix:     prefix,
+		SecretHash: hashSecret(plaintext),
+		Scopes:     scopes,
+		ExpiresAt:  expiresAt,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.users.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+	s.events.Publish("API_KEY_CREATED", map[string]any{"userID": userID, "keyID": key.ID, "name": name})
+	return &key, plaintext, nil
+}
+
+// apiKeyScopes validates the scopes requested for a key. A caller with a
+// scope cannot create a key with more rights: its key gets the caller's
+// scope when none is requested.
+func apiKeyScopes(requested []string, callerScope string) ([]string, error) {
+	allowed := APIKeyScopes
+	if callerScope != "" {
+		allowed = strings.Fields(callerScope)
+		if len(requested) == 0 {
+			requested = allowed
+		}
+	}
+	var out []string
+	for _, sc := range requested {
+		if !slices.Contains(APIKeyScopes, sc) || !slices.Contains(allowed, sc) {
+			return nil, fmt.Errorf("%w: %q", ErrAPIKeyScope, sc)
+		}
+		if !slices.Contains(out, sc) {
+			out = append(out, sc)
+		}
+	}
+	return out, nil
+}
+
+// ListAPIKeys returns the user's active API keys.
+func (s *Service) ListAPIKeys(ctx context.Context, userID string) ([]store.APIKey, error) {
+	return s.users.ListAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey revokes one of the user's API keys.
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	if err := s.users.RevokeAPIKey(ctx, userID, keyID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	s.events.Publish("API_KEY_REVOKED", map[string]any{"userID": userID, "keyID": keyID})
+	return nil
+}
+
+// ValidateAPIKey checks an API key and returns the principal it acts for.
+func (s *Service) ValidateAPIKey(ctx context.Context, plaintext string) (*Principal, error) {
+	rest, ok := strings.CutPrefix(plaintext, apiKeyPrefix)
+	if !ok || len(rest) <= apiKeyLookupLen || rest[apiKeyLookupLen] != '_' {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := s.users.FindAPIKeyByPrefix(ctx, rest[:apiKeyLookupLen])
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(hashSecret(plaintext))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+	u, err := s.users.FindByID(ctx, key.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil || u.Disabled() {
+		return nil, ErrInvalidAPIKey
+	}
+	roles, err := s.effectiveRoles(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.users.TouchAPIKey(ctx, key.ID, now)
+	return &Principal{
+		Subject:   u.ID,
+		Type:      PrincipalUser,
+		TokenType: TokenTypeAPIKey,
+		Scope:     strings.Join(key.Scopes, " "),
+		Roles:     roles,
+		CitizenID: u.CitizenID,
+		OrgIDs:    u.OrgIDs,
+		AMR:       []string{"api_key"},
+		KeyID:     key.ID,
+		ExpiresAt: key.ExpiresAt,
+	}, nil
+}
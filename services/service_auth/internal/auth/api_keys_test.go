@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	tests := []struct {
+		name        string
+		requested   []string
+		callerScope string
+		want        []string
+		wantErr     bool
+	}{
+		{name: "no scopes", want: nil},
+		{name: "known scopes", requested: []string{"export:citizens", "export:activities", "export:citizens"},
+			want: []string{"export:citizens", "export:activities"}},
+		{name: "unknown scope", requested: []string{"admin"}, wantErr: true},
+		{name: "within the caller's scope", requested: []string{"export:citizens"},
+			callerScope: "export:citizens export:activities", want: []string{"export:citizens"}},
+		{name: "caller's scope by default", callerScope: "export:citizens", want: []string{"export:citizens"}},
+		{name: "beyond the caller's scope", requested: []string{"export:organizations"},
+			callerScope: "export:citizens", wantErr: true},
+		{name: "caller's scope is not a key scope", callerScope: "openid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := apiKeyScopes(tt.requested, tt.callerScope)
+			if tt.wantErr {
+				if !errors.Is(err, ErrAPIKeyScope) {
+					t.Fatalf("apiKeyScopes = %v, %v; want ErrAPIKeyScope", got, err)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Fatalf("apiKeyScopes = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/policy"
+	"auth_project/internal/store"
+)
+
+var (
+	// ErrInvalidPolicy is returned for policy documents that do not
+	// compile; the wrapping error names the rule.
+	ErrInvalidPolicy = errors.New("invalid policy")
+	// ErrAuthzForbidden is returned when a caller asks about another
+	// subject without being an administrator or a service account.
+	ErrAuthzForbidden = errors.New("only administrators and service accounts may check other subjects")
+	// ErrInvalidAuthzCheck is returned for malformed checks.
+	ErrInvalidAuthzCheck = errors.New("invalid authorization check")
+)
+
+// maxAuthzChecks bounds the checks of one batch.
+const maxAuthzChecks = 100
+
+// activePolicy is the compiled policy version in force.
+type activePolicy struct {
+	mu      sync.RWMutex
+	version int
+	policy  *policy.Policy
+}
+
+func (a *activePolicy) get() (int, *policy.Policy) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.version, a.policy
+}
+
+func (a *activePolicy) set(version int, p *policy.Policy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if version >= a.version {
+		a.version, a.policy = version, p
+	}
+}
+
+// PolicyVersion is a stored policy version with its document.
+type PolicyVersion struct {
+	Version   int             `json:"version"`
+	Document  policy.Document `json:"document"`
+	Comment   string          `json:"comment,omitempty"`
+	CreatedBy string          `json:"created_by,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func policyVersion(v *store.PolicyVersion) (*PolicyVersion, error) {
+	out := &PolicyVersion{Version: v.Version, Comment: v.Comment, CreatedBy: v.CreatedBy, CreatedAt: v.CreatedAt}
+	if err := json.Unmarshal(v.Document, &out.Document); err != nil {
+		return nil, fmt.Errorf("policy version %d: %w", v.Version, err)
+	}
+	return out, nil
+}
+
+// LoadPolicy compiles the latest policy version if it is newer than the
+// one in force.
+func (s *Service) LoadPolicy(ctx context.Context) error {
+	v, err := s.users.LatestPolicyVersion(ctx)
+	if err != nil || v == nil {
+		return err
+	}
+	if current, _ := s.policy.get(); current >= v.Version {
+		return nil
+	}
+	pv, err := policyVersion(v)
+	if err != nil {
+		return err
+	}
+	compiled, err := policy.Compile(pv.Document)
+	if err != nil {
+		return fmt.Errorf("policy version %d: %w", v.Version, err)
+	}
+	s.policy.set(v.Version, compiled)
+	return nil
+}
+
+// RunPolicyReload picks up policy versions published by other instances
+// every interval. It blocks until ctx is done.
+func (s *Service) RunPolicyReload(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.LoadPolicy(ctx); err != nil {
+				log.Printf("policy reload: %v", err)
+			}
+		}
+	}
+}
+
+// CurrentPolicy returns the latest policy version or store.ErrNotFound.
+func (s *Service) CurrentPolicy(ctx context.Context) (*PolicyVersion, error) {
+	v, err := s.users.LatestPolicyVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, store.ErrNotFound
+	}
+	return policyVersion(v)
+}
+
+// GetPolicyVersion returns a policy version or store.ErrNotFound.
+func (s *Service) GetPolicyVersion(ctx context.Context, version int) (*PolicyVersion, error) {
+	v, err := s.users.GetPolicyVersion(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, store.ErrNotFound
+	}
+	return policyVersion(v)
+}
+
+// ListPolicyVersions returns all policy versions, newest first.
+func (s *Service) ListPolicyVersions(ctx context.Context) ([]PolicyVersion, error) {
+	list, err := s.users.ListPolicyVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PolicyVersion, 0, len(list))
+	for i := range list {
+		v, err := policyVersion(&list[i])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *v)
+	}
+	return out, nil
+}
+
+// PublishPolicy stores doc as a new policy version and puts it in force.
+// Other instances pick it up on their next reload.
+func (s *Service) PublishPolicy(ctx context.Context, adminID string, doc policy.Document, comment string) (*PolicyVersion, error) {
+	compiled, err := policy.Compile(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	if doc.Rules == nil {
+		doc.Rules = []policy.Rule{}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	v := &store.PolicyVersion{Document: data, Comment: comment, CreatedBy: adminID}
+	if err := s.users.CreatePolicyVersion(ctx, v); err != nil {
+		return nil, err
+	}
+	s.policy.set(v.Version, compiled)
+	s.events.Publish("AUTHZ_POLICY_PUBLISHED", map[string]any{"version": v.Version, "rules": len(doc.Rules), "by": adminID})
+	return &PolicyVersion{Version: v.Version, Document: doc, Comment: v.Comment, CreatedBy: v.CreatedBy, CreatedAt: v.CreatedAt}, nil
+}
+
+// RestorePolicyVersion publishes the document of an earlier version as a
+// new version.
+func (s *Service) RestorePolicyVersion(ctx context.Context, adminID string, version int) (*PolicyVersion, error) {
+	old, err := s.GetPolicyVersion(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	return s.PublishPolicy(ctx, adminID, old.Document, fmt.Sprintf("restore of version %d", version))
+}
+
+// AuthzCheck asks whether a subject may perform an action on a resource.
+// Subject.ID defaults to the caller. Attributes of the subject known to
+// the service (roles, groups, OrgDirectory links) are added to those
+// given by the caller, which cannot override them.
+type AuthzCheck struct {
+	Subject  AuthzSubject    `json:"subject"`
+	Action   string          `json:"action"`
+	Resource policy.Resource `json:"resource"`
+	Context  map[string]any  `json:"context,omitempty"`
+}
+
+// AuthzSubject is the subject of an AuthzCheck.
+type AuthzSubject struct {
+	ID         string         `json:"id"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// AuthzResult is the decision on one AuthzCheck.
+type AuthzResult struct {
+	policy.Decision
+	PolicyVersion int    `json:"policy_version"`
+	Error         string `json:"error,omitempty"`
+}
+
+// CheckAuthorization decides the checks with the policy in force. Users
+// may only ask about themselves; administrators and service accounts may
+// ask about any user. Checks of unknown subjects are denied with an
+// error.
+func (s *Service) CheckAuthorization(ctx context.Context, caller *jwt.AccessClaims, checks []AuthzCheck, explain bool) ([]AuthzResult, error) {
+	if len(checks) == 0 || len(checks) > maxAuthzChecks {
+		return nil, fmt.Errorf("%w: between 1 and %d checks required", ErrInvalidAuthzCheck, maxAuthzChecks)
+	}
+	serviceAccount := caller.ClientID != "" && caller.ClientID == caller.Subject
+	trusted := serviceAccount
+	for i := range checks {
+		if checks[i].Subject.ID == "" {
+			checks[i].Subject.ID = caller.Subject
+		}
+		if checks[i].Action == "" || checks[i].Resource.Type == "" {
+			return nil, fmt.Errorf("%w: action and resource.type are required", ErrInvalidAuthzCheck)
+		}
+		if checks[i].Subject.ID != caller.Subject && !trusted {
+			ok, err := s.HasEffectiveRole(ctx, caller.Subject, domain.RoleAdmin)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return nil, err
+			}
+			if !ok {
+				return nil, ErrAuthzForbidden
+			}
+			trusted = true
+		}
+	}
+	version, compiled := s.policy.get()
+	subjects := make(map[string]map[string]any)
+	out := make([]AuthzResult, len(checks))
+	for i, check := range checks {
+		out[i].PolicyVersion = version
+		subject, ok := subjects[check.Subject.ID]
+		if !ok {
+			var err error
+			if serviceAccount && check.Subject.ID == caller.Subject {
+				subject = map[string]any{"id": caller.Subject, "type": PrincipalServiceAccount, "roles": caller.Roles}
+			} else if subject, err = s.authzSubject(ctx, check.Subject.ID); err != nil {
+				return nil, err
+			}
+			subjects[check.Subject.ID] = subject
+		}
+		if subject == nil {
+			out[i].Error = "unknown subject"
+			continue
+		}
+		if compiled == nil {
+			if explain {
+				out[i].Reason = "no policy published"
+			}
+			continue
+		}
+		if check.Subject.Attributes != nil {
+			subject = maps.Clone(subject)
+			subject["attributes"] = check.Subject.Attributes
+		}
+		out[i].Decision = compiled.Evaluate(policy.Request{
+			Subject:  subject,
+			Action:   check.Action,
+			Resource: check.Resource,
+			Context:  check.Context,
+		}, explain)
+	}
+	return out, nil
+}
+
+// authzSubject returns the attributes of a user as seen by policies; nil
+// for unknown or disabled users.
+func (s *Service) authzSubject(ctx context.Context, userID string) (map[string]any, error) {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil || u == nil || u.Disabled() {
+		return nil, err
+	}
+	perms, err := s.EffectivePermissions(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	orgIDs := u.OrgIDs
+	if orgIDs == nil {
+		orgIDs = []int{}
+	}
+	return map[string]any{
+		"id":             u.ID,
+		"type":           PrincipalUser,
+		"login":          u.Login,
+		"email_verified": u.EmailVerified,
+		"roles":          perms.Roles,
+		"groups":         perms.Groups,
+		"citizen_id":     u.CitizenID,
+		"org_ids":        orgIDs,
+	}, nil
+}
@@ -0,0 +1,24 @@
+package auth
+
+import "context"
+
+// ClientInfo describes the client a request came from. The HTTP layer
+// attaches it to the request context so session metadata can be recorded
+// without widening every Service method.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo returns a copy of ctx carrying info.
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// clientInfoFrom returns the ClientInfo attached to ctx, if any.
+func clientInfoFrom(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"auth_project/internal/domain"
+)
+
+var (
+	// ErrInvalidClient is returned when client authentication fails.
+	ErrInvalidClient = errors.New("invalid client")
+	errPublicClient  = errors.New("public clients have no secret")
+)
+
+// ClientRegistration describes a client provisioned from configuration.
+// Confidential clients need a Secret; public clients must not have one.
+type ClientRegistration struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Secret       string   `json:"secret"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	// ServiceAccount allows the client_credentials grant.
+	ServiceAccount bool `json:"service_account"`
+	// TokenExchange lists the audiences the client may exchange tokens for.
+	TokenExchange []domain.ExchangePolicy `json:"token_exchange"`
+}
+
+// EnsureClient creates or updates a client from reg. It is used to
+// provision clients from configuration at startup.
+func (s *Service) EnsureClient(ctx context.Context, reg ClientRegistration) error {
+	if reg.ID == "" {
+		return errors.New("client id is required")
+	}
+	if reg.Public != (reg.Secret == "") {
+		return errors.New("confidential clients need a secret, public clients must not have one")
+	}
+	if err := checkExchangePolicies(reg.TokenExchange); err != nil {
+		return err
+	}
+	c, err := s.users.GetClient(ctx, reg.ID)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		c = &domain.Client{ID: reg.ID}
+	}
+	c.Name = reg.Name
+	if c.Name == "" {
+		c.Name = reg.ID
+	}
+	c.Public = reg.Public
+	c.RedirectURIs = reg.RedirectURIs
+	c.Scopes = reg.Scopes
+	c.ServiceAccount = reg.ServiceAccount && !reg.Public
+	c.TokenExchange = reg.TokenExchange
+	c.SecretHash = ""
+	if !reg.Public {
+		if c.SecretHash, err = s.hasher.HashPassword(reg.Secret); err != nil {
+			return err
+		}
+	}
+	return s.users.SaveClient(ctx, c)
+}
+
+// AuthenticateClient verifies client credentials. Public clients are
+// identified by id alone and must not send a secret. Disabled clients
+// fail authentication.
+func (s *Service) AuthenticateClient(ctx context.Context, id, secret string) (*domain.Client, error) {
+	if id == "" {
+		return nil, ErrInvalidClient
+	}
+	c, err := s.users.GetClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || c.Disabled() {
+		return nil, ErrInvalidClient
+	}
+	if c.Public {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return c, nil
+	}
+	if secret == "" || c.SecretHash == "" {
+		return nil, ErrInvalidClient
+	}
+	if err := s.hasher.CompareHashAndPassword(c.SecretHash, secret); err != nil {
+		s.events.Publish("CLIENT_AUTH_FAILED", map[string]any{"clientID": id})
+		return nil, ErrInvalidClient
+	}
+	return c, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import "strings"
+
+// deviceName derives a friendly label such as "Chrome on Windows" from a
+// User-Agent header. It only recognizes common browsers and platforms; the
+// raw header is stored alongside for anything more precise.
+func deviceName(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+	l := strings.ToLower(ua)
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(l, "edg/"):
+		browser = "Edge"
+	case strings.Contains(l, "opr/") || strings.Contains(l, "opera"):
+		browser = "Opera"
+	case strings.Contains(l, "yabrowser"):
+		browser = "Yandex Browser"
+	case strings.Contains(l, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(l, "chrome/") || strings.Contains(l, "crios/"):
+		browser = "Chrome"
+	case strings.Contains(l, "safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(l, "curl/"):
+		return "curl"
+	case strings.Contains(l, "dotnet") || strings.Contains(l, "httpclient"):
+		return ".NET client"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(l, "android"):
+		platform = "Android"
+	case strings.Contains(l, "iphone") || strings.Contains(l, "ipad"):
+		platform = "iOS"
+	case strings.Contains(l, "windows"):
+		platform = "Windows"
+	case strings.Contains(l, "mac os"):
+		platform = "macOS"
+	case strings.Contains(l, "linux"):
+		platform = "Linux"
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/store"
+)
+
+const (
+	// deviceCodeTTL is how long the user has to enter the user code.
+	deviceCodeTTL = 10 * time.Minute
+	// deviceInterval is the minimum polling interval of the device, and
+	// deviceSlowDown the increase after each too early poll (RFC 8628
+	// section 3.5).
+	deviceInterval = 5 * time.Second
+	deviceSlowDown = 5 * time.Second
+	// userCodeAlphabet has no vowels (to avoid spelling words) and no
+	// characters easily confused with each other.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// ErrInvalidUserCode is returned for an unknown, expired or already used
+// user code.
+var ErrInvalidUserCode = errors.New("invalid or expired code")
+
+// DeviceAuthorization is the response of the device authorization
+// endpoint (RFC 8628 section 3.2).
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// PendingDevice is a device authorization awaiting the user's decision,
+// as shown on the verification page.
+type PendingDevice struct {
+	UserCode string
+	Client   *domain.Client
+	Scope    string
+}
+
+// StartDeviceAuthorization begins the device flow for client, typically a
+// CLI tool without a browser. The device shows the user code and polls
+// PollDevice with the device code.
+func (s *Service) StartDeviceAuthorization(ctx context.Context, client *domain.Client, scope string) (*DeviceAuthorization, error) {
+	scope, err := grantedScope(client, scope, OIDCScopes)
+	if err != nil {
+		return nil, err
+	}
+	deviceCode := newSecret()
+	now := time.Now()
+	rec := store.DeviceAuthorization{
+		DeviceCodeHash: hashSecret(deviceCode),
+		ClientID:       client.ID,
+		Scope:          scope,
+		Status:         store.DevicePending,
+		Interval:       deviceInterval,
+		ExpiresAt:      now.Add(deviceCodeTTL),
+		CreatedAt:      now,
+	}
+	// User codes have ~34 bits of entropy; retry on the rare collision
+	// with another pending code.
+	for attempt := 0; ; attempt++ {
+		rec.UserCode = newUserCode()
+		err = s.users.SaveDeviceAuthorization(ctx, rec)
+		if err == nil {
+			break
+		}
+		if attempt == 2 {
+			return nil, err
+		}
+	}
+	userCode := formatUserCode(rec.UserCode)
+	verification := s.publicURL + "/oauth2/device"
+	s.events.Publish("DEVICE_AUTHORIZATION_STARTED", map[string]any{"clientID": client.ID, "scope": scope})
+	return &DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verification,
+		VerificationURIComplete: verification + "?user_code=" + url.QueryEscape(userCode),
+		ExpiresIn:               int(deviceCodeTTL / time.Second),
+		Interval:                int(deviceInterval / time.Second),
+	}, nil
+}
+
+// PendingDeviceAuthorization looks up a pending authorization by the code
+// the user typed in. It returns ErrInvalidUserCode if there is none.
+func (s *Service) PendingDeviceAuthorization(ctx context.Context, userCode string) (*PendingDevice, error) {
+	rec, err := s.users.FindDeviceAuthorization(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.Status != store.DevicePending {
+		return nil, ErrInvalidUserCode
+	}
+	client, err := s.users.GetClient(ctx, rec.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil || client.Disabled() {
+		return nil, ErrInvalidUserCode
+	}
+	return &PendingDevice{UserCode: formatUserCode(rec.UserCode), Client: client, Scope: rec.Scope}, nil
+}
+
+// ApproveDevice grants the device authorization to the user signed in by
+// sso. The device receives tokens on its next poll.
+func (s *Service) ApproveDevice(ctx context.Context, sso, userCode string) error {
+	u, claims, err := s.SSOUser(ctx, sso)
+	if err != nil {
+		return err
+	}
+	ok, err := s.users.DecideDeviceAuthorization(ctx, normalizeUserCode(userCode), u.ID, claims.SessionID, claims.AMR)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidUserCode
+	}
+	s.events.Publish("DEVICE_AUTHORIZATION_APPROVED", map[string]any{"userID": u.ID})
+	return nil
+}
+
+// DenyDevice rejects the device authorization. Like the Deny button of
+// the authorization page it needs no sign-in: only the user who sees the
+// code on the device can enter it.
+func (s *Service) DenyDevice(ctx context.Context, userCode string) error {
+	ok, err := s.users.DecideDeviceAuthorization(ctx, normalizeUserCode(userCode), "", "", nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidUserCode
+	}
+	s.events.Publish("DEVICE_AUTHORIZATION_DENIED", map[string]any{"userCode": normalizeUserCode(userCode)})
+	return nil
+}
+
+// PollDevice is the token request of the device
+// (grant_type=urn:ietf:params:oauth:grant-type:device_code). Until the
+// user decides it fails with authorization_pending, or slow_down when the
+// device polls faster than the interval.
+func (s *Service) PollDevice(ctx context.Context, client *domain.Client, deviceCode string) (*GrantedTokens, error) {
+	hash := hashSecret(deviceCode)
+	now := time.Now()
+	rec, tooFast, err := s.users.PollDeviceAuthorization(ctx, hash, now, deviceSlowDown)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.ClientID != client.ID {
+		return nil, oauthError("invalid_grant", "invalid device code")
+	}
+	if !now.Before(rec.ExpiresAt) {
+		return nil, oauthError("expired_token", "the device code has expired")
+	}
+	switch rec.Status {
+	case store.DeviceDenied:
+		return nil, oauthError("access_denied", "the user denied the request")
+	case store.DevicePending:
+		if tooFast {
+			return nil, oauthError("slow_down", "")
+		}
+		return nil, oauthError("authorization_pending", "")
+	}
+
+	// Approved: the device code is single use.
+	ok, err := s.users.DeleteDeviceAuthorization(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, oauthError("invalid_grant", "invalid device code")
+	}
+	sess, err := s.users.GetSession(ctx, rec.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if sess == nil || sess.RevokedAt != nil {
+		return nil, oauthError("invalid_grant", "session has ended")
+	}
+	g := grant{SessionID: rec.SessionID, AMR: rec.AMR, ClientID: client.ID, Scope: rec.Scope, JKT: dpopKeyFrom(ctx)}
+	tokens, err := s.issueTokens(ctx, rec.UserID, g)
+	if err != nil {
+		if errors.Is(err, ErrAccountDisabled) {
+			return nil, oauthError("invalid_grant", err.Error())
+		}
+		return nil, err
+	}
+	idToken, err := s.idToken(ctx, rec.UserID, g, "", sess.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish("LOGIN_SUCCESS", map[string]any{"userID": rec.UserID, "clientID": client.ID, "device": true})
+	return &GrantedTokens{Tokens: tokens, Scope: g.Scope, IDToken: idToken}, nil
+}
+
+// newUserCode returns a random code of userCodeLength letters.
+func newUserCode() string {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	b := make([]byte, userCodeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic("crypto/rand: " + err.Error())
+		}
+		b[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+// formatUserCode splits a normalized code in two halves for display.
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode upper-cases the code the user typed in and drops
+// separators and spaces.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/ldapauth"
+	"auth_project/internal/store"
+)
+
+// WithLDAPDomain routes logins whose identifier ends with suffix (e.g.
+// "@corp" for "jdoe@corp") to an LDAP directory instead of the local
+// password check. The part before the suffix is the directory username.
+func WithLDAPDomain(suffix string, a *ldapauth.Authenticator) Option {
+	return func(s *Service) {
+		if s.directories == nil {
+			s.directories = make(map[string]*ldapauth.Authenticator)
+		}
+		s.directories[strings.ToLower(suffix)] = a
+	}
+}
+
+// DirectoryIdentifier reports whether ident is routed to an LDAP
+// directory.
+func (s *Service) DirectoryIdentifier(ident string) bool {
+	dir, _, _ := s.directoryFor(ident)
+	return dir != nil
+}
+
+// directoryFor returns the directory ident is routed to, with the
+// directory username and the matching suffix. The longest suffix wins.
+func (s *Service) directoryFor(ident string) (dir *ldapauth.Authenticator, username, suffix string) {
+	ident = strings.ToLower(ident)
+	for sfx, d := range s.directories {
+		if len(sfx) > len(suffix) && len(ident) > len(sfx) && strings.HasSuffix(ident, sfx) {
+			dir, suffix = d, sfx
+		}
+	}
+	if dir == nil {
+		return nil, "", ""
+	}
+	return dir, strings.TrimSuffix(ident, suffix), suffix
+}
+
+// directoryAuthenticate checks the password in the directory and returns
+// the linked user, provisioning one on first login. Roles mapped from
+// directory groups are synchronised on every login.
+func (s *Service) directoryAuthenticate(ctx context.Context, dir *ldapauth.Authenticator, suffix, username, plaintext string) (*domain.User, error) {
+	ident := username + suffix
+	entry, err := dir.Authenticate(username, plaintext)
+	if err != nil {
+		msg := "incorrect password"
+		if !errors.Is(err, ldapauth.ErrInvalidCredentials) {
+			msg = err.Error()
+		}
+		s.events.Publish("LOGIN_FAILED", map[string]any{"login": ident, "error": msg})
+		return nil, ErrAuthFailed
+	}
+
+	now := time.Now()
+	acc, err := s.users.FindDirectoryAccount(ctx, suffix, username)
+	if err != nil {
+		return nil, err
+	}
+	var u *domain.User
+	if acc != nil {
+		if u, err = s.users.FindByID(ctx, acc.UserID); err != nil {
+			return nil, err
+		}
+	}
+	if u == nil {
+		if u, err = s.directoryUser(ctx, entry, ident, username); err != nil {
+			s.events.Publish("LOGIN_FAILED", map[string]any{"login": ident, "error": err.Error()})
+			return nil, ErrAuthFailed
+		}
+		acc = &store.DirectoryAccount{Domain: suffix, Username: username, CreatedAt: now}
+	}
+	acc.UserID, acc.DN, acc.LastLoginAt = u.ID, entry.DN, now
+	if err := s.users.SaveDirectoryAccount(ctx, *acc); err != nil {
+		return nil, err
+	}
+
+	// the directory decides about the roles of its group mapping; other
+	// roles are left alone
+	managed := dir.MappedRoles()
+	roles := make([]string, 0, len(u.Roles)+len(entry.Roles))
+	for _, r := range u.Roles {
+		if !slices.Contains(managed, r) {
+			roles = append(roles, r)
+		}
+	}
+	roles = append(roles, entry.Roles...)
+	if !sameRoles(roles, u.Roles) {
+		if err := s.users.SetUserRoles(ctx, u.ID, roles); err != nil {
+			return nil, err
+		}
+		s.events.Publish("USER_ROLES_SYNCED", map[string]any{"userID": u.ID, "roles": roles})
+		u.Roles = roles
+	}
+	return u, nil
+}
+
+// directoryUser links a directory user seen for the first time to the
+// local user with the same verified email, or provisions a new one. The
+// directory's email is trusted; without one ident is used.
+func (s *Service) directoryUser(ctx context.Context, entry *ldapauth.Entry, ident, username string) (*domain.User, error) {
+	email, verified := entry.Email, entry.Email != ""
+	if email == "" {
+		email = ident
+	}
+	existing, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if !verified || !existing.EmailVerified {
+			return nil, ErrFederatedEmailTaken
+		}
+		return existing, nil
+	}
+	// invite-only registration does not apply: the directory is
+	// authoritative for who has an account, like an invitation would be
+	u, err := s.provisionUser(ctx, username, email, verified)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish("USER_REGISTERED", map[string]any{"userID": u.ID, "email": u.Email, "directory": entry.DN})
+	return u, nil
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"auth_project/internal/jwt"
+	"auth_project/internal/ldapauth"
+	"auth_project/internal/ldapauth/ldaptest"
+	"auth_project/internal/password"
+	"auth_project/internal/store"
+)
+
+func TestDirectoryLoginSyncsGroupRoles(t *testing.T) {
+	ctx := context.Background()
+	const bobDN = "uid=bob,ou=people,dc=corp"
+	dir := ldaptest.NewServer(t, ldaptest.User{UID: "bob", DN: bobDN, Password: "bob-pw",
+		Groups: []string{"cn=admins,ou=groups,dc=corp", "cn=eng,ou=groups,dc=corp"}})
+	a, err := ldapauth.New(ldapauth.Config{
+		URL:        dir.URL,
+		UserDN:     "uid=%s,ou=people,dc=corp",
+		GroupRoles: map[string]string{"admins": "admin", "eng": "engineer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := store.NewMemStore()
+	tokens := jwt.New("test-secret", "auth_service", time.Minute, time.Hour)
+	svc := New(users, password.Argon2idHasher{}, tokens, nopPublisher{}, WithLDAPDomain("@corp", a))
+	roles := func() []string {
+		u, err := users.FindByEmail(ctx, "bob@corp")
+		if err != nil || u == nil {
+			t.Fatalf("FindByEmail: %v", err)
+		}
+		return u.Roles
+	}
+
+	if _, err := svc.Login(ctx, "bob@corp", "bob-pw"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if got := roles(); !sameRoles(got, []string{"admin", "engineer"}) {
+		t.Fatalf("roles after first login = %v", got)
+	}
+	u, _ := users.FindByEmail(ctx, "bob@corp")
+	if err := users.SetUserRoles(ctx, u.ID, append(u.Roles, "auditor")); err != nil {
+		t.Fatal(err)
+	}
+
+	// leaving a group revokes its role; local roles stay
+	dir.SetGroups(bobDN, "cn=eng,ou=groups,dc=corp")
+	if _, err := svc.Login(ctx, "bob@corp", "bob-pw"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if got := roles(); !sameRoles(got, []string{"engineer", "auditor"}) {
+		t.Fatalf("roles after leaving admins = %v", got)
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// dpopProofWindow is how old (or, for clock skew, how far in the future) a
+// DPoP proof may be. Proof ids are remembered for as long.
+const dpopProofWindow = time.Minute
+
+var (
+	// ErrDPoPRequired is returned when a token bound to a DPoP key is used
+	// without a proof.
+	ErrDPoPRequired = errors.New("DPoP proof required")
+	// ErrDPoPKeyMismatch is returned when the proof is signed by another
+	// key than the one the token is bound to.
+	ErrDPoPKeyMismatch = errors.New("DPoP proof key does not match the token")
+)
+
+type dpopKey struct{}
+
+// WithDPoPKey returns a copy of ctx carrying the thumbprint of a verified
+// DPoP proof of a token request. Tokens issued within ctx are bound to it.
+func WithDPoPKey(ctx context.Context, jkt string) context.Context {
+	return context.WithValue(ctx, dpopKey{}, jkt)
+}
+
+// dpopKeyFrom returns the DPoP key thumbprint attached to ctx, if any.
+func dpopKeyFrom(ctx context.Context) string {
+	jkt, _ := ctx.Value(dpopKey{}).(string)
+	return jkt
+}
+
+// VerifyDPoP checks the DPoP proof of a token request sent with method to
+// uri and returns the thumbprint of its key.
+func (s *Service) VerifyDPoP(proof, method, uri string) (string, error) {
+	p, err := s.dpop.Verify(proof, method, uri, "")
+	if err != nil {
+		return "", err
+	}
+	return p.JKT, nil
+}
+
+// CheckDPoP verifies that a request using accessToken carries a fresh
+// proof, signed with the key the token is bound to (jkt), for method and
+// uri. Tokens that are not bound (empty jkt) need no proof.
+func (s *Service) CheckDPoP(jkt, accessToken, proof, method, uri string) error {
+	if jkt == "" {
+		return nil
+	}
+	if proof == "" {
+		return ErrDPoPRequired
+	}
+	p, err := s.dpop.Verify(proof, method, uri, accessToken)
+	if err != nil {
+		return err
+	}
+	if p.JKT != jkt {
+		return ErrDPoPKeyMismatch
+	}
+	return nil
+}
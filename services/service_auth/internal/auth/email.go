@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth_project/internal/mail"
+	"auth_project/internal/store"
+)
+
+// emailChangeTTL bounds how long confirmation and cancel links stay valid.
+const emailChangeTTL = 24 * time.Hour
+
+var (
+	// ErrEmailTaken is returned when the requested address belongs to
+	// another account.
+	ErrEmailTaken = errors.New("email already in use")
+	// ErrInvalidLink is returned for unknown, used or expired email links.
+	ErrInvalidLink = errors.New("invalid or expired link")
+)
+
+// RequestEmailChange records a pending email change for the user. A
+// confirmation link goes to the new address and a notification with a
+// cancel link goes to the old one; users.email is only swapped once the
+// new address is confirmed. The current password is required.
+func (s *Service) RequestEmailChange(ctx context.Context, userID, newEmail, plaintext string) error {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return errors.New("user not found")
+	}
+	if err := s.hasher.CompareHashAndPassword(u.PasswordHash, plaintext); err != nil {
+		return ErrAuthFailed
+	}
+	newEmail = strings.TrimSpace(newEmail)
+	if strings.EqualFold(newEmail, u.Email) {
+		return errors.New("new email matches the current one")
+	}
+	existing, err := s.users.FindByEmail(ctx, newEmail)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrEmailTaken
+	}
+
+	confirm, cancelTok := newSecret(), newSecret()
+	now := time.Now()
+	rec := store.EmailChangeRecord{
+		UserID:      u.ID,
+		NewEmail:    newEmail,
+		ConfirmHash: hashSecret(confirm),
+		CancelHash:  hashSecret(cancelTok),
+		ExpiresAt:   now.Add(emailChangeTTL),
+		CreatedAt:   now,
+	}
+	if err := s.users.SaveEmailChange(ctx, rec); err != nil {
+		return err
+	}
+
+	if err := s.mailer.Send(ctx, mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Someone asked to use this address for the account %q.\n\n"+
+			"Confirm the change: %s\n\nThe link expires in %s.\n",
+			u.Login, s.link("/auth/email/confirm", confirm), emailChangeTTL),
+	}); err != nil {
+		return err
+	}
+	if err := s.mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("A change of the email address of your account to %s was requested.\n\n"+
+			"If this wasn't you, cancel it: %s\n",
+			newEmail, s.link("/auth/email/cancel", cancelTok)),
+	}); err != nil {
+		return err
+	}
+	s.events.Publish("EMAIL_CHANGE_REQUESTED", map[string]any{"userID": u.ID, "newEmail": newEmail})
+	return nil
+}
+
+// ConfirmEmailChange applies the pending change identified by the
+// confirmation token sent to the new address.
+func (s *Service) ConfirmEmailChange(ctx context.Context, token string) error {
+	rec, err := s.users.FindEmailChangeByConfirmHash(ctx, hashSecret(token))
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return ErrInvalidLink
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		_ = s.users.DeleteEmailChange(ctx, rec.UserID)
+		return ErrInvalidLink
+	}
+	u, err := s.users.FindByID(ctx, rec.UserID)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return ErrInvalidLink
+	}
+	if err := s.users.ApplyEmailChange(ctx, rec.UserID, rec.NewEmail); err != nil {
+		if errors.Is(err, store.ErrEmailTaken) {
+			_ = s.users.DeleteEmailChange(ctx, rec.UserID)
+			return ErrEmailTaken
+		}
+		return err
+	}
+	s.events.Publish("EMAIL_CHANGED", map[string]any{"userID": u.ID, "oldEmail": u.Email, "newEmail": rec.NewEmail})
+	return nil
+}
+
+// CancelEmailChange drops the pending change identified by the cancel token
+// sent to the old address.
+func (s *Service) CancelEmailChange(ctx context.Context, token string) error {
+	rec, err := s.users.FindEmailChangeByCancelHash(ctx, hashSecret(token))
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return ErrInvalidLink
+	}
+	if err := s.users.DeleteEmailChange(ctx, rec.UserID); err != nil {
+		return err
+	}
+	s.events.Publish("EMAIL_CHANGE_CANCELLED", map[string]any{"userID": rec.UserID, "newEmail": rec.NewEmail})
+	return nil
+}
+
+// link builds an absolute URL to path on this service carrying token.
+func (s *Service) link(path, token string) string {
+	return s.publicURL + path + "?token=" + url.QueryEscape(token)
+}
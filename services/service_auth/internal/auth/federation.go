@@ -0,0 +1,433 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+// federationStateTTL bounds the time a user may spend at the identity
+// provider.
+const federationStateTTL = 10 * time.Minute
+
+var (
+	// ErrUnknownProvider is returned for missing or disabled identity
+	// providers.
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrFederationFailed is wrapped by errors of the upstream login: a
+	// bad state, a failed code exchange or an invalid id_token.
+	ErrFederationFailed = errors.New("federated login failed")
+	// ErrFederatedEmailTaken is returned when the upstream account's email
+	// belongs to a local user it cannot be linked to automatically.
+	ErrFederatedEmailTaken = errors.New("email is already used by another account")
+)
+
+// providerIDPattern restricts identity provider ids to URL-safe slugs.
+var providerIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// IdentityProviderRegistration describes an identity provider created or
+// updated by an admin. An empty ClientSecret keeps the stored one.
+type IdentityProviderRegistration struct {
+	ID           string              `json:"id"`
+	Name         string              `json:"name"`
+	Issuer       string              `json:"issuer"`
+	ClientID     string              `json:"client_id"`
+	ClientSecret string              `json:"client_secret"`
+	Scopes       []string            `json:"scopes"`
+	Claims       domain.ClaimMapping `json:"claims"`
+	Disabled     bool                `json:"disabled"`
+}
+
+// ListIdentityProviders returns all identity providers.
+func (s *Service) ListIdentityProviders(ctx context.Context) ([]domain.IdentityProvider, error) {
+	return s.users.ListIdentityProviders(ctx)
+}
+
+// EnabledIdentityProviders returns the providers users can sign in with.
+func (s *Service) EnabledIdentityProviders(ctx context.Context) ([]domain.IdentityProvider, error) {
+	all, err := s.users.ListIdentityProviders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.IdentityProvider, 0, len(all))
+	for _, p := range all {
+		if !p.Disabled() {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// SaveIdentityProvider creates or updates an identity provider. The
+// issuer's discovery document is fetched to catch configuration mistakes
+// early.
+func (s *Service) SaveIdentityProvider(ctx context.Context, reg IdentityProviderRegistration) (*domain.IdentityProvider, error) {
+	if s.box == nil {
+		return nil, ErrMFANotConfigured
+	}
+	if !providerIDPattern.MatchString(reg.ID) {
+		return nil, errors.New("provider id must be a lowercase slug")
+	}
+	if reg.ClientID == "" {
+		return nil, errors.New("client_id is required")
+	}
+	if u, err := url.Parse(reg.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.New("issuer must be an absolute URL")
+	}
+	p, err := s.users.GetIdentityProvider(ctx, reg.ID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		p = &domain.IdentityProvider{ID: reg.ID, Protocol: domain.ProtocolOIDC}
+	}
+	if p.SAML() {
+		return nil, fmt.Errorf("provider %s is a SAML provider", p.ID)
+	}
+	if reg.ClientSecret != "" {
+		if p.ClientSecretEnc, err = s.box.Seal([]byte(reg.ClientSecret)); err != nil {
+			return nil, err
+		}
+	}
+	if p.ClientSecretEnc == "" {
+		return nil, errors.New("client_secret is required")
+	}
+	if _, err := s.federation.Discover(ctx, reg.Issuer); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+	p.Name = reg.Name
+	if p.Name == "" {
+		p.Name = reg.ID
+	}
+	p.Issuer = reg.Issuer
+	p.ClientID = reg.ClientID
+	p.Scopes = reg.Scopes
+	p.Claims = reg.Claims
+	switch {
+	case !reg.Disabled:
+		p.DisabledAt = nil
+	case p.DisabledAt == nil:
+		now := time.Now()
+		p.DisabledAt = &now
+	}
+	if err := s.users.SaveIdentityProvider(ctx, p); err != nil {
+		return nil, err
+	}
+	s.events.Publish("IDENTITY_PROVIDER_SAVED", map[string]any{"providerID": p.ID, "issuer": p.Issuer})
+	return p, nil
+}
+
+// DeleteIdentityProvider removes an identity provider and the links of
+// users to it. The users themselves are kept.
+func (s *Service) DeleteIdentityProvider(ctx context.Context, id string) error {
+	if err := s.users.DeleteIdentityProvider(ctx, id); err != nil {
+		return err
+	}
+	s.events.Publish("IDENTITY_PROVIDER_DELETED", map[string]any{"providerID": id})
+	return nil
+}
+
+// StartFederatedLogin begins a login at the identity provider and returns
+// the URL to redirect the browser to, and the state the browser must bring
+// back (the HTTP layer keeps it in a cookie to bind the callback to the
+// browser that started the login).
+func (s *Service) StartFederatedLogin(ctx context.Context, providerID string) (authURL, state string, err error) {
+	p, err := s.enabledProvider(ctx, providerID, domain.ProtocolOIDC)
+	if err != nil {
+		return "", "", err
+	}
+	state, nonce, verifier := newSecret(), newSecret(), newSecret()
+	authURL, err = s.federation.AuthCodeURL(ctx, p.Issuer, p.ClientID, s.federationRedirectURI(p.ID), p.Scopes, state, nonce, verifier)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+	st := store.FederationState{
+		StateHash:    hashSecret(state),
+		ProviderID:   p.ID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(federationStateTTL),
+	}
+	if err := s.users.SaveFederationState(ctx, st); err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// CompleteFederatedLogin finishes a login when the identity provider
+// redirects back with code. The upstream account is matched by its link,
+// then by verified email; otherwise a user is created. Login then
+// proceeds as with a password, including MFA.
+func (s *Service) CompleteFederatedLogin(ctx context.Context, providerID, state, code string) (*jwt.Tokens, error) {
+	p, err := s.enabledProvider(ctx, providerID, domain.ProtocolOIDC)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.users.ConsumeFederationState(ctx, hashSecret(state))
+	if err != nil {
+		return nil, err
+	}
+	if st == nil || st.ProviderID != p.ID {
+		return nil, fmt.Errorf("%w: invalid or expired state", ErrFederationFailed)
+	}
+	secret, err := s.box.Open(p.ClientSecretEnc)
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, err := s.federation.Exchange(ctx, p.Issuer, p.ClientID, string(secret), s.federationRedirectURI(p.ID), code, st.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+	claims, err := s.federation.VerifyIDToken(ctx, p.Issuer, p.ClientID, rawIDToken, st.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+	u, err := s.federatedUser(ctx, p, claims)
+	if err != nil {
+		s.events.Publish("LOGIN_FAILED", map[string]any{"providerID": p.ID, "error": err.Error()})
+		return nil, err
+	}
+	s.events.Publish("FEDERATED_LOGIN", map[string]any{"userID": u.ID, "providerID": p.ID})
+	return s.completeLogin(ctx, u, []string{"fed"})
+}
+
+// enabledProvider returns the provider of the protocol or
+// ErrUnknownProvider.
+func (s *Service) enabledProvider(ctx context.Context, providerID, protocol string) (*domain.IdentityProvider, error) {
+	if protocol == domain.ProtocolOIDC && s.box == nil {
+		return nil, ErrMFANotConfigured
+	}
+	p, err := s.users.GetIdentityProvider(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil || p.Disabled() || p.SAML() != (protocol == domain.ProtocolSAML) {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// federationRedirectURI is our callback registered at the provider.
+func (s *Service) federationRedirectURI(providerID string) string {
+	return s.publicURL + "/auth/federated/" + providerID + "/callback"
+}
+
+// federatedUser finds, links or creates the user of an upstream account
+// and synchronises the roles mapped from its groups.
+func (s *Service) federatedUser(ctx context.Context, p *domain.IdentityProvider, claims map[string]any) (*domain.User, error) {
+	m := p.Claims
+	sub, _ := claims["sub"].(string)
+	email, _ := claims[claimName(m.Email, "email")].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	verified := m.TrustEmail
+	switch v := claims[claimName(m.EmailVerified, "email_verified")].(type) {
+	case bool:
+		verified = verified || v
+	case string:
+		// some providers send the claim as a string
+		verified = verified || v == "true"
+	}
+
+	now := time.Now()
+	link, err := s.users.FindFederatedIdentity(ctx, p.ID, sub)
+	if err != nil {
+		return nil, err
+	}
+	var u *domain.User
+	if link != nil {
+		if u, err = s.users.FindByID(ctx, link.UserID); err != nil {
+			return nil, err
+		}
+	}
+	if u == nil {
+		if email == "" {
+			return nil, fmt.Errorf("%w: the provider did not return an email", ErrFederationFailed)
+		}
+		existing, err := s.users.FindByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case existing == nil:
+			if u, err = s.createFederatedUser(ctx, p, claims, email, verified); err != nil {
+				return nil, err
+			}
+		case verified && existing.EmailVerified:
+			// both sides proved ownership of the address
+			u = existing
+		default:
+			return nil, ErrFederatedEmailTaken
+		}
+		link = &domain.FederatedIdentity{ProviderID: p.ID, Subject: sub, UserID: u.ID, CreatedAt: now}
+		s.events.Publish("FEDERATED_IDENTITY_LINKED", map[string]any{"userID": u.ID, "providerID": p.ID, "subject": sub})
+	}
+	link.Email, link.LastLoginAt = email, now
+	if err := s.users.SaveFederatedIdentity(ctx, *link); err != nil {
+		return nil, err
+	}
+
+	// the provider decides about the roles of its group mapping on every
+	// login, as directories do; other roles are left alone
+	if len(m.Roles) > 0 && m.Groups != "" {
+		managed := slices.Collect(maps.Values(m.Roles))
+		roles := make([]string, 0, len(u.Roles))
+		for _, r := range u.Roles {
+			if !slices.Contains(managed, r) {
+				roles = append(roles, r)
+			}
+		}
+		for _, r := range mappedRoles(m, claims) {
+			if !slices.Contains(roles, r) {
+				roles = append(roles, r)
+			}
+		}
+		if !sameRoles(roles, u.Roles) {
+			if err := s.users.SetUserRoles(ctx, u.ID, roles); err != nil {
+				return nil, err
+			}
+			s.events.Publish("USER_ROLES_SYNCED", map[string]any{"userID": u.ID, "roles": roles, "providerID": p.ID})
+			u.Roles = roles
+		}
+	}
+	return u, nil
+}
+
+// createFederatedUser provisions a user for an upstream account. While
+// registration is invite-only, the user's email needs a pending invitation,
+// which is used up and grants its roles and organizations.
+func (s *Service) createFederatedUser(ctx context.Context, p *domain.IdentityProvider, claims map[string]any, email string, verified bool) (*domain.User, error) {
+	var inv *store.Invitation
+	if s.inviteOnly {
+		var err error
+		if inv, err = s.invitationFor(ctx, email, verified); err != nil {
+			return nil, err
+		}
+	}
+	preferred, _ := claims[claimName(p.Claims.Login, "preferred_username")].(string)
+	if preferred == "" {
+		preferred, _, _ = strings.Cut(email, "@")
+	}
+	u, err := s.provisionUser(ctx, preferred, email, verified)
+	if err != nil {
+		return nil, err
+	}
+	if inv != nil {
+		if err := s.acceptInvitation(ctx, inv, u); err != nil {
+			return nil, err
+		}
+	}
+	s.events.Publish("USER_REGISTERED", map[string]any{"userID": u.ID, "email": u.Email, "providerID": p.ID})
+	return u, nil
+}
+
+// provisionUser creates a user for an account of an external identity
+// source, with a free login derived from name. The user gets an unusable
+// random password and can set one via password reset.
+func (s *Service) provisionUser(ctx context.Context, name, email string, verified bool) (*domain.User, error) {
+	hashed, err := s.hasher.HashPassword(newSecret())
+	if err != nil {
+		return nil, err
+	}
+	base := externalLogin(name)
+	for i := 1; i < 100; i++ {
+		login := base
+		if i > 1 {
+			login = base + strconv.Itoa(i)
+		}
+		found, err := s.users.FindByLogin(ctx, login)
+		if err != nil {
+			return nil, err
+		}
+		if found.ID != "" {
+			continue
+		}
+		u := &domain.User{ID: generateID(), Login: login, Email: email, PasswordHash: hashed}
+		if err := s.users.CreateUser(ctx, u); err != nil {
+			return nil, err
+		}
+		if verified {
+			if err := s.users.MarkEmailVerified(ctx, u.ID); err != nil {
+				return nil, err
+			}
+			u.EmailVerified = true
+		}
+		return u, nil
+	}
+	return nil, fmt.Errorf("no free login for %q", base)
+}
+
+// externalLogin derives a login matching the registration rules
+// (3–30 alphanumerics) from an external user name.
+func externalLogin(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	login := b.String()
+	if len(login) > 24 {
+		login = login[:24]
+	}
+	if len(login) < 3 {
+		login = "user" + login
+	}
+	return login
+}
+
+// mappedRoles returns the roles granted by the provider's group mapping.
+func mappedRoles(m domain.ClaimMapping, claims map[string]any) []string {
+	if m.Groups == "" || len(m.Roles) == 0 {
+		return nil
+	}
+	var groups []string
+	switch v := claims[m.Groups].(type) {
+	case string:
+		groups = strings.Fields(v)
+	case []any:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	var roles []string
+	for _, g := range groups {
+		if r, ok := m.Roles[g]; ok {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+// sameRoles reports whether a and b hold the same roles in any order.
+func sameRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, r := range a {
+		if !slices.Contains(b, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimName returns name, or def when the mapping leaves it empty.
+func claimName(name, def string) string {
+	if name == "" {
+		return def
+	}
+	return name
+}
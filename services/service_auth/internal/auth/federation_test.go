@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/federation/oidctest"
+	"auth_project/internal/jwt"
+	"auth_project/internal/password"
+	"auth_project/internal/seal"
+	"auth_project/internal/store"
+)
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(string, any) error { return nil }
+
+// federationFixture is a service with the OIDC identity provider "idp"
+// backed by a fake provider.
+type federationFixture struct {
+	svc   *Service
+	users *store.MemStore
+	idp   *oidctest.Provider
+}
+
+func newFederationFixture(t *testing.T, opts ...Option) *federationFixture {
+	t.Helper()
+	box, err := seal.New("test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := store.NewMemStore()
+	tokens := jwt.New("test-secret", "auth_service", time.Minute, time.Hour)
+	svc := New(users, password.Argon2idHasher{}, tokens, nopPublisher{}, append([]Option{WithSecretBox(box)}, opts...)...)
+	idp := oidctest.NewProvider(t)
+	if _, err := svc.SaveIdentityProvider(context.Background(), IdentityProviderRegistration{
+		ID:           "idp",
+		Issuer:       idp.Issuer,
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+	}); err != nil {
+		t.Fatalf("SaveIdentityProvider: %v", err)
+	}
+	return &federationFixture{svc: svc, users: users, idp: idp}
+}
+
+// start begins a login and returns the code and state the browser brings
+// back from the provider.
+func (f *federationFixture) start(t *testing.T) (code, state string) {
+	t.Helper()
+	authURL, state, err := f.svc.StartFederatedLogin(context.Background(), "idp")
+	if err != nil {
+		t.Fatalf("StartFederatedLogin: %v", err)
+	}
+	code, returned, err := f.idp.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if returned != state {
+		t.Fatalf("provider returned state %q, want %q", returned, state)
+	}
+	return code, state
+}
+
+// login signs in at the provider with claims.
+func (f *federationFixture) login(t *testing.T, claims map[string]any) error {
+	t.Helper()
+	f.idp.SetClaims(claims)
+	code, state := f.start(t)
+	_, err := f.svc.CompleteFederatedLogin(context.Background(), "idp", state, code)
+	return err
+}
+
+func TestFederatedLogin(t *testing.T) {
+	f := newFederationFixture(t)
+	f.idp.SetClaims(map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+
+	code, state := f.start(t)
+	if _, err := f.svc.CompleteFederatedLogin(context.Background(), "idp", state, code); err != nil {
+		t.Fatalf("CompleteFederatedLogin: %v", err)
+	}
+	u, err := f.users.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil || u == nil {
+		t.Fatalf("no user provisioned: %v", err)
+	}
+	if !u.EmailVerified {
+		t.Error("verified upstream email is not marked verified")
+	}
+}
+
+func TestFederatedLoginStateMismatch(t *testing.T) {
+	ctx := context.Background()
+	f := newFederationFixture(t)
+	f.idp.SetClaims(map[string]any{"sub": "alice", "email": "alice@example.com"})
+
+	code, state := f.start(t)
+	if _, err := f.svc.CompleteFederatedLogin(ctx, "idp", state+"x", code); !errors.Is(err, ErrFederationFailed) {
+		t.Fatalf("CompleteFederatedLogin with another state: error = %v, want ErrFederationFailed", err)
+	}
+
+	// a state is consumed by its callback
+	code, state = f.start(t)
+	if _, err := f.svc.CompleteFederatedLogin(ctx, "idp", state, code); err != nil {
+		t.Fatalf("CompleteFederatedLogin: %v", err)
+	}
+	code, _ = f.start(t)
+	if _, err := f.svc.CompleteFederatedLogin(ctx, "idp", state, code); !errors.Is(err, ErrFederationFailed) {
+		t.Fatalf("CompleteFederatedLogin with a used state: error = %v, want ErrFederationFailed", err)
+	}
+}
+
+func TestFederatedLoginSyncsMappedRoles(t *testing.T) {
+	ctx := context.Background()
+	f := newFederationFixture(t)
+	if _, err := f.svc.SaveIdentityProvider(ctx, IdentityProviderRegistration{
+		ID:       "idp",
+		Issuer:   f.idp.Issuer,
+		ClientID: f.idp.ClientID,
+		Claims: domain.ClaimMapping{
+			Groups: "groups",
+			Roles:  map[string]string{"admins": domain.RoleAdmin, "staff": "staff"},
+		},
+	}); err != nil {
+		t.Fatalf("SaveIdentityProvider: %v", err)
+	}
+	claims := func(groups ...any) map[string]any {
+		return map[string]any{"sub": "alice", "email": "alice@example.com", "groups": groups}
+	}
+	roles := func() []string {
+		u, err := f.users.FindByEmail(ctx, "alice@example.com")
+		if err != nil || u == nil {
+			t.Fatalf("FindByEmail: %v", err)
+		}
+		return u.Roles
+	}
+
+	if err := f.login(t, claims("admins", "staff")); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if got := roles(); !sameRoles(got, []string{domain.RoleAdmin, "staff"}) {
+		t.Fatalf("roles after first login = %v", got)
+	}
+	u, _ := f.users.FindByEmail(ctx, "alice@example.com")
+	if err := f.users.SetUserRoles(ctx, u.ID, append(u.Roles, "auditor")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the admins group was withdrawn upstream; the local role stays
+	if err := f.login(t, claims("staff")); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if got := roles(); !sameRoles(got, []string{"staff", "auditor"}) {
+		t.Fatalf("roles after the group was withdrawn = %v", got)
+	}
+	if err := f.login(t, claims()); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if got := roles(); !sameRoles(got, []string{"auditor"}) {
+		t.Fatalf("roles without groups = %v", got)
+	}
+}
+
+func TestFederatedLoginInviteOnly(t *testing.T) {
+	ctx := context.Background()
+	f := newFederationFixture(t, WithInviteOnly(true))
+	alice := map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+
+	if err := f.login(t, alice); !errors.Is(err, ErrInvitationRequired) {
+		t.Fatalf("login without invitation: error = %v, want ErrInvitationRequired", err)
+	}
+	if u, _ := f.users.FindByEmail(ctx, "alice@example.com"); u != nil {
+		t.Fatal("user provisioned without invitation")
+	}
+
+	inv := &store.Invitation{ID: "inv-1", Email: "Alice@Example.com", Roles: []string{"staff"}, InvitedBy: "admin", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := f.users.CreateInvitation(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+	unverified := map[string]any{"sub": "alice", "email": "alice@example.com"}
+	if err := f.login(t, unverified); !errors.Is(err, ErrInvitationRequired) {
+		t.Fatalf("login with an unverified email: error = %v, want ErrInvitationRequired", err)
+	}
+
+	if err := f.login(t, alice); err != nil {
+		t.Fatalf("login with invitation: %v", err)
+	}
+	u, err := f.users.FindByEmail(ctx, "alice@example.com")
+	if err != nil || u == nil {
+		t.Fatalf("no user provisioned: %v", err)
+	}
+	if !sameRoles(u.Roles, []string{"staff"}) {
+		t.Errorf("roles = %v, want the invitation's", u.Roles)
+	}
+	if got, _ := f.users.GetInvitation(ctx, "inv-1"); got.AcceptedAt == nil || got.UserID != u.ID {
+		t.Errorf("invitation not accepted by the user: %+v", got)
+	}
+
+	// the linked account signs in again; the invitation cannot be reused
+	if err := f.login(t, alice); err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	other := map[string]any{"sub": "alice-2", "email": "ALICE@example.com", "email_verified": true}
+	if err := f.users.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.login(t, other); !errors.Is(err, ErrInvitationRequired) {
+		t.Fatalf("login with a used invitation: error = %v, want ErrInvitationRequired", err)
+	}
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+// impersonationTTL bounds an impersonation; the token cannot be refreshed.
+const impersonationTTL = 15 * time.Minute
+
+var (
+	// ErrImpersonationForbidden is returned when the target may not be
+	// impersonated: other administrators, or the administrator themselves.
+	ErrImpersonationForbidden = errors.New("this user cannot be impersonated")
+	// ErrImpersonating is returned for actions not allowed with an
+	// impersonation token, such as starting another impersonation.
+	ErrImpersonating = errors.New("not allowed while impersonating")
+)
+
+// Impersonate issues a short-lived access token with which the
+// administrator authenticated by admin acts as userID, e.g. to reproduce
+// a user's complaint. The token's `sub` is the user and its `act` claim
+// names the administrator; it has no session and no refresh token.
+// reason is recorded in the IMPERSONATION_STARTED event.
+func (s *Service) Impersonate(ctx context.Context, admin *jwt.AccessClaims, userID, reason string) (*jwt.Tokens, error) {
+	if admin.Actor != "" {
+		return nil, ErrImpersonating
+	}
+	if userID == admin.Subject {
+		return nil, ErrImpersonationForbidden
+	}
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, store.ErrNotFound
+	}
+	roles, err := s.effectiveRoles(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(roles, domain.RoleAdmin) {
+		return nil, ErrImpersonationForbidden
+	}
+	if u.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	opts := []jwt.IssueOption{
+		jwt.WithExpiry(time.Now().Add(impersonationTTL)),
+		jwt.WithAMR(admin.AMR...),
+		jwt.WithClaim("act", map[string]any{"sub": admin.Subject}),
+	}
+	userClaims, err := s.accessUserClaims(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, userClaims...)
+	tokens, err := s.tokens.IssueAccess(ctx, u.ID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// recorded under the user, so signing the user out ends it as well
+	rec := store.AccessTokenRecord{JTI: tokens.AccessID, UserID: u.ID, ExpiresAt: tokens.AccessExpiry}
+	if err := s.users.RecordAccessToken(ctx, rec); err != nil {
+		return nil, err
+	}
+	s.events.Publish("IMPERSONATION_STARTED", map[string]any{
+		"userID":    u.ID,
+		"by":        admin.Subject,
+		"reason":    reason,
+		"jti":       tokens.AccessID,
+		"expiresAt": tokens.AccessExpiry,
+	})
+	return tokens, nil
+}
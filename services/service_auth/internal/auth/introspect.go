@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+// Token type hints of RFC 7009 / RFC 7662.
+const (
+	HintAccessToken  = "access_token"
+	HintRefreshToken = "refresh_token"
+)
+
+// ErrUnsupportedTokenType is returned for unknown token_type_hint values.
+var ErrUnsupportedTokenType = &OAuthError{Code: "unsupported_token_type"}
+
+// Introspection is the RFC 7662 description of a token. Only Active is
+// set for tokens that are invalid, expired or revoked.
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       string   `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+	// Act names the client acting for Sub on a delegated token.
+	Act map[string]any `json:"act,omitempty"`
+	// Cnf holds the DPoP key thumbprint (`jkt`) of a bound token.
+	Cnf map[string]string `json:"cnf,omitempty"`
+}
+
+// Introspect describes an access or refresh token. hint only changes the
+// order in which the token kinds are tried.
+func (s *Service) Introspect(ctx context.Context, token, hint string) (*Introspection, error) {
+	if hint != "" && hint != HintAccessToken && hint != HintRefreshToken {
+		return nil, ErrUnsupportedTokenType
+	}
+	tryAccess := func() (*Introspection, bool) {
+		claims, err := s.validateAnyAccess(token)
+		if err != nil {
+			return nil, false
+		}
+		out := &Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			TokenType: HintAccessToken,
+			Exp:       claims.ExpiresAt.Unix(),
+			Iat:       claims.IssuedAt.Unix(),
+			Sub:       claims.Subject,
+			Jti:       claims.ID,
+			SessionID: claims.SessionID,
+			AMR:       claims.AMR,
+		}
+		out.Iss, _ = claims.Raw["iss"].(string)
+		out.Aud = claims.Audience
+		out.Act, _ = claims.Raw["act"].(map[string]any)
+		out.Cnf = confirmation(claims.JKT)
+		return out, true
+	}
+	tryRefresh := func() (*Introspection, bool) {
+		rec, claims, err := s.activeRefresh(ctx, token)
+		if err != nil {
+			return nil, false
+		}
+		return &Introspection{
+			Active:    true,
+			Scope:     rec.Scope,
+			ClientID:  rec.ClientID,
+			TokenType: HintRefreshToken,
+			Exp:       rec.ExpiresAt.Unix(),
+			Iat:       claims.IssuedAt.Unix(),
+			Sub:       rec.UserID,
+			Jti:       claims.ID,
+			SessionID: rec.SessionID,
+			AMR:       rec.AMR,
+			Cnf:       confirmation(rec.JKT),
+		}, true
+	}
+
+	first, second := tryAccess, tryRefresh
+	if hint == HintRefreshToken {
+		first, second = tryRefresh, tryAccess
+	}
+	out, ok := first()
+	if !ok {
+		out, ok = second()
+	}
+	if !ok {
+		return &Introspection{Active: false}, nil
+	}
+	if out.Sub != "" {
+		if u, err := s.users.FindByID(ctx, out.Sub); err == nil && u != nil {
+			if u.Disabled() {
+				return &Introspection{Active: false}, nil
+			}
+			out.Username = u.Login
+		}
+	}
+	return out, nil
+}
+
+// confirmation returns the `cnf` member for a DPoP key thumbprint.
+func confirmation(jkt string) map[string]string {
+	if jkt == "" {
+		return nil
+	}
+	return map[string]string{"jkt": jkt}
+}
+
+// RevokeToken implements RFC 7009. Revoking a refresh token ends its whole
+// session, so access tokens issued from the same grant stop working too.
+// A client can only revoke tokens issued to it; other tokens, including
+// first-party ones, are left alone like unknown or already invalid tokens,
+// which are not an error.
+func (s *Service) RevokeToken(ctx context.Context, client *domain.Client, token, hint string) error {
+	if hint != "" && hint != HintAccessToken && hint != HintRefreshToken {
+		return ErrUnsupportedTokenType
+	}
+	revokeAccess := func() (bool, error) {
+		claims, err := s.validateAnyAccess(token)
+		if err != nil {
+			return false, nil
+		}
+		if claims.ClientID != client.ID {
+			return true, nil
+		}
+		if err := s.denylist.Revoke(ctx, claims.ID, claims.ExpiresAt); err != nil {
+			return true, err
+		}
+		s.events.Publish("TOKEN_REVOKED", map[string]any{"userID": claims.Subject, "clientID": client.ID, "tokenType": HintAccessToken})
+		return true, nil
+	}
+	revokeRefresh := func() (bool, error) {
+		rec, _, err := s.activeRefresh(ctx, token)
+		if err != nil {
+			return false, nil
+		}
+		if rec.ClientID != client.ID {
+			return true, nil
+		}
+		if rec.SessionID != "" {
+			if err := s.users.RevokeSession(ctx, rec.SessionID); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return true, err
+			}
+			if err := s.revokeAccessTokens(ctx, rec.UserID, rec.SessionID); err != nil {
+				return true, err
+			}
+		} else if err := s.users.RevokeRefreshToken(ctx, token); err != nil {
+			return true, err
+		}
+		s.events.Publish("TOKEN_REVOKED", map[string]any{"userID": rec.UserID, "clientID": client.ID, "tokenType": HintRefreshToken})
+		return true, nil
+	}
+
+	first, second := revokeAccess, revokeRefresh
+	if hint == HintRefreshToken {
+		first, second = revokeRefresh, revokeAccess
+	}
+	if done, err := first(); done || err != nil {
+		return err
+	}
+	_, err := second()
+	return err
+}
+
+// activeRefresh returns the store record of a usable refresh token.
+func (s *Service) activeRefresh(ctx context.Context, token string) (*store.RefreshRecord, *jwt.RefreshClaims, error) {
+	claims, err := s.tokens.ParseRefresh(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec, err := s.users.GetRefreshToken(ctx, token)
+	if err != nil || rec == nil {
+		return nil, nil, errors.New("invalid refresh token")
+	}
+	if rec.Revoked || time.Now().After(rec.ExpiresAt) {
+		return nil, nil, errors.New("invalid refresh token")
+	}
+	if rec.SessionID != "" {
+		sess, err := s.users.GetSession(ctx, rec.SessionID)
+		if err != nil || sess == nil || sess.RevokedAt != nil {
+			return nil, nil, errors.New("invalid refresh token")
+		}
+	}
+	return rec, claims, nil
+}
@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/mail"
+	"auth_project/internal/store"
+)
+
+// invitationTTL bounds how long an invitation can be accepted.
+const invitationTTL = 7 * 24 * time.Hour
+
+// invitationPurpose is the `typ` of invitation tokens, which are signed
+// challenge tokens naming the invitation.
+const invitationPurpose = "invitation"
+
+var (
+	// ErrInvitationRequired is returned by Register, and by federated
+	// logins that would provision a user, when open registration is
+	// disabled, see WithInviteOnly.
+	ErrInvitationRequired = errors.New("registration requires an invitation")
+	// ErrInvalidInvitation is returned for unknown, used, revoked or
+	// expired invitation tokens, and when the email does not match.
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+	// ErrInviteForbidden is returned when the inviter may not grant the
+	// roles or organizations of the invitation.
+	ErrInviteForbidden = errors.New("not allowed to invite with these roles or organizations")
+)
+
+// WithInviteOnly disables open registration: Register then requires an
+// invitation token, and users signing in with an external identity
+// provider for the first time need a pending invitation for their email.
+func WithInviteOnly(on bool) Option {
+	return func(s *Service) { s.inviteOnly = on }
+}
+
+// NewInvitation is an invitation as requested by an administrator or an
+// organization director.
+type NewInvitation struct {
+	Email  string   `json:"email"`
+	Roles  []string `json:"roles"`
+	OrgIDs []int    `json:"org_ids"`
+}
+
+// directorGrantableRoles are the roles organization directors may invite
+// with.
+var directorGrantableRoles = []string{domain.RoleOrgDirector}
+
+// Invite creates an invitation for an email address and mails it. The
+// invited user registers with the emailed token and gets the roles and
+// organizations of the invitation. Administrators may invite with any
+// roles and organizations; organization directors only into their own
+// organizations and with directorGrantableRoles.
+func (s *Service) Invite(ctx context.Context, inviterID string, in NewInvitation) (*store.Invitation, error) {
+	addr, err := netmail.ParseAddress(strings.TrimSpace(in.Email))
+	if err != nil || addr.Name != "" {
+		return nil, fmt.Errorf("%w: invalid email", ErrInvalidInvitation)
+	}
+	email := addr.Address
+	roles, err := cleanList(in.Roles)
+	if err != nil {
+		return nil, fmt.Errorf("%w: empty role", ErrInvalidInvitation)
+	}
+	orgIDs := slices.Clone(in.OrgIDs)
+	for _, id := range orgIDs {
+		if id <= 0 {
+			return nil, ErrInvalidOrganization
+		}
+	}
+	slices.Sort(orgIDs)
+	orgIDs = slices.Compact(orgIDs)
+	if err := s.checkInviter(ctx, inviterID, roles, orgIDs); err != nil {
+		return nil, err
+	}
+	existing, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrEmailTaken
+	}
+
+	inv := &store.Invitation{
+		ID:        "inv-" + newSecret()[:22],
+		Email:     email,
+		Roles:     roles,
+		OrgIDs:    orgIDs,
+		InvitedBy: inviterID,
+		ExpiresAt: time.Now().Add(invitationTTL),
+	}
+	token, err := s.tokens.IssueChallenge(inv.ID, invitationPurpose, nil, invitationTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.CreateInvitation(ctx, inv); err != nil {
+		return nil, err
+	}
+	if err := s.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "You are invited",
+		Body: fmt.Sprintf("You have been invited to create an account.\n\n"+
+			"Your invitation: %s\n\nRegister with the token of the link; it can be used once and expires in %s.\n",
+			s.link("/auth/invitation", token), invitationTTL),
+	}); err != nil {
+		return nil, err
+	}
+	s.events.Publish("INVITATION_CREATED", map[string]any{"invitationID": inv.ID, "email": email, "roles": roles, "orgIDs": orgIDs, "by": inviterID})
+	return inv, nil
+}
+
+// checkInviter returns ErrInviteForbidden unless the inviter may grant
+// roles and orgIDs.
+func (s *Service) checkInviter(ctx context.Context, inviterID string, roles []string, orgIDs []int) error {
+	perms, err := s.EffectivePermissions(ctx, inviterID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(perms.Roles, domain.RoleAdmin) {
+		return nil
+	}
+	if !slices.Contains(perms.Roles, domain.RoleOrgDirector) || len(orgIDs) == 0 {
+		return ErrInviteForbidden
+	}
+	for _, role := range roles {
+		if !slices.Contains(directorGrantableRoles, role) {
+			return ErrInviteForbidden
+		}
+	}
+	inviter, err := s.GetUser(ctx, inviterID)
+	if err != nil {
+		return err
+	}
+	for _, id := range orgIDs {
+		if !slices.Contains(inviter.OrgIDs, id) {
+			return ErrInviteForbidden
+		}
+	}
+	return nil
+}
+
+// ListInvitations returns the invitations visible to the caller: all of
+// them for administrators, their own for others.
+func (s *Service) ListInvitations(ctx context.Context, callerID string) ([]store.Invitation, error) {
+	all, err := s.users.ListInvitations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := s.HasEffectiveRole(ctx, callerID, domain.RoleAdmin)
+	if err != nil || admin {
+		return all, err
+	}
+	out := make([]store.Invitation, 0, len(all))
+	for _, inv := range all {
+		if inv.InvitedBy == callerID {
+			out = append(out, inv)
+		}
+	}
+	return out, nil
+}
+
+// RevokeInvitation revokes a pending invitation. Administrators may revoke
+// any invitation, others only their own.
+func (s *Service) RevokeInvitation(ctx context.Context, callerID, id string) error {
+	inv, err := s.users.GetInvitation(ctx, id)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		return store.ErrNotFound
+	}
+	if inv.InvitedBy != callerID {
+		admin, err := s.HasEffectiveRole(ctx, callerID, domain.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if !admin {
+			return store.ErrNotFound
+		}
+	}
+	if err := s.users.RevokeInvitation(ctx, id, time.Now()); err != nil {
+		return err
+	}
+	s.events.Publish("INVITATION_REVOKED", map[string]any{"invitationID": id, "by": callerID})
+	return nil
+}
+
+// PendingInvitation returns the pending invitation of token, e.g. to fill
+// in the email of a registration form.
+func (s *Service) PendingInvitation(ctx context.Context, token string) (*store.Invitation, error) {
+	id, _, err := s.tokens.ValidateChallenge(token, invitationPurpose)
+	if err != nil {
+		return nil, ErrInvalidInvitation
+	}
+	inv, err := s.users.GetInvitation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil || !inv.Pending(time.Now()) {
+		return nil, ErrInvalidInvitation
+	}
+	return inv, nil
+}
+
+// RegisterWithInvitation creates the account of an invited user. The
+// email is taken from the invitation; a different email is rejected. The
+// email counts as verified since the token was sent to it, and the roles
+// and organizations of the invitation are applied. The invitation cannot
+// be used again.
+func (s *Service) RegisterWithInvitation(ctx context.Context, login, email, plaintext, token string) (*domain.User, error) {
+	inv, err := s.PendingInvitation(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if email != "" && !strings.EqualFold(email, inv.Email) {
+		return nil, ErrInvalidInvitation
+	}
+	if err := s.passwordPolicy.Check(login, plaintext); err != nil {
+		return nil, err
+	}
+	if login != "" {
+		found, err := s.users.FindByLogin(ctx, login)
+		if err != nil {
+			return nil, err
+		}
+		if found.ID != "" {
+			return nil, ErrLoginTaken
+		}
+	}
+	hashed, err := s.hasher.HashPassword(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	u := &domain.User{ID: generateID(), Login: login, Email: inv.Email, PasswordHash: hashed, Roles: inv.Roles}
+	if err := s.users.CreateUser(ctx, u); err != nil {
+		return nil, err
+	}
+	if err := s.acceptInvitation(ctx, inv, u); err != nil {
+		return nil, err
+	}
+	if err := s.users.MarkEmailVerified(ctx, u.ID); err != nil {
+		return nil, err
+	}
+	s.events.Publish("USER_REGISTERED", map[string]any{"userID": u.ID, "email": u.Email, "source": "invitation"})
+	return s.GetUser(ctx, u.ID)
+}
+
+// invitationFor returns the pending invitation a user provisioned from an
+// external identity source needs while registration is invite-only, or
+// ErrInvitationRequired. Only a verified email can claim an invitation;
+// otherwise anyone able to assert the address upstream could use it.
+func (s *Service) invitationFor(ctx context.Context, email string, verified bool) (*store.Invitation, error) {
+	if !verified {
+		return nil, ErrInvitationRequired
+	}
+	inv, err := s.users.FindPendingInvitation(ctx, email, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		return nil, ErrInvitationRequired
+	}
+	return inv, nil
+}
+
+// acceptInvitation uses inv for the just created user u and grants its
+// roles and organizations.
+func (s *Service) acceptInvitation(ctx context.Context, inv *store.Invitation, u *domain.User) error {
+	// accepting is the single-use check; a concurrent registration with
+	// the same invitation loses here and its account is removed again
+	if err := s.users.AcceptInvitation(ctx, inv.ID, u.ID, time.Now()); err != nil {
+		_ = s.users.DeleteUser(ctx, u.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return ErrInvalidInvitation
+		}
+		return err
+	}
+	roles := slices.Clone(u.Roles)
+	for _, r := range inv.Roles {
+		if !slices.Contains(roles, r) {
+			roles = append(roles, r)
+		}
+	}
+	if len(roles) > len(u.Roles) {
+		if err := s.users.SetUserRoles(ctx, u.ID, roles); err != nil {
+			return err
+		}
+		u.Roles = roles
+	}
+	if len(inv.OrgIDs) > 0 {
+		if err := s.users.SetUserOrganizations(ctx, u.ID, inv.OrgIDs); err != nil {
+			return err
+		}
+	}
+	s.events.Publish("INVITATION_ACCEPTED", map[string]any{"invitationID": inv.ID, "userID": u.ID, "invitedBy": inv.InvitedBy})
+	return nil
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/seal"
+	"auth_project/internal/store"
+	"auth_project/internal/totp"
+)
+
+const (
+	// totpIssuer is the account issuer shown by authenticator apps.
+	totpIssuer = "OrgDirectory"
+	// mfaChallengeTTL bounds the time between password and code entry.
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaMaxAttempts limits the codes tried with one challenge.
+	mfaMaxAttempts = 5
+)
+
+// Challenge statuses returned by Login instead of tokens.
+const (
+	StatusMFARequired   = "mfa_required"
+	StatusTermsRequired = "terms_required"
+)
+
+var (
+	// ErrInvalidCode is returned for wrong, expired or replayed codes.
+	ErrInvalidCode = errors.New("invalid code")
+	// ErrMFANotConfigured is returned when no encryption key was provided
+	// for TOTP secrets.
+	ErrMFANotConfigured = errors.New("mfa is not configured")
+)
+
+// ChallengeError is returned by Login when the password was correct but
+// another step is required before tokens are issued. Token is a short-lived
+// challenge to be exchanged at the corresponding endpoint.
+type ChallengeError struct {
+	Status    string
+	Token     string
+	ExpiresIn time.Duration
+	// TermsVersion is the terms version to accept with a terms_required
+	// challenge, see AcceptTermsChallenge.
+	TermsVersion int
+}
+
+func (e *ChallengeError) Error() string { return e.Status }
+
+// WithSecretBox sets the Box used to encrypt TOTP secrets at rest.
+func WithSecretBox(b *seal.Box) Option {
+	return func(s *Service) { s.box = b }
+}
+
+// EnrollTOTP starts (or restarts) TOTP enrollment and returns the shared
+// secret and its otpauth:// URI. MFA is not enforced until ConfirmTOTP.
+func (s *Service) EnrollTOTP(ctx context.Context, userID string) (secret, uri string, err error) {
+	if s.box == nil {
+		return "", "", ErrMFANotConfigured
+	}
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if u == nil {
+		return "", "", errors.New("user not found")
+	}
+	rec, err := s.users.GetTOTP(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if rec != nil && rec.Confirmed {
+		return "", "", errors.New("totp already enabled")
+	}
+	secret, err = totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	enc, err := s.box.Seal([]byte(secret))
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.users.SaveTOTP(ctx, store.TOTPRecord{UserID: userID, SecretEnc: enc, CreatedAt: time.Now()}); err != nil {
+		return "", "", err
+	}
+	account := u.Email
+	if account == "" {
+		account = u.Login
+	}
+	return secret, totp.KeyURI(totpIssuer, account, secret), nil
+}
+
+// ConfirmTOTP completes enrollment with the first code from the
+// authenticator app; from then on Login requires a second factor. It
+// returns the initial set of recovery codes.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	rec, err := s.users.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, errors.New("totp enrollment not started")
+	}
+	if rec.Confirmed {
+		return nil, errors.New("totp already enabled")
+	}
+	step, err := s.checkTOTP(rec, code)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rec.Confirmed = true
+	rec.LastStep = step
+	rec.ConfirmedAt = &now
+	if err := s.users.SaveTOTP(ctx, *rec); err != nil {
+		return nil, err
+	}
+	s.events.Publish("MFA_ENABLED", map[string]any{"userID": userID, "method": "totp"})
+	return s.GenerateRecoveryCodes(ctx, userID)
+}
+
+// DisableTOTP removes the user's TOTP enrollment. A current code is
+// required so a stolen access token alone cannot turn MFA off.
+func (s *Service) DisableTOTP(ctx context.Context, userID, code string) error {
+	rec, err := s.users.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if rec == nil || !rec.Confirmed {
+		return errors.New("totp is not enabled")
+	}
+	if err := s.verifyTOTP(ctx, rec, code); err != nil {
+		return err
+	}
+	if err := s.users.DeleteTOTP(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.users.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return err
+	}
+	s.events.Publish("MFA_DISABLED", map[string]any{"userID": userID, "method": "totp"})
+	return nil
+}
+
+// VerifyMFA exchanges an mfa_required challenge and a TOTP code for tokens.
+func (s *Service) VerifyMFA(ctx context.Context, challenge, code string) (*jwt.Tokens, error) {
+	userID, amr, err := s.verifyMFAChallenge(ctx, challenge, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.termsGate(ctx, userID, amr); err != nil {
+		return nil, err
+	}
+	tokens, err := s.issueSession(ctx, userID, amr)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish("LOGIN_SUCCESS", map[string]any{"userID": userID, "mfa": true})
+	return tokens, nil
+}
+
+// verifyMFAChallenge checks an mfa_required challenge and a TOTP code and
+// returns the user and the completed authentication methods. The challenge
+// allows mfaMaxAttempts codes and is consumed by the first correct one.
+func (s *Service) verifyMFAChallenge(ctx context.Context, challenge, code string) (string, []string, error) {
+	userID, amr, err := s.openMFAChallenge(ctx, challenge)
+	if err != nil {
+		return "", nil, err
+	}
+	rec, err := s.users.GetTOTP(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if rec == nil || !rec.Confirmed {
+		return "", nil, errors.New("invalid challenge")
+	}
+	if err := s.verifyTOTP(ctx, rec, code); err != nil {
+		s.events.Publish("MFA_FAILED", map[string]any{"userID": userID, "method": "totp"})
+		return "", nil, err
+	}
+	if err := s.consumeMFAChallenge(ctx, challenge); err != nil {
+		return "", nil, err
+	}
+	return userID, append(amr, "otp", "mfa"), nil
+}
+
+// openMFAChallenge validates an mfa_required challenge and counts an
+// attempt against it.
+func (s *Service) openMFAChallenge(ctx context.Context, challenge string) (string, []string, error) {
+	userID, amr, err := s.tokens.ValidateChallenge(challenge, StatusMFARequired)
+	if err != nil {
+		return "", nil, errors.New("invalid challenge")
+	}
+	allowed, err := s.users.RecordMFAAttempt(ctx, hashSecret(challenge), mfaMaxAttempts)
+	if err != nil {
+		return "", nil, err
+	}
+	if !allowed {
+		s.events.Publish("MFA_FAILED", map[string]any{"userID": userID, "error": "challenge used or exhausted"})
+		return "", nil, errors.New("invalid challenge")
+	}
+	return userID, amr, nil
+}
+
+// consumeMFAChallenge marks a challenge as used once its code was correct.
+func (s *Service) consumeMFAChallenge(ctx context.Context, challenge string) error {
+	consumed, err := s.users.ConsumeMFAChallenge(ctx, hashSecret(challenge))
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return errors.New("invalid challenge")
+	}
+	return nil
+}
+
+// MFAEnabled reports whether the user has a confirmed second factor.
+func (s *Service) MFAEnabled(ctx context.Context, userID string) (bool, error) {
+	rec, err := s.users.GetTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return rec != nil && rec.Confirmed, nil
+}
+
+// completeLogin finishes a successful first-factor login: it returns an
+// mfa_required challenge when the user has MFA on, a terms_required
+// challenge when mandatory terms are not accepted, and tokens otherwise.
+func (s *Service) completeLogin(ctx context.Context, u *domain.User, amr []string) (*jwt.Tokens, error) {
+	if err := s.secondFactor(ctx, u, amr); err != nil {
+		return nil, err
+	}
+	if err := s.termsGate(ctx, u.ID, amr); err != nil {
+		return nil, err
+	}
+	tokens, err := s.issueSession(ctx, u.ID, amr)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish("LOGIN_SUCCESS", map[string]any{"userID": u.ID})
+	return tokens, nil
+}
+
+// secondFactor decides whether a first-factor login may proceed. It
+// returns ErrAccountDisabled, an mfa_required *ChallengeError, or nil.
+func (s *Service) secondFactor(ctx context.Context, u *domain.User, amr []string) error {
+	if u.Disabled() {
+		s.events.Publish("LOGIN_FAILED", map[string]any{"userID": u.ID, "error": "account disabled"})
+		return ErrAccountDisabled
+	}
+	enabled, err := s.MFAEnabled(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		challenge, err := s.tokens.IssueChallenge(u.ID, StatusMFARequired, amr, mfaChallengeTTL)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := s.users.SaveMFAChallenge(ctx, store.MFAChallenge{
+			ID:        hashSecret(challenge),
+			UserID:    u.ID,
+			ExpiresAt: now.Add(mfaChallengeTTL),
+			CreatedAt: now,
+		}); err != nil {
+			return err
+		}
+		s.events.Publish("LOGIN_MFA_REQUIRED", map[string]any{"userID": u.ID})
+		return &ChallengeError{Status: StatusMFARequired, Token: challenge, ExpiresIn: mfaChallengeTTL}
+	}
+	return nil
+}
+
+// verifyTOTP checks code and marks its time step as used.
+func (s *Service) verifyTOTP(ctx context.Context, rec *store.TOTPRecord, code string) error {
+	step, err := s.checkTOTP(rec, code)
+	if err != nil {
+		return err
+	}
+	ok, err := s.users.UseTOTPStep(ctx, rec.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// checkTOTP decrypts the secret and validates code without recording it.
+func (s *Service) checkTOTP(rec *store.TOTPRecord, code string) (int64, error) {
+	if s.box == nil {
+		return 0, ErrMFANotConfigured
+	}
+	secret, err := s.box.Open(rec.SecretEnc)
+	if err != nil {
+		return 0, err
+	}
+	step, ok := totp.Validate(string(secret), code, time.Now())
+	if !ok {
+		return 0, ErrInvalidCode
+	}
+	return step, nil
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+const (
+	// authCodeTTL bounds the time between the redirect and the code
+	// exchange (RFC 6749 recommends at most ten minutes).
+	authCodeTTL = time.Minute
+	// SSOTTL is the lifetime of the single sign-on cookie of the
+	// authorization endpoint.
+	SSOTTL = 12 * time.Hour
+)
+
+// ErrInvalidRedirectURI is returned when the redirect_uri of an
+// authorization request is not registered. Such errors must be shown to
+// the user instead of redirecting.
+var ErrInvalidRedirectURI = errors.New("invalid redirect_uri")
+
+// OAuthError is an OAuth 2.0 error response (RFC 6749 sections 4.1.2.1
+// and 5.2). Code is the `error` value, e.g. "invalid_grant".
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// AuthorizeRequest holds the parameters of an authorization request.
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// GrantedTokens are tokens issued to an OAuth client with the scope they
+// were granted for. IDToken is set when the scope includes openid, and
+// IssuedTokenType for token exchange.
+type GrantedTokens struct {
+	*jwt.Tokens
+	Scope           string
+	IDToken         string
+	IssuedTokenType string
+}
+
+// CheckAuthorizeRequest validates req and normalizes its scope. It returns
+// ErrInvalidClient or ErrInvalidRedirectURI when the request must not be
+// redirected, and an *OAuthError to be sent back to the redirect URI.
+func (s *Service) CheckAuthorizeRequest(ctx context.Context, req *AuthorizeRequest) (*domain.Client, error) {
+	client, err := s.users.GetClient(ctx, req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ErrInvalidClient
+	}
+	if !client.AllowsRedirect(req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+	if req.ResponseType != "code" {
+		return client, oauthError("unsupported_response_type", "only response_type=code is supported")
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return client, oauthError("invalid_request", "PKCE with code_challenge_method=S256 is required")
+	}
+	if n := len(req.CodeChallenge); n < 43 || n > 128 {
+		return client, oauthError("invalid_request", "invalid code_challenge")
+	}
+	if req.Scope, err = grantedScope(client, req.Scope, OIDCScopes); err != nil {
+		return client, err
+	}
+	return client, nil
+}
+
+// SignIn checks the credentials entered on the authorization page and
+// starts a login session. It returns a single sign-on token for the
+// browser cookie, or an mfa_required or terms_required *ChallengeError
+// (see SignInMFA and SignInTerms).
+func (s *Service) SignIn(ctx context.Context, ident, plaintext string) (string, error) {
+	u, err := s.Authenticate(ctx, ident, plaintext)
+	if err != nil {
+		return "", err
+	}
+	amr := []string{"pwd"}
+	if err := s.secondFactor(ctx, u, amr); err != nil {
+		return "", err
+	}
+	if err := s.termsGate(ctx, u.ID, amr); err != nil {
+		return "", err
+	}
+	return s.startSSO(ctx, u.ID, amr)
+}
+
+// SignInMFA completes SignIn with an mfa_required challenge and a TOTP code.
+// It may still end with a terms_required *ChallengeError.
+func (s *Service) SignInMFA(ctx context.Context, challenge, code string) (string, error) {
+	userID, amr, err := s.verifyMFAChallenge(ctx, challenge, code)
+	if err != nil {
+		return "", err
+	}
+	if err := s.termsGate(ctx, userID, amr); err != nil {
+		return "", err
+	}
+	return s.startSSO(ctx, userID, amr)
+}
+
+// SignInTerms completes SignIn with a terms_required challenge once the
+// user accepted the latest terms version.
+func (s *Service) SignInTerms(ctx context.Context, challenge string, version int) (string, error) {
+	userID, amr, err := s.acceptTermsChallenge(ctx, challenge, version)
+	if err != nil {
+		return "", err
+	}
+	return s.startSSO(ctx, userID, amr)
+}
+
+// SSOUser returns the user signed in by a single sign-on token. It fails
+// with ErrAuthFailed once the session is revoked or the user disabled.
+func (s *Service) SSOUser(ctx context.Context, sso string) (*domain.User, *jwt.SSOClaims, error) {
+	claims, err := s.tokens.ValidateSSO(sso)
+	if err != nil {
+		return nil, nil, ErrAuthFailed
+	}
+	sess, err := s.users.GetSession(ctx, claims.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sess == nil || sess.RevokedAt != nil || sess.UserID != claims.Subject {
+		return nil, nil, ErrAuthFailed
+	}
+	u, err := s.users.FindByID(ctx, claims.Subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u == nil || u.Disabled() {
+		return nil, nil, ErrAuthFailed
+	}
+	return u, claims, nil
+}
+
+// Authorize issues an authorization code to client for the user signed in
+// by sso. req must have passed CheckAuthorizeRequest.
+func (s *Service) Authorize(ctx context.Context, sso string, client *domain.Client, req *AuthorizeRequest) (string, error) {
+	u, claims, err := s.SSOUser(ctx, sso)
+	if err != nil {
+		return "", err
+	}
+	code := newSecret()
+	now := time.Now()
+	rec := store.AuthorizationCode{
+		CodeHash:      hashSecret(code),
+		ClientID:      client.ID,
+		UserID:        u.ID,
+		SessionID:     claims.SessionID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AMR:           claims.AMR,
+		ExpiresAt:     now.Add(authCodeTTL),
+		CreatedAt:     now,
+	}
+	if err := s.users.SaveAuthorizationCode(ctx, rec); err != nil {
+		return "", err
+	}
+	s.events.Publish("OAUTH_CODE_ISSUED", map[string]any{"userID": u.ID, "clientID": client.ID, "scope": req.Scope})
+	return code, nil
+}
+
+// ExchangeCode redeems an authorization code (grant_type=authorization_code).
+// redirectURI must match the authorization request and verifier the PKCE
+// challenge.
+func (s *Service) ExchangeCode(ctx context.Context, client *domain.Client, code, redirectURI, verifier string) (*GrantedTokens, error) {
+	rec, err := s.users.ConsumeAuthorizationCode(ctx, hashSecret(code))
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.ClientID != client.ID || rec.RedirectURI != redirectURI {
+		return nil, oauthError("invalid_grant", "invalid authorization code")
+	}
+	if !verifyPKCE(verifier, rec.CodeChallenge) {
+		return nil, oauthError("invalid_grant", "code_verifier does not match")
+	}
+	sess, err := s.users.GetSession(ctx, rec.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if sess == nil || sess.RevokedAt != nil {
+		return nil, oauthError("invalid_grant", "session has ended")
+	}
+	g := grant{SessionID: rec.SessionID, AMR: rec.AMR, ClientID: client.ID, Scope: rec.Scope, JKT: dpopKeyFrom(ctx)}
+	tokens, err := s.issueTokens(ctx, rec.UserID, g)
+	if err != nil {
+		if errors.Is(err, ErrAccountDisabled) {
+			return nil, oauthError("invalid_grant", err.Error())
+		}
+		return nil, err
+	}
+	idToken, err := s.idToken(ctx, rec.UserID, g, rec.Nonce, sess.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish("LOGIN_SUCCESS", map[string]any{"userID": rec.UserID, "clientID": client.ID})
+	return &GrantedTokens{Tokens: tokens, Scope: g.Scope, IDToken: idToken}, nil
+}
+
+// RefreshClient rotates a refresh token issued to client
+// (grant_type=refresh_token). A non-empty scope narrows the grant.
+func (s *Service) RefreshClient(ctx context.Context, client *domain.Client, refreshToken, scope string) (*GrantedTokens, error) {
+	tokens, userID, g, err := s.refresh(ctx, refreshToken, client.ID, scope)
+	if err != nil {
+		var oe *OAuthError
+		if errors.As(err, &oe) {
+			return nil, err
+		}
+		return nil, oauthError("invalid_grant", err.Error())
+	}
+	out := &GrantedTokens{Tokens: tokens, Scope: g.Scope}
+	if hasScope(g.Scope, ScopeOpenID) {
+		authTime, err := s.sessionAuthTime(ctx, g.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		if out.IDToken, err = s.idToken(ctx, userID, g, "", authTime); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// startSSO records a login session for the authorization endpoint and
+// signs the single sign-on token naming it.
+func (s *Service) startSSO(ctx context.Context, userID string, amr []string) (string, error) {
+	sess, err := s.newSession(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	sso, err := s.tokens.IssueSSO(userID, sess.ID, amr, SSOTTL)
+	if err != nil {
+		return "", err
+	}
+	s.events.Publish("LOGIN_SUCCESS", map[string]any{"userID": userID, "sso": true})
+	return sso, nil
+}
+
+// grantedScope checks the requested scope against the client's allowed
+// scopes and extra, scopes any client may request (the OpenID Connect
+// ones for user grants). An empty request grants all of the client's
+// scopes.
+func grantedScope(client *domain.Client, requested string, extra []string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(client.Scopes, " "), nil
+	}
+	var out []string
+	for _, sc := range strings.Fields(requested) {
+		if !client.AllowsScope(sc) && !slices.Contains(extra, sc) {
+			return "", oauthError("invalid_scope", "scope "+sc+" is not allowed for this client")
+		}
+		if !slices.Contains(out, sc) {
+			out = append(out, sc)
+		}
+	}
+	return strings.Join(out, " "), nil
+}
+
+// narrowScope checks that requested is a subset of the granted scope.
+func narrowScope(granted, requested string) (string, error) {
+	have := strings.Fields(granted)
+	var out []string
+	for _, sc := range strings.Fields(requested) {
+		if !slices.Contains(have, sc) {
+			return "", oauthError("invalid_scope", "scope "+sc+" exceeds the original grant")
+		}
+		if !slices.Contains(out, sc) {
+			out = append(out, sc)
+		}
+	}
+	return strings.Join(out, " "), nil
+}
+
+// verifyPKCE checks a code_verifier against an S256 code_challenge
+// (RFC 7636 section 4.6).
+func verifyPKCE(verifier, challenge string) bool {
+	if n := len(verifier); n < 43 || n > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
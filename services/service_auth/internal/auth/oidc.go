@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+)
+
+// OpenID Connect scopes. They may be requested by every client.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// OIDCScopes lists the standard scopes in the order they are advertised.
+var OIDCScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// ErrInsufficientScope is returned by UserInfo for access tokens that were
+// not granted the openid scope.
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// Issuer returns the OpenID Connect issuer identifier: the public URL of
+// the service. It is the `iss` of id_tokens and the base of discovery.
+func (s *Service) Issuer() string {
+	return s.publicURL
+}
+
+// JWKS returns the public keys that verify id_tokens.
+func (s *Service) JWKS() []jwt.JWK {
+	return s.tokens.JWKS()
+}
+
+// UserInfo returns the claims about the user released for the scope of
+// the access token (OpenID Connect Core section 5.3).
+func (s *Service) UserInfo(ctx context.Context, claims *jwt.AccessClaims) (map[string]any, error) {
+	if !hasScope(claims.Scope, ScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+	u, err := s.users.FindByID(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil || u.Disabled() {
+		return nil, ErrAuthFailed
+	}
+	out := userClaims(u, claims.Scope)
+	out["sub"] = u.ID
+	return out, nil
+}
+
+// idToken signs an id_token for a grant with the openid scope; it returns
+// "" for other grants.
+func (s *Service) idToken(ctx context.Context, userID string, g grant, nonce string, authTime time.Time) (string, error) {
+	if !hasScope(g.Scope, ScopeOpenID) {
+		return "", nil
+	}
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if u == nil {
+		return "", errors.New("user not found")
+	}
+	return s.tokens.IssueIDToken(jwt.IDToken{
+		Issuer:    s.publicURL,
+		Subject:   userID,
+		Audience:  g.ClientID,
+		Nonce:     nonce,
+		SessionID: g.SessionID,
+		AuthTime:  authTime,
+		AMR:       g.AMR,
+		Claims:    userClaims(u, g.Scope),
+	})
+}
+
+// sessionAuthTime returns when the user authenticated for the session, the
+// `auth_time` of id_tokens.
+func (s *Service) sessionAuthTime(ctx context.Context, sessionID string) (time.Time, error) {
+	sess, err := s.users.GetSession(ctx, sessionID)
+	if err != nil || sess == nil {
+		return time.Time{}, err
+	}
+	return sess.CreatedAt, nil
+}
+
+// userClaims returns the standard claims about u released for scope.
+func userClaims(u *domain.User, scope string) map[string]any {
+	out := map[string]any{}
+	if hasScope(scope, ScopeProfile) {
+		out["preferred_username"] = u.Login
+		out["name"] = u.Login
+	}
+	if hasScope(scope, ScopeEmail) && u.Email != "" {
+		out["email"] = u.Email
+		out["email_verified"] = u.EmailVerified
+	}
+	return out
+}
+
+// hasScope reports whether the space separated scope contains want.
+func hasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
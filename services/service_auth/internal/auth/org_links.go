@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/store"
+)
+
+var (
+	// ErrCitizenLinked is returned when the citizen is already linked to
+	// another account.
+	ErrCitizenLinked = errors.New("citizen already linked to another user")
+	// ErrInvalidCitizenID is returned for citizen ids that are not UUIDs.
+	ErrInvalidCitizenID = errors.New("citizen id must be a UUID")
+	// ErrInvalidOrganization is returned for organization ids that are not
+	// positive integers.
+	ErrInvalidOrganization = errors.New("organization ids must be positive integers")
+)
+
+// citizenIDPattern matches the UUIDs OrgDirectory uses as citizen ids.
+var citizenIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// OrgLinks are the OrgDirectory records a user is linked to.
+type OrgLinks struct {
+	UserID    string `json:"user_id"`
+	CitizenID string `json:"citizen_id,omitempty"`
+	OrgIDs    []int  `json:"org_ids"`
+}
+
+// UserOrgLinks returns the OrgDirectory links of the user.
+func (s *Service) UserOrgLinks(ctx context.Context, userID string) (*OrgLinks, error) {
+	u, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return orgLinks(u), nil
+}
+
+// LinkCitizen links the user to an OrgDirectory citizen; a citizen belongs
+// to at most one account. Tokens issued before carry the previous links
+// until they expire.
+func (s *Service) LinkCitizen(ctx context.Context, adminID, userID, citizenID string) (*OrgLinks, error) {
+	citizenID = strings.ToLower(strings.TrimSpace(citizenID))
+	if !citizenIDPattern.MatchString(citizenID) {
+		return nil, ErrInvalidCitizenID
+	}
+	if err := s.users.SetUserCitizen(ctx, userID, citizenID); err != nil {
+		if errors.Is(err, store.ErrCitizenLinked) {
+			return nil, ErrCitizenLinked
+		}
+		return nil, err
+	}
+	s.events.Publish("USER_CITIZEN_LINKED", map[string]any{"userID": userID, "citizenID": citizenID, "by": adminID})
+	return s.UserOrgLinks(ctx, userID)
+}
+
+// UnlinkCitizen removes the citizen link of the user.
+func (s *Service) UnlinkCitizen(ctx context.Context, adminID, userID string) (*OrgLinks, error) {
+	if err := s.users.SetUserCitizen(ctx, userID, ""); err != nil {
+		return nil, err
+	}
+	s.events.Publish("USER_CITIZEN_UNLINKED", map[string]any{"userID": userID, "by": adminID})
+	return s.UserOrgLinks(ctx, userID)
+}
+
+// SetUserOrganizations replaces the OrgDirectory organizations the user
+// belongs to. Duplicates are dropped and the ids are kept sorted.
+func (s *Service) SetUserOrganizations(ctx context.Context, adminID, userID string, orgIDs []int) (*OrgLinks, error) {
+	ids := slices.Clone(orgIDs)
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, ErrInvalidOrganization
+		}
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	if err := s.users.SetUserOrganizations(ctx, userID, ids); err != nil {
+		return nil, err
+	}
+	s.events.Publish("USER_ORGANIZATIONS_CHANGED", map[string]any{"userID": userID, "orgIDs": ids, "by": adminID})
+	return s.UserOrgLinks(ctx, userID)
+}
+
+func orgLinks(u *domain.User) *OrgLinks {
+	ids := u.OrgIDs
+	if ids == nil {
+		ids = []int{}
+	}
+	return &OrgLinks{UserID: u.ID, CitizenID: u.CitizenID, OrgIDs: ids}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is returned for passwords rejected by the password
+// policy; the wrapping error names the unmet requirement.
+var ErrWeakPassword = errors.New("password does not meet the policy")
+
+// PasswordPolicy lists the requirements for passwords chosen by users or
+// set by provisioning clients. Generated secrets are not checked.
+type PasswordPolicy struct {
+	MinLength     int  `json:"min_length"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireLower  bool `json:"require_lower"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	// ForbidLogin rejects passwords containing the login.
+	ForbidLogin bool `json:"forbid_login"`
+}
+
+// DefaultPasswordPolicy is the policy of services created without
+// WithPasswordPolicy.
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 6}
+
+// WithPasswordPolicy sets the policy new passwords must meet.
+func WithPasswordPolicy(p PasswordPolicy) Option {
+	return func(s *Service) { s.passwordPolicy = p }
+}
+
+// PasswordPolicy returns the policy new passwords must meet.
+func (s *Service) PasswordPolicy() PasswordPolicy {
+	return s.passwordPolicy
+}
+
+// Check returns an error wrapping ErrWeakPassword if the password of the
+// user login does not meet the policy.
+func (p PasswordPolicy) Check(login, password string) error {
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters required", ErrWeakPassword, p.MinLength)
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !upper:
+		return fmt.Errorf("%w: an uppercase letter is required", ErrWeakPassword)
+	case p.RequireLower && !lower:
+		return fmt.Errorf("%w: a lowercase letter is required", ErrWeakPassword)
+	case p.RequireDigit && !digit:
+		return fmt.Errorf("%w: a digit is required", ErrWeakPassword)
+	case p.RequireSymbol && !symbol:
+		return fmt.Errorf("%w: a symbol is required", ErrWeakPassword)
+	case p.ForbidLogin && login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(login)):
+		return fmt.Errorf("%w: the password must not contain the login", ErrWeakPassword)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"auth_project/internal/jwt"
+	"auth_project/internal/mail"
+	"auth_project/internal/store"
+)
+
+const (
+	// passwordlessTTL bounds how long a magic link or code is valid.
+	passwordlessTTL = 15 * time.Minute
+	// passwordlessMaxAttempts limits guesses of a 6-digit code.
+	passwordlessMaxAttempts = 5
+)
+
+// Passwordless delivery methods.
+const (
+	PasswordlessLink = "link"
+	PasswordlessCode = "code"
+)
+
+// StartPasswordless emails the user a magic link or a 6-digit code and
+// returns the challenge ID the client needs to complete a code login. For
+// unknown addresses a dummy ID is returned and nothing is sent, so the
+// response does not reveal which emails are registered.
+func (s *Service) StartPasswordless(ctx context.Context, email, method string) (string, error) {
+	if method != PasswordlessLink && method != PasswordlessCode {
+		return "", fmt.Errorf("unsupported method %q", method)
+	}
+	id := "pl-" + newSecret()[:22]
+	u, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		return "", err
+	}
+	if u == nil {
+		s.events.Publish("PASSWORDLESS_FAILED", map[string]any{"email": email, "error": "user not found"})
+		return id, nil
+	}
+
+	var secret string
+	if method == PasswordlessCode {
+		if secret, err = newNumericCode(6); err != nil {
+			return "", err
+		}
+	} else {
+		secret = newSecret()
+	}
+	now := time.Now()
+	ch := store.PasswordlessChallenge{
+		ID:         id,
+		UserID:     u.ID,
+		Method:     method,
+		SecretHash: hashSecret(id + ":" + secret),
+		ExpiresAt:  now.Add(passwordlessTTL),
+		CreatedAt:  now,
+	}
+	if err := s.users.SavePasswordlessChallenge(ctx, ch); err != nil {
+		return "", err
+	}
+
+	msg := mail.Message{To: u.Email, Subject: "Your sign-in code"}
+	if method == PasswordlessCode {
+		msg.Body = fmt.Sprintf("Your sign-in code is %s.\n\nIt expires in %s. If you did not try to sign in, ignore this email.\n",
+			secret, passwordlessTTL)
+	} else {
+		msg.Subject = "Your sign-in link"
+		msg.Body = fmt.Sprintf("Sign in: %s\n\nThe link expires in %s and works once. If you did not try to sign in, ignore this email.\n",
+			s.link("/auth/passwordless/complete", id+"."+secret), passwordlessTTL)
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return "", err
+	}
+	s.events.Publish("PASSWORDLESS_STARTED", map[string]any{"userID": u.ID, "method": method})
+	return id, nil
+}
+
+// CompletePasswordless verifies the code of a challenge and issues tokens
+// exactly like Login (including the mfa_required step).
+func (s *Service) CompletePasswordless(ctx context.Context, challengeID, code string) (*jwt.Tokens, error) {
+	return s.completePasswordless(ctx, challengeID, strings.TrimSpace(code), PasswordlessCode)
+}
+
+// CompletePasswordlessLink verifies a magic link token and issues tokens.
+func (s *Service) CompletePasswordlessLink(ctx context.Context, token string) (*jwt.Tokens, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidLink
+	}
+	return s.completePasswordless(ctx, id, secret, PasswordlessLink)
+}
+
+func (s *Service) completePasswordless(ctx context.Context, id, secret, method string) (*jwt.Tokens, error) {
+	ch, err := s.users.GetPasswordlessChallenge(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ch == nil || ch.Method != method {
+		return nil, ErrInvalidCode
+	}
+	allowed, err := s.users.RecordPasswordlessAttempt(ctx, id, passwordlessMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		s.events.Publish("PASSWORDLESS_FAILED", map[string]any{"userID": ch.UserID, "error": "challenge expired or exhausted"})
+		return nil, ErrInvalidCode
+	}
+	want := []byte(ch.SecretHash)
+	got := []byte(hashSecret(id + ":" + secret))
+	if subtle.ConstantTimeCompare(want, got) != 1 {
+		s.events.Publish("PASSWORDLESS_FAILED", map[string]any{"userID": ch.UserID, "error": "incorrect code"})
+		return nil, ErrInvalidCode
+	}
+	consumed, err := s.users.ConsumePasswordlessChallenge(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrInvalidCode
+	}
+	u, err := s.users.FindByID(ctx, ch.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New("user not found")
+	}
+	// the secret arrived by email, so the address is proven
+	if !u.EmailVerified {
+		if err := s.users.MarkEmailVerified(ctx, u.ID); err != nil {
+			return nil, err
+		}
+		u.EmailVerified = true
+	}
+	return s.completeLogin(ctx, u, []string{"otp"})
+}
+
+// newNumericCode returns a uniformly random decimal code of n digits.
+func newNumericCode(n int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < n; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("numeric code: %w", err)
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
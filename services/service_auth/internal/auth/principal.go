@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"auth_project/internal/jwt"
+)
+
+// Principal types.
+const (
+	PrincipalUser           = "user"
+	PrincipalServiceAccount = "service_account"
+)
+
+// Credential types a Principal can be authenticated with.
+const (
+	TokenTypeJWT    = "jwt"
+	TokenTypeAPIKey = "api_key"
+)
+
+// Principal is who a request acts for, independent of whether it carried
+// an access token or an API key. Actor is set on tokens obtained by token
+// exchange and names the client acting for Subject; JKT is set on tokens
+// bound to a DPoP key (see CheckDPoP).
+type Principal struct {
+	Subject   string     `json:"sub"`
+	Type      string     `json:"type"`
+	TokenType string     `json:"token_type"`
+	ClientID  string     `json:"client_id,omitempty"`
+	Scope     string     `json:"scope,omitempty"`
+	Roles     []string   `json:"roles,omitempty"`
+	CitizenID string     `json:"citizen_id,omitempty"`
+	OrgIDs    []int      `json:"org_ids,omitempty"`
+	AMR       []string   `json:"amr,omitempty"`
+	SessionID string     `json:"sid,omitempty"`
+	KeyID     string     `json:"key_id,omitempty"`
+	Audience  string     `json:"aud,omitempty"`
+	Actor     string     `json:"actor,omitempty"`
+	JKT       string     `json:"jkt,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ValidatePrincipal accepts either an access token or an API key.
+func (s *Service) ValidatePrincipal(ctx context.Context, token string) (*Principal, error) {
+	if IsAPIKey(token) {
+		return s.ValidateAPIKey(ctx, token)
+	}
+	claims, err := s.ValidateClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	return s.principal(ctx, claims)
+}
+
+// ValidatePrincipalFor accepts an access token issued for audience, as
+// checked by a downstream service. API keys are only valid for this
+// service and are rejected.
+func (s *Service) ValidatePrincipalFor(ctx context.Context, token, audience string) (*Principal, error) {
+	if IsAPIKey(token) {
+		return nil, ErrInvalidAPIKey
+	}
+	claims, err := s.ValidateClaimsFor(token, audience)
+	if err != nil {
+		return nil, err
+	}
+	return s.principal(ctx, claims)
+}
+
+// principal describes the subject of a verified access token. Truncated
+// roles of users are completed with their effective roles.
+func (s *Service) principal(ctx context.Context, claims *jwt.AccessClaims) (*Principal, error) {
+	p := principalFromClaims(claims)
+	if claims.RolesTruncated && p.Type == PrincipalUser {
+		perms, err := s.EffectivePermissions(ctx, claims.Subject)
+		if err != nil {
+			return nil, err
+		}
+		p.Roles = perms.Roles
+	}
+	return p, nil
+}
+
+// principalFromClaims describes the subject of a verified access token.
+// Tokens of service accounts have `sub` = `client_id`.
+func principalFromClaims(claims *jwt.AccessClaims) *Principal {
+	p := &Principal{
+		Subject:   claims.Subject,
+		Type:      PrincipalUser,
+		TokenType: TokenTypeJWT,
+		ClientID:  claims.ClientID,
+		Scope:     claims.Scope,
+		Roles:     claims.Roles,
+		CitizenID: claims.CitizenID,
+		OrgIDs:    claims.OrgIDs,
+		AMR:       claims.AMR,
+		SessionID: claims.SessionID,
+		Audience:  claims.Audience,
+		Actor:     claims.Actor,
+		JKT:       claims.JKT,
+	}
+	if claims.ClientID != "" && claims.ClientID == claims.Subject {
+		p.Type = PrincipalServiceAccount
+	}
+	if !claims.ExpiresAt.IsZero() {
+		exp := claims.ExpiresAt
+		p.ExpiresAt = &exp
+	}
+	return p
+}
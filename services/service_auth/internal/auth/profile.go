@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Profile is the account summary returned to the signed-in user.
+type Profile struct {
+	ID                     string    `json:"id"`
+	Login                  string    `json:"login"`
+	Email                  string    `json:"email"`
+	EmailVerified          bool      `json:"email_verified"`
+	CreatedAt              time.Time `json:"created_at"`
+	MFAEnabled             bool      `json:"mfa_enabled"`
+	RecoveryCodesRemaining int       `json:"recovery_codes_remaining"`
+}
+
+// Profile returns the account summary of the user.
+func (s *Service) Profile(ctx context.Context, userID string) (*Profile, error) {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New("user not found")
+	}
+	p := &Profile{ID: u.ID, Login: u.Login, Email: u.Email, EmailVerified: u.EmailVerified, CreatedAt: u.CreatedAt}
+	if p.MFAEnabled, err = s.MFAEnabled(ctx, userID); err != nil {
+		return nil, err
+	}
+	if p.MFAEnabled {
+		if p.RecoveryCodesRemaining, err = s.RecoveryCodesRemaining(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,384 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"slices"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/store"
+)
+
+var (
+	// ErrLoginTaken is returned when the requested login belongs to
+	// another account.
+	ErrLoginTaken = errors.New("login already in use")
+	// ErrGroupExists is returned when a group with the name exists.
+	ErrGroupExists = errors.New("group already exists")
+	// ErrReservedRole is returned for groups that would grant a role
+	// provisioning clients must not hand out, such as admin.
+	ErrReservedRole = errors.New("role cannot be managed by provisioning")
+	// ErrUnknownMember is returned when a group member is not a user.
+	ErrUnknownMember = errors.New("unknown group member")
+	// ErrProtectedUser is returned when a provisioning client tries to
+	// change or delete a user holding a reserved role.
+	ErrProtectedUser = errors.New("user cannot be managed by provisioning")
+)
+
+// reservedRoles are the roles provisioning clients can neither grant
+// through groups nor take over or remove by changing their holders.
+var reservedRoles = []string{domain.RoleAdmin, domain.RoleOrgDirector}
+
+// WithSCIMToken enables the SCIM provisioning API for clients presenting
+// token as bearer token. Only its hash is kept.
+func WithSCIMToken(token string) Option {
+	return func(s *Service) {
+		if token != "" {
+			s.scimTokenHash = hashSecret(token)
+		}
+	}
+}
+
+// SCIMEnabled reports whether a SCIM token was configured.
+func (s *Service) SCIMEnabled() bool {
+	return s.scimTokenHash != ""
+}
+
+// CheckSCIMToken reports whether token is the configured SCIM token.
+func (s *Service) CheckSCIMToken(token string) bool {
+	if s.scimTokenHash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashSecret(token)), []byte(s.scimTokenHash)) == 1
+}
+
+// ProvisionedUser holds the attributes a provisioning client manages.
+type ProvisionedUser struct {
+	Login      string
+	Email      string
+	ExternalID string
+	// Password is optional; users without one sign in through
+	// federation or set one via password reset.
+	Password string
+	Active   bool
+}
+
+// ListUsers returns all users.
+func (s *Service) ListUsers(ctx context.Context) ([]domain.User, error) {
+	return s.users.ListUsers(ctx)
+}
+
+// GetUser returns the user; store.ErrNotFound if there is none.
+func (s *Service) GetUser(ctx context.Context, userID string) (*domain.User, error) {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, store.ErrNotFound
+	}
+	return u, nil
+}
+
+// ProvisionUser creates a user on behalf of a provisioning client. The
+// client is trusted for the email address, so it is marked verified.
+func (s *Service) ProvisionUser(ctx context.Context, p ProvisionedUser) (*domain.User, error) {
+	found, err := s.users.FindByLogin(ctx, p.Login)
+	if err != nil {
+		return nil, err
+	}
+	if found.ID != "" {
+		return nil, ErrLoginTaken
+	}
+	existing, err := s.users.FindByEmail(ctx, p.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrEmailTaken
+	}
+	plaintext := p.Password
+	if plaintext == "" {
+		plaintext = newSecret()
+	} else if err := s.passwordPolicy.Check(p.Login, plaintext); err != nil {
+		return nil, err
+	}
+	hashed, err := s.hasher.HashPassword(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	u := &domain.User{ID: generateID(), Login: p.Login, Email: p.Email, PasswordHash: hashed, ExternalID: p.ExternalID}
+	if err := s.users.CreateUser(ctx, u); err != nil {
+		return nil, err
+	}
+	if err := s.users.MarkEmailVerified(ctx, u.ID); err != nil {
+		return nil, err
+	}
+	if !p.Active {
+		now := time.Now()
+		if err := s.users.SetUserDisabled(ctx, u.ID, &now); err != nil {
+			return nil, err
+		}
+	}
+	s.events.Publish("USER_REGISTERED", map[string]any{"userID": u.ID, "email": u.Email, "source": "scim"})
+	return s.GetUser(ctx, u.ID)
+}
+
+// UpdateProvisionedUser replaces the managed attributes of a user.
+// Deactivating the user signs it out everywhere; a new password does too.
+// Users holding a reserved role cannot be changed.
+func (s *Service) UpdateProvisionedUser(ctx context.Context, userID string, p ProvisionedUser) (*domain.User, error) {
+	u, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkProvisionable(ctx, u); err != nil {
+		return nil, err
+	}
+	if p.Login != u.Login || p.Email != u.Email || p.ExternalID != u.ExternalID {
+		if err := s.users.UpdateUserProfile(ctx, userID, p.Login, p.Email, p.ExternalID); err != nil {
+			switch {
+			case errors.Is(err, store.ErrLoginTaken):
+				return nil, ErrLoginTaken
+			case errors.Is(err, store.ErrEmailTaken):
+				return nil, ErrEmailTaken
+			}
+			return nil, err
+		}
+		if p.Email != u.Email {
+			if err := s.users.MarkEmailVerified(ctx, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	signOut := false
+	if p.Password != "" {
+		if err := s.passwordPolicy.Check(p.Login, p.Password); err != nil {
+			return nil, err
+		}
+		hashed, err := s.hasher.HashPassword(p.Password)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.users.UpdatePassword(ctx, userID, hashed); err != nil {
+			return nil, err
+		}
+		signOut = true
+	}
+	switch {
+	case !p.Active && !u.Disabled():
+		now := time.Now()
+		if err := s.users.SetUserDisabled(ctx, userID, &now); err != nil {
+			return nil, err
+		}
+		signOut = true
+		s.events.Publish("USER_DISABLED", map[string]any{"userID": userID, "by": "scim"})
+	case p.Active && u.Disabled():
+		if err := s.users.SetUserDisabled(ctx, userID, nil); err != nil {
+			return nil, err
+		}
+		s.events.Publish("USER_ENABLED", map[string]any{"userID": userID, "by": "scim"})
+	}
+	if signOut {
+		if err := s.signOutEverywhere(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	s.events.Publish("USER_UPDATED", map[string]any{"userID": userID, "source": "scim"})
+	return s.GetUser(ctx, userID)
+}
+
+// DeleteUser signs the user out everywhere and deletes the account. Users
+// holding a reserved role cannot be deleted.
+func (s *Service) DeleteUser(ctx context.Context, userID string) error {
+	u, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkProvisionable(ctx, u); err != nil {
+		return err
+	}
+	if err := s.signOutEverywhere(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.users.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+	s.events.Publish("USER_DELETED", map[string]any{"userID": userID, "source": "scim"})
+	return nil
+}
+
+// ListGroups returns the provisioned groups.
+func (s *Service) ListGroups(ctx context.Context) ([]store.Group, error) {
+	return s.users.ListGroups(ctx)
+}
+
+// GetGroup returns the group; store.ErrNotFound if there is none.
+func (s *Service) GetGroup(ctx context.Context, id string) (*store.Group, error) {
+	g, err := s.users.GetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, store.ErrNotFound
+	}
+	return g, nil
+}
+
+// GroupMembers returns the users holding the group's role.
+func (s *Service) GroupMembers(ctx context.Context, g *store.Group) ([]domain.User, error) {
+	return s.users.UsersWithRole(ctx, g.DisplayName)
+}
+
+// CreateGroup creates a group whose members get the role displayName.
+func (s *Service) CreateGroup(ctx context.Context, displayName, externalID string, memberIDs []string) (*store.Group, error) {
+	if err := s.checkGroupName(ctx, "", displayName); err != nil {
+		return nil, err
+	}
+	g := &store.Group{ID: "g-" + newSecret()[:22], DisplayName: displayName, ExternalID: externalID}
+	if err := s.users.SaveGroup(ctx, g); err != nil {
+		return nil, err
+	}
+	if err := s.setGroupMembers(ctx, g.DisplayName, nil, memberIDs); err != nil {
+		return nil, err
+	}
+	s.events.Publish("GROUP_CREATED", map[string]any{"groupID": g.ID, "name": g.DisplayName, "members": len(memberIDs)})
+	return g, nil
+}
+
+// UpdateGroup replaces name, external id and members of a group. A new
+// name renames the role of the members.
+func (s *Service) UpdateGroup(ctx context.Context, id, displayName, externalID string, memberIDs []string) (*store.Group, error) {
+	g, err := s.GetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.GroupMembers(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+	if displayName != g.DisplayName {
+		if err := s.checkGroupName(ctx, id, displayName); err != nil {
+			return nil, err
+		}
+		for _, u := range current {
+			if err := s.replaceRole(ctx, &u, g.DisplayName, displayName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	g.DisplayName, g.ExternalID = displayName, externalID
+	if err := s.users.SaveGroup(ctx, g); err != nil {
+		return nil, err
+	}
+	if err := s.setGroupMembers(ctx, g.DisplayName, current, memberIDs); err != nil {
+		return nil, err
+	}
+	s.events.Publish("GROUP_UPDATED", map[string]any{"groupID": g.ID, "name": g.DisplayName, "members": len(memberIDs)})
+	return g, nil
+}
+
+// DeleteGroup revokes the group's role from its members and deletes it.
+func (s *Service) DeleteGroup(ctx context.Context, id string) error {
+	g, err := s.GetGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	members, err := s.GroupMembers(ctx, g)
+	if err != nil {
+		return err
+	}
+	for _, u := range members {
+		if err := s.replaceRole(ctx, &u, g.DisplayName, ""); err != nil {
+			return err
+		}
+	}
+	if err := s.users.DeleteGroup(ctx, id); err != nil {
+		return err
+	}
+	s.events.Publish("GROUP_DELETED", map[string]any{"groupID": g.ID, "name": g.DisplayName})
+	return nil
+}
+
+// checkGroupName validates the name of a new or renamed group.
+func (s *Service) checkGroupName(ctx context.Context, id, displayName string) error {
+	if slices.Contains(reservedRoles, displayName) {
+		return ErrReservedRole
+	}
+	other, err := s.users.FindGroupByName(ctx, displayName)
+	if err != nil {
+		return err
+	}
+	if other != nil && other.ID != id {
+		return ErrGroupExists
+	}
+	return nil
+}
+
+// checkProvisionable refuses changes to users holding a reserved role,
+// directly or through a role group, so that the SCIM token cannot take
+// over or remove administrators.
+func (s *Service) checkProvisionable(ctx context.Context, u *domain.User) error {
+	for _, role := range reservedRoles {
+		ok, err := s.HasEffectiveRole(ctx, u.ID, role)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return ErrProtectedUser
+		}
+	}
+	return nil
+}
+
+// setGroupMembers grants role to the users memberIDs and revokes it from
+// the current members not among them.
+func (s *Service) setGroupMembers(ctx context.Context, role string, current []domain.User, memberIDs []string) error {
+	members := make([]*domain.User, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		u, err := s.users.FindByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return ErrUnknownMember
+		}
+		members = append(members, u)
+	}
+	for _, u := range current {
+		if !slices.Contains(memberIDs, u.ID) {
+			if err := s.replaceRole(ctx, &u, role, ""); err != nil {
+				return err
+			}
+		}
+	}
+	for _, u := range members {
+		if !u.HasRole(role) {
+			if err := s.replaceRole(ctx, u, "", role); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replaceRole removes role from from the user and adds role to; either may
+// be empty.
+func (s *Service) replaceRole(ctx context.Context, u *domain.User, from, to string) error {
+	roles := make([]string, 0, len(u.Roles)+1)
+	for _, r := range u.Roles {
+		if r != from && r != to {
+			roles = append(roles, r)
+		}
+	}
+	if to != "" {
+		roles = append(roles, to)
+	}
+	if err := s.users.SetUserRoles(ctx, u.ID, roles); err != nil {
+		return err
+	}
+	u.Roles = roles
+	return nil
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+const (
+	// recoveryCodeCount is the size of a generated set.
+	recoveryCodeCount = 10
+	// recoveryAlphabet avoids look-alike characters (0/o, 1/l/i).
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// GenerateRecoveryCodes replaces the user's recovery codes with a fresh
+// set and returns the plaintext codes. They are shown once; only hashes
+// are stored.
+func (s *Service) GenerateRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	now := time.Now()
+	plain := make([]string, 0, recoveryCodeCount)
+	recs := make([]store.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := s.hasher.HashPassword(normalizeRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, code)
+		recs = append(recs, store.RecoveryCode{
+			ID:        fmt.Sprintf("rc-%d-%d", now.UnixNano(), i),
+			UserID:    userID,
+			CodeHash:  hash,
+			CreatedAt: now,
+		})
+	}
+	if err := s.users.ReplaceRecoveryCodes(ctx, userID, recs); err != nil {
+		return nil, err
+	}
+	s.events.Publish("MFA_RECOVERY_CODES_GENERATED", map[string]any{"userID": userID, "count": len(recs)})
+	return plain, nil
+}
+
+// RegenerateRecoveryCodes invalidates the current recovery codes and
+// issues a new set. A current TOTP code is required.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	rec, err := s.users.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || !rec.Confirmed {
+		return nil, errors.New("totp is not enabled")
+	}
+	if err := s.verifyTOTP(ctx, rec, code); err != nil {
+		return nil, err
+	}
+	return s.GenerateRecoveryCodes(ctx, userID)
+}
+
+// VerifyMFARecovery exchanges an mfa_required challenge and a recovery
+// code for tokens. The code is consumed, and so is the challenge; a wrong
+// code counts as an attempt like a wrong TOTP code.
+func (s *Service) VerifyMFARecovery(ctx context.Context, challenge, code string) (*jwt.Tokens, error) {
+	userID, amr, err := s.openMFAChallenge(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := s.useRecoveryCode(ctx, userID, code)
+	if err != nil {
+		s.events.Publish("MFA_FAILED", map[string]any{"userID": userID, "method": "recovery_code"})
+		return nil, err
+	}
+	if err := s.consumeMFAChallenge(ctx, challenge); err != nil {
+		return nil, err
+	}
+	s.events.Publish("MFA_RECOVERY_CODE_USED", map[string]any{"userID": userID, "remaining": remaining})
+	amr = append(amr, "mfa")
+	if err := s.termsGate(ctx, userID, amr); err != nil {
+		return nil, err
+	}
+	tokens, err := s.issueSession(ctx, userID, amr)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish("LOGIN_SUCCESS", map[string]any{"userID": userID, "mfa": true})
+	return tokens, nil
+}
+
+// RecoveryCodesRemaining returns the number of unused recovery codes.
+func (s *Service) RecoveryCodesRemaining(ctx context.Context, userID string) (int, error) {
+	codes, err := s.users.UnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return len(codes), nil
+}
+
+// useRecoveryCode finds the unused code matching plaintext, marks it used
+// and returns the number of codes left.
+func (s *Service) useRecoveryCode(ctx context.Context, userID, plaintext string) (int, error) {
+	codes, err := s.users.UnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	normalized := normalizeRecoveryCode(plaintext)
+	for _, c := range codes {
+		if s.hasher.CompareHashAndPassword(c.CodeHash, normalized) != nil {
+			continue
+		}
+		ok, err := s.users.UseRecoveryCode(ctx, userID, c.ID)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, ErrInvalidCode
+		}
+		return len(codes) - 1, nil
+	}
+	return 0, ErrInvalidCode
+}
+
+// newRecoveryCode returns a code formatted as xxxxx-xxxxx.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("recovery code: %w", err)
+	}
+	for i := range b {
+		b[i] = recoveryAlphabet[int(b[i])%len(recoveryAlphabet)]
+	}
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
+
+// normalizeRecoveryCode makes input tolerant to case, spaces and dashes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/revoke"
+	"auth_project/internal/store"
+)
+
+var (
+	// ErrTokenRevoked is returned for access tokens on the denylist.
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrAccountDisabled is returned when a disabled user tries to sign in
+	// or refresh tokens.
+	ErrAccountDisabled = errors.New("account disabled")
+)
+
+// WithDenylist sets the jti denylist consulted by Validate. By default
+// the Service creates one backed by its UserStore.
+func WithDenylist(d *revoke.Denylist) Option {
+	return func(s *Service) { s.denylist = d }
+}
+
+// Logout ends the session of the given access token: its refresh tokens
+// are revoked and all its access tokens, including this one, are denied.
+func (s *Service) Logout(ctx context.Context, claims *jwt.AccessClaims) error {
+	if claims.SessionID != "" {
+		if err := s.users.RevokeSession(ctx, claims.SessionID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		if err := s.revokeAccessTokens(ctx, claims.Subject, claims.SessionID); err != nil {
+			return err
+		}
+	}
+	if err := s.denylist.Revoke(ctx, claims.ID, claims.ExpiresAt); err != nil {
+		return err
+	}
+	s.events.Publish("LOGOUT", map[string]any{"userID": claims.Subject, "sessionID": claims.SessionID})
+	return nil
+}
+
+// ChangePassword replaces the user's password. All sessions are signed out
+// and outstanding access tokens are revoked.
+func (s *Service) ChangePassword(ctx context.Context, userID, current, next string) error {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return errors.New("user not found")
+	}
+	if err := s.hasher.CompareHashAndPassword(u.PasswordHash, current); err != nil {
+		return ErrAuthFailed
+	}
+	if err := s.passwordPolicy.Check(u.Login, next); err != nil {
+		return err
+	}
+	hashed, err := s.hasher.HashPassword(next)
+	if err != nil {
+		return err
+	}
+	if err := s.users.UpdatePassword(ctx, userID, hashed); err != nil {
+		return err
+	}
+	if err := s.signOutEverywhere(ctx, userID); err != nil {
+		return err
+	}
+	s.events.Publish("PASSWORD_CHANGED", map[string]any{"userID": userID})
+	return nil
+}
+
+// DisableUser blocks the account: logins and refreshes fail and all
+// outstanding access tokens are revoked.
+func (s *Service) DisableUser(ctx context.Context, adminID, userID string) error {
+	if adminID == userID {
+		return errors.New("cannot disable own account")
+	}
+	now := time.Now()
+	if err := s.users.SetUserDisabled(ctx, userID, &now); err != nil {
+		return err
+	}
+	if err := s.signOutEverywhere(ctx, userID); err != nil {
+		return err
+	}
+	s.events.Publish("USER_DISABLED", map[string]any{"userID": userID, "by": adminID})
+	return nil
+}
+
+// EnableUser lifts a previous DisableUser.
+func (s *Service) EnableUser(ctx context.Context, adminID, userID string) error {
+	if err := s.users.SetUserDisabled(ctx, userID, nil); err != nil {
+		return err
+	}
+	s.events.Publish("USER_ENABLED", map[string]any{"userID": userID, "by": adminID})
+	return nil
+}
+
+// EnsureRole grants role to the user identified by login or email. It is
+// used to bootstrap the first administrators from configuration.
+func (s *Service) EnsureRole(ctx context.Context, ident, role string) error {
+	var u *domain.User
+	if strings.Contains(ident, "@") {
+		found, err := s.users.FindByEmail(ctx, ident)
+		if err != nil {
+			return err
+		}
+		u = found
+	} else {
+		found, err := s.users.FindByLogin(ctx, ident)
+		if err != nil {
+			return err
+		}
+		if found.ID != "" {
+			u = &found
+		}
+	}
+	if u == nil {
+		return errors.New("user not found")
+	}
+	if u.HasRole(role) {
+		return nil
+	}
+	return s.users.SetUserRoles(ctx, u.ID, append(u.Roles, role))
+}
+
+// signOutEverywhere revokes every session and access token of the user.
+func (s *Service) signOutEverywhere(ctx context.Context, userID string) error {
+	if err := s.users.RevokeUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	return s.revokeAccessTokens(ctx, userID, "")
+}
+
+// revokeAccessTokens denies the unexpired access tokens of the user (of
+// one session when sessionID is set).
+func (s *Service) revokeAccessTokens(ctx context.Context, userID, sessionID string) error {
+	recs, err := s.users.ActiveAccessTokens(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := s.denylist.Revoke(ctx, rec.JTI, rec.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
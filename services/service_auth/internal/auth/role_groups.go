@@ -0,0 +1,378 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+var (
+	// ErrGroupCycle is returned when the parents of a group would make it
+	// inherit from itself.
+	ErrGroupCycle = errors.New("group cannot inherit from itself")
+	// ErrGroupNameTaken is returned when another group has the same name.
+	ErrGroupNameTaken = errors.New("group name already in use")
+	// ErrInvalidGroup is returned for groups without a name, with empty
+	// roles or with unknown parents.
+	ErrInvalidGroup = errors.New("invalid group")
+)
+
+// permissionCacheTTL bounds how long effective permissions computed by one
+// instance may miss group changes made by another.
+const permissionCacheTTL = time.Minute
+
+// defaultMaxTokenRoles is the number of effective roles emitted in access
+// tokens before they are truncated, see WithMaxTokenRoles.
+const defaultMaxTokenRoles = 50
+
+// WithMaxTokenRoles sets how many effective roles access tokens carry.
+// Users with more roles get tokens with their own roles only and the
+// roles_truncated claim; the full set is served by EffectivePermissions.
+func WithMaxTokenRoles(n int) Option {
+	return func(s *Service) { s.maxTokenRoles = n }
+}
+
+// RoleGroupInput is a group as created or replaced by an administrator.
+type RoleGroupInput struct {
+	Name      string   `json:"name"`
+	Roles     []string `json:"roles"`
+	ParentIDs []string `json:"parent_ids"`
+}
+
+// Permissions are the effective roles of a user: the roles granted to the
+// user directly and those of every group the user belongs to, directly or
+// through nested groups.
+type Permissions struct {
+	UserID      string   `json:"user_id"`
+	Roles       []string `json:"roles"`
+	DirectRoles []string `json:"direct_roles"`
+	// Groups are the ids of the direct and inherited groups.
+	Groups []string `json:"groups"`
+}
+
+// permissionCache keeps the group-derived roles of users. Any change of a
+// group or a membership drops all entries.
+type permissionCache struct {
+	mu     sync.Mutex
+	byUser map[string]groupGrant
+}
+
+// groupGrant are the groups of a user and the roles they grant.
+type groupGrant struct {
+	groups  []string
+	roles   []string
+	expires time.Time
+}
+
+func (c *permissionCache) get(userID string) (groupGrant, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.byUser[userID]
+	if !ok || time.Now().After(g.expires) {
+		return groupGrant{}, false
+	}
+	return g, true
+}
+
+func (c *permissionCache) put(userID string, g groupGrant) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byUser == nil {
+		c.byUser = make(map[string]groupGrant)
+	}
+	g.expires = time.Now().Add(permissionCacheTTL)
+	c.byUser[userID] = g
+}
+
+func (c *permissionCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byUser = nil
+}
+
+// ListRoleGroups returns all groups ordered by creation time.
+func (s *Service) ListRoleGroups(ctx context.Context) ([]domain.RoleGroup, error) {
+	return s.users.ListRoleGroups(ctx)
+}
+
+// GetRoleGroup returns the group or store.ErrNotFound.
+func (s *Service) GetRoleGroup(ctx context.Context, id string) (*domain.RoleGroup, error) {
+	g, err := s.users.GetRoleGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, store.ErrNotFound
+	}
+	return g, nil
+}
+
+// CreateRoleGroup creates a group granting roles to its members and to the
+// members of its child groups.
+func (s *Service) CreateRoleGroup(ctx context.Context, adminID string, in RoleGroupInput) (*domain.RoleGroup, error) {
+	g := &domain.RoleGroup{ID: "rg-" + newSecret()[:22]}
+	if err := s.saveRoleGroup(ctx, g, in); err != nil {
+		return nil, err
+	}
+	s.events.Publish("ROLE_GROUP_CREATED", map[string]any{"groupID": g.ID, "name": g.Name, "roles": g.Roles, "parentIDs": g.ParentIDs, "by": adminID})
+	return g, nil
+}
+
+// UpdateRoleGroup replaces the name, roles and parents of a group.
+// Returns ErrGroupCycle if the group would inherit from itself.
+func (s *Service) UpdateRoleGroup(ctx context.Context, adminID, id string, in RoleGroupInput) (*domain.RoleGroup, error) {
+	g, err := s.GetRoleGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveRoleGroup(ctx, g, in); err != nil {
+		return nil, err
+	}
+	s.events.Publish("ROLE_GROUP_UPDATED", map[string]any{"groupID": g.ID, "name": g.Name, "roles": g.Roles, "parentIDs": g.ParentIDs, "by": adminID})
+	return g, nil
+}
+
+// saveRoleGroup validates in, applies it to g and stores g.
+func (s *Service) saveRoleGroup(ctx context.Context, g *domain.RoleGroup, in RoleGroupInput) error {
+	name := strings.TrimSpace(in.Name)
+	if name == "" || len(name) > 100 {
+		return fmt.Errorf("%w: name must have 1 to 100 characters", ErrInvalidGroup)
+	}
+	roles, err := cleanList(in.Roles)
+	if err != nil {
+		return fmt.Errorf("%w: roles must not be empty", ErrInvalidGroup)
+	}
+	parents, err := cleanList(in.ParentIDs)
+	if err != nil {
+		return fmt.Errorf("%w: parent ids must not be empty", ErrInvalidGroup)
+	}
+	all, err := s.users.ListRoleGroups(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]domain.RoleGroup, len(all)+1)
+	for _, other := range all {
+		byID[other.ID] = other
+	}
+	for _, p := range parents {
+		if _, ok := byID[p]; !ok {
+			return fmt.Errorf("%w: unknown parent group %s", ErrInvalidGroup, p)
+		}
+	}
+	candidate := *g
+	candidate.ParentIDs = parents
+	byID[g.ID] = candidate
+	if inherits(byID, parents, g.ID) {
+		return ErrGroupCycle
+	}
+	g.Name, g.Roles, g.ParentIDs = name, roles, parents
+	if err := s.users.SaveRoleGroup(ctx, g); err != nil {
+		if errors.Is(err, store.ErrGroupNameTaken) {
+			return ErrGroupNameTaken
+		}
+		return err
+	}
+	s.permissions.reset()
+	return nil
+}
+
+// inherits reports whether target is reachable from the groups in start by
+// following parents, i.e. whether they inherit from target.
+func inherits(byID map[string]domain.RoleGroup, start []string, target string) bool {
+	seen := make(map[string]bool)
+	stack := slices.Clone(start)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == target {
+			return true
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		stack = append(stack, byID[id].ParentIDs...)
+	}
+	return false
+}
+
+// DeleteRoleGroup removes a group; its child groups stop inheriting from
+// it and its members lose its roles.
+func (s *Service) DeleteRoleGroup(ctx context.Context, adminID, id string) error {
+	if err := s.users.DeleteRoleGroup(ctx, id); err != nil {
+		return err
+	}
+	s.permissions.reset()
+	s.events.Publish("ROLE_GROUP_DELETED", map[string]any{"groupID": id, "by": adminID})
+	return nil
+}
+
+// RoleGroupMembers returns the ids of the direct members of a group.
+func (s *Service) RoleGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	if _, err := s.GetRoleGroup(ctx, groupID); err != nil {
+		return nil, err
+	}
+	members, err := s.users.RoleGroupMembers(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if members == nil {
+		members = []string{}
+	}
+	return members, nil
+}
+
+// AddRoleGroupMember adds the user to a group. Tokens issued before carry
+// the previous roles until they expire.
+func (s *Service) AddRoleGroupMember(ctx context.Context, adminID, groupID, userID string) error {
+	if err := s.users.AddRoleGroupMember(ctx, groupID, userID); err != nil {
+		return err
+	}
+	s.permissions.reset()
+	s.events.Publish("ROLE_GROUP_MEMBER_ADDED", map[string]any{"groupID": groupID, "userID": userID, "by": adminID})
+	return nil
+}
+
+// RemoveRoleGroupMember removes the user from a group.
+func (s *Service) RemoveRoleGroupMember(ctx context.Context, adminID, groupID, userID string) error {
+	if err := s.users.RemoveRoleGroupMember(ctx, groupID, userID); err != nil {
+		return err
+	}
+	s.permissions.reset()
+	s.events.Publish("ROLE_GROUP_MEMBER_REMOVED", map[string]any{"groupID": groupID, "userID": userID, "by": adminID})
+	return nil
+}
+
+// EffectivePermissions returns the effective roles of the user.
+func (s *Service) EffectivePermissions(ctx context.Context, userID string) (*Permissions, error) {
+	u, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	grant, err := s.groupGrant(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	direct := u.Roles
+	if direct == nil {
+		direct = []string{}
+	}
+	return &Permissions{UserID: u.ID, Roles: mergeRoles(direct, grant.roles), DirectRoles: direct, Groups: grant.groups}, nil
+}
+
+// HasEffectiveRole reports whether the user holds role directly or through
+// a group.
+func (s *Service) HasEffectiveRole(ctx context.Context, userID, role string) (bool, error) {
+	perms, err := s.EffectivePermissions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(perms.Roles, role), nil
+}
+
+// effectiveRoles returns the direct and group-derived roles of u.
+func (s *Service) effectiveRoles(ctx context.Context, u *domain.User) ([]string, error) {
+	grant, err := s.groupGrant(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return mergeRoles(u.Roles, grant.roles), nil
+}
+
+// groupGrant returns the groups of the user, following parents, and the
+// roles they grant. Results are cached for permissionCacheTTL.
+func (s *Service) groupGrant(ctx context.Context, userID string) (groupGrant, error) {
+	if g, ok := s.permissions.get(userID); ok {
+		return g, nil
+	}
+	direct, err := s.users.UserRoleGroups(ctx, userID)
+	if err != nil {
+		return groupGrant{}, err
+	}
+	out := groupGrant{groups: []string{}, roles: []string{}}
+	if len(direct) > 0 {
+		all, err := s.users.ListRoleGroups(ctx)
+		if err != nil {
+			return groupGrant{}, err
+		}
+		byID := make(map[string]domain.RoleGroup, len(all))
+		for _, g := range all {
+			byID[g.ID] = g
+		}
+		// the seen set also stops cycles left by concurrent updates
+		seen := make(map[string]bool)
+		stack := slices.Clone(direct)
+		for len(stack) > 0 {
+			id := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			g, ok := byID[id]
+			if !ok || seen[id] {
+				continue
+			}
+			seen[id] = true
+			out.groups = append(out.groups, id)
+			out.roles = append(out.roles, g.Roles...)
+			stack = append(stack, g.ParentIDs...)
+		}
+		slices.Sort(out.groups)
+		out.roles = mergeRoles(out.roles, nil)
+	}
+	s.permissions.put(userID, out)
+	return out, nil
+}
+
+// mergeRoles returns the sorted union of a and b.
+func mergeRoles(a, b []string) []string {
+	out := make([]string, 0, len(a)+len(b))
+	out = append(append(out, a...), b...)
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// cleanList trims the values, drops duplicates and sorts them; empty
+// values are an error.
+func cleanList(values []string) ([]string, error) {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, errors.New("empty value")
+		}
+		out = append(out, v)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// accessUserClaims returns the claims describing the user in access tokens:
+// the effective roles and the OrgDirectory links. Roles beyond the
+// maxTokenRoles limit are left out and flagged with roles_truncated.
+func (s *Service) accessUserClaims(ctx context.Context, u *domain.User) ([]jwt.IssueOption, error) {
+	roles, err := s.effectiveRoles(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	var opts []jwt.IssueOption
+	if s.maxTokenRoles > 0 && len(roles) > s.maxTokenRoles {
+		roles = u.Roles
+		opts = append(opts, jwt.WithClaim("roles_truncated", true))
+	}
+	if len(roles) > 0 {
+		opts = append(opts, jwt.WithClaim("roles", roles))
+	}
+	if u.CitizenID != "" {
+		opts = append(opts, jwt.WithClaim("citizen_id", u.CitizenID))
+	}
+	if len(u.OrgIDs) > 0 {
+		opts = append(opts, jwt.WithClaim("org_ids", u.OrgIDs))
+	}
+	return opts, nil
+}
@@ -2,9 +2,15 @@ package auth
 
 import (
 	"auth_project/internal/domain"
+	"auth_project/internal/dpop"
 	"auth_project/internal/event"
+	"auth_project/internal/federation"
 	"auth_project/internal/jwt"
+	"auth_project/internal/ldapauth"
+	"auth_project/internal/mail"
 	"auth_project/internal/password"
+	"auth_project/internal/revoke"
+	"auth_project/internal/seal"
 	"auth_project/internal/store"
 	"context"
 	"errors"
@@ -13,6 +19,10 @@ import (
 	"time"
 )
 
+// ErrAuthFailed is returned when credentials do not match. The message is
+// deliberately the same for unknown users and wrong passwords.
+var ErrAuthFailed = errors.New("authentication failed")
+
 // Service implements the core authentication logic: registration, login,
 // token refresh and validation. It coordinates user storage, password
 // hashing, JWT issuance and event publishing【351230703904074†screenshot】.
@@ -21,15 +31,80 @@ type Service struct {
 	hasher password.Hasher
 	tokens *jwt.Service
 	events event.Publisher
+	mailer mail.Mailer
+	box    *seal.Box
+
+	denylist   *revoke.Denylist
+	dpop       *dpop.Verifier
+	federation *federation.Client
+	// directories are LDAP directories by login suffix, see WithLDAPDomain.
+	directories map[string]*ldapauth.Authenticator
+	// scimTokenHash is the hash of the SCIM bearer token, see
+	// WithSCIMToken.
+	scimTokenHash string
+	// passwordPolicy is checked for every password a user chooses.
+	passwordPolicy PasswordPolicy
+	// permissions caches the roles users get from role groups; tokens
+	// carry at most maxTokenRoles of them.
+	permissions   permissionCache
+	maxTokenRoles int
+	// policy is the authorization policy in force, see LoadPolicy.
+	policy activePolicy
+	// inviteOnly disables open registration, see WithInviteOnly.
+	inviteOnly bool
+
+	// publicURL is the externally reachable base URL of the service, used
+	// to build links sent by email.
+	publicURL string
+}
+
+// Option configures optional dependencies of Service.
+type Option func(*Service)
+
+// WithMailer sets the Mailer used for confirmations and notifications.
+func WithMailer(m mail.Mailer) Option {
+	return func(s *Service) { s.mailer = m }
+}
+
+// WithPublicURL sets the base URL used for links in emails.
+func WithPublicURL(u string) Option {
+	return func(s *Service) { s.publicURL = strings.TrimRight(u, "/") }
 }
 
 // New constructs a new Service.
-func New(users store.UserStore, hasher password.Hasher, tokens *jwt.Service, events event.Publisher) *Service {
-	return &Service{users: users, hasher: hasher, tokens: tokens, events: events}
+func New(users store.UserStore, hasher password.Hasher, tokens *jwt.Service, events event.Publisher, opts ...Option) *Service {
+	s := &Service{
+		users:     users,
+		hasher:    hasher,
+		tokens:    tokens,
+		events:    events,
+		mailer:    mail.StdoutMailer{},
+		publicURL: "http://localhost:8080",
+
+		passwordPolicy: DefaultPasswordPolicy,
+		maxTokenRoles:  defaultMaxTokenRoles,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.denylist == nil {
+		s.denylist = revoke.NewDenylist(users)
+	}
+	s.dpop = dpop.NewVerifier(dpopProofWindow)
+	s.federation = federation.NewClient(nil)
+	return s
 }
 
 // Register creates a new user with a hashed password and publishes an event.
+// With WithInviteOnly it returns ErrInvitationRequired, see
+// RegisterWithInvitation.
 func (s *Service) Register(ctx context.Context, login, email, plaintext string) error {
+	if s.inviteOnly {
+		return ErrInvitationRequired
+	}
+	if err := s.passwordPolicy.Check(login, plaintext); err != nil {
+		return err
+	}
 	hashed, err := s.hasher.HashPassword(plaintext)
 	if err != nil {
 		return err
@@ -44,18 +119,34 @@ func (s *Service) Register(ctx context.Context, login, email, plaintext string)
 
 // Login authenticates the user and issues tokens. It publishes
 // LOGIN_SUCCESS or LOGIN_FAILED events depending on outcome【471101221547741†screenshot】.
+// When the user has MFA enabled no tokens are issued; a *ChallengeError
+// with status mfa_required is returned instead (see VerifyMFA).
 func (s *Service) Login(ctx context.Context, ident, plaintext string) (*jwt.Tokens, error) {
+	u, err := s.Authenticate(ctx, ident, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeLogin(ctx, u, []string{"pwd"})
+}
+
+// Authenticate checks the password of the user identified by login or
+// email without issuing tokens. It publishes LOGIN_FAILED on mismatch.
+// Identifiers routed to an LDAP directory are checked there.
+func (s *Service) Authenticate(ctx context.Context, ident, plaintext string) (*domain.User, error) {
+	if dir, username, suffix := s.directoryFor(ident); dir != nil {
+		return s.directoryAuthenticate(ctx, dir, suffix, username, plaintext)
+	}
 	var u *domain.User
 	if strings.Contains(ident, "@") {
 		// по email
 		user, err := s.users.FindByEmail(ctx, ident)
 		if err != nil {
 			s.events.Publish("LOGIN_FAILED", map[string]any{"email": ident, "error": err.Error()})
-			return nil, errors.New("authentication failed")
+			return nil, ErrAuthFailed
 		}
 		if user == nil {
 			s.events.Publish("LOGIN_FAILED", map[string]any{"email": ident, "error": "user not found"})
-			return nil, errors.New("authentication failed")
+			return nil, ErrAuthFailed
 		}
 		u = user
 	} else {
@@ -63,61 +154,185 @@ func (s *Service) Login(ctx context.Context, ident, plaintext string) (*jwt.Toke
 		user, err := s.users.FindByLogin(ctx, ident)
 		if err != nil {
 			s.events.Publish("LOGIN_FAILED", map[string]any{"login": ident, "error": err.Error()})
-			return nil, errors.New("authentication failed")
+			return nil, ErrAuthFailed
 		}
 		if user.ID == "" {
 			s.events.Publish("LOGIN_FAILED", map[string]any{"login": ident, "error": "user not found"})
-			return nil, errors.New("authentication failed")
+			return nil, ErrAuthFailed
 		}
 		u = &user
 	}
 
 	if u == nil {
-		return nil, errors.New("authentication failed")
+		return nil, ErrAuthFailed
 	}
 	if err := s.hasher.CompareHashAndPassword(u.PasswordHash, plaintext); err != nil {
 		s.events.Publish("LOGIN_FAILED", map[string]any{"userID": u.ID, "error": "incorrect password"})
-		return nil, errors.New("authentication failed")
+		return nil, ErrAuthFailed
+	}
+	return u, nil
+}
+
+// grant describes what issued tokens belong to: the login session, how the
+// user authenticated and, for tokens issued to an OAuth client, the client,
+// the granted scope and the DPoP key the tokens are bound to.
+type grant struct {
+	SessionID string
+	AMR       []string
+	ClientID  string
+	Scope     string
+	JKT       string
+}
+
+// issueSession starts a new login session for userID and issues its
+// first tokens.
+func (s *Service) issueSession(ctx context.Context, userID string, amr []string) (*jwt.Tokens, error) {
+	sess, err := s.newSession(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(ctx, userID, grant{SessionID: sess.ID, AMR: amr})
+}
+
+// issueTokens issues tokens within an existing session and persists the
+// refresh token. The grant is recorded both in the access token and the
+// refresh record so that refreshed tokens keep it.
+func (s *Service) issueTokens(ctx context.Context, userID string, g grant) (*jwt.Tokens, error) {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New("user not found")
+	}
+	if u.Disabled() {
+		return nil, ErrAccountDisabled
 	}
-	tokens, err := s.tokens.Issue(ctx, u.ID)
+	opts := []jwt.IssueOption{jwt.WithAMR(g.AMR...), jwt.WithClaim("sid", g.SessionID)}
+	userClaims, err := s.accessUserClaims(ctx, u)
 	if err != nil {
 		return nil, err
 	}
-	rec := store.RefreshRecord{UserID: u.ID, ExpiresAt: tokens.RefreshExpiry, Revoked: false}
+	opts = append(opts, userClaims...)
+	if g.ClientID != "" {
+		opts = append(opts, jwt.WithClaim("client_id", g.ClientID))
+	}
+	if g.Scope != "" {
+		opts = append(opts, jwt.WithClaim("scope", g.Scope))
+	}
+	opts = append(opts, jwt.WithConfirmation(g.JKT))
+	tokens, err := s.tokens.Issue(ctx, userID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	rec := store.RefreshRecord{
+		UserID:    userID,
+		ExpiresAt: tokens.RefreshExpiry,
+		Revoked:   false,
+		AMR:       g.AMR,
+		SessionID: g.SessionID,
+		ClientID:  g.ClientID,
+		Scope:     g.Scope,
+		JKT:       g.JKT,
+	}
 	if err := s.users.SaveRefreshToken(ctx, tokens.RefreshToken, rec); err != nil {
 		return nil, err
 	}
-	s.events.Publish("LOGIN_SUCCESS", map[string]any{"userID": u.ID})
+	access := store.AccessTokenRecord{JTI: tokens.AccessID, UserID: userID, SessionID: g.SessionID, ClientID: g.ClientID, ExpiresAt: tokens.AccessExpiry}
+	if err := s.users.RecordAccessToken(ctx, access); err != nil {
+		return nil, err
+	}
 	return tokens, nil
 }
 
 // Refresh validates the old refresh token, revokes it and issues new tokens.
+// Tokens issued to OAuth clients are refreshed at /oauth2/token instead.
 func (s *Service) Refresh(ctx context.Context, refreshToken string) (*jwt.Tokens, error) {
+	tokens, _, _, err := s.refresh(ctx, refreshToken, "", "")
+	return tokens, err
+}
+
+// refresh rotates refreshToken, which must belong to clientID ("" for
+// tokens of the first-party API), and returns the new tokens with their
+// owner and grant. A non-empty scope narrows the grant.
+func (s *Service) refresh(ctx context.Context, refreshToken, clientID, scope string) (tokens *jwt.Tokens, userID string, g grant, err error) {
 	rec, err := s.users.GetRefreshToken(ctx, refreshToken)
 	if err != nil || rec == nil {
-		return nil, errors.New("invalid refresh token")
+		return nil, "", grant{}, errors.New("invalid refresh token")
+	}
+	if rec.Revoked || time.Now().After(rec.ExpiresAt) || rec.ClientID != clientID {
+		return nil, "", grant{}, errors.New("invalid refresh token")
 	}
-	if rec.Revoked || time.Now().After(rec.ExpiresAt) {
-		return nil, errors.New("invalid refresh token")
+	if rec.SessionID != "" {
+		sess, err := s.users.GetSession(ctx, rec.SessionID)
+		if err != nil || sess == nil || sess.RevokedAt != nil {
+			return nil, "", grant{}, errors.New("invalid refresh token")
+		}
+	}
+	// a bound token needs a proof with the same key; an unbound one is
+	// bound from now on when the request carries a proof
+	g = grant{SessionID: rec.SessionID, AMR: rec.AMR, ClientID: rec.ClientID, Scope: rec.Scope, JKT: dpopKeyFrom(ctx)}
+	if rec.JKT != "" && rec.JKT != g.JKT {
+		return nil, "", grant{}, ErrDPoPKeyMismatch
+	}
+	if scope != "" {
+		if g.Scope, err = narrowScope(rec.Scope, scope); err != nil {
+			return nil, "", grant{}, err
+		}
 	}
 	// revoke the old token
 	_ = s.users.RevokeRefreshToken(ctx, refreshToken)
-	// issue new tokens
-	tokens, err := s.tokens.Issue(ctx, rec.UserID)
-	if err != nil {
-		return nil, err
+	// issue new tokens within the same session
+	if g.SessionID != "" {
+		_ = s.users.TouchSession(ctx, g.SessionID, clientInfoFrom(ctx).IP, time.Now())
+		tokens, err = s.issueTokens(ctx, rec.UserID, g)
+	} else {
+		// token issued before sessions were recorded
+		tokens, err = s.issueSession(ctx, rec.UserID, rec.AMR)
 	}
-	newRec := store.RefreshRecord{UserID: rec.UserID, ExpiresAt: tokens.RefreshExpiry, Revoked: false}
-	if err := s.users.SaveRefreshToken(ctx, tokens.RefreshToken, newRec); err != nil {
-		return nil, err
+	if err != nil {
+		return nil, "", grant{}, err
 	}
 	s.events.Publish("TOKEN_REFRESHED", map[string]any{"userID": rec.UserID})
-	return tokens, nil
+	return tokens, rec.UserID, g, nil
 }
 
 // Validate verifies the access token and returns the associated user ID.
+// Besides the signature it checks the jti denylist, so tokens revoked on
+// logout, password change or account disable stop working immediately.
 func (s *Service) Validate(accessToken string) (string, error) {
-	return s.tokens.ValidateAccess(accessToken)
+	claims, err := s.ValidateClaims(accessToken)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// ValidateClaims verifies the access token and returns all its claims.
+func (s *Service) ValidateClaims(accessToken string) (*jwt.AccessClaims, error) {
+	return s.notRevoked(s.tokens.ParseAccess(accessToken))
+}
+
+// ValidateClaimsFor verifies an access token issued for audience, e.g. a
+// downstream service's token obtained by token exchange.
+func (s *Service) ValidateClaimsFor(accessToken, audience string) (*jwt.AccessClaims, error) {
+	return s.notRevoked(s.tokens.ParseAccessFor(accessToken, audience))
+}
+
+// validateAnyAccess verifies an access token of any audience.
+func (s *Service) validateAnyAccess(accessToken string) (*jwt.AccessClaims, error) {
+	return s.notRevoked(s.tokens.ParseAnyAccess(accessToken))
+}
+
+// notRevoked checks parsed claims against the jti denylist.
+func (s *Service) notRevoked(claims *jwt.AccessClaims, err error) (*jwt.AccessClaims, error) {
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID != "" && s.denylist.Contains(claims.ID) {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
 }
 
 // generateID creates a unique identifier using current time. Use
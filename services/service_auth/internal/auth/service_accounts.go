@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+// NewClient describes a client created through the admin API. Its id and
+// secret are generated.
+type NewClient struct {
+	Name           string
+	RedirectURIs   []string
+	Scopes         []string
+	ServiceAccount bool
+	TokenExchange  []domain.ExchangePolicy
+}
+
+// ClientCredentials issues an access token to a service account acting on
+// its own behalf (grant_type=client_credentials). The token's `sub` is the
+// client id; no refresh token is issued.
+func (s *Service) ClientCredentials(ctx context.Context, client *domain.Client, scope string) (*GrantedTokens, error) {
+	if client.Public || !client.ServiceAccount {
+		return nil, oauthError("unauthorized_client", "client is not allowed to use client_credentials")
+	}
+	scope, err := grantedScope(client, scope, nil)
+	if err != nil {
+		return nil, err
+	}
+	opts := []jwt.IssueOption{jwt.WithClaim("client_id", client.ID), jwt.WithConfirmation(dpopKeyFrom(ctx))}
+	if scope != "" {
+		opts = append(opts, jwt.WithClaim("scope", scope))
+	}
+	tokens, err := s.tokens.IssueAccess(ctx, client.ID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	rec := store.AccessTokenRecord{JTI: tokens.AccessID, ClientID: client.ID, ExpiresAt: tokens.AccessExpiry}
+	if err := s.users.RecordAccessToken(ctx, rec); err != nil {
+		return nil, err
+	}
+	s.events.Publish("CLIENT_TOKEN_ISSUED", map[string]any{"clientID": client.ID, "scope": scope})
+	return &GrantedTokens{Tokens: tokens, Scope: scope}, nil
+}
+
+// ListClients returns all registered clients.
+func (s *Service) ListClients(ctx context.Context) ([]domain.Client, error) {
+	return s.users.ListClients(ctx)
+}
+
+// CreateClient registers a confidential client and returns it with its
+// secret. The secret is shown only once; just its hash is stored.
+func (s *Service) CreateClient(ctx context.Context, adminID string, nc NewClient) (*domain.Client, string, error) {
+	if err := checkExchangePolicies(nc.TokenExchange); err != nil {
+		return nil, "", err
+	}
+	secret := newSecret()
+	hashed, err := s.hasher.HashPassword(secret)
+	if err != nil {
+		return nil, "", err
+	}
+	c := &domain.Client{
+		ID:             "c-" + newSecret()[:22],
+		Name:           nc.Name,
+		SecretHash:     hashed,
+		RedirectURIs:   nc.RedirectURIs,
+		Scopes:         nc.Scopes,
+		ServiceAccount: nc.ServiceAccount,
+		TokenExchange:  nc.TokenExchange,
+	}
+	if err := s.users.SaveClient(ctx, c); err != nil {
+		return nil, "", err
+	}
+	s.events.Publish("CLIENT_CREATED", map[string]any{"clientID": c.ID, "serviceAccount": c.ServiceAccount, "by": adminID})
+	return c, secret, nil
+}
+
+// RotateClientSecret replaces the client's secret and returns the new
+// one. The old secret stops working and access tokens issued to the client
+// are revoked.
+func (s *Service) RotateClientSecret(ctx context.Context, adminID, clientID string) (string, error) {
+	c, err := s.confidentialClient(ctx, clientID)
+	if err != nil {
+		return "", err
+	}
+	secret := newSecret()
+	if c.SecretHash, err = s.hasher.HashPassword(secret); err != nil {
+		return "", err
+	}
+	if err := s.users.SaveClient(ctx, c); err != nil {
+		return "", err
+	}
+	if err := s.revokeClientTokens(ctx, clientID); err != nil {
+		return "", err
+	}
+	s.events.Publish("CLIENT_SECRET_ROTATED", map[string]any{"clientID": clientID, "by": adminID})
+	return secret, nil
+}
+
+// DisableClient blocks the client: authentication fails and its
+// outstanding access tokens are revoked.
+func (s *Service) DisableClient(ctx context.Context, adminID, clientID string) error {
+	c, err := s.users.GetClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return store.ErrNotFound
+	}
+	now := time.Now()
+	c.DisabledAt = &now
+	if err := s.users.SaveClient(ctx, c); err != nil {
+		return err
+	}
+	if err := s.revokeClientTokens(ctx, clientID); err != nil {
+		return err
+	}
+	s.events.Publish("CLIENT_DISABLED", map[string]any{"clientID": clientID, "by": adminID})
+	return nil
+}
+
+// EnableClient lifts a previous DisableClient.
+func (s *Service) EnableClient(ctx context.Context, adminID, clientID string) error {
+	c, err := s.users.GetClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return store.ErrNotFound
+	}
+	c.DisabledAt = nil
+	if err := s.users.SaveClient(ctx, c); err != nil {
+		return err
+	}
+	s.events.Publish("CLIENT_ENABLED", map[string]any{"clientID": clientID, "by": adminID})
+	return nil
+}
+
+// confidentialClient loads a client that authenticates with a secret.
+func (s *Service) confidentialClient(ctx context.Context, clientID string) (*domain.Client, error) {
+	c, err := s.users.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, store.ErrNotFound
+	}
+	if c.Public {
+		return nil, errPublicClient
+	}
+	return c, nil
+}
+
+// revokeClientTokens denies the unexpired access tokens issued to the
+// client.
+func (s *Service) revokeClientTokens(ctx context.Context, clientID string) error {
+	recs, err := s.users.ActiveClientAccessTokens(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := s.denylist.Revoke(ctx, rec.JTI, rec.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
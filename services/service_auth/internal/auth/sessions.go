@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"auth_project/internal/store"
+)
+
+// ErrSessionNotFound is returned for unknown sessions and sessions of
+// other users.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ListSessions returns the user's active sessions.
+func (s *Service) ListSessions(ctx context.Context, userID string) ([]store.Session, error) {
+	return s.users.ListSessions(ctx, userID)
+}
+
+// RevokeSession signs the user out of one session: its refresh and access
+// tokens stop working immediately.
+func (s *Service) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	sess, err := s.users.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if sess == nil || sess.UserID != userID || sess.RevokedAt != nil {
+		return ErrSessionNotFound
+	}
+	if err := s.users.RevokeSession(ctx, sessionID); err != nil {
+		return err
+	}
+	if err := s.revokeAccessTokens(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	s.events.Publish("SESSION_REVOKED", map[string]any{"userID": userID, "sessionID": sessionID})
+	return nil
+}
+
+// newSession records a login from the client attached to ctx.
+func (s *Service) newSession(ctx context.Context, userID string) (*store.Session, error) {
+	info := clientInfoFrom(ctx)
+	now := time.Now()
+	sess := store.Session{
+		ID:         "s-" + newSecret()[:22],
+		UserID:     userID,
+		UserAgent:  info.UserAgent,
+		IP:         info.IP,
+		DeviceName: deviceName(info.UserAgent),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if err := s.users.CreateSession(ctx, sess); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
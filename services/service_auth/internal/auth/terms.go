@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+// termsChallengeTTL bounds the time a user has to read and accept the
+// terms during login.
+const termsChallengeTTL = 15 * time.Minute
+
+var (
+	// ErrInvalidTerms is returned for terms versions without a title or
+	// without content and URL.
+	ErrInvalidTerms = errors.New("invalid terms version")
+	// ErrTermsOutdated is returned when a user accepts a version other
+	// than the latest one.
+	ErrTermsOutdated = errors.New("only the latest terms version can be accepted")
+)
+
+// NewTermsVersion is a terms of service version to publish. Mandatory
+// versions have to be accepted before the next login completes.
+type NewTermsVersion struct {
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	URL       string `json:"url"`
+	Mandatory bool   `json:"mandatory"`
+}
+
+// PublishTerms stores a new terms of service version. Once a mandatory
+// version is published, logins of users who accepted no version at least
+// as new end with a terms_required challenge.
+func (s *Service) PublishTerms(ctx context.Context, adminID string, in NewTermsVersion) (*store.TermsVersion, error) {
+	v := &store.TermsVersion{
+		Title:       strings.TrimSpace(in.Title),
+		Content:     in.Content,
+		URL:         strings.TrimSpace(in.URL),
+		Mandatory:   in.Mandatory,
+		PublishedBy: adminID,
+	}
+	if v.Title == "" {
+		return nil, fmt.Errorf("%w: title is required", ErrInvalidTerms)
+	}
+	if strings.TrimSpace(v.Content) == "" && v.URL == "" {
+		return nil, fmt.Errorf("%w: content or url is required", ErrInvalidTerms)
+	}
+	if err := s.users.CreateTermsVersion(ctx, v); err != nil {
+		return nil, err
+	}
+	s.events.Publish("TERMS_PUBLISHED", map[string]any{"version": v.Version, "mandatory": v.Mandatory, "by": adminID})
+	return v, nil
+}
+
+// CurrentTerms returns the latest terms version or store.ErrNotFound.
+func (s *Service) CurrentTerms(ctx context.Context) (*store.TermsVersion, error) {
+	v, err := s.users.LatestTermsVersion(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, store.ErrNotFound
+	}
+	return v, nil
+}
+
+// GetTermsVersion returns a terms version or store.ErrNotFound.
+func (s *Service) GetTermsVersion(ctx context.Context, version int) (*store.TermsVersion, error) {
+	v, err := s.users.GetTermsVersion(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, store.ErrNotFound
+	}
+	return v, nil
+}
+
+// ListTermsVersions returns all terms versions, newest first.
+func (s *Service) ListTermsVersions(ctx context.Context) ([]store.TermsVersion, error) {
+	return s.users.ListTermsVersions(ctx)
+}
+
+// TermsStatus describes the terms a user accepted.
+type TermsStatus struct {
+	// CurrentVersion is the latest version, RequiredVersion the latest
+	// mandatory one; zero if there is none.
+	CurrentVersion  int
+	RequiredVersion int
+	// Pending is set when the user has to accept the current version
+	// before the next login completes.
+	Pending     bool
+	Acceptances []store.TermsAcceptance
+}
+
+// TermsStatus returns the terms status of a user.
+func (s *Service) TermsStatus(ctx context.Context, userID string) (*TermsStatus, error) {
+	current, required, accepted, err := s.termsVersions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := &TermsStatus{Acceptances: accepted}
+	if current != nil {
+		out.CurrentVersion = current.Version
+	}
+	if required != nil {
+		out.RequiredVersion = required.Version
+		out.Pending = highestAccepted(accepted) < required.Version
+	}
+	return out, nil
+}
+
+// termsVersions returns the current and the required terms version and
+// the acceptances of userID.
+func (s *Service) termsVersions(ctx context.Context, userID string) (current, required *store.TermsVersion, accepted []store.TermsAcceptance, err error) {
+	if current, err = s.users.LatestTermsVersion(ctx, false); err != nil || current == nil {
+		return nil, nil, nil, err
+	}
+	if required, err = s.users.LatestTermsVersion(ctx, true); err != nil {
+		return nil, nil, nil, err
+	}
+	if accepted, err = s.users.ListTermsAcceptances(ctx, userID); err != nil {
+		return nil, nil, nil, err
+	}
+	return current, required, accepted, nil
+}
+
+func highestAccepted(accepted []store.TermsAcceptance) int {
+	highest := 0
+	for _, a := range accepted {
+		highest = max(highest, a.Version)
+	}
+	return highest
+}
+
+// termsGate decides whether a completed login may get tokens. It returns
+// a terms_required *ChallengeError while the user has not accepted the
+// latest mandatory terms version, or nil.
+func (s *Service) termsGate(ctx context.Context, userID string, amr []string) error {
+	current, required, accepted, err := s.termsVersions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if required == nil || highestAccepted(accepted) >= required.Version {
+		return nil
+	}
+	challenge, err := s.tokens.IssueChallenge(userID, StatusTermsRequired, amr, termsChallengeTTL)
+	if err != nil {
+		return err
+	}
+	s.events.Publish("LOGIN_TERMS_REQUIRED", map[string]any{"userID": userID, "version": current.Version})
+	return &ChallengeError{Status: StatusTermsRequired, Token: challenge, ExpiresIn: termsChallengeTTL, TermsVersion: current.Version}
+}
+
+// AcceptTermsChallenge exchanges a terms_required challenge for tokens
+// once the user accepted the latest terms version.
+func (s *Service) AcceptTermsChallenge(ctx context.Context, challenge string, version int) (*jwt.Tokens, error) {
+	userID, amr, err := s.acceptTermsChallenge(ctx, challenge, version)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.issueSession(ctx, userID, amr)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish("LOGIN_SUCCESS", map[string]any{"userID": userID})
+	return tokens, nil
+}
+
+// acceptTermsChallenge checks a terms_required challenge, records the
+// acceptance of version and returns the user and the completed
+// authentication methods.
+func (s *Service) acceptTermsChallenge(ctx context.Context, challenge string, version int) (string, []string, error) {
+	userID, amr, err := s.tokens.ValidateChallenge(challenge, StatusTermsRequired)
+	if err != nil {
+		return "", nil, errors.New("invalid challenge")
+	}
+	if err := s.AcceptTerms(ctx, userID, version); err != nil {
+		return "", nil, err
+	}
+	return userID, amr, nil
+}
+
+// AcceptTerms records that the user accepted a terms version, along with
+// the client it was accepted from. Only the latest version can be
+// accepted.
+func (s *Service) AcceptTerms(ctx context.Context, userID string, version int) error {
+	current, err := s.CurrentTerms(ctx)
+	if err != nil {
+		return err
+	}
+	if version != current.Version {
+		return ErrTermsOutdated
+	}
+	info := clientInfoFrom(ctx)
+	if err := s.users.SaveTermsAcceptance(ctx, store.TermsAcceptance{
+		UserID:     userID,
+		Version:    version,
+		AcceptedAt: time.Now(),
+		IP:         info.IP,
+		UserAgent:  info.UserAgent,
+	}); err != nil {
+		return err
+	}
+	s.events.Publish("TERMS_ACCEPTED", map[string]any{"userID": userID, "version": version, "ip": info.IP})
+	return nil
+}
+
+// TermsCoverage reports how many active users accepted the terms.
+type TermsCoverage struct {
+	ActiveUsers int `json:"active_users"`
+	// RequiredVersion is the latest mandatory version; Covered users
+	// accepted it or a newer version, Pending users did not.
+	RequiredVersion int                    `json:"required_version"`
+	Covered         int                    `json:"covered"`
+	Pending         int                    `json:"pending"`
+	Versions        []TermsVersionCoverage `json:"versions"`
+}
+
+// TermsVersionCoverage is the number of active users who accepted a
+// version or a newer one.
+type TermsVersionCoverage struct {
+	Version   int  `json:"version"`
+	Mandatory bool `json:"mandatory"`
+	Accepted  int  `json:"accepted"`
+}
+
+// TermsCoverage returns the acceptance coverage of all terms versions
+// among active users.
+func (s *Service) TermsCoverage(ctx context.Context) (*TermsCoverage, error) {
+	versions, err := s.users.ListTermsVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.users.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accepted, err := s.users.AcceptedTermsVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := &TermsCoverage{Versions: make([]TermsVersionCoverage, len(versions))}
+	for i, v := range versions {
+		out.Versions[i] = TermsVersionCoverage{Version: v.Version, Mandatory: v.Mandatory}
+		if v.Mandatory && out.RequiredVersion == 0 {
+			out.RequiredVersion = v.Version
+		}
+	}
+	for _, u := range users {
+		if u.Disabled() {
+			continue
+		}
+		out.ActiveUsers++
+		for i := range out.Versions {
+			if accepted[u.ID] >= out.Versions[i].Version {
+				out.Versions[i].Accepted++
+			}
+		}
+		if out.RequiredVersion > 0 {
+			if accepted[u.ID] >= out.RequiredVersion {
+				out.Covered++
+			} else {
+				out.Pending++
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+	"auth_project/internal/jwt"
+	"auth_project/internal/store"
+)
+
+// TokenTypeAccessToken is the RFC 8693 token type identifier of access
+// tokens, the only kind that can be exchanged or issued.
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// ErrInvalidExchangePolicy is returned when a client's token exchange
+// policy names no audience or one reserved for this service's own tokens.
+var ErrInvalidExchangePolicy = errors.New("invalid token exchange policy")
+
+// exchangeTTL is the default lifetime of exchanged tokens. They are meant
+// for a single downstream call chain.
+const exchangeTTL = 5 * time.Minute
+
+// TokenExchangeRequest holds the parameters of a token exchange request
+// (RFC 8693 section 2.1).
+type TokenExchangeRequest struct {
+	SubjectToken       string
+	SubjectTokenType   string
+	Audience           string
+	Scope              string
+	RequestedTokenType string
+}
+
+// ExchangeToken issues a token for a downstream service on behalf of the
+// subject of req.SubjectToken (grant_type=token-exchange). The subject
+// token must be one of our access tokens, either for this service or for
+// the client itself when it is a downstream service delegating further.
+// The new token has the requested audience, a narrower scope and lifetime
+// permitted by the client's exchange policy, and an `act` claim naming the
+// client.
+func (s *Service) ExchangeToken(ctx context.Context, client *domain.Client, req TokenExchangeRequest) (*GrantedTokens, error) {
+	if client.Public {
+		return nil, oauthError("unauthorized_client", "public clients cannot exchange tokens")
+	}
+	if req.SubjectToken == "" || req.SubjectTokenType != TokenTypeAccessToken {
+		return nil, oauthError("invalid_request", "subject_token of type "+TokenTypeAccessToken+" is required")
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeAccessToken {
+		return nil, oauthError("invalid_request", "only access tokens can be issued")
+	}
+	if req.Audience == "" {
+		return nil, oauthError("invalid_request", "audience is required")
+	}
+	// policies are checked when saved; this keeps clients stored before
+	// that from minting tokens this service would accept as its own
+	if jwt.ReservedAudience(req.Audience) {
+		return nil, oauthError("invalid_target", "audience "+req.Audience+" is reserved")
+	}
+	policy := client.ExchangePolicy(req.Audience)
+	if policy == nil {
+		return nil, oauthError("invalid_target", "client may not exchange tokens for "+req.Audience)
+	}
+
+	subject, err := s.ValidateClaims(req.SubjectToken)
+	if err != nil {
+		if subject, err = s.ValidateClaimsFor(req.SubjectToken, client.ID); err != nil {
+			return nil, oauthError("invalid_grant", "invalid subject_token")
+		}
+	}
+	// a subject token bound to a DPoP key is only exchanged with a proof
+	// of that key, and the exchanged token stays bound to it; an unbound
+	// one is bound to the proof's key like other grants
+	jkt := dpopKeyFrom(ctx)
+	if subject.JKT != "" && subject.JKT != jkt {
+		if jkt == "" {
+			return nil, oauthError("invalid_grant", ErrDPoPRequired.Error())
+		}
+		return nil, oauthError("invalid_grant", ErrDPoPKeyMismatch.Error())
+	}
+	userID := ""
+	if subject.ClientID == "" || subject.ClientID != subject.Subject {
+		userID = subject.Subject
+		if err := s.checkSubjectUser(ctx, subject); err != nil {
+			return nil, err
+		}
+	}
+
+	scope, err := exchangeScope(policy, subject.Scope, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	ttl := exchangeTTL
+	if policy.TTLSeconds > 0 {
+		ttl = time.Duration(policy.TTLSeconds) * time.Second
+	}
+	exp := time.Now().Add(ttl)
+	if subject.ExpiresAt.Before(exp) {
+		exp = subject.ExpiresAt
+	}
+	// nest an existing actor: the outermost act is the current client
+	act := map[string]any{"sub": client.ID}
+	if prev, ok := subject.Raw["act"].(map[string]any); ok {
+		act["act"] = prev
+	}
+	opts := []jwt.IssueOption{
+		jwt.WithAudience(req.Audience),
+		jwt.WithExpiry(exp),
+		jwt.WithAMR(subject.AMR...),
+		jwt.WithClaim("client_id", client.ID),
+		jwt.WithClaim("act", act),
+		jwt.WithConfirmation(jkt),
+	}
+	if subject.SessionID != "" {
+		opts = append(opts, jwt.WithClaim("sid", subject.SessionID))
+	}
+	if len(subject.Roles) > 0 {
+		opts = append(opts, jwt.WithClaim("roles", subject.Roles))
+	}
+	if subject.RolesTruncated {
+		opts = append(opts, jwt.WithClaim("roles_truncated", true))
+	}
+	if subject.CitizenID != "" {
+		opts = append(opts, jwt.WithClaim("citizen_id", subject.CitizenID))
+	}
+	if len(subject.OrgIDs) > 0 {
+		opts = append(opts, jwt.WithClaim("org_ids", subject.OrgIDs))
+	}
+	if scope != "" {
+		opts = append(opts, jwt.WithClaim("scope", scope))
+	}
+	tokens, err := s.tokens.IssueAccess(ctx, subject.Subject, opts...)
+	if err != nil {
+		return nil, err
+	}
+	rec := store.AccessTokenRecord{JTI: tokens.AccessID, UserID: userID, SessionID: subject.SessionID, ClientID: client.ID, ExpiresAt: tokens.AccessExpiry}
+	if err := s.users.RecordAccessToken(ctx, rec); err != nil {
+		return nil, err
+	}
+	s.events.Publish("TOKEN_EXCHANGED", map[string]any{"subject": subject.Subject, "clientID": client.ID, "audience": req.Audience, "scope": scope})
+	return &GrantedTokens{Tokens: tokens, Scope: scope, IssuedTokenType: TokenTypeAccessToken}, nil
+}
+
+// checkExchangePolicies rejects policies without an audience or for an
+// audience reserved for this service's own tokens.
+func checkExchangePolicies(policies []domain.ExchangePolicy) error {
+	for _, p := range policies {
+		if p.Audience == "" {
+			return fmt.Errorf("%w: audience is required", ErrInvalidExchangePolicy)
+		}
+		if jwt.ReservedAudience(p.Audience) {
+			return fmt.Errorf("%w: audience %s is reserved", ErrInvalidExchangePolicy, p.Audience)
+		}
+	}
+	return nil
+}
+
+// checkSubjectUser rejects subject tokens of disabled users and ended
+// sessions.
+func (s *Service) checkSubjectUser(ctx context.Context, subject *jwt.AccessClaims) error {
+	u, err := s.users.FindByID(ctx, subject.Subject)
+	if err != nil {
+		return err
+	}
+	if u == nil || u.Disabled() {
+		return oauthError("invalid_grant", "invalid subject_token")
+	}
+	if subject.SessionID != "" {
+		sess, err := s.users.GetSession(ctx, subject.SessionID)
+		if err != nil {
+			return err
+		}
+		if sess == nil || sess.RevokedAt != nil {
+			return oauthError("invalid_grant", "session has ended")
+		}
+	}
+	return nil
+}
+
+// exchangeScope computes the scope of an exchanged token: the policy's
+// scopes, limited to the subject token's scope when it has one, and to
+// requested when not empty.
+func exchangeScope(policy *domain.ExchangePolicy, subjectScope, requested string) (string, error) {
+	allowed := policy.Scopes
+	if subjectScope != "" {
+		have := strings.Fields(subjectScope)
+		allowed = slices.DeleteFunc(slices.Clone(allowed), func(sc string) bool { return !slices.Contains(have, sc) })
+	}
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), nil
+	}
+	var out []string
+	for _, sc := range strings.Fields(requested) {
+		if !slices.Contains(allowed, sc) {
+			return "", oauthError("invalid_scope", "scope "+sc+" cannot be delegated to "+policy.Audience)
+		}
+		if !slices.Contains(out, sc) {
+			out = append(out, sc)
+		}
+	}
+	return strings.Join(out, " "), nil
+}
@@ -0,0 +1,71 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// Client is an OAuth 2.0 client registered with the auth service, such as
+// the OrgDirectory web app or a resource server calling the introspection
+// endpoint. Only a hash of the client secret is stored.
+type Client struct {
+	ID         string
+	Name       string
+	SecretHash string
+	// Public clients (SPAs, native apps) cannot keep a secret and
+	// authenticate with client_id alone; PKCE protects their codes.
+	Public bool
+	// RedirectURIs are compared exactly with the redirect_uri of an
+	// authorization request.
+	RedirectURIs []string
+	// Scopes the client may request.
+	Scopes []string
+	// ServiceAccount clients may use the client_credentials grant and act
+	// on their own behalf (`sub` = client id).
+	ServiceAccount bool
+	// TokenExchange lists the downstream audiences the client may exchange
+	// user tokens for (RFC 8693). Empty disables token exchange.
+	TokenExchange []ExchangePolicy
+	// DisabledAt is set when an administrator disabled the client.
+	DisabledAt *time.Time
+	CreatedAt  time.Time
+}
+
+// ExchangePolicy allows a client to exchange a subject token for a token
+// of one downstream service. It is stored as JSON.
+type ExchangePolicy struct {
+	// Audience is the `aud` of the issued token, naming the service.
+	Audience string `json:"audience"`
+	// Scopes the issued token may carry; the subject token's scope narrows
+	// them further.
+	Scopes []string `json:"scopes,omitempty"`
+	// TTLSeconds caps the lifetime of the issued token; 0 means the
+	// default. It never outlives the subject token.
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// Disabled reports whether the client was disabled.
+func (c *Client) Disabled() bool {
+	return c.DisabledAt != nil
+}
+
+// AllowsRedirect reports whether uri is one of the registered redirect URIs.
+func (c *Client) AllowsRedirect(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// AllowsScope reports whether the client may request scope.
+func (c *Client) AllowsScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// ExchangePolicy returns the client's token exchange policy for audience;
+// nil if the client may not exchange tokens for it.
+func (c *Client) ExchangePolicy(audience string) *ExchangePolicy {
+	for i := range c.TokenExchange {
+		if c.TokenExchange[i].Audience == audience {
+			return &c.TokenExchange[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package domain
+
+import "time"
+
+// Identity provider protocols.
+const (
+	ProtocolOIDC = "oidc"
+	ProtocolSAML = "saml"
+)
+
+// IdentityProvider is an upstream OpenID Connect or SAML 2.0 provider,
+// such as a partner organization's corporate IdP, that users can sign in
+// with (federation). The client secret is stored sealed.
+type IdentityProvider struct {
+	// ID is the slug used in URLs, e.g. /auth/federated/{id}/start.
+	ID       string
+	Name     string
+	Protocol string
+	// Issuer is the OpenID Connect issuer, or the SAML entity id.
+	Issuer          string
+	ClientID        string
+	ClientSecretEnc string
+	// Scopes are requested in addition to openid.
+	Scopes []string
+	// SSOURL is the SAML single sign-on endpoint and Certificates are the
+	// SAML signing certificates (base64 DER), both from the metadata.
+	SSOURL       string
+	Certificates []string
+	Claims       ClaimMapping
+	DisabledAt   *time.Time
+	CreatedAt    time.Time
+}
+
+// SAML reports whether the provider speaks SAML 2.0 rather than OpenID
+// Connect.
+func (p *IdentityProvider) SAML() bool {
+	return p.Protocol == ProtocolSAML
+}
+
+// Disabled reports whether the provider was disabled.
+func (p *IdentityProvider) Disabled() bool {
+	return p.DisabledAt != nil
+}
+
+// ClaimMapping names the upstream id_token claims, or SAML attributes,
+// used for our account. Empty names mean the standard OpenID Connect
+// claims; SAML attributes are looked up as claims by Name or FriendlyName.
+// It is stored as JSON.
+type ClaimMapping struct {
+	Email         string `json:"email,omitempty"`
+	EmailVerified string `json:"email_verified,omitempty"`
+	Login         string `json:"login,omitempty"`
+	// Groups is a claim holding the user's upstream groups; Roles maps
+	// group names to roles granted here.
+	Groups string            `json:"groups,omitempty"`
+	Roles  map[string]string `json:"roles,omitempty"`
+	// TrustEmail treats the email as verified for providers that do not
+	// send email_verified.
+	TrustEmail bool `json:"trust_email,omitempty"`
+}
+
+// FederatedIdentity links an account at an identity provider (its `sub`)
+// to a user.
+type FederatedIdentity struct {
+	ProviderID  string
+	Subject     string
+	UserID      string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
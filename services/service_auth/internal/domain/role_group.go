@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+// RoleGroup is a group of users holding roles, managed by administrators.
+// Groups may be nested: a group is a member of its parents, so its members
+// hold the roles of the parents as well. Unlike SCIM groups, which are
+// single roles, a group grants any number of roles.
+type RoleGroup struct {
+	ID    string
+	Name  string
+	Roles []string
+	// ParentIDs are the groups this group inherits roles from. The
+	// parent graph has no cycles.
+	ParentIDs []string
+	CreatedAt time.Time
+}
@@ -15,5 +15,42 @@ type User struct {
 	Email        string
 	PasswordHash string    // hashed password
 	CreatedAt    time.Time `json:"created_at"`
-	// Roles or scopes could be added here
+	// EmailVerified is set once the user proved control of Email, by a
+	// confirmation link or an emailed login code.
+	EmailVerified bool
+	// Roles are emitted in the `roles` claim of access tokens.
+	Roles []string
+	// DisabledAt is set when an administrator disabled the account.
+	DisabledAt *time.Time
+	// ExternalID is the id of the user in the provisioning client's system
+	// (SCIM externalId), e.g. the HR system's employee id.
+	ExternalID string
+	// CitizenID links the account to an OrgDirectory citizen (a UUID); it
+	// is emitted in the `citizen_id` claim.
+	CitizenID string
+	// OrgIDs are the OrgDirectory organizations the user belongs to,
+	// emitted in the `org_ids` claim.
+	OrgIDs []int
+}
+
+// RoleAdmin grants access to the /admin API.
+const RoleAdmin = "admin"
+
+// RoleOrgDirector lets a user invite new users into the organizations
+// they belong to.
+const RoleOrgDirector = "org_director"
+
+// HasRole reports whether the user has the given role.
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Disabled reports whether the account was disabled.
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
 }
@@ -0,0 +1,256 @@
+// Package dpop verifies DPoP proofs (RFC 9449). A proof is a JWT the client
+// signs with its own key for every request; tokens bound to the key's
+// thumbprint are useless without the private key, unlike bearer tokens.
+package dpop
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidProof is wrapped by all verification errors.
+var ErrInvalidProof = errors.New("invalid DPoP proof")
+
+// SigningAlgs are the proof signature algorithms accepted by Verify.
+var SigningAlgs = []string{"ES256", "ES384", "RS256", "PS256", "EdDSA"}
+
+// Proof holds the verified claims of a DPoP proof.
+type Proof struct {
+	// JKT is the RFC 7638 SHA-256 thumbprint of the proof's public key.
+	JKT      string
+	ID       string
+	Method   string
+	URI      string
+	IssuedAt time.Time
+}
+
+// Verifier checks DPoP proofs and remembers their `jti` for the
+// acceptance window so that a captured proof cannot be replayed.
+type Verifier struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPurge time.Time
+}
+
+// NewVerifier constructs a Verifier accepting proofs issued at most window
+// before (or, for clock skew, after) the time of verification.
+func NewVerifier(window time.Duration) *Verifier {
+	return &Verifier{window: window, seen: make(map[string]time.Time)}
+}
+
+// Verify checks proof for a request with method to uri. When accessToken
+// is not empty the proof must carry its hash (`ath`), as required for
+// requests to protected resources.
+func (v *Verifier) Verify(proof, method, uri, accessToken string) (*Proof, error) {
+	var jkt string
+	token, err := jwt.Parse(proof, func(t *jwt.Token) (any, error) {
+		if typ, _ := t.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, errors.New("typ must be dpop+jwt")
+		}
+		jwk, ok := t.Header["jwk"].(map[string]any)
+		if !ok {
+			return nil, errors.New("missing jwk header")
+		}
+		key, err := PublicKey(jwk)
+		if err != nil {
+			return nil, err
+		}
+		if jkt, err = Thumbprint(jwk); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}, jwt.WithValidMethods(SigningAlgs))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid claims", ErrInvalidProof)
+	}
+	p := &Proof{JKT: jkt}
+	p.ID, _ = claims["jti"].(string)
+	p.Method, _ = claims["htm"].(string)
+	p.URI, _ = claims["htu"].(string)
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		p.IssuedAt = iat.Time
+	}
+	if p.ID == "" || p.IssuedAt.IsZero() {
+		return nil, fmt.Errorf("%w: jti and iat are required", ErrInvalidProof)
+	}
+	if p.Method != method {
+		return nil, fmt.Errorf("%w: htm does not match", ErrInvalidProof)
+	}
+	if !sameURI(p.URI, uri) {
+		return nil, fmt.Errorf("%w: htu does not match", ErrInvalidProof)
+	}
+	now := time.Now()
+	if p.IssuedAt.Before(now.Add(-v.window)) || p.IssuedAt.After(now.Add(v.window)) {
+		return nil, fmt.Errorf("%w: iat is not recent", ErrInvalidProof)
+	}
+	if accessToken != "" {
+		ath, _ := claims["ath"].(string)
+		sum := sha256.Sum256([]byte(accessToken))
+		want := base64.RawURLEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(ath), []byte(want)) != 1 {
+			return nil, fmt.Errorf("%w: ath does not match the access token", ErrInvalidProof)
+		}
+	}
+	if !v.remember(jkt+"|"+p.ID, p.IssuedAt.Add(v.window), now) {
+		return nil, fmt.Errorf("%w: proof was already used", ErrInvalidProof)
+	}
+	return p, nil
+}
+
+// remember records a proof id until expiresAt. It returns false if the id
+// was seen before.
+func (v *Verifier) remember(id string, expiresAt, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now.Sub(v.lastPurge) > v.window {
+		for k, exp := range v.seen {
+			if exp.Before(now) {
+				delete(v.seen, k)
+			}
+		}
+		v.lastPurge = now
+	}
+	if exp, ok := v.seen[id]; ok && !exp.Before(now) {
+		return false
+	}
+	v.seen[id] = expiresAt
+	return true
+}
+
+// sameURI compares htu with the request URI, ignoring query and fragment
+// (RFC 9449 section 4.3).
+func sameURI(htu, uri string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.Path == b.Path
+}
+
+// PublicKey converts a public JWK to a crypto key. Private keys are
+// rejected.
+func PublicKey(jwk map[string]any) (crypto.PublicKey, error) {
+	if _, ok := jwk["d"]; ok {
+		return nil, errors.New("jwk must not contain a private key")
+	}
+	field := func(name string) ([]byte, error) {
+		s, _ := jwk[name].(string)
+		if s == "" {
+			return nil, fmt.Errorf("jwk is missing %s", name)
+		}
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	switch jwk["kty"] {
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk["crv"] {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := field("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := field("y")
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+	case "RSA":
+		n, err := field("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := field("e")
+		if err != nil {
+			return nil, err
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA key too short")
+		}
+		return key, nil
+	case "OKP":
+		if jwk["crv"] != "Ed25519" {
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := field("x")
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+// Thumbprint returns the base64url SHA-256 JWK thumbprint (RFC 7638) of a
+// public key: the hash of its required members in lexicographic order.
+func Thumbprint(jwk map[string]any) (string, error) {
+	var members []string
+	switch jwk["kty"] {
+	case "EC":
+		members = []string{"crv", "kty", "x", "y"}
+	case "RSA":
+		members = []string{"e", "kty", "n"}
+	case "OKP":
+		members = []string{"crv", "kty", "x"}
+	default:
+		return "", errors.New("unsupported key type")
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, m := range members {
+		v, ok := jwk[m].(string)
+		if !ok {
+			return "", fmt.Errorf("jwk is missing %s", m)
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(m)
+		value, _ := json.Marshal(v)
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	sum := sha256.Sum256([]byte(b.String()))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
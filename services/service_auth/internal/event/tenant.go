@@ -0,0 +1,21 @@
+package event
+
+// TenantPublisher adds the tenant id to the map payloads of the events it
+// forwards, so that consumers of a bus shared by tenants can tell them
+// apart.
+type TenantPublisher struct {
+	Publisher
+	TenantID string
+}
+
+func (p TenantPublisher) Publish(eventType string, payload any) error {
+	if m, ok := payload.(map[string]any); ok {
+		tagged := make(map[string]any, len(m)+1)
+		for k, v := range m {
+			tagged[k] = v
+		}
+		tagged["tenantID"] = p.TenantID
+		payload = tagged
+	}
+	return p.Publisher.Publish(eventType, payload)
+}
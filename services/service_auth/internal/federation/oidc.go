@@ -0,0 +1,274 @@
+// Package federation is a minimal OpenID Connect relying party used to
+// sign users in with external identity providers: discovery, the
+// authorization code flow with PKCE and id_token verification against the
+// provider's JWKS.
+package federation
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"auth_project/internal/dpop"
+)
+
+// ErrInvalidIDToken is wrapped by all id_token verification errors.
+var ErrInvalidIDToken = errors.New("invalid id_token")
+
+// SigningAlgs are the id_token signature algorithms accepted by
+// VerifyIDToken.
+var SigningAlgs = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384"}
+
+// discoveryTTL is how long provider metadata and keys are cached.
+const discoveryTTL = time.Hour
+
+// Metadata is the part of the provider's discovery document we use.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client talks to identity providers. Metadata and keys are cached per
+// issuer.
+type Client struct {
+	http *http.Client
+
+	mu        sync.Mutex
+	providers map[string]*provider
+}
+
+type provider struct {
+	meta      Metadata
+	keys      map[string]crypto.PublicKey // kid -> key
+	fetchedAt time.Time
+}
+
+// NewClient constructs a Client using hc, or a client with a 10 second
+// timeout when hc is nil.
+func NewClient(hc *http.Client) *Client {
+	if hc == nil {
+		hc = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{http: hc, providers: make(map[string]*provider)}
+}
+
+// Discover returns the metadata of issuer from its
+// /.well-known/openid-configuration document.
+func (c *Client) Discover(ctx context.Context, issuer string) (*Metadata, error) {
+	p, err := c.provider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	meta := p.meta
+	return &meta, nil
+}
+
+func (c *Client) provider(ctx context.Context, issuer string) (*provider, error) {
+	c.mu.Lock()
+	p, ok := c.providers[issuer]
+	c.mu.Unlock()
+	if ok && time.Since(p.fetchedAt) < discoveryTTL {
+		return p, nil
+	}
+	var meta Metadata
+	if err := c.getJSON(ctx, strings.TrimRight(issuer, "/")+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if meta.Issuer != issuer {
+		return nil, fmt.Errorf("discovery: issuer mismatch: %q", meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery: incomplete provider metadata")
+	}
+	keys, err := c.fetchKeys(ctx, meta.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p = &provider{meta: meta, keys: keys, fetchedAt: time.Now()}
+	c.mu.Lock()
+	c.providers[issuer] = p
+	c.mu.Unlock()
+	return p, nil
+}
+
+// fetchKeys downloads a JWKS and returns its signing keys by kid. Keys of
+// unsupported types are skipped.
+func (c *Client) fetchKeys(ctx context.Context, uri string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := c.getJSON(ctx, uri, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if use, _ := jwk["use"].(string); use != "" && use != "sig" {
+			continue
+		}
+		key, err := dpop.PublicKey(jwk)
+		if err != nil {
+			continue
+		}
+		kid, _ := jwk["kid"].(string)
+		keys[kid] = key
+	}
+	return keys, nil
+}
+
+// AuthCodeURL returns the provider's authorization URL for the code flow
+// with the given state, nonce and PKCE verifier (S256).
+func (c *Client) AuthCodeURL(ctx context.Context, issuer, clientID, redirectURI string, scopes []string, state, nonce, verifier string) (string, error) {
+	p, err := c.provider(ctx, issuer)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(append([]string{"openid"}, scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the provider's token endpoint
+// and returns the id_token.
+func (c *Client) Exchange(ctx context.Context, issuer, clientID, clientSecret, redirectURI, code, verifier string) (string, error) {
+	p, err := c.provider(ctx, issuer)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks the signature of an id_token issued by issuer for
+// clientID and its iss, aud, exp and nonce claims, and returns its claims.
+// An unknown kid refetches the provider's keys once, to follow key
+// rotation.
+func (c *Client) VerifyIDToken(ctx context.Context, issuer, clientID, rawIDToken, nonce string) (map[string]any, error) {
+	p, err := c.provider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	refreshed := false
+	keyfunc := func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if key, ok := c.key(p, kid); ok {
+			return key, nil
+		}
+		if refreshed {
+			return nil, errors.New("unknown signing key")
+		}
+		refreshed = true
+		keys, err := c.fetchKeys(ctx, p.meta.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		p.keys = keys
+		c.mu.Unlock()
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		return nil, errors.New("unknown signing key")
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, keyfunc,
+		jwt.WithValidMethods(SigningAlgs),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// key returns the cached key of p with kid. Keys are replaced when a
+// concurrent verification refetches them, hence the lock.
+func (c *Client) key(p *provider, kid string) (crypto.PublicKey, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (c *Client) getJSON(ctx context.Context, uri string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", uri, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,158 @@
+package federation
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"auth_project/internal/federation/oidctest"
+)
+
+const redirectURI = "https://sp.example.com/auth/federated/test/callback"
+
+// authorize starts a code flow at p and returns the code the browser
+// brings back.
+func authorize(t *testing.T, c *Client, p *oidctest.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := c.AuthCodeURL(context.Background(), p.Issuer, p.ClientID, redirectURI, []string{"email"}, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, gotState, err := p.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if gotState != state {
+		t.Fatalf("state = %q, want %q", gotState, state)
+	}
+	return code
+}
+
+func TestCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	p := oidctest.NewProvider(t)
+	p.SetClaims(map[string]any{"sub": "alice", "email": "alice@example.com"})
+	c := NewClient(nil)
+
+	code := authorize(t, c, p, "state-1", "nonce-1", "verifier-1")
+	idToken, err := c.Exchange(ctx, p.Issuer, p.ClientID, p.ClientSecret, redirectURI, code, "verifier-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := c.VerifyIDToken(ctx, p.Issuer, p.ClientID, idToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims["sub"] != "alice" || claims["email"] != "alice@example.com" {
+		t.Errorf("claims = %v", claims)
+	}
+
+	// codes are single-use
+	if _, err := c.Exchange(ctx, p.Issuer, p.ClientID, p.ClientSecret, redirectURI, code, "verifier-1"); err == nil {
+		t.Error("Exchange redeemed a code twice")
+	}
+}
+
+func TestExchangePKCEMismatch(t *testing.T) {
+	p := oidctest.NewProvider(t)
+	c := NewClient(nil)
+
+	code := authorize(t, c, p, "state-1", "nonce-1", "verifier-1")
+	_, err := c.Exchange(context.Background(), p.Issuer, p.ClientID, p.ClientSecret, redirectURI, code, "verifier-2")
+	if err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Fatalf("Exchange error = %v, want PKCE failure", err)
+	}
+}
+
+func TestVerifyIDTokenNonceMismatch(t *testing.T) {
+	p := oidctest.NewProvider(t)
+	c := NewClient(nil)
+
+	_, err := c.VerifyIDToken(context.Background(), p.Issuer, p.ClientID, p.IDToken("nonce-1"), "nonce-2")
+	if !errors.Is(err, ErrInvalidIDToken) || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("VerifyIDToken error = %v, want nonce mismatch", err)
+	}
+}
+
+func TestVerifyIDTokenWrongAudience(t *testing.T) {
+	p := oidctest.NewProvider(t)
+	c := NewClient(nil)
+
+	_, err := c.VerifyIDToken(context.Background(), p.Issuer, "other-client", p.IDToken("nonce-1"), "nonce-1")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("VerifyIDToken error = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	p := oidctest.NewProvider(t)
+	c := NewClient(nil)
+
+	old := p.IDToken("nonce-1")
+	if _, err := c.VerifyIDToken(ctx, p.Issuer, p.ClientID, old, "nonce-1"); err != nil {
+		t.Fatalf("VerifyIDToken before rotation: %v", err)
+	}
+
+	// the new kid is not cached yet: the keys are refetched
+	p.RotateKey()
+	if _, err := c.VerifyIDToken(ctx, p.Issuer, p.ClientID, p.IDToken("nonce-1"), "nonce-1"); err != nil {
+		t.Fatalf("VerifyIDToken after rotation: %v", err)
+	}
+	// the retired key is no longer published
+	if _, err := c.VerifyIDToken(ctx, p.Issuer, p.ClientID, old, "nonce-1"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("VerifyIDToken with a retired key: error = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+// TestVerifyIDTokenConcurrentRefresh verifies tokens with a cached key
+// while tokens with an unknown kid make other verifications refetch the
+// keys; run with -race.
+func TestVerifyIDTokenConcurrentRefresh(t *testing.T) {
+	ctx := context.Background()
+	p := oidctest.NewProvider(t)
+	c := NewClient(nil)
+	token := p.IDToken("nonce-1")
+	// the signature no longer matches, but the kid is looked up first
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"unknown","typ":"JWT"}`))
+	unknownKid := header + token[strings.Index(token, "."):]
+	if _, err := c.VerifyIDToken(ctx, p.Issuer, p.ClientID, token, "nonce-1"); err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				if _, err := c.VerifyIDToken(ctx, p.Issuer, p.ClientID, token, "nonce-1"); err != nil {
+					t.Errorf("VerifyIDToken: %v", err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				if _, err := c.VerifyIDToken(ctx, p.Issuer, p.ClientID, unknownKid, "nonce-1"); !errors.Is(err, ErrInvalidIDToken) {
+					t.Errorf("VerifyIDToken with an unknown kid: error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	p := oidctest.NewProvider(t)
+	c := NewClient(nil)
+
+	if _, err := c.Discover(context.Background(), p.Issuer+"/"); err == nil || !strings.Contains(err.Error(), "issuer mismatch") {
+		t.Fatalf("Discover error = %v, want issuer mismatch", err)
+	}
+}
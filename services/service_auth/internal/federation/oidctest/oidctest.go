@@ -0,0 +1,201 @@
+// Package oidctest provides a fake OpenID Connect provider for tests: an
+// httptest server with discovery, JWKS and a token endpoint that checks
+// PKCE, and issues id_tokens signed with a key that can be rotated.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Provider is a fake identity provider. Its client is ClientID with
+// ClientSecret.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	t      testing.TB
+	server *httptest.Server
+
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	kid    string
+	keys   int
+	claims map[string]any
+	grants map[string]grant // code -> grant
+}
+
+// grant is an authorization waiting to be redeemed at the token endpoint.
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+// NewProvider starts a provider that is stopped when the test ends.
+func NewProvider(t testing.TB) *Provider {
+	t.Helper()
+	p := &Provider{
+		ClientID:     "client-1",
+		ClientSecret: "client-secret",
+		t:            t,
+		claims:       map[string]any{},
+		grants:       make(map[string]grant),
+	}
+	p.RotateKey()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("POST /token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	p.Issuer = p.server.URL
+	return p
+}
+
+// SetClaims sets the claims added to the id_tokens issued from now on,
+// e.g. sub, email or groups.
+func (p *Provider) SetClaims(claims map[string]any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims = claims
+}
+
+// RotateKey replaces the signing key; the JWKS only publishes the new
+// one.
+func (p *Provider) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys++
+	p.key, p.kid = key, "key-"+strconv.Itoa(p.keys)
+}
+
+// Authorize plays the browser signing in at the authorization endpoint:
+// it checks the authorization URL built by the relying party and returns
+// the code and state it is redirected back with.
+func (p *Provider) Authorize(authURL string) (code, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+	switch {
+	case u.Scheme+"://"+u.Host+u.Path != p.Issuer+"/authorize":
+		return "", "", errors.New("not the authorization endpoint")
+	case q.Get("response_type") != "code" || q.Get("client_id") != p.ClientID:
+		return "", "", errors.New("unexpected client or response type")
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		return "", "", errors.New("missing PKCE challenge")
+	}
+	code = rand.Text()
+	p.mu.Lock()
+	p.grants[code] = grant{redirectURI: q.Get("redirect_uri"), challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	p.mu.Unlock()
+	return code, q.Get("state"), nil
+}
+
+// IDToken returns an id_token for the client signed with the current key.
+func (p *Provider) IDToken(nonce string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idToken(nonce)
+}
+
+func (p *Provider) idToken(nonce string) string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.Issuer,
+		"aud":   p.ClientID,
+		"sub":   "subject-1",
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return signed
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                 p.Issuer,
+		"authorization_endpoint": p.Issuer + "/authorize",
+		"token_endpoint":         p.Issuer + "/token",
+		"jwks_uri":               p.Issuer + "/jwks",
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	pub := p.key.PublicKey
+	kid := p.kid
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]any{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+// token redeems a code once, for the client it was issued to and with the
+// verifier of its PKCE challenge.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if id != p.ClientID || secret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid_client"})
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := r.PostFormValue("code")
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	switch {
+	case r.PostFormValue("grant_type") != "authorization_code" || !ok:
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant", "error_description": "unknown code"})
+	case r.PostFormValue("redirect_uri") != g.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant", "error_description": "redirect_uri mismatch"})
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"token_type": "Bearer", "access_token": rand.Text(), "id_token": p.idToken(g.nonce)})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
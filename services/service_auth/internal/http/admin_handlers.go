@@ -0,0 +1,201 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+	"auth_project/internal/store"
+)
+
+// registerAdminRoutes configures the administrator API. All routes require
+// an access token with the admin role.
+func registerAdminRoutes(router *gin.Engine, svc *auth.Service) {
+	admin := router.Group("/admin", requireUser(svc), requireRole(svc, domain.RoleAdmin))
+
+	admin.POST("/users/:id/disable", func(c *gin.Context) {
+		if err := svc.DisableUser(c.Request.Context(), c.GetString(userIDKey), c.Param("id")); err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/users/:id/enable", func(c *gin.Context) {
+		if err := svc.EnableUser(c.Request.Context(), c.GetString(userIDKey), c.Param("id")); err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/users/:id/impersonate", func(c *gin.Context) {
+		var req struct {
+			Reason string `json:"reason" binding:"required,max=500"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tokens, err := svc.Impersonate(c.Request.Context(), accessClaims(c), c.Param("id"), req.Reason)
+		if err != nil {
+			status := adminErrorStatus(err)
+			if errors.Is(err, auth.ErrImpersonationForbidden) || errors.Is(err, auth.ErrImpersonating) {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"access_token": tokens.AccessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(time.Until(tokens.AccessExpiry).Seconds()),
+			"user_id":      c.Param("id"),
+		})
+	})
+
+	// links to OrgDirectory citizens and organizations
+	admin.GET("/users/:id/org-links", func(c *gin.Context) {
+		links, err := svc.UserOrgLinks(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, links)
+	})
+	admin.PUT("/users/:id/citizen", func(c *gin.Context) {
+		var req struct {
+			CitizenID string `json:"citizen_id" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		links, err := svc.LinkCitizen(c.Request.Context(), c.GetString(userIDKey), c.Param("id"), req.CitizenID)
+		if err != nil {
+			status := adminErrorStatus(err)
+			if errors.Is(err, auth.ErrCitizenLinked) {
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, links)
+	})
+	admin.DELETE("/users/:id/citizen", func(c *gin.Context) {
+		links, err := svc.UnlinkCitizen(c.Request.Context(), c.GetString(userIDKey), c.Param("id"))
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, links)
+	})
+	admin.PUT("/users/:id/organizations", func(c *gin.Context) {
+		var req struct {
+			OrgIDs []int `json:"org_ids" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		links, err := svc.SetUserOrganizations(c.Request.Context(), c.GetString(userIDKey), c.Param("id"), req.OrgIDs)
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, links)
+	})
+
+	// OAuth clients and service accounts
+	admin.GET("/clients", func(c *gin.Context) {
+		clients, err := svc.ListClients(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := make([]gin.H, 0, len(clients))
+		for i := range clients {
+			out = append(out, clientJSON(&clients[i]))
+		}
+		c.JSON(http.StatusOK, gin.H{"clients": out})
+	})
+	admin.POST("/clients", func(c *gin.Context) {
+		var req struct {
+			Name           string                  `json:"name" binding:"required"`
+			RedirectURIs   []string                `json:"redirect_uris"`
+			Scopes         []string                `json:"scopes"`
+			ServiceAccount bool                    `json:"service_account"`
+			TokenExchange  []domain.ExchangePolicy `json:"token_exchange"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		client, secret, err := svc.CreateClient(c.Request.Context(), c.GetString(userIDKey), auth.NewClient{
+			Name:           req.Name,
+			RedirectURIs:   req.RedirectURIs,
+			Scopes:         req.Scopes,
+			ServiceAccount: req.ServiceAccount,
+			TokenExchange:  req.TokenExchange,
+		})
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, auth.ErrInvalidExchangePolicy) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		out := clientJSON(client)
+		out["client_secret"] = secret
+		c.JSON(http.StatusCreated, out)
+	})
+	admin.POST("/clients/:id/secret", func(c *gin.Context) {
+		secret, err := svc.RotateClientSecret(c.Request.Context(), c.GetString(userIDKey), c.Param("id"))
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"client_id": c.Param("id"), "client_secret": secret})
+	})
+	admin.POST("/clients/:id/disable", func(c *gin.Context) {
+		if err := svc.DisableClient(c.Request.Context(), c.GetString(userIDKey), c.Param("id")); err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/clients/:id/enable", func(c *gin.Context) {
+		if err := svc.EnableClient(c.Request.Context(), c.GetString(userIDKey), c.Param("id")); err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// clientJSON is the admin API view of a client; the secret hash is never
+// returned.
+func clientJSON(cl *domain.Client) gin.H {
+	return gin.H{
+		"client_id":       cl.ID,
+		"name":            cl.Name,
+		"public":          cl.Public,
+		"service_account": cl.ServiceAccount,
+		"redirect_uris":   cl.RedirectURIs,
+		"scopes":          cl.Scopes,
+		"token_exchange":  cl.TokenExchange,
+		"disabled_at":     cl.DisabledAt,
+		"created_at":      cl.CreatedAt,
+	}
+}
+
+func adminErrorStatus(err error) int {
+	if errors.Is(err, store.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
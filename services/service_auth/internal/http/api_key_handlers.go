@@ -0,0 +1,88 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/store"
+)
+
+// registerAPIKeyRoutes lets users manage their personal API keys.
+func registerAPIKeyRoutes(router *gin.Engine, svc *auth.Service) {
+	keys := router.Group("/auth/api-keys", requireUser(svc), denyImpersonation())
+	keys.POST("", func(c *gin.Context) {
+		var req struct {
+			Name      string     `json:"name" binding:"required,max=100"`
+			Scopes    []string   `json:"scopes"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		key, plaintext, err := svc.CreateAPIKey(c.Request.Context(), accessClaims(c), req.Name, req.Scopes, req.ExpiresAt)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, auth.ErrClientToken) {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		out := apiKeyView(key)
+		out.Key = plaintext
+		c.JSON(http.StatusCreated, out)
+	})
+	keys.GET("", func(c *gin.Context) {
+		list, err := svc.ListAPIKeys(c.Request.Context(), c.GetString(userIDKey))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := make([]apiKeyJSON, 0, len(list))
+		for i := range list {
+			out = append(out, apiKeyView(&list[i]))
+		}
+		c.JSON(http.StatusOK, gin.H{"api_keys": out})
+	})
+	keys.DELETE("/:id", func(c *gin.Context) {
+		if err := svc.RevokeAPIKey(c.Request.Context(), c.GetString(userIDKey), c.Param("id")); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, auth.ErrAPIKeyNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// apiKeyJSON is the API view of a key. Key is only set in the response to
+// its creation.
+type apiKeyJSON struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func apiKeyView(k *store.APIKey) apiKeyJSON {
+	return apiKeyJSON{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		ExpiresAt:  k.ExpiresAt,
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"html/template"
+	"log"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/store"
+)
+
+// authorizePage is the data of the login/consent page of /oauth2/authorize
+// and of the device verification page /oauth2/device.
+type authorizePage struct {
+	// Action is the URL the consent form posts to.
+	Action     string
+	ClientName string
+	Scopes     []string
+	Request    auth.AuthorizeRequest
+	// Device selects the device verification page; without a ClientName it
+	// asks for the user code.
+	Device   bool
+	UserCode string
+	// User is the login of the user signed in by the SSO cookie; the page
+	// then only asks for consent.
+	User string
+	// Challenge is set when the password was accepted and a TOTP code is
+	// required.
+	Challenge string
+	// Terms is set when the user has to accept this terms version to sign
+	// in; TermsChallenge is the terms_required challenge.
+	Terms          *store.TermsVersion
+	TermsChallenge string
+	Error          string
+	// Done replaces the forms with a final message.
+	Done string
+}
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in – OrgDirectory</title>
+<style>
+body { font-family: sans-serif; max-width: 24rem; margin: 4rem auto; padding: 0 1rem; }
+label, input, button { display: block; width: 100%; box-sizing: border-box; }
+input { margin: .25rem 0 .75rem; padding: .5rem; }
+button { padding: .5rem; margin-top: .5rem; }
+input[type=checkbox] { display: inline; width: auto; margin-right: .5rem; }
+.terms { white-space: pre-wrap; max-height: 16rem; overflow-y: auto; border: 1px solid #ccc; padding: .5rem; margin-bottom: .75rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+{{if .ClientName}}<h1>{{.ClientName}}</h1>
+<p>wants to access your OrgDirectory account{{if .Scopes}} with the following permissions:{{end}}</p>
+{{if .Scopes}}<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}{{end}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Done}}<p>{{.Done}}</p>
+{{else if and .Device (not .ClientName)}}<form method="get" action="{{.Action}}">
+<label for="user_code">Enter the code shown on your device</label>
+<input id="user_code" name="user_code" value="{{.UserCode}}" autocomplete="off" autocapitalize="characters" autofocus required>
+<button type="submit">Continue</button>
+</form>
+{{else if .ClientName}}<form method="post" action="{{.Action}}">
+{{if .Device}}<input type="hidden" name="user_code" value="{{.UserCode}}">
+{{else}}<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+{{end}}{{if .Terms}}<input type="hidden" name="terms_challenge" value="{{.TermsChallenge}}">
+<input type="hidden" name="terms_version" value="{{.Terms.Version}}">
+<p>To continue, accept the {{if .Terms.URL}}<a href="{{.Terms.URL}}" target="_blank" rel="noopener">{{.Terms.Title}}</a>{{else}}{{.Terms.Title}}{{end}}.</p>
+{{if .Terms.Content}}<div class="terms">{{.Terms.Content}}</div>
+{{end}}<label><input type="checkbox" name="accept_terms" value="yes" required>I accept the terms</label>
+{{else if .Challenge}}<input type="hidden" name="challenge_token" value="{{.Challenge}}">
+<label for="code">Code from your authenticator app</label>
+<input id="code" name="code" inputmode="numeric" autocomplete="one-time-code" autofocus required>
+{{else if .User}}<p>Signed in as <strong>{{.User}}</strong>.</p>
+{{else}}<label for="login">Login or email</label>
+<input id="login" name="login" autocomplete="username" autofocus required>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+{{end}}<button type="submit" name="action" value="allow">Allow</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+// renderAuthorize writes the login/consent page. The page must not be
+// framed by other sites (clickjacking on the consent buttons).
+func renderAuthorize(c *gin.Context, status int, page authorizePage) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	switch {
+	case page.Action != "":
+	case page.Device:
+		page.Action = basePath(c) + "/oauth2/device"
+	default:
+		page.Action = basePath(c) + "/oauth2/authorize"
+	}
+	c.Status(status)
+	if err := authorizeTemplate.Execute(c.Writer, page); err != nil {
+		log.Printf("render authorize page: %v", err)
+	}
+}
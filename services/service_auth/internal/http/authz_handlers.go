@@ -0,0 +1,118 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+	"auth_project/internal/policy"
+	"auth_project/internal/store"
+)
+
+// registerAuthzRoutes configures the authorization decision endpoint and
+// the administration of its policy.
+func registerAuthzRoutes(router *gin.Engine, svc *auth.Service) {
+	// POST /authz/check answers one check, or a batch under "checks":
+	//   {"subject":{"id":"u-1"},"action":"organization.edit","resource":{"type":"organization","id":"42"}}
+	//   {"checks":[...],"explain":true}
+	router.POST("/authz/check", requireCaller(svc), func(c *gin.Context) {
+		var req struct {
+			auth.AuthzCheck
+			Checks  []auth.AuthzCheck `json:"checks"`
+			Explain bool              `json:"explain"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		checks := req.Checks
+		if checks == nil {
+			checks = []auth.AuthzCheck{req.AuthzCheck}
+		}
+		results, err := svc.CheckAuthorization(c.Request.Context(), accessClaims(c), checks, req.Explain)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, auth.ErrAuthzForbidden):
+				status = http.StatusForbidden
+			case errors.Is(err, auth.ErrInvalidAuthzCheck):
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Checks == nil {
+			c.JSON(http.StatusOK, results[0])
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"results": results})
+	})
+
+	admin := router.Group("/admin/authz/policy", requireUser(svc), requireRole(svc, domain.RoleAdmin))
+	admin.GET("", func(c *gin.Context) {
+		v, err := svc.CurrentPolicy(c.Request.Context())
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, v)
+	})
+	admin.PUT("", func(c *gin.Context) {
+		var req struct {
+			policy.Document
+			Comment string `json:"comment" binding:"max=500"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		v, err := svc.PublishPolicy(c.Request.Context(), c.GetString(userIDKey), req.Document, req.Comment)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, auth.ErrInvalidPolicy) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, v)
+	})
+	admin.GET("/versions", func(c *gin.Context) {
+		list, err := svc.ListPolicyVersions(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"versions": list})
+	})
+	admin.GET("/versions/:version", func(c *gin.Context) {
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": store.ErrNotFound.Error()})
+			return
+		}
+		v, err := svc.GetPolicyVersion(c.Request.Context(), version)
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, v)
+	})
+	admin.POST("/versions/:version/restore", func(c *gin.Context) {
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": store.ErrNotFound.Error()})
+			return
+		}
+		v, err := svc.RestorePolicyVersion(c.Request.Context(), c.GetString(userIDKey), version)
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, v)
+	})
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+)
+
+// deviceCodeGrant is the grant_type of the device's token requests.
+const deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// registerDeviceRoutes configures the device authorization grant (RFC 8628)
+// for CLI tools and other clients without a browser. The device calls
+// /oauth2/device_authorization, shows the user code and polls
+// /oauth2/token; the user enters the code at /oauth2/device, signs in and
+// approves. The verification page lives under /oauth2 so it receives the
+// single sign-on cookie.
+func registerDeviceRoutes(router *gin.Engine, svc *auth.Service) {
+	router.POST("/oauth2/device_authorization", func(c *gin.Context) {
+		client, ok := authenticateClient(c, svc)
+		if !ok {
+			return
+		}
+		out, err := svc.StartDeviceAuthorization(c.Request.Context(), client, c.PostForm("scope"))
+		if err != nil {
+			writeOAuthError(c, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, out)
+	})
+
+	router.GET("/oauth2/device", func(c *gin.Context) {
+		page := authorizePage{Device: true, UserCode: c.Query("user_code")}
+		if page.UserCode == "" {
+			renderAuthorize(c, http.StatusOK, page)
+			return
+		}
+		if !loadPendingDevice(c, svc, &page) {
+			return
+		}
+		if sso, err := c.Cookie(ssoCookie); err == nil {
+			if u, _, err := svc.SSOUser(c.Request.Context(), sso); err == nil {
+				page.User = u.Login
+			}
+		}
+		renderAuthorize(c, http.StatusOK, page)
+	})
+	router.POST("/oauth2/device", func(c *gin.Context) {
+		page := authorizePage{Device: true, UserCode: c.PostForm("user_code")}
+		if !loadPendingDevice(c, svc, &page) {
+			return
+		}
+		ctx := c.Request.Context()
+		if c.PostForm("action") != "allow" {
+			if err := svc.DenyDevice(ctx, page.UserCode); err != nil && !errors.Is(err, auth.ErrInvalidUserCode) {
+				renderAuthorize(c, http.StatusInternalServerError, authorizePage{Error: "Something went wrong."})
+				return
+			}
+			renderAuthorize(c, http.StatusOK, authorizePage{Done: "Access denied. You can close this window."})
+			return
+		}
+
+		sso, ok := signInForm(c, svc, &page)
+		if !ok {
+			return
+		}
+		if err := svc.ApproveDevice(ctx, sso, page.UserCode); err != nil {
+			switch {
+			case errors.Is(err, auth.ErrAuthFailed):
+				clearSSOCookie(c)
+				page.User = ""
+				renderAuthorize(c, http.StatusUnauthorized, page)
+			case errors.Is(err, auth.ErrInvalidUserCode):
+				renderAuthorize(c, http.StatusBadRequest, authorizePage{Device: true, Error: "The code is invalid or has expired."})
+			default:
+				renderAuthorize(c, http.StatusInternalServerError, authorizePage{Error: "Something went wrong."})
+			}
+			return
+		}
+		renderAuthorize(c, http.StatusOK, authorizePage{Done: "Your device is now signed in. You can return to it."})
+	})
+}
+
+// loadPendingDevice fills page with the client and scopes of the device
+// authorization named by page.UserCode. Unknown codes get the code entry
+// form again.
+func loadPendingDevice(c *gin.Context, svc *auth.Service, page *authorizePage) bool {
+	pending, err := svc.PendingDeviceAuthorization(c.Request.Context(), page.UserCode)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidUserCode) {
+			renderAuthorize(c, http.StatusBadRequest, authorizePage{Device: true, UserCode: page.UserCode, Error: "The code is invalid or has expired."})
+		} else {
+			renderAuthorize(c, http.StatusInternalServerError, authorizePage{Error: "Something went wrong."})
+		}
+		return false
+	}
+	page.UserCode = pending.UserCode
+	page.ClientName = pending.Client.Name
+	page.Scopes = strings.Fields(pending.Scope)
+	return true
+}
@@ -0,0 +1,107 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+)
+
+// registerEmailRoutes configures the email change flow. The confirmation
+// and cancel links open a page whose form posts the token.
+func registerEmailRoutes(router *gin.Engine, svc *auth.Service) {
+	router.POST("/auth/email/change", requireUser(svc), denyImpersonation(), func(c *gin.Context) {
+		var req struct {
+			NewEmail string `json:"new_email" binding:"required,email"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		email := strings.ToLower(strings.TrimSpace(req.NewEmail))
+		if err := svc.RequestEmailChange(c.Request.Context(), c.GetString(userIDKey), email, req.Password); err != nil {
+			c.JSON(emailErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"status": "confirmation_sent"})
+	})
+
+	// the links only open a form: mail scanners and link previews follow
+	// links, and must not apply or cancel the change
+	confirmPage := linkPage{
+		Title:  "Confirm email address",
+		Prompt: "Confirm the change of the email address of your account.",
+		Button: "Confirm",
+	}
+	cancelPage := linkPage{
+		Title:  "Cancel email change",
+		Prompt: "Cancel the change of the email address of your account.",
+		Button: "Cancel the change",
+	}
+	router.GET("/auth/email/confirm", func(c *gin.Context) {
+		page := confirmPage
+		page.Token = c.Query("token")
+		renderLinkPage(c, http.StatusOK, page)
+	})
+	router.POST("/auth/email/confirm", func(c *gin.Context) {
+		if err := svc.ConfirmEmailChange(c.Request.Context(), linkToken(c)); err != nil {
+			if fromLinkPage(c) {
+				page := confirmPage
+				page.Error, page.Done = err.Error(), "The email address was not changed."
+				renderLinkPage(c, emailErrorStatus(err), page)
+				return
+			}
+			c.JSON(emailErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if fromLinkPage(c) {
+			page := confirmPage
+			page.Done = "Your email address has been changed."
+			renderLinkPage(c, http.StatusOK, page)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "email_changed"})
+	})
+
+	router.GET("/auth/email/cancel", func(c *gin.Context) {
+		page := cancelPage
+		page.Token = c.Query("token")
+		renderLinkPage(c, http.StatusOK, page)
+	})
+	router.POST("/auth/email/cancel", func(c *gin.Context) {
+		if err := svc.CancelEmailChange(c.Request.Context(), linkToken(c)); err != nil {
+			if fromLinkPage(c) {
+				page := cancelPage
+				page.Error, page.Done = err.Error(), "The change could not be cancelled."
+				renderLinkPage(c, emailErrorStatus(err), page)
+				return
+			}
+			c.JSON(emailErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if fromLinkPage(c) {
+			page := cancelPage
+			page.Done = "The email address change has been cancelled."
+			renderLinkPage(c, http.StatusOK, page)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "email_change_cancelled"})
+	})
+}
+
+func emailErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrEmailTaken):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrInvalidLink):
+		return http.StatusBadRequest
+	case errors.Is(err, auth.ErrAuthFailed):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+)
+
+// fedStateCookie binds a federated login to the browser that started it.
+const fedStateCookie = "auth_fed_state"
+
+// registerFederationRoutes configures login with external OpenID Connect
+// identity providers and their administration.
+func registerFederationRoutes(router *gin.Engine, svc *auth.Service) {
+	router.GET("/auth/federated", func(c *gin.Context) {
+		providers, err := svc.EnabledIdentityProviders(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := make([]gin.H, 0, len(providers))
+		for _, p := range providers {
+			loginURL := basePath(c) + "/auth/federated/" + p.ID + "/start"
+			if p.SAML() {
+				loginURL = basePath(c) + "/auth/saml/" + p.ID + "/start"
+			}
+			out = append(out, gin.H{"id": p.ID, "name": p.Name, "login_url": loginURL})
+		}
+		c.JSON(http.StatusOK, gin.H{"providers": out})
+	})
+	router.GET("/auth/federated/:provider/start", func(c *gin.Context) {
+		authURL, state, err := svc.StartFederatedLogin(c.Request.Context(), c.Param("provider"))
+		if err != nil {
+			c.JSON(federationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(fedStateCookie, state, 600, basePath(c)+"/auth/federated", "", isSecure(c), true)
+		c.Redirect(http.StatusFound, authURL)
+	})
+	router.GET("/auth/federated/:provider/callback", func(c *gin.Context) {
+		state, _ := c.Cookie(fedStateCookie)
+		c.SetCookie(fedStateCookie, "", -1, basePath(c)+"/auth/federated", "", isSecure(c), true)
+		if e := c.Query("error"); e != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": e, "error_description": c.Query("error_description")})
+			return
+		}
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "state mismatch"})
+			return
+		}
+		if c.Query("code") == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing code"})
+			return
+		}
+		tokens, err := svc.CompleteFederatedLogin(c.Request.Context(), c.Param("provider"), state, c.Query("code"))
+		if err != nil {
+			var challenge *auth.ChallengeError
+			if errors.As(err, &challenge) {
+				writeChallenge(c, challenge)
+				return
+			}
+			c.JSON(federationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"access_token": tokens.AccessToken, "refresh_token": tokens.RefreshToken})
+	})
+
+	admin := router.Group("/admin/identity-providers", requireUser(svc), requireRole(svc, domain.RoleAdmin))
+	admin.GET("", func(c *gin.Context) {
+		providers, err := svc.ListIdentityProviders(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := make([]gin.H, 0, len(providers))
+		for i := range providers {
+			out = append(out, identityProviderJSON(svc, &providers[i]))
+		}
+		c.JSON(http.StatusOK, gin.H{"identity_providers": out})
+	})
+	admin.PUT("/:id", func(c *gin.Context) {
+		var req auth.IdentityProviderRegistration
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.ID = c.Param("id")
+		p, err := svc.SaveIdentityProvider(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, identityProviderJSON(svc, p))
+	})
+	admin.DELETE("/:id", func(c *gin.Context) {
+		if err := svc.DeleteIdentityProvider(c.Request.Context(), c.Param("id")); err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// identityProviderJSON is the admin API view of an identity provider with
+// what to register there: the redirect URI, or for SAML our metadata. The
+// client secret is never returned.
+func identityProviderJSON(svc *auth.Service, p *domain.IdentityProvider) gin.H {
+	out := gin.H{
+		"id":          p.ID,
+		"name":        p.Name,
+		"protocol":    domain.ProtocolOIDC,
+		"issuer":      p.Issuer,
+		"claims":      p.Claims,
+		"disabled_at": p.DisabledAt,
+		"created_at":  p.CreatedAt,
+	}
+	if p.SAML() {
+		out["protocol"] = domain.ProtocolSAML
+		out["sso_url"] = p.SSOURL
+		out["certificates"] = len(p.Certificates)
+		out["sp_metadata_url"] = svc.Issuer() + "/auth/saml/metadata"
+		return out
+	}
+	out["client_id"] = p.ClientID
+	out["scopes"] = p.Scopes
+	out["redirect_uri"] = svc.Issuer() + "/auth/federated/" + p.ID + "/callback"
+	return out
+}
+
+func federationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrUnknownProvider):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrFederatedEmailTaken):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrInvitationRequired):
+		return http.StatusForbidden
+	case errors.Is(err, auth.ErrFederationFailed), errors.Is(err, auth.ErrAccountDisabled):
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
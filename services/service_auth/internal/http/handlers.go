@@ -2,10 +2,12 @@ package http
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 
 	"auth_project/internal/auth"
 )
@@ -13,20 +15,42 @@ import (
 // RegisterRoutes configures authentication routes on the provided gin router.
 // It expects an instance of auth.Service to execute business logic.
 func RegisterRoutes(ctx context.Context, router *gin.Engine, svc *auth.Service) {
+	router.Use(clientInfo(), publicBasePath(svc))
+
 	// registration
 	router.POST("/auth/register", func(c *gin.Context) {
 		var req struct {
 			Login    string `json:"login"  binding:"omitempty,alphanum,min=3,max=30"`
-			Email    string `json:"email" binding:"required,email"`
-			Password string `json:"password" binding:"required,min=6"`
+			Email    string `json:"email" binding:"omitempty,email"`
+			Password string `json:"password" binding:"required"`
+			// InviteToken is the token of an invitation email; the email
+			// then defaults to the invited address.
+			InviteToken string `json:"invite_token"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if req.Email == "" && req.InviteToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
 		login := strings.ToLower(req.Login) // import "strings"
-		if err := svc.Register(ctx, login, req.Email, req.Password); err != nil {
-			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		var err error
+		if req.InviteToken != "" {
+			_, err = svc.RegisterWithInvitation(ctx, login, req.Email, req.Password, req.InviteToken)
+		} else {
+			err = svc.Register(ctx, login, req.Email, req.Password)
+		}
+		if err != nil {
+			status := http.StatusConflict
+			switch {
+			case errors.Is(err, auth.ErrWeakPassword), errors.Is(err, auth.ErrInvalidInvitation):
+				status = http.StatusBadRequest
+			case errors.Is(err, auth.ErrInvitationRequired):
+				status = http.StatusForbidden
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
 		c.Status(http.StatusCreated)
@@ -35,7 +59,7 @@ func RegisterRoutes(ctx context.Context, router *gin.Engine, svc *auth.Service)
 	router.POST("/auth/login", func(c *gin.Context) {
 		var req struct {
 			Login    string `json:"login" binding:"omitempty,alphanum,required_without=Email"`
-			Email    string `json:"email" binding:"omitempty,email,required_without=Login"`
+			Email    string `json:"email" binding:"omitempty,required_without=Login"`
 			Password string `json:"password" binding:"required"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -45,6 +69,16 @@ func RegisterRoutes(ctx context.Context, router *gin.Engine, svc *auth.Service)
 		//login := strings.ToLower(req.Login) // import "strings"
 		//email := strings.ToLower(strings.TrimSpace(req.Email))
 		ident := strings.ToLower(strings.TrimSpace(req.Email))
+		// identifiers of LDAP domains such as jdoe@corp are not emails
+		if ident != "" && !svc.DirectoryIdentifier(ident) {
+			email := struct {
+				Email string `binding:"email"`
+			}{ident}
+			if err := binding.Validator.ValidateStruct(&email); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
 		if ident == "" {
 			ident = strings.ToLower(strings.TrimSpace(req.Login))
 		}
@@ -52,6 +86,11 @@ func RegisterRoutes(ctx context.Context, router *gin.Engine, svc *auth.Service)
 		tokens, err := svc.Login(c.Request.Context(), ident, req.Password)
 
 		if err != nil {
+			var challenge *auth.ChallengeError
+			if errors.As(err, &challenge) {
+				writeChallenge(c, challenge)
+				return
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
@@ -66,27 +105,121 @@ func RegisterRoutes(ctx context.Context, router *gin.Engine, svc *auth.Service)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		tokens, err := svc.Refresh(ctx, req.RefreshToken)
+		tokens, err := svc.Refresh(c.Request.Context(), req.RefreshToken)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"access_token": tokens.AccessToken, "refresh_token": tokens.RefreshToken})
 	})
-	// validate token
+	// validate token: an access token (JWT) or a personal API key; with an
+	// audience, a token issued for that downstream service
 	router.POST("/auth/validate", func(c *gin.Context) {
 		var req struct {
-			AccessToken string `json:"access_token" binding:"required"`
+			AccessToken string `json:"access_token" binding:"required_without=APIKey"`
+			APIKey      string `json:"api_key" binding:"required_without=AccessToken"`
+			Audience    string `json:"audience"`
+			// DPoP proof of the resource server's request, with its method and
+			// URL, required for tokens bound to a DPoP key
+			DPoPProof string `json:"dpop_proof"`
+			HTM       string `json:"htm"`
+			HTU       string `json:"htu"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		userID, err := svc.Validate(req.AccessToken)
+		token := req.AccessToken
+		if token == "" {
+			token = req.APIKey
+		}
+		var principal *auth.Principal
+		var err error
+		if req.Audience != "" {
+			principal, err = svc.ValidatePrincipalFor(c.Request.Context(), token, req.Audience)
+		} else {
+			principal, err = svc.ValidatePrincipal(c.Request.Context(), token)
+		}
+		if err == nil {
+			err = svc.CheckDPoP(principal.JKT, token, req.DPoPProof, req.HTM, req.HTU)
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"user_id": userID})
+		// user_id is kept for callers of the original response shape
+		c.JSON(http.StatusOK, struct {
+			UserID string `json:"user_id"`
+			*auth.Principal
+		}{principal.Subject, principal})
+	})
+
+	// profile of the signed-in user
+	router.GET("/auth/me", requireUser(svc), func(c *gin.Context) {
+		profile, err := svc.Profile(c.Request.Context(), c.GetString(userIDKey))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, profile)
 	})
+
+	// logout: ends the current session and revokes its tokens
+	router.POST("/auth/logout", requireUser(svc), func(c *gin.Context) {
+		if err := svc.Logout(c.Request.Context(), accessClaims(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	// password change: signs the user out everywhere
+	router.POST("/auth/password/change", requireUser(svc), denyImpersonation(), func(c *gin.Context) {
+		var req struct {
+			CurrentPassword string `json:"current_password" binding:"required"`
+			NewPassword     string `json:"new_password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := svc.ChangePassword(c.Request.Context(), c.GetString(userIDKey), req.CurrentPassword, req.NewPassword); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, auth.ErrAuthFailed) {
+				status = http.StatusUnauthorized
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	registerEmailRoutes(router, svc)
+	registerMFARoutes(router, svc)
+	registerPasswordlessRoutes(router, svc)
+	registerSessionRoutes(router, svc)
+	registerAPIKeyRoutes(router, svc)
+	registerFederationRoutes(router, svc)
+	registerSAMLRoutes(router, svc)
+	registerSCIMRoutes(router, svc)
+	registerAdminRoutes(router, svc)
+	registerRoleGroupRoutes(router, svc)
+	registerInvitationRoutes(router, svc)
+	registerTermsRoutes(router, svc)
+	registerAuthzRoutes(router, svc)
+	registerOAuthRoutes(router, svc)
+	registerOIDCRoutes(router, svc)
+}
+
+// writeChallenge answers a login that needs another step. The client
+// continues with challenge_token at the endpoint matching status.
+func writeChallenge(c *gin.Context, ch *auth.ChallengeError) {
+	body := gin.H{
+		"status":          ch.Status,
+		"challenge_token": ch.Token,
+		"expires_in":      int(ch.ExpiresIn.Seconds()),
+	}
+	if ch.TermsVersion != 0 {
+		body["terms_version"] = ch.TermsVersion
+	}
+	c.JSON(http.StatusOK, body)
 }
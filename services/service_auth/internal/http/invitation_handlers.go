@@ -0,0 +1,131 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/store"
+)
+
+// registerInvitationRoutes lets administrators and organization directors
+// invite users. Invited users register through POST /auth/register with
+// the invite_token of the email.
+func registerInvitationRoutes(router *gin.Engine, svc *auth.Service) {
+	// GET /auth/invitation?token=... is the link of the invitation email;
+	// it tells the registration form which address was invited
+	router.GET("/auth/invitation", func(c *gin.Context) {
+		inv, err := svc.PendingInvitation(c.Request.Context(), c.Query("token"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, auth.ErrInvalidInvitation) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"email": inv.Email, "expires_at": inv.ExpiresAt})
+	})
+
+	invitations := router.Group("/auth/invitations", requireUser(svc), denyImpersonation())
+	invitations.POST("", func(c *gin.Context) {
+		var req auth.NewInvitation
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		inv, err := svc.Invite(c.Request.Context(), c.GetString(userIDKey), req)
+		if err != nil {
+			c.JSON(invitationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, invitationView(inv, time.Now()))
+	})
+	invitations.GET("", func(c *gin.Context) {
+		list, err := svc.ListInvitations(c.Request.Context(), c.GetString(userIDKey))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		out := make([]invitationJSON, 0, len(list))
+		for i := range list {
+			out = append(out, invitationView(&list[i], now))
+		}
+		c.JSON(http.StatusOK, gin.H{"invitations": out})
+	})
+	invitations.DELETE("/:id", func(c *gin.Context) {
+		if err := svc.RevokeInvitation(c.Request.Context(), c.GetString(userIDKey), c.Param("id")); err != nil {
+			c.JSON(invitationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// invitationErrorStatus maps errors of the invitation endpoints to HTTP
+// statuses.
+func invitationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrInviteForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, auth.ErrEmailTaken):
+		return http.StatusConflict
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrInvalidInvitation), errors.Is(err, auth.ErrInvalidOrganization):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// invitationJSON is the API view of an invitation; the token is only
+// sent by email.
+type invitationJSON struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Roles      []string   `json:"roles"`
+	OrgIDs     []int      `json:"org_ids"`
+	InvitedBy  string     `json:"invited_by"`
+	Status     string     `json:"status"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	UserID     string     `json:"user_id,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func invitationView(inv *store.Invitation, now time.Time) invitationJSON {
+	status := "pending"
+	switch {
+	case inv.AcceptedAt != nil:
+		status = "accepted"
+	case inv.RevokedAt != nil:
+		status = "revoked"
+	case !inv.Pending(now):
+		status = "expired"
+	}
+	roles, orgIDs := inv.Roles, inv.OrgIDs
+	if roles == nil {
+		roles = []string{}
+	}
+	if orgIDs == nil {
+		orgIDs = []int{}
+	}
+	return invitationJSON{
+		ID:         inv.ID,
+		Email:      inv.Email,
+		Roles:      roles,
+		OrgIDs:     orgIDs,
+		InvitedBy:  inv.InvitedBy,
+		Status:     status,
+		ExpiresAt:  inv.ExpiresAt,
+		AcceptedAt: inv.AcceptedAt,
+		UserID:     inv.UserID,
+		RevokedAt:  inv.RevokedAt,
+		CreatedAt:  inv.CreatedAt,
+	}
+}
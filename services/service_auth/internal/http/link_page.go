@@ -0,0 +1,81 @@
+package http
+
+import (
+	"html/template"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// linkPage is the page an emailed link opens. Mail scanners and link
+// previews follow links, so the link itself changes nothing: the page
+// posts its token back once the user confirms.
+type linkPage struct {
+	Title  string
+	Prompt string
+	Button string
+	// Action is the URL the form posts the token to.
+	Action string
+	Token  string
+	Error  string
+	// Done replaces the form with a final message.
+	Done string
+}
+
+var linkTemplate = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} – OrgDirectory</title>
+<style>
+body { font-family: sans-serif; max-width: 24rem; margin: 4rem auto; padding: 0 1rem; }
+button { display: block; width: 100%; padding: .5rem; margin-top: .5rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Done}}<p>{{.Done}}</p>
+{{else}}<p>{{.Prompt}}</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{.Button}}</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+// renderLinkPage writes a link page. Like the consent page it must not be
+// framed by other sites, and the token in the URL must not leak through
+// the Referer.
+func renderLinkPage(c *gin.Context, status int, page linkPage) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Header("Referrer-Policy", "no-referrer")
+	if page.Action == "" {
+		page.Action = basePath(c) + c.Request.URL.Path
+	}
+	c.Status(status)
+	if err := linkTemplate.Execute(c.Writer, page); err != nil {
+		log.Printf("render link page: %v", err)
+	}
+}
+
+// linkToken returns the token posted by a link page, or of the query for
+// API clients.
+func linkToken(c *gin.Context) string {
+	if token := c.PostForm("token"); token != "" {
+		return token
+	}
+	return c.Query("token")
+}
+
+// fromLinkPage reports whether the request was posted by a link page,
+// which gets a page back rather than JSON.
+func fromLinkPage(c *gin.Context) bool {
+	return c.ContentType() == "application/x-www-form-urlencoded"
+}
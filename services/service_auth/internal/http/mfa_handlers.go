@@ -0,0 +1,113 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/jwt"
+)
+
+// registerMFARoutes configures TOTP enrollment and the second login step.
+func registerMFARoutes(router *gin.Engine, svc *auth.Service) {
+	totp := router.Group("/auth/mfa/totp", requireUser(svc), denyImpersonation())
+	totp.POST("/enroll", func(c *gin.Context) {
+		secret, uri, err := svc.EnrollTOTP(c.Request.Context(), c.GetString(userIDKey))
+		if err != nil {
+			c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": uri})
+	})
+	totp.POST("/confirm", func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		codes, err := svc.ConfirmTOTP(c.Request.Context(), c.GetString(userIDKey), req.Code)
+		if err != nil {
+			c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "mfa_enabled", "recovery_codes": codes})
+	})
+	totp.POST("/disable", func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := svc.DisableTOTP(c.Request.Context(), c.GetString(userIDKey), req.Code); err != nil {
+			c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "mfa_disabled"})
+	})
+
+	// regenerate recovery codes (invalidates the previous set)
+	router.POST("/auth/mfa/recovery-codes", requireUser(svc), denyImpersonation(), func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		codes, err := svc.RegenerateRecoveryCodes(c.Request.Context(), c.GetString(userIDKey), req.Code)
+		if err != nil {
+			c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	})
+
+	// second login step: challenge from /auth/login + TOTP or recovery code
+	router.POST("/auth/mfa/verify", func(c *gin.Context) {
+		var req struct {
+			ChallengeToken string `json:"challenge_token" binding:"required"`
+			Code           string `json:"code" binding:"required_without=RecoveryCode"`
+			RecoveryCode   string `json:"recovery_code" binding:"required_without=Code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var (
+			tokens *jwt.Tokens
+			err    error
+		)
+		if req.RecoveryCode != "" {
+			tokens, err = svc.VerifyMFARecovery(c.Request.Context(), req.ChallengeToken, req.RecoveryCode)
+		} else {
+			tokens, err = svc.VerifyMFA(c.Request.Context(), req.ChallengeToken, req.Code)
+		}
+		if err != nil {
+			var challenge *auth.ChallengeError
+			if errors.As(err, &challenge) {
+				writeChallenge(c, challenge)
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"access_token": tokens.AccessToken, "refresh_token": tokens.RefreshToken})
+	})
+}
+
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrMFANotConfigured):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,179 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/dpop"
+	"auth_project/internal/jwt"
+)
+
+// Gin context keys set by requireUser.
+const (
+	userIDKey = "user_id"
+	claimsKey = "claims"
+)
+
+// basePathKey is the gin context key set by publicBasePath.
+const basePathKey = "base_path"
+
+// clientInfo attaches the caller's User-Agent and IP to the request
+// context, where auth.Service picks them up for session records.
+func clientInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := auth.WithClientInfo(c.Request.Context(), auth.ClientInfo{
+			UserAgent: c.Request.UserAgent(),
+			IP:        c.ClientIP(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// publicBasePath stores the path of the service's public URL in the gin
+// context. Tenants reached by path prefix see their routes without it,
+// while links, forms and cookies sent to browsers need it (see basePath).
+func publicBasePath(svc *auth.Service) gin.HandlerFunc {
+	base := ""
+	if u, err := url.Parse(svc.Issuer()); err == nil {
+		base = strings.TrimRight(u.Path, "/")
+	}
+	return func(c *gin.Context) {
+		c.Set(basePathKey, base)
+		c.Next()
+	}
+}
+
+// basePath returns the path browsers prefix the service's routes with.
+func basePath(c *gin.Context) string {
+	return c.GetString(basePathKey)
+}
+
+// requireUser authenticates the request by its `Authorization: Bearer`
+// (or `DPoP`) access token and stores the user ID in the gin context.
+func requireUser(svc *auth.Service) gin.HandlerFunc {
+	return authenticate(svc, false)
+}
+
+// requireCaller is requireUser for routes also serving service accounts,
+// whose tokens have `sub` = `client_id`. Other tokens issued to OAuth
+// clients are still rejected.
+func requireCaller(svc *auth.Service) gin.HandlerFunc {
+	return authenticate(svc, true)
+}
+
+func authenticate(svc *auth.Service, serviceAccounts bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+			return
+		}
+		claims, err := validateRequestToken(c, svc, token)
+		if err != nil {
+			if isDPoPError(err) {
+				c.Header("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		// tokens issued to OAuth clients carry the user's roles but are
+		// granted for the client's scope at resource servers only
+		if claims.ClientID != "" && !(serviceAccounts && claims.ClientID == claims.Subject) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token was issued to an OAuth client"})
+			return
+		}
+		c.Set(userIDKey, claims.Subject)
+		c.Set(claimsKey, claims)
+		c.Next()
+	}
+}
+
+// denyImpersonation rejects impersonation and other delegated tokens on
+// routes that change the user's credentials. It must run after
+// requireUser.
+func denyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if accessClaims(c).Actor != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": auth.ErrImpersonating.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireRole allows the request only if the access token carries role.
+// Tokens with truncated roles are checked against the user's effective
+// roles. It must run after requireUser.
+func requireRole(svc *auth.Service, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := accessClaims(c)
+		for _, r := range claims.Roles {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+		if claims.RolesTruncated {
+			ok, err := svc.HasEffectiveRole(c.Request.Context(), claims.Subject, role)
+			if err == nil && ok {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	}
+}
+
+// bearerToken extracts the token from the Authorization header. The DPoP
+// scheme is accepted as well; see validateRequestToken.
+func bearerToken(c *gin.Context) (string, bool) {
+	h := c.GetHeader("Authorization")
+	scheme, token, ok := strings.Cut(h, " ")
+	if !ok || !(strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, "DPoP")) || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// validateRequestToken verifies the access token of the request. Tokens
+// bound to a DPoP key must be sent with the DPoP scheme and a proof for
+// this request in the DPoP header.
+func validateRequestToken(c *gin.Context, svc *auth.Service, token string) (*jwt.AccessClaims, error) {
+	claims, err := svc.ValidateClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.JKT != "" {
+		scheme, _, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "DPoP") {
+			return nil, auth.ErrDPoPRequired
+		}
+		if err := svc.CheckDPoP(claims.JKT, token, c.GetHeader("DPoP"), c.Request.Method, requestURL(c, svc)); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// requestURL is the URL of the request as seen by clients, the `htu` of
+// DPoP proofs.
+func requestURL(c *gin.Context, svc *auth.Service) string {
+	return svc.Issuer() + c.Request.URL.Path
+}
+
+// isDPoPError reports whether err is a failed DPoP proof check.
+func isDPoPError(err error) bool {
+	return errors.Is(err, dpop.ErrInvalidProof) || errors.Is(err, auth.ErrDPoPRequired) || errors.Is(err, auth.ErrDPoPKeyMismatch)
+}
+
+// accessClaims returns the claims stored by requireUser.
+func accessClaims(c *gin.Context) *jwt.AccessClaims {
+	claims, _ := c.MustGet(claimsKey).(*jwt.AccessClaims)
+	return claims
+}
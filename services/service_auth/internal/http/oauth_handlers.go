@@ -0,0 +1,368 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+)
+
+// ssoCookie holds the single sign-on token of the authorization endpoint.
+// SameSite=Lax keeps it out of cross-site form posts to the consent page.
+const ssoCookie = "auth_sso"
+
+// tokenExchangeGrant is the grant_type of RFC 8693 token exchange.
+const tokenExchangeGrant = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// registerOAuthRoutes configures the OAuth 2.0 authorization server: the
+// authorization code flow with PKCE (/oauth2/authorize, /oauth2/token),
+// the client_credentials grant for service accounts, the RFC 8628 device
+// authorization grant (see registerDeviceRoutes), RFC 8693 token exchange
+// for calls to downstream services on behalf of a user, RFC 7662
+// introspection and RFC 7009 revocation. The token, introspection and
+// revocation endpoints take form-encoded bodies and require client
+// credentials (HTTP Basic or client_id/client_secret form fields). A DPoP
+// header on token requests binds the issued tokens to the client's key.
+func registerOAuthRoutes(router *gin.Engine, svc *auth.Service) {
+	router.GET("/oauth2/authorize", func(c *gin.Context) {
+		req, client, ok := checkAuthorizeRequest(c, svc)
+		if !ok {
+			return
+		}
+		page := authorizePage{ClientName: client.Name, Scopes: strings.Fields(req.Scope), Request: *req}
+		if sso, err := c.Cookie(ssoCookie); err == nil {
+			if u, _, err := svc.SSOUser(c.Request.Context(), sso); err == nil {
+				page.User = u.Login
+			}
+		}
+		renderAuthorize(c, http.StatusOK, page)
+	})
+	router.POST("/oauth2/authorize", func(c *gin.Context) {
+		req, client, ok := checkAuthorizeRequest(c, svc)
+		if !ok {
+			return
+		}
+		if c.PostForm("action") != "allow" {
+			redirectAuthorize(c, req, url.Values{"error": {"access_denied"}})
+			return
+		}
+		page := authorizePage{ClientName: client.Name, Scopes: strings.Fields(req.Scope), Request: *req}
+		ctx := c.Request.Context()
+
+		sso, ok := signInForm(c, svc, &page)
+		if !ok {
+			return
+		}
+		code, err := svc.Authorize(ctx, sso, client, req)
+		if err != nil {
+			if errors.Is(err, auth.ErrAuthFailed) {
+				// missing or stale cookie: ask for the password again
+				clearSSOCookie(c)
+				renderAuthorize(c, http.StatusUnauthorized, page)
+				return
+			}
+			redirectAuthorize(c, req, url.Values{"error": {"server_error"}})
+			return
+		}
+		redirectAuthorize(c, req, url.Values{"code": {code}})
+	})
+	router.POST("/oauth2/token", func(c *gin.Context) {
+		client, ok := authenticateClient(c, svc)
+		if !ok {
+			return
+		}
+		ctx := c.Request.Context()
+		// a DPoP proof binds the issued tokens to the client's key
+		tokenType := "Bearer"
+		if proof := c.GetHeader("DPoP"); proof != "" {
+			jkt, err := svc.VerifyDPoP(proof, http.MethodPost, requestURL(c, svc))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_dpop_proof", "error_description": err.Error()})
+				return
+			}
+			ctx = auth.WithDPoPKey(ctx, jkt)
+			tokenType = "DPoP"
+		}
+		var tokens *auth.GrantedTokens
+		var err error
+		switch c.PostForm("grant_type") {
+		case "authorization_code":
+			tokens, err = svc.ExchangeCode(ctx, client, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+		case "refresh_token":
+			tokens, err = svc.RefreshClient(ctx, client, c.PostForm("refresh_token"), c.PostForm("scope"))
+		case "client_credentials":
+			tokens, err = svc.ClientCredentials(ctx, client, c.PostForm("scope"))
+		case deviceCodeGrant:
+			tokens, err = svc.PollDevice(ctx, client, c.PostForm("device_code"))
+		case tokenExchangeGrant:
+			tokens, err = svc.ExchangeToken(ctx, client, auth.TokenExchangeRequest{
+				SubjectToken:       c.PostForm("subject_token"),
+				SubjectTokenType:   c.PostForm("subject_token_type"),
+				Audience:           c.PostForm("audience"),
+				Scope:              c.PostForm("scope"),
+				RequestedTokenType: c.PostForm("requested_token_type"),
+			})
+		default:
+			err = &auth.OAuthError{Code: "unsupported_grant_type"}
+		}
+		if err != nil {
+			writeOAuthError(c, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+		body := gin.H{
+			"access_token": tokens.AccessToken,
+			"token_type":   tokenType,
+			"expires_in":   int(time.Until(tokens.AccessExpiry).Seconds()),
+		}
+		if tokens.RefreshToken != "" {
+			body["refresh_token"] = tokens.RefreshToken
+		}
+		if tokens.Scope != "" {
+			body["scope"] = tokens.Scope
+		}
+		if tokens.IDToken != "" {
+			body["id_token"] = tokens.IDToken
+		}
+		if tokens.IssuedTokenType != "" {
+			body["issued_token_type"] = tokens.IssuedTokenType
+		}
+		c.JSON(http.StatusOK, body)
+	})
+	registerDeviceRoutes(router, svc)
+
+	router.POST("/oauth2/introspect", func(c *gin.Context) {
+		client, ok := authenticateClient(c, svc)
+		if !ok {
+			return
+		}
+		// only confidential clients (resource servers) may introspect
+		if client.Public {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			return
+		}
+		token := c.PostForm("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+		out, err := svc.Introspect(c.Request.Context(), token, c.PostForm("token_type_hint"))
+		if err != nil {
+			writeOAuthError(c, err)
+			return
+		}
+		// a resource server may pass on the DPoP proof of its request so
+		// that a bound token is only reported active with a valid proof
+		if proof := c.PostForm("dpop_proof"); proof != "" && out.Active && out.TokenType == auth.HintAccessToken {
+			if err := svc.CheckDPoP(out.Cnf["jkt"], token, proof, c.PostForm("htm"), c.PostForm("htu")); err != nil {
+				out = &auth.Introspection{Active: false}
+			}
+		}
+		c.JSON(http.StatusOK, out)
+	})
+	router.POST("/oauth2/revoke", func(c *gin.Context) {
+		client, ok := authenticateClient(c, svc)
+		if !ok {
+			return
+		}
+		token := c.PostForm("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+		if err := svc.RevokeToken(c.Request.Context(), client, token, c.PostForm("token_type_hint")); err != nil {
+			writeOAuthError(c, err)
+			return
+		}
+		// RFC 7009: invalid tokens are not an error
+		c.Status(http.StatusOK)
+	})
+}
+
+// signInForm handles the sign-in fields of the consent form: a TOTP code
+// for a pending challenge, the acceptance of the terms of service or a
+// login and password. It returns the single sign-on token to act with,
+// from the form or else from the cookie. When the user must try again or
+// take another step it renders page and returns false.
+func signInForm(c *gin.Context, svc *auth.Service, page *authorizePage) (string, bool) {
+	ctx := c.Request.Context()
+	sso, _ := c.Cookie(ssoCookie)
+	var err error
+	switch {
+	case c.PostForm("challenge_token") != "":
+		sso, err = svc.SignInMFA(ctx, c.PostForm("challenge_token"), c.PostForm("code"))
+		if err != nil {
+			var challenge *auth.ChallengeError
+			if errors.As(err, &challenge) {
+				challengeForm(c, svc, page, challenge)
+				return "", false
+			}
+			page.Challenge = c.PostForm("challenge_token")
+			page.Error = "Invalid code."
+			renderAuthorize(c, http.StatusUnauthorized, *page)
+			return "", false
+		}
+		setSSOCookie(c, sso)
+	case c.PostForm("terms_challenge") != "":
+		version, _ := strconv.Atoi(c.PostForm("terms_version"))
+		if c.PostForm("accept_terms") == "" {
+			page.Error = "Accept the terms to continue."
+			challengeForm(c, svc, page, &auth.ChallengeError{Status: auth.StatusTermsRequired, Token: c.PostForm("terms_challenge"), TermsVersion: version})
+			return "", false
+		}
+		sso, err = svc.SignInTerms(ctx, c.PostForm("terms_challenge"), version)
+		if err != nil {
+			page.Error = "The terms could not be accepted. Please sign in again."
+			renderAuthorize(c, http.StatusUnauthorized, *page)
+			return "", false
+		}
+		setSSOCookie(c, sso)
+	case c.PostForm("login") != "":
+		ident := strings.ToLower(strings.TrimSpace(c.PostForm("login")))
+		sso, err = svc.SignIn(ctx, ident, c.PostForm("password"))
+		if err != nil {
+			var challenge *auth.ChallengeError
+			if errors.As(err, &challenge) {
+				challengeForm(c, svc, page, challenge)
+				return "", false
+			}
+			page.Error = "Invalid login or password."
+			if errors.Is(err, auth.ErrAccountDisabled) {
+				page.Error = "This account is disabled."
+			}
+			renderAuthorize(c, http.StatusUnauthorized, *page)
+			return "", false
+		}
+		setSSOCookie(c, sso)
+	}
+	return sso, true
+}
+
+// challengeForm renders page with the form of the sign-in step asked for
+// by challenge: a TOTP code or the acceptance of the terms of service.
+func challengeForm(c *gin.Context, svc *auth.Service, page *authorizePage, challenge *auth.ChallengeError) {
+	if challenge.Status != auth.StatusTermsRequired {
+		page.Challenge = challenge.Token
+		renderAuthorize(c, http.StatusOK, *page)
+		return
+	}
+	terms, err := svc.GetTermsVersion(c.Request.Context(), challenge.TermsVersion)
+	if err != nil {
+		renderAuthorize(c, http.StatusInternalServerError, authorizePage{Error: "Something went wrong."})
+		return
+	}
+	page.Terms, page.TermsChallenge = terms, challenge.Token
+	renderAuthorize(c, http.StatusOK, *page)
+}
+
+// authenticateClient checks the client credentials of an OAuth request and
+// writes the invalid_client response when they are missing or wrong.
+func authenticateClient(c *gin.Context, svc *auth.Service) (*domain.Client, bool) {
+	id, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		id, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	client, err := svc.AuthenticateClient(c.Request.Context(), id, secret)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			c.Header("WWW-Authenticate", `Basic realm="oauth2"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		} else {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		}
+		return nil, false
+	}
+	return client, true
+}
+
+// checkAuthorizeRequest reads and validates the authorization request.
+// Unknown clients and redirect URIs get an error page; other errors are
+// sent back to the client's redirect URI.
+func checkAuthorizeRequest(c *gin.Context, svc *auth.Service) (*auth.AuthorizeRequest, *domain.Client, bool) {
+	req := &auth.AuthorizeRequest{
+		ResponseType:        c.Request.FormValue("response_type"),
+		ClientID:            c.Request.FormValue("client_id"),
+		RedirectURI:         c.Request.FormValue("redirect_uri"),
+		Scope:               c.Request.FormValue("scope"),
+		State:               c.Request.FormValue("state"),
+		CodeChallenge:       c.Request.FormValue("code_challenge"),
+		CodeChallengeMethod: c.Request.FormValue("code_challenge_method"),
+		Nonce:               c.Request.FormValue("nonce"),
+	}
+	client, err := svc.CheckAuthorizeRequest(c.Request.Context(), req)
+	if err == nil {
+		return req, client, true
+	}
+	var oe *auth.OAuthError
+	switch {
+	case errors.Is(err, auth.ErrInvalidClient):
+		renderAuthorize(c, http.StatusBadRequest, authorizePage{Error: "Unknown client."})
+	case errors.Is(err, auth.ErrInvalidRedirectURI):
+		renderAuthorize(c, http.StatusBadRequest, authorizePage{Error: "The redirect URI is not registered for this client."})
+	case errors.As(err, &oe):
+		params := url.Values{"error": {oe.Code}}
+		if oe.Description != "" {
+			params.Set("error_description", oe.Description)
+		}
+		redirectAuthorize(c, req, params)
+	default:
+		renderAuthorize(c, http.StatusInternalServerError, authorizePage{Error: "Something went wrong."})
+	}
+	return nil, nil, false
+}
+
+// redirectAuthorize sends the browser back to the client with params and
+// the request's state.
+func redirectAuthorize(c *gin.Context, req *auth.AuthorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		renderAuthorize(c, http.StatusBadRequest, authorizePage{Error: "Invalid redirect URI."})
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, u.String())
+}
+
+func setSSOCookie(c *gin.Context, sso string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoCookie, sso, int(auth.SSOTTL.Seconds()), basePath(c)+"/oauth2", "", isSecure(c), true)
+}
+
+func clearSSOCookie(c *gin.Context) {
+	c.SetCookie(ssoCookie, "", -1, basePath(c)+"/oauth2", "", isSecure(c), true)
+}
+
+// isSecure reports whether the request reached us over HTTPS, directly or
+// through a TLS-terminating proxy.
+func isSecure(c *gin.Context) bool {
+	return c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+// writeOAuthError writes an RFC 6749 section 5.2 error response.
+func writeOAuthError(c *gin.Context, err error) {
+	var oe *auth.OAuthError
+	if !errors.As(err, &oe) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	body := gin.H{"error": oe.Code}
+	if oe.Description != "" {
+		body["error_description"] = oe.Description
+	}
+	c.JSON(http.StatusBadRequest, body)
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/dpop"
+)
+
+// registerOIDCRoutes configures the OpenID Connect provider endpoints:
+// discovery, the JWKS with the id_token signing key, and userinfo.
+func registerOIDCRoutes(router *gin.Engine, svc *auth.Service) {
+	router.GET("/.well-known/openid-configuration", func(c *gin.Context) {
+		issuer := svc.Issuer()
+		c.JSON(http.StatusOK, gin.H{
+			"issuer":                                issuer,
+			"authorization_endpoint":                issuer + "/oauth2/authorize",
+			"token_endpoint":                        issuer + "/oauth2/token",
+			"device_authorization_endpoint":         issuer + "/oauth2/device_authorization",
+			"userinfo_endpoint":                     issuer + "/oauth2/userinfo",
+			"jwks_uri":                              issuer + "/.well-known/jwks.json",
+			"introspection_endpoint":                issuer + "/oauth2/introspect",
+			"revocation_endpoint":                   issuer + "/oauth2/revoke",
+			"response_types_supported":              []string{"code"},
+			"response_modes_supported":              []string{"query"},
+			"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials", deviceCodeGrant, tokenExchangeGrant},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"dpop_signing_alg_values_supported":     dpop.SigningAlgs,
+			"scopes_supported":                      auth.OIDCScopes,
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"claims_supported": []string{
+				"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr", "sid",
+				"name", "preferred_username", "email", "email_verified",
+			},
+		})
+	})
+	router.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"keys": svc.JWKS()})
+	})
+
+	userinfo := func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		claims, err := validateRequestToken(c, svc, token)
+		if err != nil {
+			if isDPoPError(err) {
+				c.Header("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_dpop_proof"})
+				return
+			}
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		info, err := svc.UserInfo(c.Request.Context(), claims)
+		switch {
+		case errors.Is(err, auth.ErrInsufficientScope):
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+		case errors.Is(err, auth.ErrAuthFailed):
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		default:
+			c.JSON(http.StatusOK, info)
+		}
+	}
+	router.GET("/oauth2/userinfo", userinfo)
+	router.POST("/oauth2/userinfo", userinfo)
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/jwt"
+)
+
+// registerPasswordlessRoutes configures login by emailed magic link or
+// one-time code.
+func registerPasswordlessRoutes(router *gin.Engine, svc *auth.Service) {
+	router.POST("/auth/passwordless/start", func(c *gin.Context) {
+		var req struct {
+			Email  string `json:"email" binding:"required,email"`
+			Method string `json:"method" binding:"omitempty,oneof=link code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Method == "" {
+			req.Method = auth.PasswordlessLink
+		}
+		email := strings.ToLower(strings.TrimSpace(req.Email))
+		id, err := svc.StartPasswordless(c.Request.Context(), email, req.Method)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"challenge_id": id, "method": req.Method})
+	})
+
+	// the emailed link only opens a form: mail scanners and link previews
+	// follow links, and must not use up the link
+	signInPage := linkPage{
+		Title:  "Sign in",
+		Prompt: "Continue signing in to OrgDirectory.",
+		Button: "Sign in",
+	}
+	router.GET("/auth/passwordless/complete", func(c *gin.Context) {
+		page := signInPage
+		page.Token = c.Query("token")
+		renderLinkPage(c, http.StatusOK, page)
+	})
+
+	// POST with a code or a link token, as JSON or from the link page
+	router.POST("/auth/passwordless/complete", func(c *gin.Context) {
+		var req struct {
+			ChallengeID string `json:"challenge_id" binding:"required_with=Code"`
+			Code        string `json:"code" binding:"required_without=Token"`
+			Token       string `json:"token" binding:"required_without=Code"`
+		}
+		if fromLinkPage(c) {
+			req.Token = linkToken(c)
+		} else if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var (
+			tokens *jwt.Tokens
+			err    error
+		)
+		if req.Token != "" {
+			tokens, err = svc.CompletePasswordlessLink(c.Request.Context(), req.Token)
+		} else {
+			tokens, err = svc.CompletePasswordless(c.Request.Context(), req.ChallengeID, req.Code)
+		}
+		if err != nil {
+			var challenge *auth.ChallengeError
+			if errors.As(err, &challenge) {
+				writeChallenge(c, challenge)
+				return
+			}
+			if fromLinkPage(c) {
+				page := signInPage
+				page.Error, page.Done = err.Error(), "Request a new sign-in link."
+				renderLinkPage(c, http.StatusUnauthorized, page)
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"access_token": tokens.AccessToken, "refresh_token": tokens.RefreshToken})
+	})
+}
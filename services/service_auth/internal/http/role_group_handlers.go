@@ -0,0 +1,135 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+)
+
+// registerRoleGroupRoutes configures role group management for
+// administrators and the effective permissions API.
+func registerRoleGroupRoutes(router *gin.Engine, svc *auth.Service) {
+	// the full role set, for tokens flagged with roles_truncated
+	router.GET("/auth/me/permissions", requireUser(svc), func(c *gin.Context) {
+		perms, err := svc.EffectivePermissions(c.Request.Context(), c.GetString(userIDKey))
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, perms)
+	})
+
+	admin := router.Group("/admin", requireUser(svc), requireRole(svc, domain.RoleAdmin))
+	admin.GET("/users/:id/permissions", func(c *gin.Context) {
+		perms, err := svc.EffectivePermissions(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, perms)
+	})
+	admin.GET("/groups", func(c *gin.Context) {
+		groups, err := svc.ListRoleGroups(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := make([]gin.H, 0, len(groups))
+		for i := range groups {
+			out = append(out, roleGroupJSON(&groups[i]))
+		}
+		c.JSON(http.StatusOK, gin.H{"groups": out})
+	})
+	admin.POST("/groups", func(c *gin.Context) {
+		var req auth.RoleGroupInput
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		g, err := svc.CreateRoleGroup(c.Request.Context(), c.GetString(userIDKey), req)
+		if err != nil {
+			c.JSON(roleGroupErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, roleGroupJSON(g))
+	})
+	admin.GET("/groups/:id", func(c *gin.Context) {
+		g, err := svc.GetRoleGroup(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, roleGroupJSON(g))
+	})
+	admin.PUT("/groups/:id", func(c *gin.Context) {
+		var req auth.RoleGroupInput
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		g, err := svc.UpdateRoleGroup(c.Request.Context(), c.GetString(userIDKey), c.Param("id"), req)
+		if err != nil {
+			c.JSON(roleGroupErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, roleGroupJSON(g))
+	})
+	admin.DELETE("/groups/:id", func(c *gin.Context) {
+		if err := svc.DeleteRoleGroup(c.Request.Context(), c.GetString(userIDKey), c.Param("id")); err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	admin.GET("/groups/:id/members", func(c *gin.Context) {
+		members, err := svc.RoleGroupMembers(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"group_id": c.Param("id"), "user_ids": members})
+	})
+	admin.PUT("/groups/:id/members/:user_id", func(c *gin.Context) {
+		if err := svc.AddRoleGroupMember(c.Request.Context(), c.GetString(userIDKey), c.Param("id"), c.Param("user_id")); err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	admin.DELETE("/groups/:id/members/:user_id", func(c *gin.Context) {
+		if err := svc.RemoveRoleGroupMember(c.Request.Context(), c.GetString(userIDKey), c.Param("id"), c.Param("user_id")); err != nil {
+			c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// roleGroupJSON is the admin API view of a role group.
+func roleGroupJSON(g *domain.RoleGroup) gin.H {
+	roles, parents := g.Roles, g.ParentIDs
+	if roles == nil {
+		roles = []string{}
+	}
+	if parents == nil {
+		parents = []string{}
+	}
+	return gin.H{
+		"id":         g.ID,
+		"name":       g.Name,
+		"roles":      roles,
+		"parent_ids": parents,
+		"created_at": g.CreatedAt,
+	}
+}
+
+func roleGroupErrorStatus(err error) int {
+	if errors.Is(err, auth.ErrGroupNameTaken) || errors.Is(err, auth.ErrGroupCycle) {
+		return http.StatusConflict
+	}
+	return adminErrorStatus(err)
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+)
+
+// registerSAMLRoutes configures SP-initiated login with SAML 2.0 identity
+// providers. Providers are listed and deleted with the other identity
+// providers under /admin/identity-providers.
+func registerSAMLRoutes(router *gin.Engine, svc *auth.Service) {
+	router.GET("/auth/saml/metadata", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/samlmetadata+xml", svc.SAMLServiceProvider().Metadata())
+	})
+	router.GET("/auth/saml/:provider/start", func(c *gin.Context) {
+		redirect, err := svc.StartSAMLLogin(c.Request.Context(), c.Param("provider"))
+		if err != nil {
+			c.JSON(federationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Redirect(http.StatusFound, redirect)
+	})
+	// assertion consumer service (HTTP-POST binding)
+	router.POST("/auth/saml/acs", func(c *gin.Context) {
+		response, relayState := c.PostForm("SAMLResponse"), c.PostForm("RelayState")
+		if response == "" || relayState == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "SAMLResponse and RelayState are required"})
+			return
+		}
+		tokens, err := svc.CompleteSAMLLogin(c.Request.Context(), relayState, response)
+		if err != nil {
+			var challenge *auth.ChallengeError
+			if errors.As(err, &challenge) {
+				writeChallenge(c, challenge)
+				return
+			}
+			c.JSON(federationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"access_token": tokens.AccessToken, "refresh_token": tokens.RefreshToken})
+	})
+
+	admin := router.Group("/admin/identity-providers", requireUser(svc), requireRole(svc, domain.RoleAdmin))
+	admin.PUT("/:id/saml", func(c *gin.Context) {
+		var req auth.SAMLProviderRegistration
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.ID = c.Param("id")
+		p, err := svc.SaveSAMLProvider(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, identityProviderJSON(svc, p))
+	})
+}
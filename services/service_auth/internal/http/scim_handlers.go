@@ -0,0 +1,524 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+	"auth_project/internal/scim"
+	"auth_project/internal/store"
+)
+
+// scimMaxResults bounds the page size of list responses.
+const scimMaxResults = 200
+
+// scimLoginPattern matches the registration rules for logins, which SCIM
+// userNames map to.
+var scimLoginPattern = regexp.MustCompile(`^[A-Za-z0-9]{3,30}$`)
+
+// registerSCIMRoutes configures the SCIM 2.0 provisioning API (RFC 7644)
+// used by identity management systems to create, update and remove users
+// and groups. Groups are roles: the members of a group hold the role named
+// by its displayName. The API is authenticated with the dedicated bearer
+// token of WithSCIMToken and is not found when none is configured.
+func registerSCIMRoutes(router *gin.Engine, svc *auth.Service) {
+	api := router.Group("/scim/v2", requireSCIMToken(svc))
+
+	api.GET("/ServiceProviderConfig", func(c *gin.Context) {
+		scimJSON(c, http.StatusOK, gin.H{
+			"schemas":        []string{scim.ServiceProviderSchema},
+			"patch":          gin.H{"supported": true},
+			"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+			"filter":         gin.H{"supported": true, "maxResults": scimMaxResults},
+			"changePassword": gin.H{"supported": true},
+			"sort":           gin.H{"supported": false},
+			"etag":           gin.H{"supported": false},
+			"authenticationSchemes": []gin.H{{
+				"type":        "oauthbearertoken",
+				"name":        "Bearer token",
+				"description": "The SCIM token configured for the service",
+			}},
+		})
+	})
+	api.GET("/ResourceTypes", func(c *gin.Context) {
+		scimJSON(c, http.StatusOK, scimList([]any{
+			gin.H{"schemas": []string{scim.ResourceTypeSchema}, "id": "User", "name": "User", "endpoint": "/Users", "schema": scim.UserSchema},
+			gin.H{"schemas": []string{scim.ResourceTypeSchema}, "id": "Group", "name": "Group", "endpoint": "/Groups", "schema": scim.GroupSchema},
+		}, 1))
+	})
+
+	api.GET("/Users", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		users, err := svc.ListUsers(ctx)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		groups, err := scimGroupsByName(c, svc)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		resources := make([]map[string]any, 0, len(users))
+		for i := range users {
+			resources = append(resources, scimUser(svc, &users[i], groups))
+		}
+		scimListResponse(c, resources)
+	})
+	api.POST("/Users", func(c *gin.Context) {
+		res, ok := scimBody(c)
+		if !ok {
+			return
+		}
+		p, err := provisionedUser(res)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		u, err := svc.ProvisionUser(c.Request.Context(), p)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		out := scimUser(svc, u, nil)
+		c.Header("Location", out["meta"].(map[string]any)["location"].(string))
+		scimJSON(c, http.StatusCreated, out)
+	})
+	api.GET("/Users/:id", func(c *gin.Context) {
+		res, err := scimUserByID(c, svc, c.Param("id"))
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, res)
+	})
+	api.PUT("/Users/:id", func(c *gin.Context) {
+		res, ok := scimBody(c)
+		if !ok {
+			return
+		}
+		scimUpdateUser(c, svc, c.Param("id"), res)
+	})
+	api.PATCH("/Users/:id", func(c *gin.Context) {
+		var req scim.PatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			scimError(c, scim.BadRequest("invalidSyntax", "%s", err.Error()))
+			return
+		}
+		res, err := scimUserByID(c, svc, c.Param("id"))
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		if err := scim.Apply(res, req.Operations); err != nil {
+			scimError(c, err)
+			return
+		}
+		scimUpdateUser(c, svc, c.Param("id"), res)
+	})
+	api.DELETE("/Users/:id", func(c *gin.Context) {
+		if err := svc.DeleteUser(c.Request.Context(), c.Param("id")); err != nil {
+			scimError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	api.GET("/Groups", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		groups, err := svc.ListGroups(ctx)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		resources := make([]map[string]any, 0, len(groups))
+		for i := range groups {
+			res, err := scimGroup(c, svc, &groups[i])
+			if err != nil {
+				scimError(c, err)
+				return
+			}
+			resources = append(resources, res)
+		}
+		scimListResponse(c, resources)
+	})
+	api.POST("/Groups", func(c *gin.Context) {
+		res, ok := scimBody(c)
+		if !ok {
+			return
+		}
+		name, externalID, members, err := groupAttributes(res)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		g, err := svc.CreateGroup(c.Request.Context(), name, externalID, members)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		out, err := scimGroup(c, svc, g)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		c.Header("Location", out["meta"].(map[string]any)["location"].(string))
+		scimJSON(c, http.StatusCreated, out)
+	})
+	api.GET("/Groups/:id", func(c *gin.Context) {
+		res, err := scimGroupByID(c, svc, c.Param("id"))
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, res)
+	})
+	api.PUT("/Groups/:id", func(c *gin.Context) {
+		res, ok := scimBody(c)
+		if !ok {
+			return
+		}
+		scimUpdateGroup(c, svc, c.Param("id"), res)
+	})
+	api.PATCH("/Groups/:id", func(c *gin.Context) {
+		var req scim.PatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			scimError(c, scim.BadRequest("invalidSyntax", "%s", err.Error()))
+			return
+		}
+		res, err := scimGroupByID(c, svc, c.Param("id"))
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		if err := scim.Apply(res, req.Operations); err != nil {
+			scimError(c, err)
+			return
+		}
+		scimUpdateGroup(c, svc, c.Param("id"), res)
+	})
+	api.DELETE("/Groups/:id", func(c *gin.Context) {
+		if err := svc.DeleteGroup(c.Request.Context(), c.Param("id")); err != nil {
+			scimError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// requireSCIMToken authenticates provisioning clients by the SCIM token.
+func requireSCIMToken(svc *auth.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !svc.SCIMEnabled() {
+			scimError(c, &scim.Error{Status: http.StatusNotFound, Detail: "SCIM is not enabled"})
+			c.Abort()
+			return
+		}
+		token, ok := bearerToken(c)
+		if !ok || !svc.CheckSCIMToken(token) {
+			c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+			scimError(c, &scim.Error{Status: http.StatusUnauthorized, Detail: "invalid token"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// scimJSON writes body with the SCIM media type.
+func scimJSON(c *gin.Context, status int, body any) {
+	c.Header("Content-Type", scim.ContentType+"; charset=utf-8")
+	c.JSON(status, body)
+}
+
+// scimError writes err as a SCIM error response.
+func scimError(c *gin.Context, err error) {
+	var e *scim.Error
+	switch {
+	case errors.As(err, &e):
+	case errors.Is(err, store.ErrNotFound):
+		e = &scim.Error{Status: http.StatusNotFound, Detail: "resource not found"}
+	case errors.Is(err, auth.ErrLoginTaken), errors.Is(err, auth.ErrEmailTaken), errors.Is(err, auth.ErrGroupExists):
+		e = &scim.Error{Status: http.StatusConflict, Type: "uniqueness", Detail: err.Error()}
+	case errors.Is(err, auth.ErrReservedRole), errors.Is(err, auth.ErrUnknownMember), errors.Is(err, auth.ErrWeakPassword):
+		e = scim.BadRequest("invalidValue", "%s", err.Error())
+	case errors.Is(err, auth.ErrProtectedUser):
+		e = &scim.Error{Status: http.StatusForbidden, Detail: err.Error()}
+	default:
+		e = &scim.Error{Status: http.StatusInternalServerError, Detail: err.Error()}
+	}
+	scimJSON(c, e.Status, e.Body())
+}
+
+// scimBody reads a resource from the request body.
+func scimBody(c *gin.Context) (map[string]any, bool) {
+	var res map[string]any
+	if err := json.NewDecoder(c.Request.Body).Decode(&res); err != nil || res == nil {
+		scimError(c, scim.BadRequest("invalidSyntax", "request body must be a JSON object"))
+		return nil, false
+	}
+	return res, true
+}
+
+// scimListResponse filters and pages resources according to the filter,
+// startIndex and count query parameters.
+func scimListResponse(c *gin.Context, resources []map[string]any) {
+	if expr := c.Query("filter"); expr != "" {
+		f, err := scim.Parse(expr)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		matched := resources[:0]
+		for _, res := range resources {
+			if f.Match(res) {
+				matched = append(matched, res)
+			}
+		}
+		resources = matched
+	}
+	start, _ := strconv.Atoi(c.Query("startIndex"))
+	if start < 1 {
+		start = 1
+	}
+	count := scimMaxResults
+	if s := c.Query("count"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			count = min(max(n, 0), scimMaxResults)
+		}
+	}
+	total := len(resources)
+	page := make([]any, 0, count)
+	for i := start - 1; i < total && len(page) < count; i++ {
+		page = append(page, resources[i])
+	}
+	out := scimList(page, start)
+	out["totalResults"] = total
+	scimJSON(c, http.StatusOK, out)
+}
+
+func scimList(resources []any, start int) gin.H {
+	return gin.H{
+		"schemas":      []string{scim.ListResponseSchema},
+		"totalResults": len(resources),
+		"startIndex":   start,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+}
+
+// scimMeta returns the meta attribute of a resource.
+func scimMeta(svc *auth.Service, resourceType, endpoint, id string, created time.Time) map[string]any {
+	return map[string]any{
+		"resourceType": resourceType,
+		"created":      created.UTC().Format(time.RFC3339),
+		"location":     svc.Issuer() + "/scim/v2/" + endpoint + "/" + id,
+	}
+}
+
+// scimGroupsByName returns the groups by the role they grant.
+func scimGroupsByName(c *gin.Context, svc *auth.Service) (map[string]store.Group, error) {
+	groups, err := svc.ListGroups(c.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]store.Group, len(groups))
+	for _, g := range groups {
+		out[g.DisplayName] = g
+	}
+	return out, nil
+}
+
+// scimUser returns the SCIM representation of a user. It is built from
+// the types encoding/json decodes to, so filters and PATCH operations
+// apply to it as to a request body.
+func scimUser(svc *auth.Service, u *domain.User, groups map[string]store.Group) map[string]any {
+	res := map[string]any{
+		"schemas":  []any{scim.UserSchema},
+		"id":       u.ID,
+		"userName": u.Login,
+		"emails":   []any{map[string]any{"value": u.Email, "primary": true, "type": "work"}},
+		"active":   !u.Disabled(),
+		"meta":     scimMeta(svc, "User", "Users", u.ID, u.CreatedAt),
+	}
+	if u.ExternalID != "" {
+		res["externalId"] = u.ExternalID
+	}
+	var memberOf []any
+	for _, r := range u.Roles {
+		if g, ok := groups[r]; ok {
+			memberOf = append(memberOf, map[string]any{
+				"value":   g.ID,
+				"display": g.DisplayName,
+				"$ref":    svc.Issuer() + "/scim/v2/Groups/" + g.ID,
+			})
+		}
+	}
+	if len(memberOf) > 0 {
+		res["groups"] = memberOf
+	}
+	return res
+}
+
+func scimUserByID(c *gin.Context, svc *auth.Service, id string) (map[string]any, error) {
+	u, err := svc.GetUser(c.Request.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := scimGroupsByName(c, svc)
+	if err != nil {
+		return nil, err
+	}
+	return scimUser(svc, u, groups), nil
+}
+
+// scimUpdateUser replaces the user with the attributes of res.
+func scimUpdateUser(c *gin.Context, svc *auth.Service, id string, res map[string]any) {
+	p, err := provisionedUser(res)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	u, err := svc.UpdateProvisionedUser(c.Request.Context(), id, p)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	groups, err := scimGroupsByName(c, svc)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, scimUser(svc, u, groups))
+}
+
+// provisionedUser reads the attributes we store from a User resource:
+// userName is the login, the primary (or first) email the address. The
+// groups attribute is read-only; membership is managed via Groups.
+func provisionedUser(res map[string]any) (auth.ProvisionedUser, error) {
+	p := auth.ProvisionedUser{
+		Login:      strings.TrimSpace(scim.String(res, "userName")),
+		ExternalID: scim.String(res, "externalId"),
+		Password:   scim.String(res, "password"),
+		Active:     true,
+	}
+	if !scimLoginPattern.MatchString(p.Login) {
+		return p, scim.BadRequest("invalidValue", "userName must be 3 to 30 letters or digits")
+	}
+	emails, _, _ := scim.Lookup(res, "emails")
+	list, _ := emails.([]any)
+	for _, e := range list {
+		em, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+		primary, _, _ := scim.Lookup(em, "primary")
+		if p.Email == "" || primary == true {
+			p.Email = strings.TrimSpace(scim.String(em, "value"))
+		}
+	}
+	if p.Email == "" || !strings.Contains(p.Email, "@") {
+		return p, scim.BadRequest("invalidValue", "an email address is required")
+	}
+	if active, _, ok := scim.Lookup(res, "active"); ok {
+		switch v := active.(type) {
+		case bool:
+			p.Active = v
+		case string:
+			// some clients send booleans as strings
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return p, scim.BadRequest("invalidValue", "active must be a boolean")
+			}
+			p.Active = b
+		case nil:
+		default:
+			return p, scim.BadRequest("invalidValue", "active must be a boolean")
+		}
+	}
+	return p, nil
+}
+
+// scimGroup returns the SCIM representation of a group with its members.
+func scimGroup(c *gin.Context, svc *auth.Service, g *store.Group) (map[string]any, error) {
+	users, err := svc.GroupMembers(c.Request.Context(), g)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]any, 0, len(users))
+	for _, u := range users {
+		members = append(members, map[string]any{
+			"value":   u.ID,
+			"display": u.Login,
+			"$ref":    svc.Issuer() + "/scim/v2/Users/" + u.ID,
+		})
+	}
+	res := map[string]any{
+		"schemas":     []any{scim.GroupSchema},
+		"id":          g.ID,
+		"displayName": g.DisplayName,
+		"members":     members,
+		"meta":        scimMeta(svc, "Group", "Groups", g.ID, g.CreatedAt),
+	}
+	if g.ExternalID != "" {
+		res["externalId"] = g.ExternalID
+	}
+	return res, nil
+}
+
+func scimGroupByID(c *gin.Context, svc *auth.Service, id string) (map[string]any, error) {
+	g, err := svc.GetGroup(c.Request.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	return scimGroup(c, svc, g)
+}
+
+// scimUpdateGroup replaces the group with the attributes of res.
+func scimUpdateGroup(c *gin.Context, svc *auth.Service, id string, res map[string]any) {
+	name, externalID, members, err := groupAttributes(res)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	g, err := svc.UpdateGroup(c.Request.Context(), id, name, externalID, members)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	out, err := scimGroup(c, svc, g)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, out)
+}
+
+// groupAttributes reads displayName, externalId and the member ids of a
+// Group resource.
+func groupAttributes(res map[string]any) (name, externalID string, members []string, err error) {
+	name = strings.TrimSpace(scim.String(res, "displayName"))
+	if name == "" {
+		return "", "", nil, scim.BadRequest("invalidValue", "displayName is required")
+	}
+	v, _, _ := scim.Lookup(res, "members")
+	list, _ := v.([]any)
+	for _, e := range list {
+		em, ok := e.(map[string]any)
+		if !ok {
+			return "", "", nil, scim.BadRequest("invalidValue", "members must be objects")
+		}
+		if id := scim.String(em, "value"); id != "" {
+			members = append(members, id)
+		}
+	}
+	return name, scim.String(res, "externalId"), members, nil
+}
@@ -3,23 +3,31 @@ package http
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
 
-	"github.com/gin-gonic/gin"
-
 	"auth_project/internal/auth"
+	"auth_project/internal/store"
 )
 
 // Start runs the HTTP server on the configured port. It creates a gin
 // engine, registers routes and listens until stopped. Environment
 // variables AUTH_SECRET and AUTH_ISSUER can override defaults.
 func Start(ctx context.Context, svc *auth.Service) error {
-	router := gin.Default()
-	RegisterRoutes(ctx, router, svc)
+	return StartTenants(ctx, []Tenant{{ID: store.DefaultTenant, Service: svc}})
+}
+
+// StartTenants runs the HTTP server for several tenants, see
+// NewTenantHandler.
+func StartTenants(ctx context.Context, tenants []Tenant) error {
+	handler, err := NewTenantHandler(ctx, tenants)
+	if err != nil {
+		return err
+	}
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 	log.Printf("HTTP server listening on :%s", port)
-	return router.Run(":" + port)
+	return http.ListenAndServe(":"+port, handler)
 }
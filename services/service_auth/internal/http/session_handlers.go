@@ -0,0 +1,58 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+)
+
+// registerSessionRoutes lets users see and revoke their logins.
+func registerSessionRoutes(router *gin.Engine, svc *auth.Service) {
+	sessions := router.Group("/auth/sessions", requireUser(svc))
+	sessions.GET("", func(c *gin.Context) {
+		list, err := svc.ListSessions(c.Request.Context(), c.GetString(userIDKey))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		current := accessClaims(c).SessionID
+		type sessionView struct {
+			ID         string    `json:"id"`
+			DeviceName string    `json:"device_name"`
+			UserAgent  string    `json:"user_agent"`
+			IP         string    `json:"ip"`
+			CreatedAt  time.Time `json:"created_at"`
+			LastUsedAt time.Time `json:"last_used_at"`
+			Current    bool      `json:"current"`
+		}
+		out := make([]sessionView, 0, len(list))
+		for _, s := range list {
+			out = append(out, sessionView{
+				ID:         s.ID,
+				DeviceName: s.DeviceName,
+				UserAgent:  s.UserAgent,
+				IP:         s.IP,
+				CreatedAt:  s.CreatedAt,
+				LastUsedAt: s.LastUsedAt,
+				Current:    s.ID == current,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"sessions": out})
+	})
+	sessions.DELETE("/:id", denyImpersonation(), func(c *gin.Context) {
+		err := svc.RevokeSession(c.Request.Context(), c.GetString(userIDKey), c.Param("id"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, auth.ErrSessionNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
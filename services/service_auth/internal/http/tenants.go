@@ -0,0 +1,141 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+)
+
+// Tenant is a realm served by the HTTP server: an auth.Service with its
+// own users, keys and settings, reached on its host names or below its
+// path prefix. A tenant with both is reached below its path prefix on its
+// own host names as on others. A tenant with neither receives the requests
+// no other tenant matches.
+type Tenant struct {
+	ID         string
+	Hosts      []string
+	PathPrefix string
+	Service    *auth.Service
+}
+
+// tenantHandler dispatches requests to the router of their tenant.
+type tenantHandler struct {
+	byHost   map[string]tenantPrefix
+	prefixes []tenantPrefix // longest first
+	fallback http.Handler
+}
+
+type tenantPrefix struct {
+	prefix  string
+	handler http.Handler
+}
+
+// NewTenantHandler builds a router per tenant and returns the handler
+// resolving the tenant of each request: by the Host header first, then by
+// path prefix. The tenant's path prefix is stripped before its routes see
+// the request, whichever way it was resolved. Requests of no tenant are
+// answered with 404.
+func NewTenantHandler(ctx context.Context, tenants []Tenant) (http.Handler, error) {
+	h := &tenantHandler{byHost: make(map[string]tenantPrefix)}
+	seen := make(map[string]bool)
+	for _, t := range tenants {
+		if seen[t.ID] {
+			return nil, fmt.Errorf("tenant %s configured twice", t.ID)
+		}
+		seen[t.ID] = true
+		router := gin.Default()
+		RegisterRoutes(ctx, router, t.Service)
+		prefix := strings.TrimRight(t.PathPrefix, "/")
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("tenant %s: path prefix must start with /", t.ID)
+		}
+		for _, host := range t.Hosts {
+			host = strings.ToLower(host)
+			if _, ok := h.byHost[host]; ok {
+				return nil, fmt.Errorf("host %s belongs to two tenants", host)
+			}
+			h.byHost[host] = tenantPrefix{prefix: prefix, handler: router}
+		}
+		switch {
+		case prefix != "":
+			for _, p := range h.prefixes {
+				if p.prefix == prefix {
+					return nil, fmt.Errorf("path prefix %s belongs to two tenants", prefix)
+				}
+			}
+			h.prefixes = append(h.prefixes, tenantPrefix{prefix: prefix, handler: router})
+		case len(t.Hosts) == 0:
+			if h.fallback != nil {
+				return nil, errors.New("only one tenant may have neither hosts nor a path prefix")
+			}
+			h.fallback = router
+		}
+	}
+	sort.Slice(h.prefixes, func(i, j int) bool { return len(h.prefixes[i].prefix) > len(h.prefixes[j].prefix) })
+	return h, nil
+}
+
+func (h *tenantHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	if p, ok := h.byHost[strings.ToLower(host)]; ok {
+		// the links of a tenant with a path prefix include it on its
+		// hosts too
+		if !p.serve(w, r) {
+			notFound(w)
+		}
+		return
+	}
+	for _, p := range h.prefixes {
+		if p.serve(w, r) {
+			return
+		}
+	}
+	if h.fallback != nil {
+		h.fallback.ServeHTTP(w, r)
+		return
+	}
+	notFound(w)
+}
+
+// serve passes r to the tenant's routes with the prefix stripped. It
+// reports false, without writing a response, if the path is not below the
+// prefix.
+func (p tenantPrefix) serve(w http.ResponseWriter, r *http.Request) bool {
+	if p.prefix == "" {
+		p.handler.ServeHTTP(w, r)
+		return true
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, p.prefix)
+	if !ok || rest != "" && !strings.HasPrefix(rest, "/") {
+		return false
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = rest
+	if r2.URL.Path == "" {
+		r2.URL.Path = "/"
+	}
+	r2.URL.RawPath = ""
+	p.handler.ServeHTTP(w, r2)
+	return true
+}
+
+func notFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"error":"unknown tenant"}`))
+}
@@ -0,0 +1,174 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"auth_project/internal/auth"
+	"auth_project/internal/domain"
+	"auth_project/internal/store"
+)
+
+// registerTermsRoutes configures the terms of service: the published
+// versions, their acceptance by users and the coverage report for
+// administrators.
+func registerTermsRoutes(router *gin.Engine, svc *auth.Service) {
+	router.GET("/auth/terms", func(c *gin.Context) {
+		v, err := svc.CurrentTerms(c.Request.Context())
+		if err != nil {
+			c.JSON(termsErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, termsView(v))
+	})
+	router.GET("/auth/terms/versions/:version", func(c *gin.Context) {
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": store.ErrNotFound.Error()})
+			return
+		}
+		v, err := svc.GetTermsVersion(c.Request.Context(), version)
+		if err != nil {
+			c.JSON(termsErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, termsView(v))
+	})
+	// completes a login answered with status terms_required
+	router.POST("/auth/terms/accept", func(c *gin.Context) {
+		var req struct {
+			ChallengeToken string `json:"challenge_token" binding:"required"`
+			Version        int    `json:"version" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tokens, err := svc.AcceptTermsChallenge(c.Request.Context(), req.ChallengeToken, req.Version)
+		if err != nil {
+			status := termsErrorStatus(err)
+			if status == http.StatusInternalServerError {
+				status = http.StatusUnauthorized
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"access_token": tokens.AccessToken, "refresh_token": tokens.RefreshToken})
+	})
+
+	me := router.Group("/auth/me/terms", requireUser(svc))
+	me.GET("", func(c *gin.Context) {
+		status, err := svc.TermsStatus(c.Request.Context(), c.GetString(userIDKey))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		accepted := make([]termsAcceptanceJSON, 0, len(status.Acceptances))
+		for _, a := range status.Acceptances {
+			accepted = append(accepted, termsAcceptanceJSON{Version: a.Version, AcceptedAt: a.AcceptedAt, IP: a.IP})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"current_version":  status.CurrentVersion,
+			"required_version": status.RequiredVersion,
+			"pending":          status.Pending,
+			"accepted":         accepted,
+		})
+	})
+	// accepting on behalf of someone else would forge their consent
+	me.POST("", denyImpersonation(), func(c *gin.Context) {
+		var req struct {
+			Version int `json:"version" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := svc.AcceptTerms(c.Request.Context(), c.GetString(userIDKey), req.Version); err != nil {
+			c.JSON(termsErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	admin := router.Group("/admin/terms", requireUser(svc), requireRole(svc, domain.RoleAdmin))
+	admin.GET("", func(c *gin.Context) {
+		list, err := svc.ListTermsVersions(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := make([]termsJSON, 0, len(list))
+		for i := range list {
+			out = append(out, termsView(&list[i]))
+		}
+		c.JSON(http.StatusOK, gin.H{"versions": out})
+	})
+	admin.POST("", func(c *gin.Context) {
+		var req auth.NewTermsVersion
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		v, err := svc.PublishTerms(c.Request.Context(), c.GetString(userIDKey), req)
+		if err != nil {
+			c.JSON(termsErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, termsView(v))
+	})
+	admin.GET("/coverage", func(c *gin.Context) {
+		report, err := svc.TermsCoverage(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}
+
+// termsErrorStatus maps errors of the terms endpoints to HTTP statuses.
+func termsErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrTermsOutdated):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrInvalidTerms):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// termsJSON is the API view of a terms version.
+type termsJSON struct {
+	Version     int       `json:"version"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Mandatory   bool      `json:"mandatory"`
+	PublishedBy string    `json:"published_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func termsView(v *store.TermsVersion) termsJSON {
+	return termsJSON{
+		Version:     v.Version,
+		Title:       v.Title,
+		Content:     v.Content,
+		URL:         v.URL,
+		Mandatory:   v.Mandatory,
+		PublishedBy: v.PublishedBy,
+		CreatedAt:   v.CreatedAt,
+	}
+}
+
+// termsAcceptanceJSON is the API view of an acceptance.
+type termsAcceptanceJSON struct {
+	Version    int       `json:"version"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	IP         string    `json:"ip,omitempty"`
+}
@@ -0,0 +1,134 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoSigningKey is returned by IssueIDToken when no RSA key was set.
+var ErrNoSigningKey = errors.New("no id_token signing key configured")
+
+// WithRSAKey sets the RSA key used to sign id_tokens (RS256). The key id
+// is its RFC 7638 thumbprint, so it stays stable across restarts.
+func WithRSAKey(key *rsa.PrivateKey) Option {
+	return func(s *Service) {
+		s.signingKey = key
+		s.keyID = thumbprint(&key.PublicKey)
+	}
+}
+
+// LoadRSAKey reads a PEM encoded (PKCS #1 or PKCS #8) RSA private key.
+func LoadRSAKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// GenerateRSAKey returns a fresh 2048-bit key for development setups.
+// id_tokens signed with it cannot be verified after a restart.
+func GenerateRSAKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// IDToken describes an OpenID Connect id_token. Claims holds the user
+// claims released for the granted scopes (email, preferred_username, ...).
+type IDToken struct {
+	Issuer    string
+	Subject   string
+	Audience  string // client_id
+	Nonce     string
+	SessionID string
+	AuthTime  time.Time
+	AMR       []string
+	Claims    map[string]any
+}
+
+// IssueIDToken signs t with the RSA key. The token lives as long as an
+// access token.
+func (s *Service) IssueIDToken(t IDToken) (string, error) {
+	if s.signingKey == nil {
+		return "", ErrNoSigningKey
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for k, v := range t.Claims {
+		claims[k] = v
+	}
+	claims["iss"] = t.Issuer
+	claims["sub"] = t.Subject
+	claims["aud"] = t.Audience
+	claims["azp"] = t.Audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.accessTTL).Unix()
+	if !t.AuthTime.IsZero() {
+		claims["auth_time"] = t.AuthTime.Unix()
+	}
+	if t.Nonce != "" {
+		claims["nonce"] = t.Nonce
+	}
+	if t.SessionID != "" {
+		claims["sid"] = t.SessionID
+	}
+	if len(t.AMR) > 0 {
+		claims["amr"] = t.AMR
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	return token.SignedString(s.signingKey)
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS returns the public keys that verify id_tokens.
+func (s *Service) JWKS() []JWK {
+	if s.signingKey == nil {
+		return []JWK{}
+	}
+	pub := &s.signingKey.PublicKey
+	n, e := jwkModulus(pub)
+	return []JWK{{Kty: "RSA", Use: "sig", Alg: "RS256", Kid: s.keyID, N: n, E: e}}
+}
+
+// jwkModulus returns the base64url encoded modulus and exponent of pub.
+func jwkModulus(pub *rsa.PublicKey) (string, string) {
+	n := base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	return n, e
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of pub.
+func thumbprint(pub *rsa.PublicKey) string {
+	n, e := jwkModulus(pub)
+	// members in lexicographic order, no whitespace
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{e, "RSA", n})
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -2,6 +2,9 @@ package jwt
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"time"
@@ -17,16 +20,28 @@ type Service struct {
 	issuer     string
 	accessTTL  time.Duration
 	refreshTTL time.Duration
+
+	// signingKey signs OpenID Connect id_tokens, which clients verify
+	// with the public key published as JWKS.
+	signingKey *rsa.PrivateKey
+	keyID      string
 }
 
+// Option configures optional features of Service.
+type Option func(*Service)
+
 // New constructs a new JWT service.
-func New(secret, issuer string, accessTTL, refreshTTL time.Duration) *Service {
-	return &Service{
+func New(secret, issuer string, accessTTL, refreshTTL time.Duration, opts ...Option) *Service {
+	s := &Service{
 		secret:     []byte(secret),
 		issuer:     issuer,
 		accessTTL:  accessTTL,
 		refreshTTL: refreshTTL,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Tokens holds the issued access and refresh tokens and the expiry of
@@ -35,62 +50,406 @@ type Tokens struct {
 	AccessToken   string
 	RefreshToken  string
 	RefreshExpiry time.Time
+	// AccessID is the `jti` of the access token; AccessExpiry its `exp`.
+	// They are needed to revoke the token before it expires.
+	AccessID     string
+	AccessExpiry time.Time
+}
+
+// accessAudience is the `aud` of access tokens. ValidateAccess rejects
+// tokens with any other audience (refresh and challenge tokens).
+const accessAudience = "auth_service"
+
+// challengeAudience is the `aud` of short-lived challenge tokens issued
+// between the steps of a multi-step login.
+const challengeAudience = "auth_service/challenge"
+
+// ssoAudience is the `aud` of the single sign-on cookie set by the
+// authorization endpoint.
+const ssoAudience = "auth_service/sso"
+
+// ReservedAudience reports whether aud is one of the audiences of tokens
+// this service accepts itself (access, challenge and single sign-on
+// tokens). Tokens for other services must never carry them.
+func ReservedAudience(aud string) bool {
+	return aud == accessAudience || aud == challengeAudience || aud == ssoAudience
+}
+
+// IssueOption adds claims to an issued access token.
+type IssueOption func(claims jwt.MapClaims)
+
+// WithAMR sets the `amr` claim (RFC 8176 authentication method
+// references), e.g. "pwd" or "otp".
+func WithAMR(methods ...string) IssueOption {
+	return func(claims jwt.MapClaims) {
+		if len(methods) > 0 {
+			claims["amr"] = methods
+		}
+	}
+}
+
+// WithClaim sets an arbitrary claim on the access token.
+func WithClaim(name string, value any) IssueOption {
+	return func(claims jwt.MapClaims) { claims[name] = value }
+}
+
+// WithAudience sets the `aud` claim of a token meant for another service
+// (see ParseAccessFor). Such tokens are not accepted by ParseAccess.
+func WithAudience(audience string) IssueOption {
+	return func(claims jwt.MapClaims) { claims["aud"] = audience }
+}
+
+// WithExpiry shortens the lifetime of the access token to end at exp.
+func WithExpiry(exp time.Time) IssueOption {
+	return func(claims jwt.MapClaims) {
+		if e, ok := claims["exp"].(int64); ok && exp.Unix() < e {
+			claims["exp"] = exp.Unix()
+		}
+	}
+}
+
+// WithConfirmation binds the access token to a DPoP key by its thumbprint
+// (`cnf.jkt`, RFC 9449 section 6). An empty jkt issues a bearer token.
+func WithConfirmation(jkt string) IssueOption {
+	return func(claims jwt.MapClaims) {
+		if jkt != "" {
+			claims["cnf"] = map[string]any{"jkt": jkt}
+		}
+	}
 }
 
 // Issue generates a new pair of access and refresh tokens for the given
 // user ID【471101221547741†screenshot】.
-func (s *Service) Issue(ctx context.Context, userID string) (*Tokens, error) {
+func (s *Service) Issue(ctx context.Context, userID string, opts ...IssueOption) (*Tokens, error) {
 	now := time.Now()
-	// build access token
+	tokens, err := s.signAccess(userID, now, opts)
+	if err != nil {
+		return nil, err
+	}
+	// build refresh token (also JWT for simplicity)
+	refreshClaims := jwt.MapClaims{
+		"sub": userID,
+		"jti": newID(),
+		"iat": now.Unix(),
+		"exp": now.Add(s.refreshTTL).Unix(),
+	}
+	refresh := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
+	refreshStr, err := refresh.SignedString(s.secret)
+	if err != nil {
+		return nil, err
+	}
+	tokens.RefreshToken = refreshStr
+	tokens.RefreshExpiry = now.Add(s.refreshTTL)
+	return tokens, nil
+}
+
+// IssueAccess generates an access token without a refresh token, as
+// returned by the client_credentials grant.
+func (s *Service) IssueAccess(ctx context.Context, subject string, opts ...IssueOption) (*Tokens, error) {
+	return s.signAccess(subject, time.Now(), opts)
+}
+
+// signAccess signs an access token for subject issued at now.
+func (s *Service) signAccess(subject string, now time.Time, opts []IssueOption) (*Tokens, error) {
+	jti := newID()
 	accessClaims := jwt.MapClaims{
 		"iss": s.issuer,
-		"sub": userID,
-		"aud": "auth_service",
+		"sub": subject,
+		"aud": accessAudience,
+		"jti": jti,
 		"iat": now.Unix(),
 		"exp": now.Add(s.accessTTL).Unix(),
 		// additional claims (roles/scopes) could go here【809718908566546†screenshot】
 	}
+	for _, opt := range opts {
+		opt(accessClaims)
+	}
 	access := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
 	accessStr, err := access.SignedString(s.secret)
 	if err != nil {
 		return nil, err
 	}
-	// build refresh token (also JWT for simplicity)
-	refreshClaims := jwt.MapClaims{
+	exp, _ := accessClaims["exp"].(int64)
+	return &Tokens{
+		AccessToken:  accessStr,
+		AccessID:     jti,
+		AccessExpiry: time.Unix(exp, 0),
+	}, nil
+}
+
+// AccessClaims are the verified claims of an access token.
+type AccessClaims struct {
+	ID        string // jti
+	Subject   string
+	SessionID string
+	ClientID  string
+	Scope     string
+	Roles     []string
+	// RolesTruncated is set when Roles hold only the subject's own roles
+	// because the effective set was too large for the token.
+	RolesTruncated bool
+	AMR            []string
+	Audience       string
+	// CitizenID and OrgIDs link the subject to OrgDirectory.
+	CitizenID string
+	OrgIDs    []int
+	// Actor is the `sub` of the `act` claim of a delegated token: the
+	// client acting on behalf of Subject (RFC 8693 section 4.1).
+	Actor string
+	// JKT is the DPoP key thumbprint of a sender-constrained token.
+	JKT       string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Raw holds all claims, including ones without a dedicated field.
+	Raw jwt.MapClaims
+}
+
+// ValidateAccess verifies the access token and returns the subject (user ID).
+func (s *Service) ValidateAccess(tokenStr string) (string, error) {
+	claims, err := s.ParseAccess(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// ParseAccess verifies the access token and returns its claims.
+func (s *Service) ParseAccess(tokenStr string) (*AccessClaims, error) {
+	return s.ParseAccessFor(tokenStr, accessAudience)
+}
+
+// ParseAccessFor verifies an access token issued for audience, such as a
+// token of a downstream service obtained by token exchange.
+func (s *Service) ParseAccessFor(tokenStr, audience string) (*AccessClaims, error) {
+	claims, err := s.parse(tokenStr, jwt.WithAudience(audience))
+	if err != nil {
+		return nil, err
+	}
+	return accessClaims(claims)
+}
+
+// ParseAnyAccess verifies an access token of any audience, as needed by
+// introspection on behalf of resource servers. Refresh, challenge and
+// single sign-on tokens are rejected.
+func (s *Service) ParseAnyAccess(tokenStr string) (*AccessClaims, error) {
+	claims, err := s.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	aud, _ := claims["aud"].(string)
+	if aud == "" || aud == challengeAudience || aud == ssoAudience {
+		return nil, errors.New("not an access token")
+	}
+	return accessClaims(claims)
+}
+
+// accessClaims converts verified claims to AccessClaims.
+func accessClaims(claims jwt.MapClaims) (*AccessClaims, error) {
+	sub, ok := claims["sub"].(string)
+	if !ok {
+		return nil, errors.New("missing sub")
+	}
+	out := &AccessClaims{Subject: sub, AMR: stringList(claims["amr"]), Roles: stringList(claims["roles"]), Raw: claims}
+	out.ID, _ = claims["jti"].(string)
+	out.SessionID, _ = claims["sid"].(string)
+	out.ClientID, _ = claims["client_id"].(string)
+	out.Scope, _ = claims["scope"].(string)
+	out.Audience, _ = claims["aud"].(string)
+	out.CitizenID, _ = claims["citizen_id"].(string)
+	out.OrgIDs = intList(claims["org_ids"])
+	out.RolesTruncated, _ = claims["roles_truncated"].(bool)
+	if act, ok := claims["act"].(map[string]any); ok {
+		out.Actor, _ = act["sub"].(string)
+	}
+	if cnf, ok := claims["cnf"].(map[string]any); ok {
+		out.JKT, _ = cnf["jkt"].(string)
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		out.IssuedAt = iat.Time
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		out.ExpiresAt = exp.Time
+	}
+	return out, nil
+}
+
+// RefreshClaims are the verified claims of a refresh token. Whether the
+// token is still usable is decided by its store record, not by the JWT.
+type RefreshClaims struct {
+	ID        string
+	Subject   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// ParseRefresh verifies a refresh token issued by Issue.
+func (s *Service) ParseRefresh(tokenStr string) (*RefreshClaims, error) {
+	claims, err := s.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	// access and challenge tokens carry an audience, refresh tokens do not
+	if _, ok := claims["aud"]; ok {
+		return nil, errors.New("not a refresh token")
+	}
+	sub, ok := claims["sub"].(string)
+	if !ok {
+		return nil, errors.New("missing sub")
+	}
+	out := &RefreshClaims{Subject: sub}
+	out.ID, _ = claims["jti"].(string)
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		out.IssuedAt = iat.Time
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		out.ExpiresAt = exp.Time
+	}
+	return out, nil
+}
+
+// IssueChallenge signs a short-lived token stating that the first step of
+// a login succeeded for userID. `purpose` (e.g. "mfa_required") is bound
+// into the token so a challenge cannot be used for another step, and amr
+// carries the methods already completed. Every challenge is unique, so it
+// can be tracked by its hash.
+func (s *Service) IssueChallenge(userID, purpose string, amr []string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": s.issuer,
 		"sub": userID,
+		"aud": challengeAudience,
+		"typ": purpose,
+		"amr": amr,
+		"jti": newID(),
 		"iat": now.Unix(),
-		"exp": now.Add(s.refreshTTL).Unix(),
+		"exp": now.Add(ttl).Unix(),
 	}
-	refresh := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshStr, err := refresh.SignedString(s.secret)
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+// ValidateChallenge verifies a challenge token of the given purpose and
+// returns its subject and the authentication methods already completed.
+func (s *Service) ValidateChallenge(tokenStr, purpose string) (string, []string, error) {
+	claims, err := s.parse(tokenStr, jwt.WithAudience(challengeAudience))
+	if err != nil {
+		return "", nil, err
+	}
+	if typ, _ := claims["typ"].(string); typ != purpose {
+		return "", nil, errors.New("unexpected challenge type")
+	}
+	sub, ok := claims["sub"].(string)
+	if !ok {
+		return "", nil, errors.New("missing sub")
+	}
+	return sub, stringList(claims["amr"]), nil
+}
+
+// SSOClaims are the verified claims of a single sign-on token.
+type SSOClaims struct {
+	Subject   string
+	SessionID string
+	AMR       []string
+	ExpiresAt time.Time
+}
+
+// IssueSSO signs the single sign-on token kept in a browser cookie by the
+// authorization endpoint. It names the login session so that signing out
+// of the session also ends single sign-on.
+func (s *Service) IssueSSO(userID, sessionID string, amr []string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": s.issuer,
+		"sub": userID,
+		"aud": ssoAudience,
+		"sid": sessionID,
+		"amr": amr,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+// ValidateSSO verifies a token issued by IssueSSO.
+func (s *Service) ValidateSSO(tokenStr string) (*SSOClaims, error) {
+	claims, err := s.parse(tokenStr, jwt.WithAudience(ssoAudience))
 	if err != nil {
 		return nil, err
 	}
-	return &Tokens{AccessToken: accessStr, RefreshToken: refreshStr, RefreshExpiry: now.Add(s.refreshTTL)}, nil
+	out := &SSOClaims{AMR: stringList(claims["amr"])}
+	out.Subject, _ = claims["sub"].(string)
+	out.SessionID, _ = claims["sid"].(string)
+	if out.Subject == "" || out.SessionID == "" {
+		return nil, errors.New("missing sub or sid")
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		out.ExpiresAt = exp.Time
+	}
+	return out, nil
 }
 
-// ValidateAccess verifies the access token and returns the subject (user ID).
-func (s *Service) ValidateAccess(tokenStr string) (string, error) {
+// parse verifies the signature and standard time claims of tokenStr.
+func (s *Service) parse(tokenStr string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
 		// ensure HMAC method
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
 		return s.secret, nil
-	})
+	}, opts...)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", errors.New("invalid claims")
+		return nil, errors.New("invalid claims")
 	}
-	sub, ok := claims["sub"].(string)
-	if !ok {
-		return "", errors.New("missing sub")
+	return claims, nil
+}
+
+// newID returns a random token identifier for the `jti` claim.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// intList converts a decoded JSON array claim of integers to []int.
+func intList(v any) []int {
+	switch vv := v.(type) {
+	case []int:
+		return vv
+	case []any:
+		out := make([]int, 0, len(vv))
+		for _, x := range vv {
+			if n, ok := x.(float64); ok && n == float64(int(n)) {
+				out = append(out, int(n))
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// stringList converts a decoded JSON array claim to []string.
+func stringList(v any) []string {
+	switch vv := v.(type) {
+	case []string:
+		return vv
+	case []any:
+		out := make([]string, 0, len(vv))
+		for _, x := range vv {
+			if str, ok := x.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out
+	case string:
+		return []string{vv}
 	}
-	return sub, nil
+	return nil
 }
@@ -0,0 +1,237 @@
+// Package ldapauth checks passwords against an LDAP directory such as
+// OpenLDAP or Active Directory by binding as the user, and reads the
+// user's email and groups.
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrInvalidCredentials is returned for unknown users and wrong passwords
+// alike.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Config describes an LDAP directory. Users are found either by a search
+// with the service account BindDN (search-then-bind), or, without BindDN,
+// by formatting UserDN with the username, e.g.
+// "uid=%s,ou=people,dc=corp,dc=example", or "%s@corp.example" for Active
+// Directory together with BaseDN to find the entry after the bind.
+type Config struct {
+	// URL is ldap://host:389 or ldaps://host:636.
+	URL      string `json:"url"`
+	StartTLS bool   `json:"start_tls"`
+	// InsecureSkipVerify disables certificate checks; for test setups only.
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password"`
+	UserDN       string `json:"user_dn"`
+
+	BaseDN string `json:"base_dn"`
+	// UserFilter finds the user's entry; %s is replaced by the escaped
+	// username. Defaults to (uid=%s).
+	UserFilter string `json:"user_filter"`
+	// EmailAttr and GroupAttr default to mail and memberOf.
+	EmailAttr string `json:"email_attr"`
+	GroupAttr string `json:"group_attr"`
+	// GroupRoles maps groups, by DN or common name, to roles.
+	GroupRoles map[string]string `json:"group_roles"`
+
+	// TimeoutSeconds bounds connecting and each operation. Defaults to 5.
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// Entry is an authenticated directory user.
+type Entry struct {
+	DN     string
+	Email  string
+	Groups []string
+	// Roles are the roles mapped from Groups.
+	Roles []string
+}
+
+// Authenticator authenticates users against one directory. A new
+// connection is used for every login.
+type Authenticator struct {
+	cfg     Config
+	timeout time.Duration
+}
+
+// New constructs an Authenticator, filling in defaults.
+func New(cfg Config) (*Authenticator, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("ldap: url is required")
+	}
+	if cfg.BindDN == "" && cfg.UserDN == "" {
+		return nil, errors.New("ldap: bind_dn or user_dn is required")
+	}
+	if cfg.BindDN != "" && cfg.BaseDN == "" {
+		return nil, errors.New("ldap: base_dn is required for search")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.EmailAttr == "" {
+		cfg.EmailAttr = "mail"
+	}
+	if cfg.GroupAttr == "" {
+		cfg.GroupAttr = "memberOf"
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 5
+	}
+	return &Authenticator{cfg: cfg, timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}, nil
+}
+
+// MappedRoles returns all roles the group mapping can grant. The directory
+// is authoritative for them: they are revoked when the user leaves the
+// group.
+func (a *Authenticator) MappedRoles() []string {
+	roles := make([]string, 0, len(a.cfg.GroupRoles))
+	for _, r := range a.cfg.GroupRoles {
+		roles = append(roles, r)
+	}
+	return roles
+}
+
+// Authenticate binds as the user with password and returns the user's
+// entry. Empty passwords are rejected: most servers treat them as an
+// anonymous bind that always succeeds.
+func (a *Authenticator) Authenticate(username, password string) (*Entry, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var entry *ldap.Entry
+	userDN := fmt.Sprintf(a.cfg.UserDN, ldap.EscapeDN(username))
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service bind: %w", err)
+		}
+		if entry, err = a.findUser(conn, username); err != nil {
+			return nil, err
+		}
+		userDN = entry.DN
+	}
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: bind: %w", err)
+	}
+	if entry == nil {
+		// read the entry with the user's own rights
+		if a.cfg.BaseDN != "" {
+			entry, err = a.findUser(conn, username)
+		} else {
+			entry, err = a.readEntry(conn, userDN)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a.entry(entry), nil
+}
+
+func (a *Authenticator) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.cfg.InsecureSkipVerify}
+	if u, err := url.Parse(a.cfg.URL); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: dial: %w", err)
+	}
+	conn.SetTimeout(a.timeout)
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// findUser returns the single entry matching the user filter.
+func (a *Authenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, a.cfg.TimeoutSeconds, false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.cfg.EmailAttr, a.cfg.GroupAttr}, nil)
+	res, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap: search: %w", err)
+	}
+	if res == nil || len(res.Entries) != 1 {
+		// unknown or ambiguous
+		return nil, ErrInvalidCredentials
+	}
+	return res.Entries[0], nil
+}
+
+// readEntry reads the entry at dn.
+func (a *Authenticator) readEntry(conn *ldap.Conn, dn string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, a.cfg.TimeoutSeconds, false,
+		"(objectClass=*)", []string{a.cfg.EmailAttr, a.cfg.GroupAttr}, nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: read entry: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return res.Entries[0], nil
+}
+
+// entry converts a directory entry and maps its groups to roles.
+func (a *Authenticator) entry(e *ldap.Entry) *Entry {
+	out := &Entry{
+		DN:     e.DN,
+		Email:  strings.ToLower(strings.TrimSpace(e.GetAttributeValue(a.cfg.EmailAttr))),
+		Groups: e.GetAttributeValues(a.cfg.GroupAttr),
+	}
+	seen := make(map[string]bool)
+	for _, g := range out.Groups {
+		role, ok := a.groupRole(g)
+		if ok && !seen[role] {
+			seen[role] = true
+			out.Roles = append(out.Roles, role)
+		}
+	}
+	return out
+}
+
+// groupRole looks a group up in the mapping by its DN, then by its first
+// RDN value (the common name).
+func (a *Authenticator) groupRole(group string) (string, bool) {
+	for k, role := range a.cfg.GroupRoles {
+		if strings.EqualFold(k, group) {
+			return role, true
+		}
+	}
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return "", false
+	}
+	cn := dn.RDNs[0].Attributes[0].Value
+	for k, role := range a.cfg.GroupRoles {
+		if strings.EqualFold(k, cn) {
+			return role, true
+		}
+	}
+	return "", false
+}
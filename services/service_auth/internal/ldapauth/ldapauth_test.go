@@ -0,0 +1,171 @@
+package ldapauth
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"auth_project/internal/ldapauth/ldaptest"
+)
+
+const (
+	aliceDN = "uid=alice,ou=people,dc=corp,dc=example"
+	bobDN   = "uid=bob,ou=people,dc=corp,dc=example"
+	svcDN   = "cn=svc,dc=corp,dc=example"
+)
+
+func newDirectory(t *testing.T) *ldaptest.Server {
+	t.Helper()
+	dir := ldaptest.NewServer(t,
+		ldaptest.User{UID: "alice", DN: aliceDN, Password: "alice-pw", Mail: "Alice@Corp.Example",
+			Groups: []string{"cn=eng,ou=groups,dc=corp,dc=example"}},
+		ldaptest.User{UID: "bob", DN: bobDN, Password: "bob-pw",
+			Groups: []string{"cn=admins,ou=groups,dc=corp,dc=example", "cn=eng,ou=groups,dc=corp,dc=example"}},
+	)
+	dir.AddServiceAccount(svcDN, "svc-pw")
+	return dir
+}
+
+// configs are the two ways of finding users: binding with a DN built
+// from the username, and search-then-bind with a service account.
+func configs(dir *ldaptest.Server) map[string]Config {
+	groups := map[string]string{"admins": "admin", "cn=eng,ou=groups,dc=corp,dc=example": "engineer"}
+	return map[string]Config{
+		"direct bind": {
+			URL:        dir.URL,
+			UserDN:     "uid=%s,ou=people,dc=corp,dc=example",
+			GroupRoles: groups,
+		},
+		"search then bind": {
+			URL:          dir.URL,
+			BindDN:       svcDN,
+			BindPassword: "svc-pw",
+			BaseDN:       "dc=corp,dc=example",
+			GroupRoles:   groups,
+		},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for name, cfg := range configs(newDirectory(t)) {
+		t.Run(name, func(t *testing.T) {
+			a, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry, err := a.Authenticate("alice", "alice-pw")
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if entry.DN != aliceDN || entry.Email != "alice@corp.example" {
+				t.Errorf("entry = %+v", entry)
+			}
+			if !slices.Equal(entry.Roles, []string{"engineer"}) {
+				t.Errorf("roles = %v, want [engineer]", entry.Roles)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWrongPassword(t *testing.T) {
+	for name, cfg := range configs(newDirectory(t)) {
+		t.Run(name, func(t *testing.T) {
+			a, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.Authenticate("alice", "bob-pw"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Authenticate error = %v, want ErrInvalidCredentials", err)
+			}
+			if _, err := a.Authenticate("nobody", "alice-pw"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Authenticate of an unknown user: error = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+// TestAuthenticateEmptyPassword checks that an empty password is rejected
+// before it reaches the directory, which would accept it as an
+// unauthenticated bind.
+func TestAuthenticateEmptyPassword(t *testing.T) {
+	dir := newDirectory(t)
+	for name, cfg := range configs(dir) {
+		t.Run(name, func(t *testing.T) {
+			a, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.Authenticate("alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Authenticate error = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+	if binds := dir.Binds(); len(binds) != 0 {
+		t.Fatalf("directory received binds %q", binds)
+	}
+}
+
+func TestAuthenticateEscapesUsername(t *testing.T) {
+	dir := newDirectory(t)
+	cfgs := configs(dir)
+
+	// a username naming another entry must stay a single RDN value
+	direct, err := New(cfgs["direct bind"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := direct.Authenticate("bob,ou=people,dc=corp,dc=example", "bob-pw"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate error = %v, want ErrInvalidCredentials", err)
+	}
+	want := `uid=bob\,ou=people\,dc=corp\,dc=example,ou=people,dc=corp,dc=example`
+	if binds := dir.Binds(); len(binds) != 1 || binds[0] != want {
+		t.Fatalf("binds = %q, want [%q]", binds, want)
+	}
+
+	// filter metacharacters must not turn the equality filter into a
+	// substring or presence filter
+	search, err := New(cfgs["search then bind"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := search.Authenticate("ali*", "alice-pw"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate error = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := search.Authenticate("*)(uid=alice", "alice-pw"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate error = %v, want ErrInvalidCredentials", err)
+	}
+	if filters := dir.Filters(); !slices.Equal(filters, []string{"uid=ali*", "uid=*)(uid=alice"}) {
+		t.Fatalf("filters = %q", filters)
+	}
+}
+
+func TestAuthenticateGroupRoles(t *testing.T) {
+	dir := newDirectory(t)
+	a, err := New(configs(dir)["search then bind"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped := a.MappedRoles()
+	slices.Sort(mapped)
+	if !slices.Equal(mapped, []string{"admin", "engineer"}) {
+		t.Errorf("MappedRoles = %v", mapped)
+	}
+
+	// groups are mapped by common name and by DN
+	entry, err := a.Authenticate("bob", "bob-pw")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !slices.Equal(entry.Roles, []string{"admin", "engineer"}) {
+		t.Errorf("roles = %v, want [admin engineer]", entry.Roles)
+	}
+
+	// the roles follow the groups on the next login
+	dir.SetGroups(bobDN, "cn=eng,ou=groups,dc=corp,dc=example", "cn=unmapped,ou=groups,dc=corp,dc=example")
+	if entry, err = a.Authenticate("bob", "bob-pw"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !slices.Equal(entry.Roles, []string{"engineer"}) {
+		t.Errorf("roles after leaving admins = %v, want [engineer]", entry.Roles)
+	}
+}
@@ -0,0 +1,220 @@
+// Package ldaptest provides an in-process LDAP server for tests. It
+// answers simple binds and searches by base DN or by an equality filter,
+// and records the binds and filters it receives. Like many real servers it
+// treats a bind with an empty password as a successful unauthenticated
+// bind, and lets anyone read entries.
+package ldaptest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAP operations and result codes used by the server.
+const (
+	opBindRequest    = 0
+	opBindResponse   = 1
+	opUnbindRequest  = 2
+	opSearchRequest  = 3
+	opSearchEntry    = 4
+	opSearchDone     = 5
+	filterEquality   = 3
+	resultSuccess    = 0
+	resultInvalid    = 49
+	resultNotAllowed = 53
+)
+
+// User is a directory entry. UID is matched by (uid=...) filters.
+type User struct {
+	UID      string
+	DN       string
+	Password string
+	Mail     string
+	Groups   []string
+}
+
+// Server is a running fake directory.
+type Server struct {
+	// URL is the ldap:// URL of the server.
+	URL string
+
+	mu      sync.Mutex
+	users   map[string]*User // lowercased DN -> user
+	accts   map[string]string
+	binds   []string
+	filters []string
+}
+
+// NewServer starts a directory with users that is stopped when the test
+// ends.
+func NewServer(t testing.TB, users ...User) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{URL: "ldap://" + ln.Addr().String(), users: make(map[string]*User), accts: make(map[string]string)}
+	for _, u := range users {
+		s.users[strings.ToLower(u.DN)] = &u
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// AddServiceAccount lets dn bind with password without being a user.
+func (s *Server) AddServiceAccount(dn, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accts[strings.ToLower(dn)] = password
+}
+
+// SetGroups replaces the groups of the user with dn.
+func (s *Server) SetGroups(dn string, groups ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[strings.ToLower(dn)].Groups = groups
+}
+
+// Binds returns the DNs of all bind requests received.
+func (s *Server) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+// Filters returns the search filters received, as attr=value for equality
+// filters and "unsupported" for others.
+func (s *Server) Filters() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.filters...)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		p, err := ber.ReadPacket(r)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id, _ := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		switch op.Tag {
+		case opBindRequest:
+			conn.Write(message(id, result(opBindResponse, s.bind(op))).Bytes())
+		case opSearchRequest:
+			for _, u := range s.search(op) {
+				conn.Write(message(id, entry(u)).Bytes())
+			}
+			conn.Write(message(id, result(opSearchDone, resultSuccess)).Bytes())
+		case opUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (s *Server) bind(op *ber.Packet) int64 {
+	if len(op.Children) < 3 {
+		return resultNotAllowed
+	}
+	dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.binds = append(s.binds, dn)
+	if password == "" {
+		// unauthenticated bind (RFC 4513, section 5.1.2)
+		return resultSuccess
+	}
+	if pw, ok := s.accts[strings.ToLower(dn)]; ok && pw == password {
+		return resultSuccess
+	}
+	if u, ok := s.users[strings.ToLower(dn)]; ok && u.Password == password {
+		return resultSuccess
+	}
+	return resultInvalid
+}
+
+func (s *Server) search(op *ber.Packet) []User {
+	if len(op.Children) < 7 {
+		return nil
+	}
+	base := strings.ToLower(op.Children[0].Data.String())
+	scope, _ := op.Children[1].Value.(int64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if scope == 0 {
+		if u, ok := s.users[base]; ok {
+			return []User{*u}
+		}
+		return nil
+	}
+	f := op.Children[6]
+	if f.Tag != filterEquality || len(f.Children) != 2 {
+		s.filters = append(s.filters, "unsupported")
+		return nil
+	}
+	attr, value := f.Children[0].Data.String(), f.Children[1].Data.String()
+	s.filters = append(s.filters, attr+"="+value)
+	var out []User
+	for dn, u := range s.users {
+		if strings.EqualFold(attr, "uid") && strings.EqualFold(u.UID, value) && strings.HasSuffix(dn, base) {
+			out = append(out, *u)
+		}
+	}
+	return out
+}
+
+func message(id int64, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAPMessage")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "messageID"))
+	p.AppendChild(op)
+	return p
+}
+
+func result(tag ber.Tag, code int64) *ber.Packet {
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "result")
+	r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, fmt.Sprint("code ", code), "diagnosticMessage"))
+	return r
+}
+
+func entry(u User) *ber.Packet {
+	e := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchEntry, nil, "SearchResultEntry")
+	e.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, u.DN, "objectName"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	add := func(name string, values []string) {
+		a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		a.AppendChild(set)
+		attrs.AppendChild(a)
+	}
+	if u.Mail != "" {
+		add("mail", []string{u.Mail})
+	}
+	add("memberOf", u.Groups)
+	e.AppendChild(attrs)
+	return e
+}
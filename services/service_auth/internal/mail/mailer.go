@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+)
+
+// Message is a plain-text email addressed to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outgoing emails (confirmations, notifications, one-time
+// codes). Like event.Publisher it hides the concrete transport so the auth
+// service can run without an SMTP relay in development.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// StdoutMailer logs messages instead of sending them. It is the default
+// when no SMTP relay is configured.
+type StdoutMailer struct{}
+
+func (StdoutMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("MAIL to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// SMTPMailer sends messages through an SMTP relay using net/smtp.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer constructs an SMTPMailer. `addr` is host:port of the relay;
+// when `user` is empty no authentication is performed.
+func NewSMTPMailer(addr, from, user, pass string) *SMTPMailer {
+	var auth smtp.Auth
+	if user != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		auth = smtp.PlainAuth("", user, pass, host)
+	}
+	return &SMTPMailer{addr: addr, from: from, auth: auth}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(b.String())); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Condition is a compiled rule condition, e.g.
+//
+//	"editor" in subject.roles and resource.attributes.org_id in subject.org_ids
+//
+// Operands are attribute paths into the request (subject, action,
+// resource, context) or literals: strings, numbers, true, false, null and
+// lists such as ["draft", "review"]. Operators are eq, ne, gt, ge, lt, le,
+// in, contains, sw (starts with), pr (present), and, or and not(...).
+type Condition interface {
+	eval(in map[string]any) bool
+}
+
+// operand is a path or a literal; value resolves it against the request.
+type operand interface {
+	value(in map[string]any) any
+}
+
+type literal struct{ v any }
+
+func (l literal) value(map[string]any) any { return l.v }
+
+// path is a dotted attribute path, e.g. resource.attributes.org_id.
+type path []string
+
+func (p path) value(in map[string]any) any {
+	var cur any = in
+	for _, name := range p {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[name]
+	}
+	return cur
+}
+
+var pathPattern = regexp.MustCompile(`^(subject|action|resource|context)(\.[A-Za-z_][A-Za-z0-9_-]*)*$`)
+
+type andCond struct{ left, right Condition }
+
+func (c andCond) eval(in map[string]any) bool { return c.left.eval(in) && c.right.eval(in) }
+
+type orCond struct{ left, right Condition }
+
+func (c orCond) eval(in map[string]any) bool { return c.left.eval(in) || c.right.eval(in) }
+
+type notCond struct{ c Condition }
+
+func (c notCond) eval(in map[string]any) bool { return !c.c.eval(in) }
+
+// trueCond is the condition of rules without one.
+type trueCond struct{}
+
+func (trueCond) eval(map[string]any) bool { return true }
+
+type presentCond struct{ x operand }
+
+func (c presentCond) eval(in map[string]any) bool {
+	switch v := c.x.value(in).(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	}
+	return true
+}
+
+type compareCond struct {
+	left  operand
+	op    string
+	right operand
+}
+
+func (c compareCond) eval(in map[string]any) bool {
+	l, r := c.left.value(in), c.right.value(in)
+	switch c.op {
+	case "eq":
+		return equal(l, r)
+	case "ne":
+		return !equal(l, r)
+	case "in":
+		return member(l, r)
+	case "contains":
+		if s, ok := l.(string); ok {
+			sub, ok := r.(string)
+			return ok && strings.Contains(s, sub)
+		}
+		return member(r, l)
+	case "sw":
+		s, ok1 := l.(string)
+		prefix, ok2 := r.(string)
+		return ok1 && ok2 && strings.HasPrefix(s, prefix)
+	}
+	return order(l, c.op, r)
+}
+
+// equal compares scalars; numbers are float64 after JSON decoding. A
+// string holding a number equals that number, as resource ids are strings
+// and the org_ids of subjects numbers.
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case string:
+		if n, ok := b.(float64); ok {
+			return numeric(a, n)
+		}
+		return a == b
+	case float64:
+		if s, ok := b.(string); ok {
+			return numeric(s, a)
+		}
+		return a == b
+	case bool:
+		return a == b
+	case nil:
+		return b == nil
+	}
+	return false
+}
+
+// numeric reports whether s holds the number n.
+func numeric(s string, n float64) bool {
+	v, err := strconv.ParseFloat(s, 64)
+	return err == nil && v == n
+}
+
+// member reports whether v, or any element of v if it is a list, is an
+// element of the list set.
+func member(v, set any) bool {
+	list, ok := set.([]any)
+	if !ok {
+		return false
+	}
+	if vs, ok := v.([]any); ok {
+		for _, e := range vs {
+			if member(e, list) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, e := range list {
+		if equal(v, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// order applies gt, ge, lt or le to two numbers or two strings.
+func order(a any, op string, b any) bool {
+	var cmp int
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(a, b)
+	default:
+		return false
+	}
+	switch op {
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	}
+	return false
+}
+
+var compareOps = map[string]bool{"eq": true, "ne": true, "gt": true, "ge": true, "lt": true, "le": true, "in": true, "contains": true, "sw": true}
+
+// ParseCondition compiles a condition; an empty string always holds.
+func ParseCondition(s string) (Condition, error) {
+	if strings.TrimSpace(s) == "" {
+		return trueCond{}, nil
+	}
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	c, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	return c, nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func lex(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '(':
+			toks = append(toks, token{tokLParen, "("})
+			i++
+		case ')':
+			toks = append(toks, token{tokRParen, ")"})
+			i++
+		case '[':
+			toks = append(toks, token{tokLBracket, "["})
+			i++
+		case ']':
+			toks = append(toks, token{tokRBracket, "]"})
+			i++
+		case ',':
+			toks = append(toks, token{tokComma, ","})
+			i++
+		case '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			var str string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &str); err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[i:j+1])
+			}
+			toks = append(toks, token{tokString, str})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(s) && !strings.ContainsRune(" \t\n\r()[],\"", rune(s[j])); j++ {
+			}
+			toks = append(toks, token{tokWord, s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.toks) }
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: -1}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword reports whether the next token is the keyword kw and consumes
+// it.
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, what string) error {
+	if p.next().kind != kind {
+		return fmt.Errorf("expected %s", what)
+	}
+	return nil
+}
+
+func (p *parser) or() (Condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Condition, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (Condition, error) {
+	if !p.keyword("not") {
+		return p.atom()
+	}
+	if err := p.expect(tokLParen, "( after not"); err != nil {
+		return nil, err
+	}
+	c, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+	return notCond{c}, nil
+}
+
+func (p *parser) atom() (Condition, error) {
+	if p.peek().kind == tokLParen {
+		p.pos++
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	opTok := p.next()
+	op := strings.ToLower(opTok.text)
+	if opTok.kind != tokWord || (op != "pr" && !compareOps[op]) {
+		return nil, fmt.Errorf("expected an operator, got %q", opTok.text)
+	}
+	if op == "pr" {
+		return presentCond{left}, nil
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return compareCond{left, op, right}, nil
+}
+
+// operand parses a path, a scalar literal or a list of scalar literals.
+func (p *parser) operand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return literal{t.text}, nil
+	case tokLBracket:
+		list := []any{}
+		for p.peek().kind != tokRBracket {
+			if len(list) > 0 {
+				if err := p.expect(tokComma, ", between list values"); err != nil {
+					return nil, err
+				}
+			}
+			v, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			lit, ok := v.(literal)
+			if !ok {
+				return nil, fmt.Errorf("lists may only hold literals")
+			}
+			list = append(list, lit.v)
+		}
+		p.pos++
+		return literal{list}, nil
+	case tokWord:
+	default:
+		return nil, fmt.Errorf("expected a value or an attribute")
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return literal{true}, nil
+	case "false":
+		return literal{false}, nil
+	case "null":
+		return literal{nil}, nil
+	}
+	if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+		return literal{n}, nil
+	}
+	if !pathPattern.MatchString(t.text) {
+		return nil, fmt.Errorf("invalid attribute %q", t.text)
+	}
+	return path(strings.Split(t.text, ".")), nil
+}
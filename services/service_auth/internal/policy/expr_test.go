@@ -0,0 +1,106 @@
+package policy
+
+import "testing"
+
+// input is a request as conditions see it, see requestInput.
+func input() map[string]any {
+	return requestInput(Request{
+		Subject: map[string]any{
+			"id":      "u-1",
+			"roles":   []string{"editor", "viewer"},
+			"org_ids": []int{7, 12},
+			"level":   3,
+			"active":  true,
+		},
+		Action: "organization.update",
+		Resource: Resource{
+			Type:       "organization",
+			ID:         "12",
+			Attributes: map[string]any{"org_id": 7, "status": "draft", "name": "Acme Corp"},
+		},
+		Context: map[string]any{"ip": "10.0.0.1"},
+	})
+}
+
+func TestCondition(t *testing.T) {
+	tests := []struct {
+		cond string
+		want bool
+	}{
+		{``, true},
+		{`subject.id eq "u-1"`, true},
+		{`subject.id ne "u-1"`, false},
+		{`"editor" in subject.roles`, true},
+		{`"admin" in subject.roles`, false},
+		{`subject.roles contains "viewer"`, true},
+		{`subject.roles in ["admin", "viewer"]`, true},
+		{`resource.attributes.org_id in subject.org_ids`, true},
+		{`resource.attributes.status in ["draft", "review"]`, true},
+		{`resource.attributes.name contains "Corp"`, true},
+		{`resource.attributes.name sw "Acme"`, true},
+		{`resource.attributes.name sw "Corp"`, false},
+		{`subject.level ge 3`, true},
+		{`subject.level gt 3`, false},
+		{`subject.level lt 10`, true},
+		{`resource.attributes.status lt "review"`, true},
+		{`subject.active eq true`, true},
+		{`context.ip pr`, true},
+		{`context.country pr`, false},
+		{`context.country eq null`, true},
+		{`resource.attributes.missing.deeper eq null`, true},
+		{`not (subject.active eq true)`, false},
+		{`action EQ "organization.update"`, true},
+		// numeric strings equal numbers
+		{`resource.id in subject.org_ids`, true},
+		{`resource.id eq 12`, true},
+		{`resource.id eq 12.0`, true},
+		{`resource.id eq 7`, false},
+		{`subject.org_ids contains resource.id`, true},
+		{`resource.attributes.org_id eq "7"`, true},
+		{`resource.attributes.status eq 0`, false},
+		{`subject.level ne "3"`, false},
+		// and binds tighter than or
+		{`subject.id eq "x" and subject.active eq true or "editor" in subject.roles`, true},
+		{`subject.id eq "x" and (subject.active eq true or "editor" in subject.roles)`, false},
+		{`"editor" in subject.roles or subject.id eq "x" and subject.active eq false`, true},
+		{`not (subject.id eq "x") and subject.active eq false or subject.level eq 3`, true},
+		{`not (subject.id eq "x" or subject.level eq 3)`, false},
+	}
+	in := input()
+	for _, tt := range tests {
+		t.Run(tt.cond, func(t *testing.T) {
+			c, err := ParseCondition(tt.cond)
+			if err != nil {
+				t.Fatalf("ParseCondition: %v", err)
+			}
+			if got := c.eval(in); got != tt.want {
+				t.Fatalf("eval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	tests := []string{
+		`subject.id`,
+		`subject.id eq`,
+		`subject.id like "a"`,
+		`user.id eq "a"`,
+		`subject..id eq "a"`,
+		`subject.id eq "a`,
+		`(subject.id eq "a"`,
+		`subject.id eq "a")`,
+		`subject.id eq "a" and`,
+		`not subject.id eq "a"`,
+		`subject.id in ["a" "b"]`,
+		`subject.id in ["a", subject.roles]`,
+		`subject.id in ["a"`,
+	}
+	for _, cond := range tests {
+		t.Run(cond, func(t *testing.T) {
+			if c, err := ParseCondition(cond); err == nil {
+				t.Fatalf("ParseCondition = %v, want an error", c)
+			}
+		})
+	}
+}
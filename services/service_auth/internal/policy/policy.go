@@ -0,0 +1,174 @@
+// Package policy implements the authorization policies evaluated by
+// POST /authz/check: declarative allow and deny rules matched against a
+// subject, an action and a resource.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Effects of a rule.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Document is a policy as written by administrators and stored as JSON.
+type Document struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule grants or denies actions on resources of the given types when its
+// condition holds. Actions and resource types may end with "*" to match
+// any suffix, e.g. "organization.*"; a lone "*" matches everything.
+type Rule struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description,omitempty"`
+	Effect      string   `json:"effect"`
+	Actions     []string `json:"actions"`
+	Resources   []string `json:"resources"`
+	Condition   string   `json:"condition,omitempty"`
+}
+
+var ruleIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,99}$`)
+
+// Policy is a compiled Document.
+type Policy struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	Rule
+	cond Condition
+}
+
+// Compile checks the document and compiles the rule conditions.
+func Compile(doc Document) (*Policy, error) {
+	p := &Policy{rules: make([]compiledRule, 0, len(doc.Rules))}
+	seen := make(map[string]bool)
+	for i, r := range doc.Rules {
+		if !ruleIDPattern.MatchString(r.ID) {
+			return nil, fmt.Errorf("rule %d: invalid id %q", i+1, r.ID)
+		}
+		if seen[r.ID] {
+			return nil, fmt.Errorf("rule %s: duplicate id", r.ID)
+		}
+		seen[r.ID] = true
+		if r.Effect != EffectAllow && r.Effect != EffectDeny {
+			return nil, fmt.Errorf("rule %s: effect must be allow or deny", r.ID)
+		}
+		if len(r.Actions) == 0 || len(r.Resources) == 0 {
+			return nil, fmt.Errorf("rule %s: actions and resources are required", r.ID)
+		}
+		for _, pattern := range append(append([]string(nil), r.Actions...), r.Resources...) {
+			if pattern == "" || strings.Contains(strings.TrimSuffix(pattern, "*"), "*") {
+				return nil, fmt.Errorf("rule %s: invalid pattern %q", r.ID, pattern)
+			}
+		}
+		cond, err := ParseCondition(r.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: condition: %w", r.ID, err)
+		}
+		p.rules = append(p.rules, compiledRule{Rule: r, cond: cond})
+	}
+	return p, nil
+}
+
+// Request is an authorization question: may Subject perform Action on
+// Resource? Context holds further attributes, such as the client's IP.
+type Request struct {
+	Subject  map[string]any `json:"subject"`
+	Action   string         `json:"action"`
+	Resource Resource       `json:"resource"`
+	Context  map[string]any `json:"context,omitempty"`
+}
+
+// Resource is the object of a Request.
+type Resource struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// Decision is the answer to a Request. A matching deny rule wins over
+// allow rules; without a matching rule the request is denied.
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Rule is the id of the deciding rule; empty for the default deny.
+	Rule string `json:"rule,omitempty"`
+	// Matched lists all matching rules in policy order; Reason explains
+	// the decision. Both are only filled when explaining.
+	Matched []string `json:"matched,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+// Evaluate decides the request. With explain, all rules are evaluated and
+// the decision lists those that matched.
+func (p *Policy) Evaluate(req Request, explain bool) Decision {
+	in := requestInput(req)
+	var d Decision
+	var allowRule, denyRule string
+	for _, r := range p.rules {
+		if !matchAny(r.Actions, req.Action) || !matchAny(r.Resources, req.Resource.Type) || !r.cond.eval(in) {
+			continue
+		}
+		if explain {
+			d.Matched = append(d.Matched, r.ID)
+		}
+		if r.Effect == EffectDeny && denyRule == "" {
+			denyRule = r.ID
+			if !explain {
+				break
+			}
+		}
+		if r.Effect == EffectAllow && allowRule == "" {
+			allowRule = r.ID
+		}
+	}
+	switch {
+	case denyRule != "":
+		d.Rule = denyRule
+		if explain {
+			d.Reason = "denied by rule " + denyRule
+		}
+	case allowRule != "":
+		d.Allowed, d.Rule = true, allowRule
+		if explain {
+			d.Reason = "allowed by rule " + allowRule
+		}
+	case explain:
+		d.Reason = "no rule matched"
+	}
+	return d
+}
+
+// requestInput is the request as seen by conditions. It goes through JSON
+// so that all numbers are float64 and all lists []any.
+func requestInput(req Request) map[string]any {
+	var in map[string]any
+	data, err := json.Marshal(req)
+	if err == nil {
+		err = json.Unmarshal(data, &in)
+	}
+	if err != nil {
+		return map[string]any{}
+	}
+	return in
+}
+
+// matchAny reports whether s matches one of the patterns.
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(s, prefix) {
+				return true
+			}
+		} else if p == s {
+			return true
+		}
+	}
+	return false
+}
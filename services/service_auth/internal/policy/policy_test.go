@@ -0,0 +1,121 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	rule := func(mod func(*Rule)) Document {
+		r := Rule{ID: "r1", Effect: EffectAllow, Actions: []string{"*"}, Resources: []string{"*"}}
+		mod(&r)
+		return Document{Rules: []Rule{r}}
+	}
+	tests := []struct {
+		name string
+		doc  Document
+	}{
+		{"invalid id", rule(func(r *Rule) { r.ID = "-r" })},
+		{"empty id", rule(func(r *Rule) { r.ID = "" })},
+		{"duplicate id", Document{Rules: []Rule{
+			{ID: "r1", Effect: EffectAllow, Actions: []string{"*"}, Resources: []string{"*"}},
+			{ID: "r1", Effect: EffectDeny, Actions: []string{"*"}, Resources: []string{"*"}},
+		}}},
+		{"unknown effect", rule(func(r *Rule) { r.Effect = "permit" })},
+		{"no actions", rule(func(r *Rule) { r.Actions = nil })},
+		{"no resources", rule(func(r *Rule) { r.Resources = nil })},
+		{"empty pattern", rule(func(r *Rule) { r.Actions = []string{""} })},
+		{"inner wildcard", rule(func(r *Rule) { r.Resources = []string{"org*.x"} })},
+		{"invalid condition", rule(func(r *Rule) { r.Condition = `subject.id eq` })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p, err := Compile(tt.doc); err == nil {
+				t.Fatalf("Compile = %v, want an error", p)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := Compile(Document{Rules: []Rule{
+		{ID: "editors", Effect: EffectAllow, Actions: []string{"organization.*"}, Resources: []string{"organization"},
+			Condition: `"editor" in subject.roles and resource.id in subject.org_ids`},
+		{ID: "readers", Effect: EffectAllow, Actions: []string{"organization.read"}, Resources: []string{"*"}},
+		{ID: "archived", Effect: EffectDeny, Actions: []string{"*"}, Resources: []string{"organization"},
+			Condition: `resource.attributes.status eq "archived"`},
+		{ID: "no-delete", Effect: EffectDeny, Actions: []string{"organization.delete"}, Resources: []string{"organization"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor := map[string]any{"id": "u-1", "roles": []string{"editor"}, "org_ids": []int{7}}
+	tests := []struct {
+		name    string
+		req     Request
+		allowed bool
+		rule    string
+		matched []string
+		reason  string
+	}{
+		{
+			name:    "allowed",
+			req:     Request{Subject: editor, Action: "organization.update", Resource: Resource{Type: "organization", ID: "7"}},
+			allowed: true, rule: "editors",
+			matched: []string{"editors"}, reason: "allowed by rule editors",
+		},
+		{
+			name:    "first allow rule decides",
+			req:     Request{Subject: editor, Action: "organization.read", Resource: Resource{Type: "organization", ID: "7"}},
+			allowed: true, rule: "editors",
+			matched: []string{"editors", "readers"}, reason: "allowed by rule editors",
+		},
+		{
+			name: "deny overrides allow",
+			req: Request{Subject: editor, Action: "organization.read",
+				Resource: Resource{Type: "organization", ID: "7", Attributes: map[string]any{"status": "archived"}}},
+			rule:    "archived",
+			matched: []string{"editors", "readers", "archived"}, reason: "denied by rule archived",
+		},
+		{
+			name:    "first deny rule decides",
+			req:     Request{Subject: editor, Action: "organization.delete", Resource: Resource{Type: "organization", ID: "7", Attributes: map[string]any{"status": "archived"}}},
+			rule:    "archived",
+			matched: []string{"editors", "archived", "no-delete"}, reason: "denied by rule archived",
+		},
+		{
+			name:   "condition does not hold",
+			req:    Request{Subject: editor, Action: "organization.update", Resource: Resource{Type: "organization", ID: "8"}},
+			reason: "no rule matched",
+		},
+		{
+			name:   "resource type does not match",
+			req:    Request{Subject: editor, Action: "organization.update", Resource: Resource{Type: "citizen", ID: "7"}},
+			reason: "no rule matched",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Evaluate(tt.req, false)
+			if d.Allowed != tt.allowed || d.Rule != tt.rule || d.Matched != nil || d.Reason != "" {
+				t.Fatalf("Evaluate = %+v, want allowed %v by %q", d, tt.allowed, tt.rule)
+			}
+			d = p.Evaluate(tt.req, true)
+			if d.Allowed != tt.allowed || d.Rule != tt.rule || !slices.Equal(d.Matched, tt.matched) || d.Reason != tt.reason {
+				t.Fatalf("Evaluate with explain = %+v, want allowed %v by %q, matched %v, reason %q",
+					d, tt.allowed, tt.rule, tt.matched, tt.reason)
+			}
+		})
+	}
+}
+
+func TestEvaluateEmptyPolicy(t *testing.T) {
+	p, err := Compile(Document{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := p.Evaluate(Request{Action: "organization.read", Resource: Resource{Type: "organization"}}, true)
+	if d.Allowed || d.Rule != "" || d.Reason != "no rule matched" {
+		t.Fatalf("Evaluate = %+v, want the default deny", d)
+	}
+}
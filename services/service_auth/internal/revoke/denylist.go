@@ -0,0 +1,126 @@
+package revoke
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Store persists denylist entries so they survive restarts.
+type Store interface {
+	DenyJTI(ctx context.Context, jti string, expiresAt time.Time) error
+	DeniedJTIs(ctx context.Context) (map[string]time.Time, error)
+	PurgeExpiredTokens(ctx context.Context) error
+}
+
+// Listener is implemented by stores that can push entries denied by other
+// service instances (PgStore does this with LISTEN/NOTIFY).
+type Listener interface {
+	ListenJTIDenials(ctx context.Context, fn func(jti string, expiresAt time.Time)) error
+}
+
+// Denylist is an in-memory set of revoked access token IDs (`jti`). An
+// entry only has to live until the token would expire anyway, so the set
+// stays small. Lookups never touch the store, which keeps token
+// validation on the hot path cheap.
+type Denylist struct {
+	mu      sync.RWMutex
+	entries map[string]time.Time
+	store   Store
+}
+
+// NewDenylist constructs a Denylist backed by store.
+func NewDenylist(store Store) *Denylist {
+	return &Denylist{entries: make(map[string]time.Time), store: store}
+}
+
+// Load fills the in-memory set from the store.
+func (d *Denylist) Load(ctx context.Context) error {
+	entries, err := d.store.DeniedJTIs(ctx)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for jti, exp := range entries {
+		d.entries[jti] = exp
+	}
+	return nil
+}
+
+// Revoke denies jti until expiresAt and persists the entry.
+func (d *Denylist) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" || !expiresAt.After(time.Now()) {
+		return nil
+	}
+	d.add(jti, expiresAt)
+	return d.store.DenyJTI(ctx, jti, expiresAt)
+}
+
+// Contains reports whether jti is revoked.
+func (d *Denylist) Contains(jti string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	exp, ok := d.entries[jti]
+	return ok && exp.After(time.Now())
+}
+
+// Run purges expired entries every interval and, if the store supports
+// it, applies entries denied by other instances. It blocks until ctx is
+// done.
+func (d *Denylist) Run(ctx context.Context, interval time.Duration) {
+	if l, ok := d.store.(Listener); ok {
+		go d.listen(ctx, l)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.purge()
+			if err := d.store.PurgeExpiredTokens(ctx); err != nil {
+				log.Printf("denylist: purge: %v", err)
+			}
+		}
+	}
+}
+
+// listen keeps the store subscription alive, reconnecting on errors.
+func (d *Denylist) listen(ctx context.Context, l Listener) {
+	for ctx.Err() == nil {
+		err := l.ListenJTIDenials(ctx, d.add)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("denylist: listen: %v; retrying", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		// catch up on entries missed while disconnected
+		if err := d.Load(ctx); err != nil {
+			log.Printf("denylist: reload: %v", err)
+		}
+	}
+}
+
+func (d *Denylist) add(jti string, expiresAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[jti] = expiresAt
+}
+
+func (d *Denylist) purge() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for jti, exp := range d.entries {
+		if !exp.After(now) {
+			delete(d.entries, jti)
+		}
+	}
+}
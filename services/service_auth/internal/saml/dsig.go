@@ -0,0 +1,183 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// XML Signature namespaces and algorithms.
+const (
+	dsigNS         = "http://www.w3.org/2000/09/xmldsig#"
+	excC14NAlg     = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	envelopedAlg   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	inclusiveNSTag = "InclusiveNamespaces"
+)
+
+// signatureAlgs are the accepted SignatureMethod algorithms. SHA-1 is not
+// among them.
+var signatureAlgs = map[string]struct {
+	hash  crypto.Hash
+	ecdsa bool
+}{
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":   {crypto.SHA256, false},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512":   {crypto.SHA512, false},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256": {crypto.SHA256, true},
+}
+
+// digestAlgs are the accepted DigestMethod algorithms.
+var digestAlgs = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmlenc#sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmlenc#sha512": crypto.SHA512,
+}
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// verifySignature checks the enveloped signature of e, a child
+// ds:Signature whose single reference points at e by its ID. It returns
+// false without error if e is not signed. Only the given certificates are
+// trusted; certificates in KeyInfo are ignored.
+//
+// The digest is computed over e itself rather than over an element looked
+// up by the reference, so that the signed element is the one the caller
+// reads (no signature wrapping).
+func verifySignature(e *element, certs []*x509.Certificate) (bool, error) {
+	sigs := e.all(dsigNS, "Signature")
+	switch len(sigs) {
+	case 0:
+		return false, nil
+	case 1:
+	default:
+		return false, errors.New("more than one signature")
+	}
+	sig := sigs[0]
+	si := sig.child(dsigNS, "SignedInfo")
+	if si == nil {
+		return false, errors.New("signature without SignedInfo")
+	}
+	cm := si.child(dsigNS, "CanonicalizationMethod")
+	if cm == nil || cm.attr("Algorithm") != excC14NAlg {
+		return false, errors.New("unsupported canonicalization method")
+	}
+	sm := si.child(dsigNS, "SignatureMethod")
+	if sm == nil {
+		return false, errors.New("signature without SignatureMethod")
+	}
+	alg, ok := signatureAlgs[sm.attr("Algorithm")]
+	if !ok {
+		return false, fmt.Errorf("unsupported signature method %s", sm.attr("Algorithm"))
+	}
+	refs := si.all(dsigNS, "Reference")
+	if len(refs) != 1 {
+		return false, errors.New("signature must have exactly one reference")
+	}
+	ref := refs[0]
+	if id := e.attr("ID"); id == "" || ref.attr("URI") != "#"+id {
+		return false, errors.New("signature does not reference the signed element")
+	}
+
+	var refPrefixes []string
+	enveloped, exclusive := false, false
+	if ts := ref.child(dsigNS, "Transforms"); ts != nil {
+		for _, t := range ts.all(dsigNS, "Transform") {
+			switch t.attr("Algorithm") {
+			case envelopedAlg:
+				enveloped = true
+			case excC14NAlg:
+				exclusive = true
+				refPrefixes = inclusivePrefixes(t)
+			default:
+				return false, fmt.Errorf("unsupported transform %s", t.attr("Algorithm"))
+			}
+		}
+	}
+	if !enveloped || !exclusive {
+		return false, errors.New("reference must use the enveloped-signature and exclusive c14n transforms")
+	}
+	dm := ref.child(dsigNS, "DigestMethod")
+	if dm == nil {
+		return false, errors.New("reference without DigestMethod")
+	}
+	digestHash, ok := digestAlgs[dm.attr("Algorithm")]
+	if !ok {
+		return false, fmt.Errorf("unsupported digest method %s", dm.attr("Algorithm"))
+	}
+	dv := ref.child(dsigNS, "DigestValue")
+	if dv == nil {
+		return false, errors.New("reference without DigestValue")
+	}
+	want, err := decodeBase64(dv.text())
+	if err != nil {
+		return false, errors.New("malformed DigestValue")
+	}
+	h := digestHash.New()
+	h.Write(canonicalize(e, sig, refPrefixes))
+	if subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+		return false, errors.New("digest mismatch")
+	}
+
+	sv := sig.child(dsigNS, "SignatureValue")
+	if sv == nil {
+		return false, errors.New("signature without SignatureValue")
+	}
+	value, err := decodeBase64(sv.text())
+	if err != nil {
+		return false, errors.New("malformed SignatureValue")
+	}
+	h = alg.hash.New()
+	h.Write(canonicalize(si, nil, inclusivePrefixes(cm)))
+	sum := h.Sum(nil)
+	for _, cert := range certs {
+		if verifyWithKey(cert.PublicKey, alg.hash, alg.ecdsa, sum, value) {
+			return true, nil
+		}
+	}
+	return false, errInvalidSignature
+}
+
+func verifyWithKey(pub any, hash crypto.Hash, isECDSA bool, sum, sig []byte) bool {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return !isECDSA && rsa.VerifyPKCS1v15(key, hash, sum, sig) == nil
+	case *ecdsa.PublicKey:
+		// XML Signature encodes ECDSA signatures as r || s
+		if !isECDSA || len(sig)%2 != 0 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		return ecdsa.Verify(key, sum, r, s)
+	}
+	return false
+}
+
+// inclusivePrefixes returns the InclusiveNamespaces PrefixList of an
+// exclusive c14n transform or canonicalization method.
+func inclusivePrefixes(method *element) []string {
+	in := method.child(excC14NAlg, inclusiveNSTag)
+	if in == nil {
+		return nil
+	}
+	prefixes := strings.Fields(in.attr("PrefixList"))
+	for i, p := range prefixes {
+		if p == "#default" {
+			prefixes[i] = ""
+		}
+	}
+	return prefixes
+}
+
+// decodeBase64 decodes base64 content that may be wrapped across lines.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,407 @@
+// Package saml implements the service provider side of SAML 2.0 Web
+// Browser SSO: metadata, AuthnRequests sent with the HTTP-Redirect binding
+// and Responses received with the HTTP-POST binding, whose assertions must
+// be signed by a certificate of the identity provider's metadata.
+// Encrypted assertions are not supported.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SAML namespaces, bindings and identifiers.
+const (
+	protocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	assertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	metadataNS  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	redirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	postBinding     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	bearerMethod  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// NameIDEmail is the NameID format of email addresses.
+	NameIDEmail       = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	nameIDUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// clockSkew is tolerated between our clock and the identity provider's.
+const clockSkew = 2 * time.Minute
+
+// ErrInvalidResponse is wrapped by all errors of ParseResponse.
+var ErrInvalidResponse = errors.New("invalid SAML response")
+
+// IdentityProvider is the configuration of an identity provider taken
+// from its metadata.
+type IdentityProvider struct {
+	EntityID string
+	// SSOURL is the single sign-on endpoint of the HTTP-Redirect binding.
+	SSOURL       string
+	Certificates []*x509.Certificate
+}
+
+// NewIdentityProvider builds an IdentityProvider from stored values;
+// certs are base64 DER as in metadata.
+func NewIdentityProvider(entityID, ssoURL string, certs []string) (*IdentityProvider, error) {
+	idp := &IdentityProvider{EntityID: entityID, SSOURL: ssoURL}
+	for _, c := range certs {
+		der, err := decodeBase64(c)
+		if err != nil {
+			return nil, fmt.Errorf("saml: malformed certificate: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("saml: %w", err)
+		}
+		idp.Certificates = append(idp.Certificates, cert)
+	}
+	if len(idp.Certificates) == 0 {
+		return nil, errors.New("saml: no signing certificate")
+	}
+	return idp, nil
+}
+
+// EncodedCertificates returns the certificates as base64 DER.
+func (idp *IdentityProvider) EncodedCertificates() []string {
+	out := make([]string, 0, len(idp.Certificates))
+	for _, c := range idp.Certificates {
+		out = append(out, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+	return out
+}
+
+// ParseMetadata reads an identity provider's EntityDescriptor: its entity
+// id, HTTP-Redirect SSO endpoint and signing certificates.
+func ParseMetadata(data []byte) (*IdentityProvider, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, fmt.Errorf("saml: metadata: %w", err)
+	}
+	ed := root
+	if root.is(metadataNS, "EntitiesDescriptor") {
+		eds := root.all(metadataNS, "EntityDescriptor")
+		if len(eds) != 1 {
+			return nil, errors.New("saml: metadata must describe exactly one entity")
+		}
+		ed = eds[0]
+	}
+	if !ed.is(metadataNS, "EntityDescriptor") {
+		return nil, errors.New("saml: metadata is not an EntityDescriptor")
+	}
+	desc := ed.child(metadataNS, "IDPSSODescriptor")
+	if desc == nil {
+		return nil, errors.New("saml: metadata has no IDPSSODescriptor")
+	}
+	var ssoURL string
+	for _, sso := range desc.all(metadataNS, "SingleSignOnService") {
+		if sso.attr("Binding") == redirectBinding {
+			ssoURL = sso.attr("Location")
+			break
+		}
+	}
+	if u, err := url.Parse(ssoURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.New("saml: metadata has no HTTP-Redirect SingleSignOnService")
+	}
+	var certs []string
+	for _, kd := range desc.all(metadataNS, "KeyDescriptor") {
+		if use := kd.attr("use"); use != "" && use != "signing" {
+			continue
+		}
+		ki := kd.child(dsigNS, "KeyInfo")
+		if ki == nil {
+			continue
+		}
+		for _, data := range ki.all(dsigNS, "X509Data") {
+			for _, c := range data.all(dsigNS, "X509Certificate") {
+				certs = append(certs, c.text())
+			}
+		}
+	}
+	entityID := ed.attr("entityID")
+	if entityID == "" {
+		return nil, errors.New("saml: metadata has no entityID")
+	}
+	return NewIdentityProvider(entityID, ssoURL, certs)
+}
+
+// ServiceProvider is this service as a SAML service provider.
+type ServiceProvider struct {
+	EntityID string
+	// ACSURL is the assertion consumer service, receiving responses with
+	// the HTTP-POST binding.
+	ACSURL string
+}
+
+// Metadata returns the SP metadata to register at identity providers.
+func (sp *ServiceProvider) Metadata() []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<md:EntityDescriptor xmlns:md="%s" entityID="%s">`, metadataNS, escape(sp.EntityID))
+	fmt.Fprintf(&b, `<md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="%s">`, protocolNS)
+	fmt.Fprintf(&b, `<md:NameIDFormat>%s</md:NameIDFormat>`, NameIDEmail)
+	fmt.Fprintf(&b, `<md:NameIDFormat>%s</md:NameIDFormat>`, "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent")
+	fmt.Fprintf(&b, `<md:AssertionConsumerService Binding="%s" Location="%s" index="0" isDefault="true"/>`, postBinding, escape(sp.ACSURL))
+	b.WriteString(`</md:SPSSODescriptor></md:EntityDescriptor>`)
+	return b.Bytes()
+}
+
+// NewRequestID returns a random AuthnRequest ID.
+func NewRequestID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand: " + err.Error())
+	}
+	// IDs are xs:ID and must not start with a digit
+	return "_" + hex.EncodeToString(b)
+}
+
+// AuthnRequestURL returns the URL that sends the browser to the identity
+// provider with an AuthnRequest (HTTP-Redirect binding).
+func (sp *ServiceProvider) AuthnRequestURL(idp *IdentityProvider, requestID, relayState string, now time.Time) (string, error) {
+	var req bytes.Buffer
+	fmt.Fprintf(&req, `<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`,
+		protocolNS, assertionNS, escape(requestID), now.UTC().Format(time.RFC3339), escape(idp.SSOURL), escape(sp.ACSURL), postBinding)
+	fmt.Fprintf(&req, `<saml:Issuer>%s</saml:Issuer>`, escape(sp.EntityID))
+	fmt.Fprintf(&req, `<samlp:NameIDPolicy Format="%s" AllowCreate="true"/>`, nameIDUnspecified)
+	req.WriteString(`</samlp:AuthnRequest>`)
+
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(req.Bytes()); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+	sep := "?"
+	if strings.Contains(idp.SSOURL, "?") {
+		sep = "&"
+	}
+	return idp.SSOURL + sep + q.Encode(), nil
+}
+
+// Assertion is the verified content of a response.
+type Assertion struct {
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+	// InResponseTo is the ID of the AuthnRequest answered; the caller must
+	// check it belongs to a pending request.
+	InResponseTo string
+	// Attributes are keyed by Name and, if present, FriendlyName.
+	Attributes map[string][]string
+}
+
+// ParseResponse verifies a base64 encoded Response received with the
+// HTTP-POST binding and returns its assertion. The response or the
+// assertion must be signed by the identity provider; the assertion must be
+// addressed to us, currently valid and a bearer confirmation answering a
+// request.
+func (sp *ServiceProvider) ParseResponse(idp *IdentityProvider, encoded string, now time.Time) (*Assertion, error) {
+	a, err := sp.parseResponse(idp, encoded, now)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	return a, nil
+}
+
+func (sp *ServiceProvider) parseResponse(idp *IdentityProvider, encoded string, now time.Time) (*Assertion, error) {
+	data, err := decodeBase64(encoded)
+	if err != nil {
+		return nil, errors.New("malformed base64")
+	}
+	resp, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.is(protocolNS, "Response") {
+		return nil, errors.New("not a Response")
+	}
+	if err := uniqueIDs(resp, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	responseSigned, err := verifySignature(resp, idp.Certificates)
+	if err != nil {
+		return nil, fmt.Errorf("response signature: %w", err)
+	}
+	if iss := resp.child(assertionNS, "Issuer"); iss != nil && iss.text() != idp.EntityID {
+		return nil, fmt.Errorf("unexpected issuer %q", iss.text())
+	}
+	if d := resp.attr("Destination"); d != "" && d != sp.ACSURL {
+		return nil, fmt.Errorf("unexpected destination %q", d)
+	}
+	if code := statusCode(resp); code != statusSuccess {
+		return nil, fmt.Errorf("identity provider returned status %s", code)
+	}
+	if resp.child(assertionNS, "EncryptedAssertion") != nil {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := resp.all(assertionNS, "Assertion")
+	if len(assertions) != 1 {
+		return nil, errors.New("response must contain exactly one assertion")
+	}
+	as := assertions[0]
+	assertionSigned, err := verifySignature(as, idp.Certificates)
+	if err != nil {
+		return nil, fmt.Errorf("assertion signature: %w", err)
+	}
+	if !responseSigned && !assertionSigned {
+		return nil, errors.New("neither response nor assertion is signed")
+	}
+	if iss := as.child(assertionNS, "Issuer"); iss == nil || iss.text() != idp.EntityID {
+		return nil, errors.New("assertion from another issuer")
+	}
+	if err := sp.checkConditions(as.child(assertionNS, "Conditions"), now); err != nil {
+		return nil, err
+	}
+
+	subject := as.child(assertionNS, "Subject")
+	if subject == nil {
+		return nil, errors.New("assertion without subject")
+	}
+	nameID := subject.child(assertionNS, "NameID")
+	if nameID == nil || nameID.text() == "" {
+		return nil, errors.New("assertion without NameID")
+	}
+	out := &Assertion{
+		NameID:       nameID.text(),
+		NameIDFormat: nameID.attr("Format"),
+		Attributes:   make(map[string][]string),
+	}
+	if out.InResponseTo, err = sp.confirmation(subject, now); err != nil {
+		return nil, err
+	}
+	if irt := resp.attr("InResponseTo"); irt != "" && irt != out.InResponseTo {
+		return nil, errors.New("InResponseTo mismatch")
+	}
+	if authn := as.child(assertionNS, "AuthnStatement"); authn != nil {
+		out.SessionIndex = authn.attr("SessionIndex")
+	}
+	for _, st := range as.all(assertionNS, "AttributeStatement") {
+		for _, at := range st.all(assertionNS, "Attribute") {
+			var values []string
+			for _, v := range at.all(assertionNS, "AttributeValue") {
+				values = append(values, v.text())
+			}
+			for _, name := range []string{at.attr("Name"), at.attr("FriendlyName")} {
+				if name != "" {
+					out.Attributes[name] = append(out.Attributes[name], values...)
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// checkConditions checks the validity period and audience restrictions.
+// An assertion must be restricted to our entity ID: one without audience
+// restriction could have been issued to any service provider.
+func (sp *ServiceProvider) checkConditions(cond *element, now time.Time) error {
+	if cond == nil {
+		return errors.New("assertion without conditions")
+	}
+	if nb := cond.attr("NotBefore"); nb != "" {
+		t, err := time.Parse(time.RFC3339Nano, nb)
+		if err != nil || now.Add(clockSkew).Before(t) {
+			return errors.New("assertion is not yet valid")
+		}
+	}
+	if na := cond.attr("NotOnOrAfter"); na != "" {
+		t, err := time.Parse(time.RFC3339Nano, na)
+		if err != nil || !now.Add(-clockSkew).Before(t) {
+			return errors.New("assertion has expired")
+		}
+	}
+	restrictions := cond.all(assertionNS, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return errors.New("assertion without audience restriction")
+	}
+	for _, ar := range restrictions {
+		ok := false
+		for _, aud := range ar.all(assertionNS, "Audience") {
+			ok = ok || aud.text() == sp.EntityID
+		}
+		if !ok {
+			return errors.New("assertion is for another audience")
+		}
+	}
+	return nil
+}
+
+// confirmation returns the InResponseTo of a valid bearer subject
+// confirmation addressed to our ACS.
+func (sp *ServiceProvider) confirmation(subject *element, now time.Time) (string, error) {
+	for _, sc := range subject.all(assertionNS, "SubjectConfirmation") {
+		if sc.attr("Method") != bearerMethod {
+			continue
+		}
+		data := sc.child(assertionNS, "SubjectConfirmationData")
+		if data == nil || data.attr("Recipient") != sp.ACSURL || data.attr("InResponseTo") == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, data.attr("NotOnOrAfter"))
+		if err != nil || !now.Add(-clockSkew).Before(t) {
+			continue
+		}
+		return data.attr("InResponseTo"), nil
+	}
+	return "", errors.New("no valid bearer subject confirmation")
+}
+
+// statusCode returns the top-level status code of a response.
+func statusCode(resp *element) string {
+	st := resp.child(protocolNS, "Status")
+	if st == nil {
+		return ""
+	}
+	code := st.child(protocolNS, "StatusCode")
+	if code == nil {
+		return ""
+	}
+	return code.attr("Value")
+}
+
+// uniqueIDs rejects documents with duplicate ID attributes, which
+// signature wrapping attacks rely on.
+func uniqueIDs(e *element, seen map[string]bool) error {
+	if id := e.attr("ID"); id != "" {
+		if seen[id] {
+			return errors.New("duplicate ID")
+		}
+		seen[id] = true
+	}
+	for _, c := range e.children {
+		if el, ok := c.(*element); ok {
+			if err := uniqueIDs(el, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,267 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIdP = "https://idp.example.com/metadata"
+	testSP  = "https://sp.example.com/saml/metadata"
+	testACS = "https://sp.example.com/saml/acs"
+)
+
+// fixture signs responses the way an identity provider does. Assertions
+// are written in exclusive canonical form, so that their digest does not
+// depend on the canonicalization under test.
+type fixture struct {
+	key *rsa.PrivateKey
+	idp *IdentityProvider
+	sp  *ServiceProvider
+	now time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	key, cert := newCertificate(t)
+	idp, err := NewIdentityProvider(testIdP, "https://idp.example.com/sso", []string{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{
+		key: key,
+		idp: idp,
+		sp:  &ServiceProvider{EntityID: testSP, ACSURL: testACS},
+		now: time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+	}
+}
+
+// newCertificate returns a key and its self-signed certificate as base64
+// DER.
+func newCertificate(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, base64.StdEncoding.EncodeToString(der)
+}
+
+func (f *fixture) ts(d time.Duration) string {
+	return f.now.Add(d).Format(time.RFC3339)
+}
+
+// conditions returns Conditions valid around f.now for audience; no
+// AudienceRestriction if audience is empty.
+func (f *fixture) conditions(audience string) string {
+	out := `<saml:Conditions NotBefore="` + f.ts(-time.Minute) + `" NotOnOrAfter="` + f.ts(5*time.Minute) + `">`
+	if audience != "" {
+		out += `<saml:AudienceRestriction><saml:Audience>` + audience + `</saml:Audience></saml:AudienceRestriction>`
+	}
+	return out + `</saml:Conditions>`
+}
+
+// assertion returns an unsigned assertion about nameID.
+func (f *fixture) assertion(id, nameID, conditions string) string {
+	return `<saml:Assertion xmlns:saml="` + assertionNS + `" ID="` + id + `" IssueInstant="` + f.ts(0) + `" Version="2.0">` +
+		`<saml:Issuer>` + testIdP + `</saml:Issuer>` +
+		`<saml:Subject><saml:NameID Format="` + NameIDEmail + `">` + nameID + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + bearerMethod + `">` +
+		`<saml:SubjectConfirmationData InResponseTo="_req1" NotOnOrAfter="` + f.ts(5*time.Minute) + `" Recipient="` + testACS + `"></saml:SubjectConfirmationData>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		conditions +
+		`<saml:AuthnStatement AuthnInstant="` + f.ts(0) + `" SessionIndex="_s1"></saml:AuthnStatement>` +
+		`<saml:AttributeStatement><saml:Attribute Name="email"><saml:AttributeValue>` + nameID + `</saml:AttributeValue></saml:Attribute></saml:AttributeStatement>` +
+		`</saml:Assertion>`
+}
+
+// signature returns the enveloped signature of the canonical element
+// elem with the given ID.
+func (f *fixture) signature(t *testing.T, id, elem string) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(elem))
+	si := `<ds:SignedInfo xmlns:ds="` + dsigNS + `">` +
+		`<ds:CanonicalizationMethod Algorithm="` + excC14NAlg + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + envelopedAlg + `"></ds:Transform>` +
+		`<ds:Transform Algorithm="` + excC14NAlg + `"></ds:Transform>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue></ds:Reference></ds:SignedInfo>`
+	sum := sha256.Sum256([]byte(si))
+	value, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return `<ds:Signature xmlns:ds="` + dsigNS + `">` + si +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(value) + `</ds:SignatureValue></ds:Signature>`
+}
+
+// sign inserts the signature of an assertion after its Issuer.
+func (f *fixture) sign(t *testing.T, id, assertion string) string {
+	t.Helper()
+	return withSignature(assertion, f.signature(t, id, assertion))
+}
+
+// signatureOf returns the ds:Signature element of a signed assertion.
+func signatureOf(signed string) string {
+	start := strings.Index(signed, "<ds:Signature ")
+	end := strings.Index(signed, "</ds:Signature>") + len("</ds:Signature>")
+	return signed[start:end]
+}
+
+// withSignature inserts sig into an assertion after its Issuer.
+func withSignature(assertion, sig string) string {
+	const issuerEnd = `</saml:Issuer>`
+	i := strings.Index(assertion, issuerEnd) + len(issuerEnd)
+	return assertion[:i] + sig + assertion[i:]
+}
+
+// response wraps the given extensions and assertions into an encoded
+// successful Response.
+func response(extensions, assertions string) string {
+	doc := `<samlp:Response xmlns:samlp="` + protocolNS + `" xmlns:saml="` + assertionNS + `" Destination="` + testACS +
+		`" ID="_r1" InResponseTo="_req1" IssueInstant="2026-01-02T15:04:05Z" Version="2.0">` +
+		`<saml:Issuer>` + testIdP + `</saml:Issuer>`
+	if extensions != "" {
+		doc += `<samlp:Extensions>` + extensions + `</samlp:Extensions>`
+	}
+	doc += `<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"></samlp:StatusCode></samlp:Status>` +
+		assertions + `</samlp:Response>`
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+func TestParseResponseValid(t *testing.T) {
+	f := newFixture(t)
+	signed := f.sign(t, "_a1", f.assertion("_a1", "alice@example.com", f.conditions(testSP)))
+
+	a, err := f.sp.ParseResponse(f.idp, response("", signed), f.now)
+	if err != nil {
+		t.Fatalf("ParseResponse: %v", err)
+	}
+	if a.NameID != "alice@example.com" || a.NameIDFormat != NameIDEmail {
+		t.Errorf("NameID = %q (%s)", a.NameID, a.NameIDFormat)
+	}
+	if a.InResponseTo != "_req1" || a.SessionIndex != "_s1" {
+		t.Errorf("InResponseTo = %q, SessionIndex = %q", a.InResponseTo, a.SessionIndex)
+	}
+	if got := a.Attributes["email"]; len(got) != 1 || got[0] != "alice@example.com" {
+		t.Errorf("email attribute = %v", got)
+	}
+}
+
+func TestParseResponseRejected(t *testing.T) {
+	f := newFixture(t)
+	valid := f.assertion("_a1", "alice@example.com", f.conditions(testSP))
+	signed := f.sign(t, "_a1", valid)
+	evil := f.assertion("_a2", "mallory@example.com", f.conditions(testSP))
+
+	_, otherCert := newCertificate(t)
+	otherIdP, err := NewIdentityProvider(testIdP, f.idp.SSOURL, []string{otherCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		idp     *IdentityProvider
+		encoded string
+		now     time.Time
+		want    string
+	}{
+		{
+			name:    "tampered",
+			encoded: response("", strings.ReplaceAll(signed, "alice@", "mallory@")),
+			want:    "digest mismatch",
+		},
+		{
+			name:    "unsigned",
+			encoded: response("", valid),
+			want:    "neither response nor assertion is signed",
+		},
+		{
+			name:    "wrong certificate",
+			idp:     otherIdP,
+			encoded: response("", signed),
+			want:    errInvalidSignature.Error(),
+		},
+		{
+			name:    "expired",
+			encoded: response("", signed),
+			now:     f.now.Add(time.Hour),
+			want:    "assertion has expired",
+		},
+		{
+			name:    "second assertion",
+			encoded: response("", signed+evil),
+			want:    "exactly one assertion",
+		},
+		{
+			// the signed assertion is hidden where it is not read and an
+			// unsigned one takes its place under the same ID
+			name:    "wrapped with duplicate ID",
+			encoded: response(signed, strings.Replace(evil, `ID="_a2"`, `ID="_a1"`, 1)),
+			want:    "duplicate ID",
+		},
+		{
+			// the evil assertion carries the signature of the hidden one,
+			// whose reference names an element outside the evil assertion
+			name:    "wrapped with copied signature",
+			encoded: response(valid, withSignature(evil, signatureOf(signed))),
+			want:    "signature does not reference the signed element",
+		},
+		{
+			name:    "without conditions",
+			encoded: response("", f.sign(t, "_a1", f.assertion("_a1", "alice@example.com", ""))),
+			want:    "assertion without conditions",
+		},
+		{
+			name:    "without audience restriction",
+			encoded: response("", f.sign(t, "_a1", f.assertion("_a1", "alice@example.com", f.conditions("")))),
+			want:    "assertion without audience restriction",
+		},
+		{
+			name:    "other audience",
+			encoded: response("", f.sign(t, "_a1", f.assertion("_a1", "alice@example.com", f.conditions("https://other.example.com")))),
+			want:    "assertion is for another audience",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp, now := tt.idp, tt.now
+			if idp == nil {
+				idp = f.idp
+			}
+			if now.IsZero() {
+				now = f.now
+			}
+			a, err := f.sp.ParseResponse(idp, tt.encoded, now)
+			if err == nil {
+				t.Fatalf("ParseResponse accepted the response for %q", a.NameID)
+			}
+			if !errors.Is(err, ErrInvalidResponse) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseResponse error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// element is a node of the minimal DOM that signature checks work on.
+// Unlike encoding/xml's structs it keeps prefixes and namespace
+// declarations, which canonicalization needs.
+type element struct {
+	parent *element
+	prefix string
+	local  string
+	// space is the resolved namespace URI.
+	space    string
+	decls    []nsDecl
+	attrs    []attr
+	children []any // *element, charData or procInst
+}
+
+type nsDecl struct {
+	prefix string // "" for the default namespace
+	uri    string
+}
+
+type attr struct {
+	prefix string
+	local  string
+	space  string
+	value  string
+}
+
+type charData string
+
+type procInst struct {
+	target string
+	inst   string
+}
+
+// parseXML parses a document into the DOM. DTDs are rejected.
+func parseXML(data []byte) (*element, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *element
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if cur == nil && root != nil {
+				return nil, errors.New("xml: more than one root element")
+			}
+			el := &element{parent: cur, prefix: t.Name.Space, local: t.Name.Local}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					el.decls = append(el.decls, nsDecl{prefix: a.Name.Local, uri: a.Value})
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					el.decls = append(el.decls, nsDecl{uri: a.Value})
+				default:
+					el.attrs = append(el.attrs, attr{prefix: a.Name.Space, local: a.Name.Local, value: a.Value})
+				}
+			}
+			uri, ok := el.lookupNS(el.prefix)
+			if !ok && el.prefix != "" {
+				return nil, fmt.Errorf("xml: undeclared prefix %q", el.prefix)
+			}
+			el.space = uri
+			for i := range el.attrs {
+				a := &el.attrs[i]
+				if a.prefix == "" {
+					continue
+				}
+				if a.space, ok = el.lookupNS(a.prefix); !ok {
+					return nil, fmt.Errorf("xml: undeclared prefix %q", a.prefix)
+				}
+			}
+			if cur == nil {
+				root = el
+			} else {
+				cur.children = append(cur.children, el)
+			}
+			cur = el
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+				return nil, errors.New("xml: mismatched end element")
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, charData(t))
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, errors.New("xml: text outside the root element")
+			}
+		case xml.ProcInst:
+			if cur != nil {
+				cur.children = append(cur.children, procInst{target: t.Target, inst: string(t.Inst)})
+			}
+		case xml.Directive:
+			return nil, errors.New("xml: DTDs are not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, errors.New("xml: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNS resolves a prefix in the scope of the element.
+func (e *element) lookupNS(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for el := e; el != nil; el = el.parent {
+		for _, d := range el.decls {
+			if d.prefix == prefix {
+				return d.uri, true
+			}
+		}
+	}
+	return "", false
+}
+
+// is reports whether the element has the given namespace and name.
+func (e *element) is(space, local string) bool {
+	return e.space == space && e.local == local
+}
+
+// child returns the first child element with the name, or nil.
+func (e *element) child(space, local string) *element {
+	for _, c := range e.children {
+		if el, ok := c.(*element); ok && el.is(space, local) {
+			return el
+		}
+	}
+	return nil
+}
+
+// all returns the child elements with the name.
+func (e *element) all(space, local string) []*element {
+	var out []*element
+	for _, c := range e.children {
+		if el, ok := c.(*element); ok && el.is(space, local) {
+			out = append(out, el)
+		}
+	}
+	return out
+}
+
+// attr returns the value of the unqualified attribute name.
+func (e *element) attr(name string) string {
+	for _, a := range e.attrs {
+		if a.prefix == "" && a.local == name {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// text returns the trimmed character data of the element.
+func (e *element) text() string {
+	var b strings.Builder
+	for _, c := range e.children {
+		if s, ok := c.(charData); ok {
+			b.WriteString(string(s))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// canonicalize serializes the subtree of e with Exclusive XML
+// Canonicalization without comments. The element omit, an enveloped
+// signature, is left out. inclusive lists the prefixes of the
+// InclusiveNamespaces PrefixList, "" standing for #default.
+func canonicalize(e, omit *element, inclusive []string) []byte {
+	var buf bytes.Buffer
+	c14n(&buf, e, omit, map[string]string{}, inclusive)
+	return buf.Bytes()
+}
+
+// c14n writes e. rendered holds the namespace declarations in effect in
+// the output so far.
+func c14n(buf *bytes.Buffer, e, omit *element, rendered map[string]string, inclusive []string) {
+	if e == omit {
+		return
+	}
+	// namespaces visibly utilized by the element or its attributes, and
+	// those of the inclusive list that are in scope
+	needed := []string{e.prefix}
+	for _, a := range e.attrs {
+		if a.prefix != "" && a.prefix != "xml" {
+			needed = append(needed, a.prefix)
+		}
+	}
+	for _, p := range inclusive {
+		if _, ok := e.lookupNS(p); ok {
+			needed = append(needed, p)
+		}
+	}
+	var decls []nsDecl
+	next := rendered
+	for _, p := range needed {
+		uri, _ := e.lookupNS(p)
+		prev, ok := next[p]
+		if ok && prev == uri || !ok && p == "" && uri == "" {
+			continue
+		}
+		if len(decls) == 0 {
+			next = make(map[string]string, len(rendered)+1)
+			for k, v := range rendered {
+				next[k] = v
+			}
+		}
+		next[p] = uri
+		decls = append(decls, nsDecl{prefix: p, uri: uri})
+	}
+	sort.Slice(decls, func(i, j int) bool { return decls[i].prefix < decls[j].prefix })
+	attrs := append([]attr(nil), e.attrs...)
+	for i := range attrs {
+		if attrs[i].prefix == "xml" {
+			attrs[i].space = xmlNamespace
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	name := qname(e.prefix, e.local)
+	buf.WriteString("<" + name)
+	for _, d := range decls {
+		if d.prefix == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(" xmlns:" + d.prefix + `="`)
+		}
+		escapeAttr(buf, d.uri)
+		buf.WriteByte('"')
+	}
+	for _, a := range attrs {
+		buf.WriteString(" " + qname(a.prefix, a.local) + `="`)
+		escapeAttr(buf, a.value)
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+	for _, c := range e.children {
+		switch c := c.(type) {
+		case *element:
+			c14n(buf, c, omit, next, inclusive)
+		case charData:
+			escapeText(buf, string(c))
+		case procInst:
+			buf.WriteString("<?" + c.target)
+			if c.inst != "" {
+				buf.WriteString(" " + c.inst)
+			}
+			buf.WriteString("?>")
+		}
+	}
+	buf.WriteString("</" + name + ">")
+}
+
+func qname(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+func escapeText(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+func escapeAttr(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '"':
+			buf.WriteString("&quot;")
+		case '\t':
+			buf.WriteString("&#x9;")
+		case '\n':
+			buf.WriteString("&#xA;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package scim
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2).
+type Filter interface {
+	// Match reports whether the resource, in its JSON form, matches.
+	Match(res map[string]any) bool
+}
+
+// attrPath is an attribute reference such as userName, name.givenName or
+// urn:...:enterprise:2.0:User:employeeNumber.
+type attrPath struct {
+	// URN is the schema of an extension attribute; empty for core
+	// attributes.
+	URN  string
+	Attr string
+	Sub  string
+}
+
+var attrNamePattern = regexp.MustCompile(`^\$?[A-Za-z][A-Za-z0-9_-]*$`)
+
+func parseAttrPath(s string) (attrPath, error) {
+	var p attrPath
+	if strings.HasPrefix(strings.ToLower(s), "urn:") {
+		i := strings.LastIndex(s, ":")
+		p.URN, s = s[:i], s[i+1:]
+		if strings.EqualFold(p.URN, UserSchema) || strings.EqualFold(p.URN, GroupSchema) {
+			p.URN = ""
+		}
+	}
+	p.Attr, p.Sub, _ = strings.Cut(s, ".")
+	if !attrNamePattern.MatchString(p.Attr) || (p.Sub != "" && !attrNamePattern.MatchString(p.Sub)) {
+		return attrPath{}, BadRequest("invalidPath", "invalid attribute path %q", s)
+	}
+	return p, nil
+}
+
+// container returns the map holding the attribute: the resource itself
+// or, for extension attributes, the extension's object.
+func (p attrPath) container(res map[string]any) map[string]any {
+	if p.URN == "" {
+		return res
+	}
+	v, _, _ := Lookup(res, p.URN)
+	m, _ := v.(map[string]any)
+	return m
+}
+
+// values returns the values the path refers to; multi-valued attributes
+// contribute each of their values. For multi-valued complex attributes
+// without a sub-attribute, the "value" sub-attribute is used.
+func (p attrPath) values(res map[string]any) []any {
+	m := p.container(res)
+	if m == nil {
+		return nil
+	}
+	v, _, ok := Lookup(m, p.Attr)
+	if !ok || v == nil {
+		return nil
+	}
+	var out []any
+	add := func(v any) {
+		if p.Sub != "" {
+			if cm, ok := v.(map[string]any); ok {
+				if sv, _, ok := Lookup(cm, p.Sub); ok && sv != nil {
+					out = append(out, sv)
+				}
+			}
+			return
+		}
+		if cm, ok := v.(map[string]any); ok {
+			if sv, _, ok := Lookup(cm, "value"); ok && sv != nil {
+				out = append(out, sv)
+			}
+			return
+		}
+		out = append(out, v)
+	}
+	if list, ok := v.([]any); ok {
+		for _, e := range list {
+			add(e)
+		}
+	} else {
+		add(v)
+	}
+	return out
+}
+
+// caseExact reports whether string comparisons on the attribute are case
+// sensitive. Identifiers are; names and emails are not.
+func (p attrPath) caseExact() bool {
+	if p.Sub != "" {
+		return strings.EqualFold(p.Sub, "$ref")
+	}
+	return strings.EqualFold(p.Attr, "id") || strings.EqualFold(p.Attr, "externalId")
+}
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Match(res map[string]any) bool { return f.left.Match(res) && f.right.Match(res) }
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Match(res map[string]any) bool { return f.left.Match(res) || f.right.Match(res) }
+
+type notFilter struct{ f Filter }
+
+func (f notFilter) Match(res map[string]any) bool { return !f.f.Match(res) }
+
+type presentFilter struct{ path attrPath }
+
+func (f presentFilter) Match(res map[string]any) bool {
+	for _, v := range f.path.values(res) {
+		if s, ok := v.(string); !ok || s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// valuePathFilter matches when an element of a multi-valued attribute
+// matches the inner filter, e.g. emails[type eq "work" and value co "@"].
+type valuePathFilter struct {
+	path attrPath
+	f    Filter
+}
+
+func (f valuePathFilter) Match(res map[string]any) bool {
+	for _, e := range f.path.elements(res) {
+		if f.f.Match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// elements returns the complex values of the attribute.
+func (p attrPath) elements(res map[string]any) []map[string]any {
+	m := p.container(res)
+	if m == nil {
+		return nil
+	}
+	v, _, _ := Lookup(m, p.Attr)
+	switch v := v.(type) {
+	case []any:
+		out := make([]map[string]any, 0, len(v))
+		for _, e := range v {
+			if em, ok := e.(map[string]any); ok {
+				out = append(out, em)
+			}
+		}
+		return out
+	case map[string]any:
+		return []map[string]any{v}
+	}
+	return nil
+}
+
+type compareFilter struct {
+	path  attrPath
+	op    string
+	value any
+}
+
+func (f compareFilter) Match(res map[string]any) bool {
+	values := f.path.values(res)
+	if f.value == nil {
+		// "eq null" means unassigned
+		switch f.op {
+		case "eq":
+			return len(values) == 0
+		case "ne":
+			return len(values) > 0
+		}
+		return false
+	}
+	if f.op == "ne" {
+		for _, v := range values {
+			if compare(v, "eq", f.value, f.path.caseExact()) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if compare(v, f.op, f.value, f.path.caseExact()) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies op to an attribute value and a literal of the filter.
+func compare(v any, op string, lit any, caseExact bool) bool {
+	switch lit := lit.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		if !caseExact {
+			s, lit = strings.ToLower(s), strings.ToLower(lit)
+		}
+		switch op {
+		case "eq":
+			return s == lit
+		case "co":
+			return strings.Contains(s, lit)
+		case "sw":
+			return strings.HasPrefix(s, lit)
+		case "ew":
+			return strings.HasSuffix(s, lit)
+		case "gt":
+			return s > lit
+		case "ge":
+			return s >= lit
+		case "lt":
+			return s < lit
+		case "le":
+			return s <= lit
+		}
+	case bool:
+		b, ok := v.(bool)
+		return ok && op == "eq" && b == lit
+	case float64:
+		var n float64
+		switch v := v.(type) {
+		case float64:
+			n = v
+		case int:
+			n = float64(v)
+		case int64:
+			n = float64(v)
+		default:
+			return false
+		}
+		switch op {
+		case "eq":
+			return n == lit
+		case "gt":
+			return n > lit
+		case "ge":
+			return n >= lit
+		case "lt":
+			return n < lit
+		case "le":
+			return n <= lit
+		}
+	}
+	return false
+}
+
+var compareOps = map[string]bool{"eq": true, "ne": true, "co": true, "sw": true, "ew": true, "gt": true, "ge": true, "lt": true, "le": true}
+
+// Parse parses a filter expression. Errors are *Error with scimType
+// invalidFilter.
+func Parse(s string) (Filter, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, BadRequest("invalidFilter", "unexpected %q", p.peek().text)
+	}
+	return f, nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func lex(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '(':
+			toks = append(toks, token{tokLParen, "("})
+			i++
+		case ')':
+			toks = append(toks, token{tokRParen, ")"})
+			i++
+		case '[':
+			toks = append(toks, token{tokLBracket, "["})
+			i++
+		case ']':
+			toks = append(toks, token{tokRBracket, "]"})
+			i++
+		case '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, BadRequest("invalidFilter", "unterminated string")
+			}
+			var str string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &str); err != nil {
+				return nil, BadRequest("invalidFilter", "invalid string %s", s[i:j+1])
+			}
+			toks = append(toks, token{tokString, str})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])); j++ {
+			}
+			toks = append(toks, token{tokWord, s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.toks) }
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: -1}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword reports whether the next token is the keyword kw and consumes
+// it.
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, what string) error {
+	if p.next().kind != kind {
+		return BadRequest("invalidFilter", "expected %s", what)
+	}
+	return nil
+}
+
+func (p *parser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Filter, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (Filter, error) {
+	if !p.keyword("not") {
+		return p.atom()
+	}
+	if err := p.expect(tokLParen, "( after not"); err != nil {
+		return nil, err
+	}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+	return notFilter{f}, nil
+}
+
+func (p *parser) atom() (Filter, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case tokWord:
+	default:
+		return nil, BadRequest("invalidFilter", "expected an attribute")
+	}
+	path, err := parseAttrPath(t.text)
+	if err != nil {
+		return nil, BadRequest("invalidFilter", "invalid attribute path %q", t.text)
+	}
+	if p.peek().kind == tokLBracket {
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRBracket, "]"); err != nil {
+			return nil, err
+		}
+		return valuePathFilter{path, inner}, nil
+	}
+	opTok := p.next()
+	op := strings.ToLower(opTok.text)
+	if opTok.kind != tokWord || (op != "pr" && !compareOps[op]) {
+		return nil, BadRequest("invalidFilter", "expected an operator after %s", t.text)
+	}
+	if op == "pr" {
+		return presentFilter{path}, nil
+	}
+	lit := p.next()
+	var value any
+	switch lit.kind {
+	case tokString:
+		value = lit.text
+	case tokWord:
+		switch strings.ToLower(lit.text) {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			n, err := strconv.ParseFloat(lit.text, 64)
+			if err != nil {
+				return nil, BadRequest("invalidFilter", "invalid value %q", lit.text)
+			}
+			value = n
+		}
+	default:
+		return nil, BadRequest("invalidFilter", "expected a value after %s", op)
+	}
+	return compareFilter{path, op, value}, nil
+}
@@ -0,0 +1,105 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// user is a SCIM user resource in its JSON form, decoded the way request
+// bodies and stored resources are.
+func user(t *testing.T) map[string]any {
+	t.Helper()
+	var res map[string]any
+	err := json.Unmarshal([]byte(`{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"id": "u-1",
+		"externalId": "EXT-1",
+		"userName": "Alice@Example.com",
+		"active": true,
+		"name": {"givenName": "Alice", "familyName": "Smith"},
+		"emails": [
+			{"type": "work", "value": "alice@example.com", "primary": true},
+			{"type": "home", "value": "alice@home.example"}
+		],
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"employeeNumber": "42", "level": 3}
+	}`), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestParseMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "alice@example.com"`, true},
+		{`USERNAME EQ "ALICE@EXAMPLE.COM"`, true},
+		{`userName ne "alice@example.com"`, false},
+		{`userName sw "alice"`, true},
+		{`userName ew "@example.com"`, true},
+		{`userName co "bob"`, false},
+		{`id eq "U-1"`, false},
+		{`externalId eq "EXT-1"`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`name.givenName eq "alice"`, true},
+		{`name.middleName pr`, false},
+		{`title eq null`, true},
+		{`emails pr`, true},
+		{`emails co "@home"`, true},
+		{`emails.type eq "home"`, true},
+		{`emails[type eq "work" and value co "@example.com"]`, true},
+		{`emails[type eq "work" and value co "@home"]`, false},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "alice"`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber eq "42"`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:level ge 3`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:level lt 3`, false},
+		{`not (active eq true)`, false},
+		// and binds tighter than or
+		{`userName eq "bob" and active eq true or name.familyName eq "smith"`, true},
+		{`userName eq "bob" and (active eq true or name.familyName eq "smith")`, false},
+		{`userName eq "bob" or active eq false and name.familyName eq "smith"`, false},
+	}
+	res := user(t)
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := f.Match(res); got != tt.want {
+				t.Fatalf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName like "a"`,
+		`userName eq alice`,
+		`userName eq "alice`,
+		`(userName eq "a"`,
+		`userName eq "a")`,
+		`userName eq "a" and`,
+		`emails[type eq "work"`,
+		`emails[primary eq true].value eq "x"`,
+		`1name eq "a"`,
+		`name.given.name eq "a"`,
+	}
+	for _, filter := range tests {
+		t.Run(filter, func(t *testing.T) {
+			f, err := Parse(filter)
+			var se *Error
+			if !errors.As(err, &se) || se.Status != 400 {
+				t.Fatalf("Parse = %v, %v; want a 400 *Error", f, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package scim
+
+import (
+	"reflect"
+	"strings"
+)
+
+// PatchOp is one operation of a PATCH request (RFC 7644 section 3.5.2).
+type PatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// PatchRequest is the body of a PATCH request.
+type PatchRequest struct {
+	Schemas    []string  `json:"schemas"`
+	Operations []PatchOp `json:"Operations" binding:"required,min=1"`
+}
+
+// path is the target of a patch operation: an attribute, optionally
+// narrowed to the elements of a multi-valued attribute matching a filter,
+// as in emails[type eq "work"].value.
+type path struct {
+	attr   attrPath
+	filter Filter
+	// sub is the sub-attribute after the filter.
+	sub string
+}
+
+func parsePath(s string) (*path, error) {
+	toks, err := lex(s)
+	if err != nil || len(toks) == 0 || toks[0].kind != tokWord {
+		return nil, BadRequest("invalidPath", "invalid path %q", s)
+	}
+	attr, err := parseAttrPath(toks[0].text)
+	if err != nil {
+		return nil, err
+	}
+	pt := &path{attr: attr}
+	if len(toks) == 1 {
+		return pt, nil
+	}
+	if attr.Sub != "" || toks[1].kind != tokLBracket {
+		return nil, BadRequest("invalidPath", "invalid path %q", s)
+	}
+	p := &parser{toks: toks, pos: 2}
+	if pt.filter, err = p.or(); err != nil {
+		return nil, BadRequest("invalidPath", "invalid filter in path %q", s)
+	}
+	if err := p.expect(tokRBracket, "]"); err != nil {
+		return nil, BadRequest("invalidPath", "invalid path %q", s)
+	}
+	if !p.done() {
+		t := p.next()
+		if t.kind != tokWord || !strings.HasPrefix(t.text, ".") || !p.done() || !attrNamePattern.MatchString(t.text[1:]) {
+			return nil, BadRequest("invalidPath", "invalid path %q", s)
+		}
+		pt.sub = t.text[1:]
+	}
+	return pt, nil
+}
+
+// Apply applies the operations to the resource in order. Operations
+// without a path take an object whose members are applied one by one.
+func Apply(res map[string]any, ops []PatchOp) error {
+	for _, op := range ops {
+		name := strings.ToLower(op.Op)
+		if name != "add" && name != "replace" && name != "remove" {
+			return BadRequest("invalidSyntax", "unknown op %q", op.Op)
+		}
+		if op.Path == "" {
+			if name == "remove" {
+				return BadRequest("noTarget", "remove needs a path")
+			}
+			obj, ok := op.Value.(map[string]any)
+			if !ok {
+				return BadRequest("invalidValue", "%s without a path needs an object value", op.Op)
+			}
+			for k, v := range obj {
+				if strings.EqualFold(k, "schemas") {
+					continue
+				}
+				pt, err := parsePath(k)
+				if err != nil {
+					return err
+				}
+				if err := apply(res, name, pt, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		pt, err := parsePath(op.Path)
+		if err != nil {
+			return err
+		}
+		if err := apply(res, name, pt, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func apply(res map[string]any, op string, pt *path, value any) error {
+	m := pt.attr.container(res)
+	if m == nil {
+		if op == "remove" {
+			return nil
+		}
+		m = map[string]any{}
+		res[pt.attr.URN] = m
+	}
+	existing, key, found := Lookup(m, pt.attr.Attr)
+	if !found {
+		key = pt.attr.Attr
+	}
+	if pt.filter != nil {
+		return applyFiltered(m, key, existing, op, pt, value)
+	}
+
+	if pt.attr.Sub != "" {
+		switch cur := existing.(type) {
+		case []any:
+			// a sub-attribute of all values
+			for _, e := range cur {
+				if em, ok := e.(map[string]any); ok {
+					setSub(em, op, pt.attr.Sub, value)
+				}
+			}
+		case map[string]any:
+			setSub(cur, op, pt.attr.Sub, value)
+		default:
+			if op != "remove" {
+				m[key] = map[string]any{pt.attr.Sub: value}
+			}
+		}
+		return nil
+	}
+
+	switch op {
+	case "remove":
+		list, isList := existing.([]any)
+		values, hasValues := value.([]any)
+		if isList && hasValues {
+			// remove the given values, e.g. members of a group
+			m[key] = removeValues(list, values)
+			return nil
+		}
+		delete(m, key)
+	case "add":
+		if list, ok := existing.([]any); ok {
+			m[key] = addValues(list, value)
+			return nil
+		}
+		if cur, ok := existing.(map[string]any); ok {
+			if obj, ok := value.(map[string]any); ok {
+				merge(cur, obj)
+				return nil
+			}
+		}
+		m[key] = value
+	case "replace":
+		if cur, ok := existing.(map[string]any); ok {
+			if obj, ok := value.(map[string]any); ok {
+				merge(cur, obj)
+				return nil
+			}
+		}
+		m[key] = value
+	}
+	return nil
+}
+
+// applyFiltered applies an operation to the elements matching the path's
+// filter. Add and replace create the element when nothing matches and the
+// filter only consists of equality tests, which is what clients mean by
+// e.g. replace emails[type eq "work"].value.
+func applyFiltered(m map[string]any, key string, existing any, op string, pt *path, value any) error {
+	list, _ := existing.([]any)
+	matched := false
+	out := list[:0:0]
+	for _, e := range list {
+		em, ok := e.(map[string]any)
+		if !ok || !pt.filter.Match(em) {
+			out = append(out, e)
+			continue
+		}
+		matched = true
+		switch {
+		case op == "remove" && pt.sub == "":
+			continue
+		case pt.sub != "":
+			setSub(em, op, pt.sub, value)
+		default:
+			if obj, ok := value.(map[string]any); ok {
+				merge(em, obj)
+			}
+		}
+		out = append(out, em)
+	}
+	if !matched && op != "remove" {
+		seed, ok := equalities(pt.filter)
+		if !ok {
+			return BadRequest("noTarget", "no value matches the path filter")
+		}
+		if pt.sub != "" {
+			seed[pt.sub] = value
+		} else if obj, ok := value.(map[string]any); ok {
+			merge(seed, obj)
+		}
+		out = append(out, seed)
+	}
+	m[key] = out
+	return nil
+}
+
+// equalities returns the attribute values a filter of "eq" tests joined
+// by "and" requires.
+func equalities(f Filter) (map[string]any, bool) {
+	switch f := f.(type) {
+	case compareFilter:
+		if f.op != "eq" || f.path.Sub != "" || f.path.URN != "" {
+			return nil, false
+		}
+		return map[string]any{f.path.Attr: f.value}, true
+	case andFilter:
+		l, ok := equalities(f.left)
+		if !ok {
+			return nil, false
+		}
+		r, ok := equalities(f.right)
+		if !ok {
+			return nil, false
+		}
+		merge(l, r)
+		return l, true
+	}
+	return nil, false
+}
+
+func setSub(m map[string]any, op, sub string, value any) {
+	_, key, found := Lookup(m, sub)
+	if !found {
+		key = sub
+	}
+	if op == "remove" {
+		delete(m, key)
+		return
+	}
+	m[key] = value
+}
+
+// merge copies the members of src into dst, matching names
+// case-insensitively.
+func merge(dst, src map[string]any) {
+	for k, v := range src {
+		if _, key, found := Lookup(dst, k); found {
+			k = key
+		}
+		dst[k] = v
+	}
+}
+
+// addValues appends values not yet present to a multi-valued attribute.
+func addValues(list []any, value any) []any {
+	values, ok := value.([]any)
+	if !ok {
+		values = []any{value}
+	}
+	for _, v := range values {
+		if !containsValue(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// removeValues removes values from a multi-valued attribute. Complex
+// values are compared by their "value" sub-attribute.
+func removeValues(list, values []any) []any {
+	out := list[:0:0]
+	for _, e := range list {
+		if !containsValue(values, e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func containsValue(list []any, v any) bool {
+	for _, e := range list {
+		if sameValue(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValue(a, b any) bool {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if aok && bok {
+		av, _, _ := Lookup(am, "value")
+		bv, _, _ := Lookup(bm, "value")
+		if av != nil || bv != nil {
+			return av == bv
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,146 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name string
+		res  string
+		ops  string
+		want string
+	}{
+		{
+			name: "replace an attribute",
+			res:  `{"userName": "alice", "active": true}`,
+			ops:  `[{"op": "Replace", "path": "active", "value": false}]`,
+			want: `{"userName": "alice", "active": false}`,
+		},
+		{
+			name: "attribute names are case insensitive",
+			res:  `{"displayName": "Alice"}`,
+			ops:  `[{"op": "replace", "path": "DISPLAYNAME", "value": "Al"}]`,
+			want: `{"displayName": "Al"}`,
+		},
+		{
+			name: "replace a sub-attribute",
+			res:  `{"name": {"givenName": "Alice", "familyName": "Smith"}}`,
+			ops:  `[{"op": "replace", "path": "name.familyName", "value": "Jones"}]`,
+			want: `{"name": {"givenName": "Alice", "familyName": "Jones"}}`,
+		},
+		{
+			name: "add a sub-attribute of a missing attribute",
+			res:  `{}`,
+			ops:  `[{"op": "add", "path": "name.givenName", "value": "Alice"}]`,
+			want: `{"name": {"givenName": "Alice"}}`,
+		},
+		{
+			name: "operation without a path",
+			res:  `{"userName": "alice", "name": {"givenName": "Alice"}}`,
+			ops:  `[{"op": "replace", "value": {"userName": "bob", "name.familyName": "Smith", "schemas": ["x"]}}]`,
+			want: `{"userName": "bob", "name": {"givenName": "Alice", "familyName": "Smith"}}`,
+		},
+		{
+			name: "add values to a multi-valued attribute",
+			res:  `{"members": [{"value": "u1"}]}`,
+			ops:  `[{"op": "add", "path": "members", "value": [{"value": "u1"}, {"value": "u2"}]}]`,
+			want: `{"members": [{"value": "u1"}, {"value": "u2"}]}`,
+		},
+		{
+			name: "remove values from a multi-valued attribute",
+			res:  `{"members": [{"value": "u1"}, {"value": "u2"}]}`,
+			ops:  `[{"op": "remove", "path": "members", "value": [{"value": "u1"}]}]`,
+			want: `{"members": [{"value": "u2"}]}`,
+		},
+		{
+			name: "remove elements matching a filter",
+			res:  `{"members": [{"value": "u1"}, {"value": "u2"}]}`,
+			ops:  `[{"op": "remove", "path": "members[value eq \"u2\"]"}]`,
+			want: `{"members": [{"value": "u1"}]}`,
+		},
+		{
+			name: "replace a sub-attribute of matching elements",
+			res:  `{"emails": [{"type": "work", "value": "a@x"}, {"type": "home", "value": "a@y"}]}`,
+			ops:  `[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "a@z"}]`,
+			want: `{"emails": [{"type": "work", "value": "a@z"}, {"type": "home", "value": "a@y"}]}`,
+		},
+		{
+			name: "replace creates the element an equality filter names",
+			res:  `{"emails": [{"type": "home", "value": "a@y"}]}`,
+			ops:  `[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "a@z"}]`,
+			want: `{"emails": [{"type": "home", "value": "a@y"}, {"type": "work", "value": "a@z"}]}`,
+		},
+		{
+			name: "remove an attribute",
+			res:  `{"userName": "alice", "title": "Dr"}`,
+			ops:  `[{"op": "remove", "path": "title"}]`,
+			want: `{"userName": "alice"}`,
+		},
+		{
+			name: "extension attribute",
+			res:  `{}`,
+			ops:  `[{"op": "add", "path": "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber", "value": "42"}]`,
+			want: `{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"employeeNumber": "42"}}`,
+		},
+		{
+			name: "operations apply in order",
+			res:  `{"title": "Dr"}`,
+			ops:  `[{"op": "remove", "path": "title"}, {"op": "add", "path": "title", "value": "Prof"}]`,
+			want: `{"title": "Prof"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := decode(t, tt.res)
+			var ops []PatchOp
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatal(err)
+			}
+			if err := Apply(res, ops); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if want := decode(t, tt.want); !reflect.DeepEqual(res, want) {
+				t.Fatalf("resource = %v, want %v", res, want)
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      []PatchOp
+		scimType string
+	}{
+		{"unknown op", []PatchOp{{Op: "move", Path: "title"}}, "invalidSyntax"},
+		{"remove without a path", []PatchOp{{Op: "remove"}}, "noTarget"},
+		{"no path and no object", []PatchOp{{Op: "add", Value: "x"}}, "invalidValue"},
+		{"invalid path", []PatchOp{{Op: "add", Path: "na me", Value: "x"}}, "invalidPath"},
+		{"invalid filter in path", []PatchOp{{Op: "add", Path: `emails[type eq]`, Value: "x"}}, "invalidPath"},
+		{"unclosed filter", []PatchOp{{Op: "add", Path: `emails[type eq "work"`, Value: "x"}}, "invalidPath"},
+		{"sub-attribute and filter", []PatchOp{{Op: "add", Path: `name.givenName[type eq "a"]`, Value: "x"}}, "invalidPath"},
+		{"filter without a target", []PatchOp{{Op: "replace", Path: `emails[value co "@"].type`, Value: "work"}}, "noTarget"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Apply(map[string]any{}, tt.ops)
+			var se *Error
+			if !errors.As(err, &se) || se.Type != tt.scimType {
+				t.Fatalf("Apply error = %v, want scimType %s", err, tt.scimType)
+			}
+		})
+	}
+}
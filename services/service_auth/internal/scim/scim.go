@@ -0,0 +1,80 @@
+// Package scim implements the protocol parts of SCIM 2.0 (RFC 7643, RFC
+// 7644) that do not depend on our data model: the filter grammar, PATCH
+// operations and error responses. Resources are handled in their JSON
+// form, as map[string]any.
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Schema URNs.
+const (
+	UserSchema            = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema           = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema         = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema           = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceProviderSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema    = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Error is a SCIM error response (RFC 7644 section 3.12).
+type Error struct {
+	Status int
+	// Type is the scimType, e.g. invalidFilter or uniqueness.
+	Type   string
+	Detail string
+}
+
+func (e *Error) Error() string {
+	if e.Type != "" {
+		return e.Type + ": " + e.Detail
+	}
+	return e.Detail
+}
+
+// Body returns the JSON body of the error response.
+func (e *Error) Body() map[string]any {
+	body := map[string]any{
+		"schemas": []string{ErrorSchema},
+		"status":  fmt.Sprint(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.Type != "" {
+		body["scimType"] = e.Type
+	}
+	return body
+}
+
+// BadRequest returns a 400 error with the given scimType.
+func BadRequest(scimType, format string, args ...any) *Error {
+	return &Error{Status: http.StatusBadRequest, Type: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Lookup returns the value of attribute name in m, compared
+// case-insensitively as attribute names are in SCIM, and the key it is
+// stored under.
+func Lookup(m map[string]any, name string) (any, string, bool) {
+	if v, ok := m[name]; ok {
+		return v, name, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v, k, true
+		}
+	}
+	return nil, "", false
+}
+
+// String returns the string attribute name of m, or "".
+func String(m map[string]any, name string) string {
+	v, _, _ := Lookup(m, name)
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,62 @@
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Box performs authenticated encryption with AES-256-GCM. The key is
+// derived from an arbitrary passphrase with SHA-256, so any sufficiently
+// random string can be used as configuration.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New constructs a Box from the given key material.
+func New(key string) (*Box, error) {
+	if key == "" {
+		return nil, errors.New("seal: empty key")
+	}
+	k := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext and returns base64(nonce || ciphertext).
+func (b *Box) Seal(plaintext []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("seal: nonce: %w", err)
+	}
+	out := b.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// Open decrypts a value produced by Seal.
+func (b *Box) Open(sealed string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("seal: decode: %w", err)
+	}
+	n := b.aead.NonceSize()
+	if len(raw) < n {
+		return nil, errors.New("seal: ciphertext too short")
+	}
+	pt, err := b.aead.Open(nil, raw[:n], raw[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("seal: open: %w", err)
+	}
+	return pt, nil
+}
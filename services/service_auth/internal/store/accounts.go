@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"auth_project/internal/domain"
+)
+
+// AccountStore covers account changes made after registration.
+type AccountStore interface {
+	// UpdatePassword replaces the user's password hash.
+	UpdatePassword(ctx context.Context, userID, passwordHash string) error
+	// SetUserRoles replaces the user's roles.
+	SetUserRoles(ctx context.Context, userID string, roles []string) error
+	// SetUserDisabled disables the account (at != nil) or re-enables it.
+	SetUserDisabled(ctx context.Context, userID string, at *time.Time) error
+	// MarkEmailVerified records that the user proved control of the email.
+	MarkEmailVerified(ctx context.Context, userID string) error
+	// RevokeUserSessions revokes all sessions of the user together with
+	// their refresh tokens.
+	RevokeUserSessions(ctx context.Context, userID string) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+// updateUser applies fn to the stored copy of the user. Callers hold s.mu.
+func (s *MemStore) updateUser(userID string, fn func(u *domain.User)) error {
+	login, ok := s.byID[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	u := s.byLogin[login]
+	fn(&u)
+	s.byLogin[login] = u
+	return nil
+}
+
+func (s *MemStore) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateUser(userID, func(u *domain.User) { u.PasswordHash = passwordHash })
+}
+
+func (s *MemStore) SetUserRoles(ctx context.Context, userID string, roles []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateUser(userID, func(u *domain.User) { u.Roles = append([]string(nil), roles...) })
+}
+
+func (s *MemStore) SetUserDisabled(ctx context.Context, userID string, at *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateUser(userID, func(u *domain.User) { u.DisabledAt = at })
+}
+
+func (s *MemStore) MarkEmailVerified(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateUser(userID, func(u *domain.User) { u.EmailVerified = true })
+}
+
+func (s *MemStore) RevokeUserSessions(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, sess := range s.sessions {
+		if sess.UserID == userID && sess.RevokedAt == nil {
+			sess.RevokedAt = &now
+		}
+	}
+	for _, rec := range s.refresh {
+		if rec.UserID == userID {
+			rec.Revoked = true
+		}
+	}
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	return p.updateUser(ctx, `UPDATE users SET password_hash = $2 WHERE id = $1 AND tenant_id = $3`, userID, passwordHash, p.tenant)
+}
+
+func (p *PgStore) SetUserRoles(ctx context.Context, userID string, roles []string) error {
+	return p.updateUser(ctx, `UPDATE users SET roles = COALESCE($2::text[], '{}') WHERE id = $1 AND tenant_id = $3`, userID, roles, p.tenant)
+}
+
+func (p *PgStore) SetUserDisabled(ctx context.Context, userID string, at *time.Time) error {
+	return p.updateUser(ctx, `UPDATE users SET disabled_at = $2 WHERE id = $1 AND tenant_id = $3`, userID, at, p.tenant)
+}
+
+func (p *PgStore) MarkEmailVerified(ctx context.Context, userID string) error {
+	return p.updateUser(ctx, `UPDATE users SET email_verified = TRUE WHERE id = $1 AND tenant_id = $2`, userID, p.tenant)
+}
+
+func (p *PgStore) updateUser(ctx context.Context, sql string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PgStore) RevokeUserSessions(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND tenant_id = $2 AND revoked_at IS NULL`, userID, p.tenant); err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = $1 AND tenant_id = $2 AND NOT revoked`, userID, p.tenant); err != nil {
+		return fmt.Errorf("revoke refresh tokens: %w", err)
+	}
+	return tx.Commit(ctx)
+}
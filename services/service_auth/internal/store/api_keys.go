@@ -0,0 +1,186 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// APIKey is a long-lived personal credential of a user. The key is shown
+// once at creation; only its lookup Prefix and the hash of the whole key
+// are stored.
+type APIKey struct {
+	ID         string
+	UserID     string
+	Name       string
+	Prefix     string
+	SecretHash string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// APIKeyStore persists personal API keys.
+type APIKeyStore interface {
+	// CreateAPIKey stores a new key.
+	CreateAPIKey(ctx context.Context, key APIKey) error
+	// FindAPIKeyByPrefix returns the key; nil if not found.
+	FindAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	// ListAPIKeys returns the user's keys that are not revoked, newest first.
+	ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	// RevokeAPIKey marks the user's key revoked. Returns ErrNotFound if the
+	// user has no such active key.
+	RevokeAPIKey(ctx context.Context, userID, id string) error
+	// TouchAPIKey records the last use of the key.
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.apiKeys {
+		if k.Prefix == key.Prefix {
+			return errors.New("api key prefix collision")
+		}
+	}
+	s.apiKeys[key.ID] = &key
+	return nil
+}
+
+func (s *MemStore) FindAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.apiKeys {
+		if k.Prefix == prefix {
+			key := *k
+			return &key, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemStore) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []APIKey
+	for _, k := range s.apiKeys {
+		if k.UserID == userID && k.RevokedAt == nil {
+			out = append(out, *k)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *MemStore) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.apiKeys[id]
+	if !ok || k.UserID != userID || k.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	return nil
+}
+
+func (s *MemStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.apiKeys[id]; ok {
+		k.LastUsedAt = &at
+	}
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+// apiKeyColumns is the column list read by scanAPIKey.
+const apiKeyColumns = `id, user_id, name, prefix, secret_hash, scopes, expires_at, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row pgx.Row, k *APIKey) error {
+	return row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.SecretHash, &k.Scopes, &k.ExpiresAt, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+}
+
+func (p *PgStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO api_keys (id, user_id, name, prefix, secret_hash, scopes, expires_at, created_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, COALESCE($6::text[], '{}'), $7, $8, $9)`,
+		key.ID, key.UserID, key.Name, key.Prefix, key.SecretHash, key.Scopes, key.ExpiresAt, key.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("create api key: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) FindAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1 AND tenant_id = $2`, prefix, p.tenant)
+	var k APIKey
+	if err := scanAPIKey(row, &k); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find api key: %w", err)
+	}
+	return &k, nil
+}
+
+func (p *PgStore) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys
+		  WHERE user_id = $1 AND tenant_id = $2 AND revoked_at IS NULL
+		  ORDER BY created_at DESC`, userID, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+	defer rows.Close()
+	var out []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := scanAPIKey(rows, &k); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE api_keys SET revoked_at = now()
+		  WHERE id = $1 AND user_id = $2 AND tenant_id = $3 AND revoked_at IS NULL`, id, userID, p.tenant)
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PgStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1 AND tenant_id = $3`, id, at, p.tenant)
+	return err
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AuthorizationCode is an OAuth 2.0 authorization code issued by
+// /oauth2/authorize. Only the hash of the code is stored, together with
+// everything needed to issue tokens when it is redeemed.
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        string
+	SessionID     string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string // PKCE S256 challenge
+	Nonce         string // OpenID Connect nonce, echoed in the id_token
+	AMR           []string
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
+
+// AuthorizationCodeStore persists authorization codes. Codes are single
+// use: ConsumeAuthorizationCode removes the code atomically.
+type AuthorizationCodeStore interface {
+	// SaveAuthorizationCode stores a new code.
+	SaveAuthorizationCode(ctx context.Context, code AuthorizationCode) error
+	// ConsumeAuthorizationCode deletes and returns the unexpired code; nil
+	// if it is unknown, expired or was already redeemed.
+	ConsumeAuthorizationCode(ctx context.Context, hash string) (*AuthorizationCode, error)
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) SaveAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authCodes[code.CodeHash] = code
+	return nil
+}
+
+func (s *MemStore) ConsumeAuthorizationCode(ctx context.Context, hash string) (*AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.authCodes[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.authCodes, hash)
+	if time.Now().After(code.ExpiresAt) {
+		return nil, nil
+	}
+	return &code, nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) SaveAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO oauth_authorization_codes
+		        (code_hash, client_id, user_id, session_id, redirect_uri, scope, code_challenge, nonce, amr, expires_at, created_at, tenant_id)
+		 VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11, $12)`,
+		code.CodeHash, code.ClientID, code.UserID, code.SessionID, code.RedirectURI, code.Scope,
+		code.CodeChallenge, code.Nonce, code.AMR, code.ExpiresAt, code.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save authorization code: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) ConsumeAuthorizationCode(ctx context.Context, hash string) (*AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`DELETE FROM oauth_authorization_codes WHERE code_hash = $1 AND tenant_id = $2
+		 RETURNING code_hash, client_id, user_id, COALESCE(session_id, ''), redirect_uri, scope,
+		           code_challenge, nonce, amr, expires_at, created_at`, hash, p.tenant)
+	var code AuthorizationCode
+	if err := row.Scan(&code.CodeHash, &code.ClientID, &code.UserID, &code.SessionID, &code.RedirectURI, &code.Scope,
+		&code.CodeChallenge, &code.Nonce, &code.AMR, &code.ExpiresAt, &code.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("consume authorization code: %w", err)
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return nil, nil
+	}
+	return &code, nil
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"auth_project/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ClientStore persists registered OAuth 2.0 clients.
+type ClientStore interface {
+	// GetClient returns the client; nil if not found.
+	GetClient(ctx context.Context, id string) (*domain.Client, error)
+	// ListClients returns all clients ordered by creation time.
+	ListClients(ctx context.Context) ([]domain.Client, error)
+	// SaveClient creates or replaces a client.
+	SaveClient(ctx context.Context, c *domain.Client) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) GetClient(ctx context.Context, id string) (*domain.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (s *MemStore) ListClients(ctx context.Context) ([]domain.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]domain.Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *MemStore) SaveClient(ctx context.Context, c *domain.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	s.clients[c.ID] = *c
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+// clientColumns is the column list read by scanClient.
+const clientColumns = `id, name, secret_hash, public, redirect_uris, scopes, service_account, token_exchange, disabled_at, created_at`
+
+func scanClient(row pgx.Row, c *domain.Client) error {
+	return row.Scan(&c.ID, &c.Name, &c.SecretHash, &c.Public, &c.RedirectURIs, &c.Scopes, &c.ServiceAccount, &c.TokenExchange, &c.DisabledAt, &c.CreatedAt)
+}
+
+func (p *PgStore) GetClient(ctx context.Context, id string) (*domain.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx, `SELECT `+clientColumns+` FROM oauth_clients WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	var c domain.Client
+	if err := scanClient(row, &c); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get client: %w", err)
+	}
+	return &c, nil
+}
+
+func (p *PgStore) ListClients(ctx context.Context) ([]domain.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, `SELECT `+clientColumns+` FROM oauth_clients WHERE tenant_id = $1 ORDER BY created_at`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list clients: %w", err)
+	}
+	defer rows.Close()
+	var out []domain.Client
+	for rows.Next() {
+		var c domain.Client
+		if err := scanClient(rows, &c); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// exchangePolicies makes a nil slice encode as an empty JSON array.
+func exchangePolicies(policies []domain.ExchangePolicy) []domain.ExchangePolicy {
+	if policies == nil {
+		return []domain.ExchangePolicy{}
+	}
+	return policies
+}
+
+func (p *PgStore) SaveClient(ctx context.Context, c *domain.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO oauth_clients (id, name, secret_hash, public, redirect_uris, scopes, service_account, token_exchange, disabled_at, created_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, COALESCE($5::text[], '{}'), COALESCE($6::text[], '{}'), $7, $8, $9, $10, $11)
+		 ON CONFLICT (tenant_id, id) DO UPDATE
+		    SET name = EXCLUDED.name,
+		        secret_hash = EXCLUDED.secret_hash,
+		        public = EXCLUDED.public,
+		        redirect_uris = EXCLUDED.redirect_uris,
+		        scopes = EXCLUDED.scopes,
+		        service_account = EXCLUDED.service_account,
+		        token_exchange = EXCLUDED.token_exchange,
+		        disabled_at = EXCLUDED.disabled_at`,
+		c.ID, c.Name, c.SecretHash, c.Public, c.RedirectURIs, c.Scopes, c.ServiceAccount, exchangePolicies(c.TokenExchange), c.DisabledAt, c.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save client: %w", err)
+	}
+	return nil
+}
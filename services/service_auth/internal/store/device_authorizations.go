@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Device authorization statuses.
+const (
+	DevicePending  = "pending"
+	DeviceApproved = "approved"
+	DeviceDenied   = "denied"
+)
+
+// DeviceAuthorization is a pending RFC 8628 device authorization. The
+// device code is kept as a hash; the short user code is stored normalized
+// (upper case, no separator) because the user types it in.
+type DeviceAuthorization struct {
+	DeviceCodeHash string
+	UserCode       string
+	ClientID       string
+	Scope          string
+	Status         string
+	// UserID, SessionID and AMR are set on approval.
+	UserID       string
+	SessionID    string
+	AMR          []string
+	Interval     time.Duration
+	LastPolledAt *time.Time
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// DeviceAuthorizationStore persists device authorizations.
+type DeviceAuthorizationStore interface {
+	// SaveDeviceAuthorization stores a new authorization.
+	SaveDeviceAuthorization(ctx context.Context, d DeviceAuthorization) error
+	// FindDeviceAuthorization returns the unexpired authorization with the
+	// user code; nil if not found.
+	FindDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, error)
+	// DecideDeviceAuthorization approves (userID set) or denies (userID
+	// empty) a pending, unexpired authorization. It returns false if it
+	// was not pending any more.
+	DecideDeviceAuthorization(ctx context.Context, userCode, userID, sessionID string, amr []string) (bool, error)
+	// PollDeviceAuthorization records a token request for the device code
+	// at `at` and returns the authorization as it was before. When the
+	// previous poll was less than the interval ago, the interval grows by
+	// slowDown and tooFast is true. Returns nil if not found.
+	PollDeviceAuthorization(ctx context.Context, deviceCodeHash string, at time.Time, slowDown time.Duration) (d *DeviceAuthorization, tooFast bool, err error)
+	// DeleteDeviceAuthorization removes the authorization once tokens were
+	// issued. It returns false if it was already removed.
+	DeleteDeviceAuthorization(ctx context.Context, deviceCodeHash string) (bool, error)
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) SaveDeviceAuthorization(ctx context.Context, d DeviceAuthorization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.devices {
+		if other.UserCode == d.UserCode && time.Now().Before(other.ExpiresAt) {
+			return errors.New("user code collision")
+		}
+	}
+	s.devices[d.DeviceCodeHash] = &d
+	return nil
+}
+
+func (s *MemStore) FindDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	for _, d := range s.devices {
+		if d.UserCode == userCode && now.Before(d.ExpiresAt) {
+			out := *d
+			return &out, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemStore) DecideDeviceAuthorization(ctx context.Context, userCode, userID, sessionID string, amr []string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, d := range s.devices {
+		if d.UserCode != userCode || !now.Before(d.ExpiresAt) {
+			continue
+		}
+		if d.Status != DevicePending {
+			return false, nil
+		}
+		d.Status = DeviceDenied
+		if userID != "" {
+			d.Status = DeviceApproved
+			d.UserID, d.SessionID, d.AMR = userID, sessionID, amr
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *MemStore) PollDeviceAuthorization(ctx context.Context, deviceCodeHash string, at time.Time, slowDown time.Duration) (*DeviceAuthorization, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[deviceCodeHash]
+	if !ok {
+		return nil, false, nil
+	}
+	prev := *d
+	tooFast := d.LastPolledAt != nil && at.Before(d.LastPolledAt.Add(d.Interval))
+	if tooFast {
+		d.Interval += slowDown
+	}
+	d.LastPolledAt = &at
+	return &prev, tooFast, nil
+}
+
+func (s *MemStore) DeleteDeviceAuthorization(ctx context.Context, deviceCodeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices[deviceCodeHash]; !ok {
+		return false, nil
+	}
+	delete(s.devices, deviceCodeHash)
+	return true, nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+// deviceColumns is the column list read by scanDevice.
+const deviceColumns = `device_code_hash, user_code, client_id, scope, status, COALESCE(user_id, ''),
+	COALESCE(session_id, ''), amr, interval_seconds, last_polled_at, expires_at, created_at`
+
+func scanDevice(row pgx.Row, d *DeviceAuthorization) error {
+	var interval int
+	if err := row.Scan(&d.DeviceCodeHash, &d.UserCode, &d.ClientID, &d.Scope, &d.Status, &d.UserID,
+		&d.SessionID, &d.AMR, &interval, &d.LastPolledAt, &d.ExpiresAt, &d.CreatedAt); err != nil {
+		return err
+	}
+	d.Interval = time.Duration(interval) * time.Second
+	return nil
+}
+
+func (p *PgStore) SaveDeviceAuthorization(ctx context.Context, d DeviceAuthorization) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO device_authorizations
+		        (device_code_hash, user_code, client_id, scope, status, interval_seconds, expires_at, created_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		d.DeviceCodeHash, d.UserCode, d.ClientID, d.Scope, d.Status, int(d.Interval/time.Second), d.ExpiresAt, d.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save device authorization: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) FindDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`SELECT `+deviceColumns+` FROM device_authorizations
+		  WHERE user_code = $1 AND tenant_id = $2 AND expires_at > now()`, userCode, p.tenant)
+	var d DeviceAuthorization
+	if err := scanDevice(row, &d); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find device authorization: %w", err)
+	}
+	return &d, nil
+}
+
+func (p *PgStore) DecideDeviceAuthorization(ctx context.Context, userCode, userID, sessionID string, amr []string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	status := DeviceDenied
+	if userID != "" {
+		status = DeviceApproved
+	}
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE device_authorizations
+		    SET status = $2, user_id = NULLIF($3, ''), session_id = NULLIF($4, ''), amr = $5
+		  WHERE user_code = $1 AND tenant_id = $6 AND status = 'pending' AND expires_at > now()`,
+		userCode, status, userID, sessionID, amr, p.tenant)
+	if err != nil {
+		return false, fmt.Errorf("decide device authorization: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (p *PgStore) PollDeviceAuthorization(ctx context.Context, deviceCodeHash string, at time.Time, slowDown time.Duration) (*DeviceAuthorization, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	row := tx.QueryRow(ctx,
+		`SELECT `+deviceColumns+` FROM device_authorizations WHERE device_code_hash = $1 AND tenant_id = $2 FOR UPDATE`, deviceCodeHash, p.tenant)
+	var d DeviceAuthorization
+	if err := scanDevice(row, &d); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("poll device authorization: %w", err)
+	}
+	tooFast := d.LastPolledAt != nil && at.Before(d.LastPolledAt.Add(d.Interval))
+	interval := d.Interval
+	if tooFast {
+		interval += slowDown
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE device_authorizations SET last_polled_at = $2, interval_seconds = $3 WHERE device_code_hash = $1 AND tenant_id = $4`,
+		deviceCodeHash, at, int(interval/time.Second), p.tenant); err != nil {
+		return nil, false, fmt.Errorf("poll device authorization: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return &d, tooFast, nil
+}
+
+func (p *PgStore) DeleteDeviceAuthorization(ctx context.Context, deviceCodeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx, `DELETE FROM device_authorizations WHERE device_code_hash = $1 AND tenant_id = $2`, deviceCodeHash, p.tenant)
+	if err != nil {
+		return false, fmt.Errorf("delete device authorization: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DirectoryAccount links a user of an LDAP directory, identified by the
+// login domain it is routed by (e.g. "@corp") and the username, to a
+// local user provisioned on first login.
+type DirectoryAccount struct {
+	Domain      string
+	Username    string
+	UserID      string
+	DN          string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// DirectoryAccountStore persists links of directory users.
+type DirectoryAccountStore interface {
+	// FindDirectoryAccount returns the link; nil if not linked.
+	FindDirectoryAccount(ctx context.Context, domain, username string) (*DirectoryAccount, error)
+	// SaveDirectoryAccount creates a link or updates its DN, user and last
+	// login time.
+	SaveDirectoryAccount(ctx context.Context, a DirectoryAccount) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) FindDirectoryAccount(ctx context.Context, domain, username string) (*DirectoryAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.dirAccounts[domain+"\x00"+username]
+	if !ok {
+		return nil, nil
+	}
+	return &a, nil
+}
+
+func (s *MemStore) SaveDirectoryAccount(ctx context.Context, a DirectoryAccount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := a.Domain + "\x00" + a.Username
+	if prev, ok := s.dirAccounts[key]; ok {
+		a.CreatedAt = prev.CreatedAt
+	}
+	s.dirAccounts[key] = a
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) FindDirectoryAccount(ctx context.Context, domain, username string) (*DirectoryAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`SELECT domain, username, user_id, dn, created_at, last_login_at
+		   FROM directory_accounts
+		  WHERE domain = $1 AND username = $2 AND tenant_id = $3`, domain, username, p.tenant)
+	var a DirectoryAccount
+	if err := row.Scan(&a.Domain, &a.Username, &a.UserID, &a.DN, &a.CreatedAt, &a.LastLoginAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find directory account: %w", err)
+	}
+	return &a, nil
+}
+
+func (p *PgStore) SaveDirectoryAccount(ctx context.Context, a DirectoryAccount) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO directory_accounts (domain, username, user_id, dn, created_at, last_login_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (tenant_id, domain, username) DO UPDATE
+		    SET user_id = EXCLUDED.user_id, dn = EXCLUDED.dn, last_login_at = EXCLUDED.last_login_at`,
+		a.Domain, a.Username, a.UserID, a.DN, a.CreatedAt, a.LastLoginAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save directory account: %w", err)
+	}
+	return nil
+}
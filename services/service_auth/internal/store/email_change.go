@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// EmailChangeRecord is a pending email change. Only hashes of the
+// confirmation and cancellation tokens are kept; the plaintext tokens are
+// sent by email and never stored.
+type EmailChangeRecord struct {
+	UserID      string
+	NewEmail    string
+	ConfirmHash string
+	CancelHash  string
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+}
+
+// EmailChangeStore persists pending email changes. A user has at most one
+// pending change; saving a new one replaces the previous request.
+type EmailChangeStore interface {
+	// SaveEmailChange stores (or replaces) the user's pending change.
+	SaveEmailChange(ctx context.Context, rec EmailChangeRecord) error
+	// FindEmailChangeByConfirmHash returns the pending change; nil if not found.
+	FindEmailChangeByConfirmHash(ctx context.Context, hash string) (*EmailChangeRecord, error)
+	// FindEmailChangeByCancelHash returns the pending change; nil if not found.
+	FindEmailChangeByCancelHash(ctx context.Context, hash string) (*EmailChangeRecord, error)
+	// DeleteEmailChange drops the user's pending change, if any.
+	DeleteEmailChange(ctx context.Context, userID string) error
+	// ApplyEmailChange swaps users.email, marks it verified and drops the
+	// pending change atomically. Returns ErrEmailTaken if the address was claimed meanwhile.
+	ApplyEmailChange(ctx context.Context, userID, newEmail string) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) SaveEmailChange(ctx context.Context, rec EmailChangeRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emailChanges[rec.UserID] = rec
+	return nil
+}
+
+func (s *MemStore) FindEmailChangeByConfirmHash(ctx context.Context, hash string) (*EmailChangeRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rec := range s.emailChanges {
+		if rec.ConfirmHash == hash {
+			r := rec
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemStore) FindEmailChangeByCancelHash(ctx context.Context, hash string) (*EmailChangeRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rec := range s.emailChanges {
+		if rec.CancelHash == hash {
+			r := rec
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemStore) DeleteEmailChange(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.emailChanges, userID)
+	return nil
+}
+
+func (s *MemStore) ApplyEmailChange(ctx context.Context, userID, newEmail string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	login, ok := s.byID[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	// та же семантика, что и uniq_users_tenant_email_lower
+	for email, owner := range s.byEmail {
+		if strings.EqualFold(email, newEmail) && owner != login {
+			return ErrEmailTaken
+		}
+	}
+	u := s.byLogin[login]
+	delete(s.byEmail, u.Email)
+	u.Email = newEmail
+	u.EmailVerified = true
+	s.byLogin[login] = u
+	s.byEmail[newEmail] = login
+	delete(s.emailChanges, userID)
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) SaveEmailChange(ctx context.Context, rec EmailChangeRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO email_changes (user_id, new_email, confirm_hash, cancel_hash, expires_at, created_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (user_id) DO UPDATE
+		    SET new_email = EXCLUDED.new_email,
+		        confirm_hash = EXCLUDED.confirm_hash,
+		        cancel_hash = EXCLUDED.cancel_hash,
+		        expires_at = EXCLUDED.expires_at,
+		        created_at = EXCLUDED.created_at`,
+		rec.UserID, rec.NewEmail, rec.ConfirmHash, rec.CancelHash, rec.ExpiresAt, rec.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save email change: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) FindEmailChangeByConfirmHash(ctx context.Context, hash string) (*EmailChangeRecord, error) {
+	return p.findEmailChange(ctx, `confirm_hash = $1`, hash)
+}
+
+func (p *PgStore) FindEmailChangeByCancelHash(ctx context.Context, hash string) (*EmailChangeRecord, error) {
+	return p.findEmailChange(ctx, `cancel_hash = $1`, hash)
+}
+
+func (p *PgStore) findEmailChange(ctx context.Context, where string, arg any) (*EmailChangeRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`SELECT user_id, new_email, confirm_hash, cancel_hash, expires_at, created_at
+		   FROM email_changes WHERE tenant_id = $2 AND `+where, arg, p.tenant)
+	var rec EmailChangeRecord
+	if err := row.Scan(&rec.UserID, &rec.NewEmail, &rec.ConfirmHash, &rec.CancelHash, &rec.ExpiresAt, &rec.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find email change: %w", err)
+	}
+	return &rec, nil
+}
+
+func (p *PgStore) DeleteEmailChange(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx, `DELETE FROM email_changes WHERE user_id = $1 AND tenant_id = $2`, userID, p.tenant)
+	return err
+}
+
+func (p *PgStore) ApplyEmailChange(ctx context.Context, userID, newEmail string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET email = $2, email_verified = TRUE WHERE id = $1 AND tenant_id = $3`, userID, newEmail, p.tenant)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("update email: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM email_changes WHERE user_id = $1 AND tenant_id = $2`, userID, p.tenant); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,291 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"auth_project/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// FederationState is a login redirected to an identity provider, looked
+// up by the hash of the `state` parameter when the provider redirects
+// back. For SAML the state is the RelayState and Nonce the ID of the
+// AuthnRequest.
+type FederationState struct {
+	StateHash    string
+	ProviderID   string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// FederationStore persists identity providers, the links of users to
+// their upstream accounts and pending federated logins.
+type FederationStore interface {
+	// GetIdentityProvider returns the provider; nil if not found.
+	GetIdentityProvider(ctx context.Context, id string) (*domain.IdentityProvider, error)
+	// ListIdentityProviders returns all providers ordered by creation time.
+	ListIdentityProviders(ctx context.Context) ([]domain.IdentityProvider, error)
+	// SaveIdentityProvider creates or replaces a provider.
+	SaveIdentityProvider(ctx context.Context, p *domain.IdentityProvider) error
+	// DeleteIdentityProvider removes a provider and its links. Returns
+	// ErrNotFound if there is none.
+	DeleteIdentityProvider(ctx context.Context, id string) error
+	// FindFederatedIdentity returns the link of an upstream account; nil
+	// if not linked.
+	FindFederatedIdentity(ctx context.Context, providerID, subject string) (*domain.FederatedIdentity, error)
+	// SaveFederatedIdentity creates a link or updates its email and last
+	// login time.
+	SaveFederatedIdentity(ctx context.Context, fi domain.FederatedIdentity) error
+	// SaveFederationState stores a pending login.
+	SaveFederationState(ctx context.Context, st FederationState) error
+	// ConsumeFederationState deletes and returns an unexpired pending
+	// login; nil if not found.
+	ConsumeFederationState(ctx context.Context, stateHash string) (*FederationState, error)
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) GetIdentityProvider(ctx context.Context, id string) (*domain.IdentityProvider, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.idps[id]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (s *MemStore) ListIdentityProviders(ctx context.Context) ([]domain.IdentityProvider, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]domain.IdentityProvider, 0, len(s.idps))
+	for _, p := range s.idps {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *MemStore) SaveIdentityProvider(ctx context.Context, p *domain.IdentityProvider) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+	s.idps[p.ID] = *p
+	return nil
+}
+
+func (s *MemStore) DeleteIdentityProvider(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.idps[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.idps, id)
+	for k, fi := range s.fedIdentities {
+		if fi.ProviderID == id {
+			delete(s.fedIdentities, k)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) FindFederatedIdentity(ctx context.Context, providerID, subject string) (*domain.FederatedIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fi, ok := s.fedIdentities[providerID+"\x00"+subject]
+	if !ok {
+		return nil, nil
+	}
+	return &fi, nil
+}
+
+func (s *MemStore) SaveFederatedIdentity(ctx context.Context, fi domain.FederatedIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fi.ProviderID + "\x00" + fi.Subject
+	if prev, ok := s.fedIdentities[key]; ok {
+		fi.UserID, fi.CreatedAt = prev.UserID, prev.CreatedAt
+	}
+	s.fedIdentities[key] = fi
+	return nil
+}
+
+func (s *MemStore) SaveFederationState(ctx context.Context, st FederationState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fedStates[st.StateHash] = st
+	return nil
+}
+
+func (s *MemStore) ConsumeFederationState(ctx context.Context, stateHash string) (*FederationState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.fedStates[stateHash]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.fedStates, stateHash)
+	if !time.Now().Before(st.ExpiresAt) {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+// identityProviderColumns is the column list read by scanIdentityProvider.
+const identityProviderColumns = `id, name, protocol, issuer, client_id, client_secret_enc, scopes, sso_url, certificates, claims, disabled_at, created_at`
+
+func scanIdentityProvider(row pgx.Row, p *domain.IdentityProvider) error {
+	return row.Scan(&p.ID, &p.Name, &p.Protocol, &p.Issuer, &p.ClientID, &p.ClientSecretEnc, &p.Scopes, &p.SSOURL, &p.Certificates, &p.Claims, &p.DisabledAt, &p.CreatedAt)
+}
+
+func (p *PgStore) GetIdentityProvider(ctx context.Context, id string) (*domain.IdentityProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx, `SELECT `+identityProviderColumns+` FROM identity_providers WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	var idp domain.IdentityProvider
+	if err := scanIdentityProvider(row, &idp); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get identity provider: %w", err)
+	}
+	return &idp, nil
+}
+
+func (p *PgStore) ListIdentityProviders(ctx context.Context) ([]domain.IdentityProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, `SELECT `+identityProviderColumns+` FROM identity_providers WHERE tenant_id = $1 ORDER BY created_at`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list identity providers: %w", err)
+	}
+	defer rows.Close()
+	var out []domain.IdentityProvider
+	for rows.Next() {
+		var idp domain.IdentityProvider
+		if err := scanIdentityProvider(rows, &idp); err != nil {
+			return nil, err
+		}
+		out = append(out, idp)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) SaveIdentityProvider(ctx context.Context, idp *domain.IdentityProvider) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if idp.CreatedAt.IsZero() {
+		idp.CreatedAt = time.Now()
+	}
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO identity_providers (id, name, protocol, issuer, client_id, client_secret_enc, scopes, sso_url, certificates, claims, disabled_at, created_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7::text[], '{}'), $8, COALESCE($9::text[], '{}'), $10, $11, $12, $13)
+		 ON CONFLICT (tenant_id, id) DO UPDATE
+		    SET name = EXCLUDED.name,
+		        protocol = EXCLUDED.protocol,
+		        issuer = EXCLUDED.issuer,
+		        client_id = EXCLUDED.client_id,
+		        client_secret_enc = EXCLUDED.client_secret_enc,
+		        scopes = EXCLUDED.scopes,
+		        sso_url = EXCLUDED.sso_url,
+		        certificates = EXCLUDED.certificates,
+		        claims = EXCLUDED.claims,
+		        disabled_at = EXCLUDED.disabled_at`,
+		idp.ID, idp.Name, idp.Protocol, idp.Issuer, idp.ClientID, idp.ClientSecretEnc, idp.Scopes, idp.SSOURL, idp.Certificates, idp.Claims, idp.DisabledAt, idp.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save identity provider: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) DeleteIdentityProvider(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx, `DELETE FROM identity_providers WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	if err != nil {
+		return fmt.Errorf("delete identity provider: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PgStore) FindFederatedIdentity(ctx context.Context, providerID, subject string) (*domain.FederatedIdentity, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`SELECT provider_id, subject, user_id, email, created_at, last_login_at
+		   FROM federated_identities
+		  WHERE provider_id = $1 AND subject = $2 AND tenant_id = $3`, providerID, subject, p.tenant)
+	var fi domain.FederatedIdentity
+	if err := row.Scan(&fi.ProviderID, &fi.Subject, &fi.UserID, &fi.Email, &fi.CreatedAt, &fi.LastLoginAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find federated identity: %w", err)
+	}
+	return &fi, nil
+}
+
+func (p *PgStore) SaveFederatedIdentity(ctx context.Context, fi domain.FederatedIdentity) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO federated_identities (provider_id, subject, user_id, email, created_at, last_login_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (tenant_id, provider_id, subject) DO UPDATE
+		    SET email = EXCLUDED.email, last_login_at = EXCLUDED.last_login_at`,
+		fi.ProviderID, fi.Subject, fi.UserID, fi.Email, fi.CreatedAt, fi.LastLoginAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save federated identity: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) SaveFederationState(ctx context.Context, st FederationState) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO federation_states (state_hash, provider_id, nonce, code_verifier, expires_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		st.StateHash, st.ProviderID, st.Nonce, st.CodeVerifier, st.ExpiresAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save federation state: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) ConsumeFederationState(ctx context.Context, stateHash string) (*FederationState, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`DELETE FROM federation_states
+		  WHERE state_hash = $1 AND tenant_id = $2
+		  RETURNING state_hash, provider_id, nonce, code_verifier, expires_at`, stateHash, p.tenant)
+	var st FederationState
+	if err := row.Scan(&st.StateHash, &st.ProviderID, &st.Nonce, &st.CodeVerifier, &st.ExpiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("consume federation state: %w", err)
+	}
+	if !time.Now().Before(st.ExpiresAt) {
+		return nil, nil
+	}
+	return &st, nil
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Invitation lets the holder of the emailed invitation token register an
+// account for Email with pre-assigned roles and organizations.
+type Invitation struct {
+	ID        string
+	Email     string
+	Roles     []string
+	OrgIDs    []int
+	InvitedBy string
+	ExpiresAt time.Time
+	// AcceptedAt and UserID are set once the invitation was used.
+	AcceptedAt *time.Time
+	UserID     string
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// Pending reports whether the invitation can still be accepted.
+func (inv *Invitation) Pending(now time.Time) bool {
+	return inv.AcceptedAt == nil && inv.RevokedAt == nil && now.Before(inv.ExpiresAt)
+}
+
+// InvitationStore keeps invitations.
+type InvitationStore interface {
+	// CreateInvitation stores a new invitation.
+	CreateInvitation(ctx context.Context, inv *Invitation) error
+	// GetInvitation returns the invitation; nil if not found.
+	GetInvitation(ctx context.Context, id string) (*Invitation, error)
+	// FindPendingInvitation returns the newest invitation for email,
+	// compared case-insensitively, that is pending at now; nil if there is
+	// none.
+	FindPendingInvitation(ctx context.Context, email string, now time.Time) (*Invitation, error)
+	// ListInvitations returns all invitations, newest first.
+	ListInvitations(ctx context.Context) ([]Invitation, error)
+	// RevokeInvitation revokes a pending invitation. Returns ErrNotFound
+	// if there is no pending invitation with that id.
+	RevokeInvitation(ctx context.Context, id string, at time.Time) error
+	// AcceptInvitation marks a pending invitation as used by userID.
+	// Returns ErrNotFound if there is no pending invitation with that id,
+	// so an invitation is accepted at most once.
+	AcceptInvitation(ctx context.Context, id, userID string, at time.Time) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) CreateInvitation(ctx context.Context, inv *Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inv.CreatedAt.IsZero() {
+		inv.CreatedAt = time.Now()
+	}
+	s.invitations[inv.ID] = *inv
+	return nil
+}
+
+func (s *MemStore) GetInvitation(ctx context.Context, id string) (*Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	inv, ok := s.invitations[id]
+	if !ok {
+		return nil, nil
+	}
+	return &inv, nil
+}
+
+func (s *MemStore) FindPendingInvitation(ctx context.Context, email string, now time.Time) (*Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found *Invitation
+	for _, inv := range s.invitations {
+		if strings.EqualFold(inv.Email, email) && inv.Pending(now) && (found == nil || inv.CreatedAt.After(found.CreatedAt)) {
+			found = &inv
+		}
+	}
+	return found, nil
+}
+
+func (s *MemStore) ListInvitations(ctx context.Context) ([]Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Invitation, 0, len(s.invitations))
+	for _, inv := range s.invitations {
+		out = append(out, inv)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *MemStore) RevokeInvitation(ctx context.Context, id string, at time.Time) error {
+	return s.updateInvitation(id, at, func(inv *Invitation) { inv.RevokedAt = &at })
+}
+
+func (s *MemStore) AcceptInvitation(ctx context.Context, id, userID string, at time.Time) error {
+	return s.updateInvitation(id, at, func(inv *Invitation) { inv.AcceptedAt, inv.UserID = &at, userID })
+}
+
+// updateInvitation applies fn to a pending invitation.
+func (s *MemStore) updateInvitation(id string, now time.Time, fn func(*Invitation)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok := s.invitations[id]
+	if !ok || !inv.Pending(now) {
+		return ErrNotFound
+	}
+	fn(&inv)
+	s.invitations[id] = inv
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+// invitationColumns is the column list read by scanInvitation.
+const invitationColumns = `id, email, roles, org_ids, invited_by, expires_at, accepted_at, COALESCE(user_id, ''), revoked_at, created_at`
+
+func scanInvitation(row pgx.Row, inv *Invitation) error {
+	return row.Scan(&inv.ID, &inv.Email, &inv.Roles, &inv.OrgIDs, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.UserID, &inv.RevokedAt, &inv.CreatedAt)
+}
+
+func (p *PgStore) CreateInvitation(ctx context.Context, inv *Invitation) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if inv.CreatedAt.IsZero() {
+		inv.CreatedAt = time.Now()
+	}
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO invitations (id, email, roles, org_ids, invited_by, expires_at, created_at, tenant_id)
+		 VALUES ($1, $2, COALESCE($3::text[], '{}'), COALESCE($4::integer[], '{}'), $5, $6, $7, $8)`,
+		inv.ID, inv.Email, inv.Roles, inv.OrgIDs, inv.InvitedBy, inv.ExpiresAt, inv.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("create invitation: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) GetInvitation(ctx context.Context, id string) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx, `SELECT `+invitationColumns+` FROM invitations WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	var inv Invitation
+	if err := scanInvitation(row, &inv); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get invitation: %w", err)
+	}
+	return &inv, nil
+}
+
+func (p *PgStore) FindPendingInvitation(ctx context.Context, email string, now time.Time) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`SELECT `+invitationColumns+` FROM invitations
+		 WHERE tenant_id = $1 AND LOWER(email) = LOWER($2)
+		   AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $3
+		 ORDER BY created_at DESC LIMIT 1`, p.tenant, email, now)
+	var inv Invitation
+	if err := scanInvitation(row, &inv); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find pending invitation: %w", err)
+	}
+	return &inv, nil
+}
+
+func (p *PgStore) ListInvitations(ctx context.Context) ([]Invitation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, `SELECT `+invitationColumns+` FROM invitations WHERE tenant_id = $1 ORDER BY created_at DESC`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list invitations: %w", err)
+	}
+	defer rows.Close()
+	var out []Invitation
+	for rows.Next() {
+		var inv Invitation
+		if err := scanInvitation(rows, &inv); err != nil {
+			return nil, err
+		}
+		out = append(out, inv)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) RevokeInvitation(ctx context.Context, id string, at time.Time) error {
+	return p.updateInvitation(ctx, `UPDATE invitations SET revoked_at = $3`, id, at)
+}
+
+func (p *PgStore) AcceptInvitation(ctx context.Context, id, userID string, at time.Time) error {
+	return p.updateInvitation(ctx, `UPDATE invitations SET accepted_at = $3, user_id = $4`, id, at, userID)
+}
+
+// updateInvitation runs the UPDATE prefix set on a pending invitation; $1
+// is the id, $2 the tenant and $3 the current time.
+func (p *PgStore) updateInvitation(ctx context.Context, set, id string, at time.Time, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		set+` WHERE id = $1 AND tenant_id = $2
+		   AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $3`,
+		append([]any{id, p.tenant, at}, args...)...)
+	if err != nil {
+		return fmt.Errorf("update invitation: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
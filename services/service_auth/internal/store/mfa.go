@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TOTPRecord holds a user's TOTP enrollment. The shared secret is stored
+// encrypted (see seal.Box); Confirmed becomes true once the user proves
+// possession with a first valid code. LastStep is the last accepted time
+// step and prevents replaying a code within its validity window.
+type TOTPRecord struct {
+	UserID      string
+	SecretEnc   string
+	Confirmed   bool
+	LastStep    int64
+	CreatedAt   time.Time
+	ConfirmedAt *time.Time
+}
+
+// MFAChallenge tracks an mfa_required challenge issued by Login. ID is the
+// hash of the challenge token.
+type MFAChallenge struct {
+	ID        string
+	UserID    string
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// MFAStore persists second-factor enrollments and the challenges waiting
+// for a second factor. Attempt limits and single use of challenges are
+// enforced by the store so that concurrent requests cannot bypass them.
+type MFAStore interface {
+	// GetTOTP returns the user's enrollment; nil if not found.
+	GetTOTP(ctx context.Context, userID string) (*TOTPRecord, error)
+	// SaveTOTP stores (or replaces) the user's enrollment.
+	SaveTOTP(ctx context.Context, rec TOTPRecord) error
+	// DeleteTOTP removes the user's enrollment, if any.
+	DeleteTOTP(ctx context.Context, userID string) error
+	// UseTOTPStep records step as used. It returns false if a step at or
+	// after it was already accepted (replay).
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	// SaveMFAChallenge stores a new challenge.
+	SaveMFAChallenge(ctx context.Context, ch MFAChallenge) error
+	// RecordMFAAttempt counts a verification attempt. It returns false if
+	// the challenge is unknown, used, expired or out of attempts.
+	RecordMFAAttempt(ctx context.Context, id string, maxAttempts int) (bool, error)
+	// ConsumeMFAChallenge marks the challenge as used. It returns false if
+	// it was already used or has expired.
+	ConsumeMFAChallenge(ctx context.Context, id string) (bool, error)
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) GetTOTP(ctx context.Context, userID string) (*TOTPRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.totp[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+func (s *MemStore) SaveTOTP(ctx context.Context, rec TOTPRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totp[rec.UserID] = rec
+	return nil
+}
+
+func (s *MemStore) DeleteTOTP(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.totp, userID)
+	return nil
+}
+
+func (s *MemStore) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.totp[userID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if rec.LastStep >= step {
+		return false, nil
+	}
+	rec.LastStep = step
+	s.totp[userID] = rec
+	return true, nil
+}
+
+func (s *MemStore) SaveMFAChallenge(ctx context.Context, ch MFAChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mfaChallenges[ch.ID] = &ch
+	return nil
+}
+
+func (s *MemStore) RecordMFAAttempt(ctx context.Context, id string, maxAttempts int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.mfaChallenges[id]
+	if !ok || ch.UsedAt != nil || time.Now().After(ch.ExpiresAt) || ch.Attempts >= maxAttempts {
+		return false, nil
+	}
+	ch.Attempts++
+	return true, nil
+}
+
+func (s *MemStore) ConsumeMFAChallenge(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.mfaChallenges[id]
+	if !ok || ch.UsedAt != nil || time.Now().After(ch.ExpiresAt) {
+		return false, nil
+	}
+	now := time.Now()
+	ch.UsedAt = &now
+	return true, nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) GetTOTP(ctx context.Context, userID string) (*TOTPRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`SELECT user_id, secret_enc, confirmed, last_step, created_at, confirmed_at
+		   FROM user_totp WHERE user_id = $1 AND tenant_id = $2`, userID, p.tenant)
+	var rec TOTPRecord
+	if err := row.Scan(&rec.UserID, &rec.SecretEnc, &rec.Confirmed, &rec.LastStep, &rec.CreatedAt, &rec.ConfirmedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get totp: %w", err)
+	}
+	return &rec, nil
+}
+
+func (p *PgStore) SaveTOTP(ctx context.Context, rec TOTPRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO user_totp (user_id, secret_enc, confirmed, last_step, created_at, confirmed_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (user_id) DO UPDATE
+		    SET secret_enc = EXCLUDED.secret_enc,
+		        confirmed = EXCLUDED.confirmed,
+		        last_step = EXCLUDED.last_step,
+		        created_at = EXCLUDED.created_at,
+		        confirmed_at = EXCLUDED.confirmed_at`,
+		rec.UserID, rec.SecretEnc, rec.Confirmed, rec.LastStep, rec.CreatedAt, rec.ConfirmedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save totp: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) DeleteTOTP(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1 AND tenant_id = $2`, userID, p.tenant)
+	return err
+}
+
+func (p *PgStore) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND tenant_id = $3 AND last_step < $2`, userID, step, p.tenant)
+	if err != nil {
+		return false, fmt.Errorf("use totp step: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (p *PgStore) SaveMFAChallenge(ctx context.Context, ch MFAChallenge) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO mfa_challenges (id, user_id, attempts, expires_at, created_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		ch.ID, ch.UserID, ch.Attempts, ch.ExpiresAt, ch.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save mfa challenge: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) RecordMFAAttempt(ctx context.Context, id string, maxAttempts int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE mfa_challenges SET attempts = attempts + 1
+		  WHERE id = $1 AND tenant_id = $3 AND used_at IS NULL AND expires_at > now() AND attempts < $2`, id, maxAttempts, p.tenant)
+	if err != nil {
+		return false, fmt.Errorf("record mfa attempt: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (p *PgStore) ConsumeMFAChallenge(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE mfa_challenges SET used_at = now()
+		  WHERE id = $1 AND tenant_id = $2 AND used_at IS NULL AND expires_at > now()`, id, p.tenant)
+	if err != nil {
+		return false, fmt.Errorf("consume mfa challenge: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"auth_project/internal/domain"
+)
+
+// ErrCitizenLinked is returned when the citizen is already linked to
+// another account.
+var ErrCitizenLinked = errors.New("citizen already linked to another user")
+
+// OrgLinkStore keeps the links of users to OrgDirectory citizens and
+// organizations.
+type OrgLinkStore interface {
+	// SetUserCitizen links the user to a citizen, or unlinks it when
+	// citizenID is empty. Returns ErrCitizenLinked or ErrNotFound.
+	SetUserCitizen(ctx context.Context, userID, citizenID string) error
+	// SetUserOrganizations replaces the organizations of the user.
+	SetUserOrganizations(ctx context.Context, userID string, orgIDs []int) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) SetUserCitizen(ctx context.Context, userID, citizenID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if citizenID != "" {
+		for _, u := range s.byLogin {
+			if u.CitizenID == citizenID && u.ID != userID {
+				return ErrCitizenLinked
+			}
+		}
+	}
+	return s.updateUser(userID, func(u *domain.User) { u.CitizenID = citizenID })
+}
+
+func (s *MemStore) SetUserOrganizations(ctx context.Context, userID string, orgIDs []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateUser(userID, func(u *domain.User) { u.OrgIDs = append([]int(nil), orgIDs...) })
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) SetUserCitizen(ctx context.Context, userID, citizenID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx, `UPDATE users SET citizen_id = NULLIF($2, '')::uuid WHERE id = $1 AND tenant_id = $3`, userID, citizenID, p.tenant)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrCitizenLinked
+		}
+		return fmt.Errorf("set user citizen: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PgStore) SetUserOrganizations(ctx context.Context, userID string, orgIDs []int) error {
+	return p.updateUser(ctx, `UPDATE users SET org_ids = COALESCE($2::integer[], '{}') WHERE id = $1 AND tenant_id = $3`, userID, orgIDs, p.tenant)
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PasswordlessChallenge is an emailed magic link or one-time code. Only
+// the hash of the secret is stored.
+type PasswordlessChallenge struct {
+	ID         string
+	UserID     string
+	Method     string // "link" or "code"
+	SecretHash string
+	Attempts   int
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	UsedAt     *time.Time
+}
+
+// PasswordlessStore persists passwordless login challenges. Attempt limits,
+// expiry and single use are enforced by the store so that concurrent
+// requests cannot bypass them.
+type PasswordlessStore interface {
+	// SavePasswordlessChallenge stores a new challenge.
+	SavePasswordlessChallenge(ctx context.Context, ch PasswordlessChallenge) error
+	// GetPasswordlessChallenge returns the challenge; nil if not found.
+	GetPasswordlessChallenge(ctx context.Context, id string) (*PasswordlessChallenge, error)
+	// RecordPasswordlessAttempt counts a verification attempt. It returns
+	// false if the challenge is used, expired or out of attempts.
+	RecordPasswordlessAttempt(ctx context.Context, id string, maxAttempts int) (bool, error)
+	// ConsumePasswordlessChallenge marks the challenge as used. It returns
+	// false if it was already used or has expired.
+	ConsumePasswordlessChallenge(ctx context.Context, id string) (bool, error)
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) SavePasswordlessChallenge(ctx context.Context, ch PasswordlessChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwordless[ch.ID] = &ch
+	return nil
+}
+
+func (s *MemStore) GetPasswordlessChallenge(ctx context.Context, id string) (*PasswordlessChallenge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ch, ok := s.passwordless[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *ch
+	return &c, nil
+}
+
+func (s *MemStore) RecordPasswordlessAttempt(ctx context.Context, id string, maxAttempts int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.passwordless[id]
+	if !ok || ch.UsedAt != nil || time.Now().After(ch.ExpiresAt) || ch.Attempts >= maxAttempts {
+		return false, nil
+	}
+	ch.Attempts++
+	return true, nil
+}
+
+func (s *MemStore) ConsumePasswordlessChallenge(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.passwordless[id]
+	if !ok || ch.UsedAt != nil || time.Now().After(ch.ExpiresAt) {
+		return false, nil
+	}
+	now := time.Now()
+	ch.UsedAt = &now
+	return true, nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) SavePasswordlessChallenge(ctx context.Context, ch PasswordlessChallenge) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO passwordless_challenges (id, user_id, method, secret_hash, attempts, expires_at, created_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		ch.ID, ch.UserID, ch.Method, ch.SecretHash, ch.Attempts, ch.ExpiresAt, ch.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save passwordless challenge: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) GetPasswordlessChallenge(ctx context.Context, id string) (*PasswordlessChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`SELECT id, user_id, method, secret_hash, attempts, expires_at, created_at, used_at
+		   FROM passwordless_challenges WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	var ch PasswordlessChallenge
+	if err := row.Scan(&ch.ID, &ch.UserID, &ch.Method, &ch.SecretHash, &ch.Attempts, &ch.ExpiresAt, &ch.CreatedAt, &ch.UsedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get passwordless challenge: %w", err)
+	}
+	return &ch, nil
+}
+
+func (p *PgStore) RecordPasswordlessAttempt(ctx context.Context, id string, maxAttempts int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE passwordless_challenges SET attempts = attempts + 1
+		  WHERE id = $1 AND tenant_id = $3 AND used_at IS NULL AND expires_at > now() AND attempts < $2`, id, maxAttempts, p.tenant)
+	if err != nil {
+		return false, fmt.Errorf("record passwordless attempt: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (p *PgStore) ConsumePasswordlessChallenge(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE passwordless_challenges SET used_at = now()
+		  WHERE id = $1 AND tenant_id = $2 AND used_at IS NULL AND expires_at > now()`, id, p.tenant)
+	if err != nil {
+		return false, fmt.Errorf("consume passwordless challenge: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
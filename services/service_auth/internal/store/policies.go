@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PolicyVersion is a version of the authorization policy. Versions are
+// never changed; the latest one is in force.
+type PolicyVersion struct {
+	Version int
+	// Document is the policy as JSON, see policy.Document.
+	Document  []byte
+	Comment   string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// PolicyStore keeps the versions of the authorization policy.
+type PolicyStore interface {
+	// CreatePolicyVersion stores v as the next version and sets
+	// v.Version.
+	CreatePolicyVersion(ctx context.Context, v *PolicyVersion) error
+	// LatestPolicyVersion returns the version in force; nil if there is
+	// none.
+	LatestPolicyVersion(ctx context.Context) (*PolicyVersion, error)
+	// GetPolicyVersion returns a version; nil if not found.
+	GetPolicyVersion(ctx context.Context, version int) (*PolicyVersion, error)
+	// ListPolicyVersions returns all versions, newest first.
+	ListPolicyVersions(ctx context.Context) ([]PolicyVersion, error)
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) CreatePolicyVersion(ctx context.Context, v *PolicyVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v.Version = len(s.policies) + 1
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	s.policies = append(s.policies, *v)
+	return nil
+}
+
+func (s *MemStore) LatestPolicyVersion(ctx context.Context) (*PolicyVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.policies) == 0 {
+		return nil, nil
+	}
+	v := s.policies[len(s.policies)-1]
+	return &v, nil
+}
+
+func (s *MemStore) GetPolicyVersion(ctx context.Context, version int) (*PolicyVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if version < 1 || version > len(s.policies) {
+		return nil, nil
+	}
+	v := s.policies[version-1]
+	return &v, nil
+}
+
+func (s *MemStore) ListPolicyVersions(ctx context.Context) ([]PolicyVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := append([]PolicyVersion(nil), s.policies...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Version > out[j].Version })
+	return out, nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+// policyColumns is the column list read by scanPolicyVersion.
+const policyColumns = `version, document, comment, created_by, created_at`
+
+func scanPolicyVersion(row pgx.Row, v *PolicyVersion) error {
+	return row.Scan(&v.Version, &v.Document, &v.Comment, &v.CreatedBy, &v.CreatedAt)
+}
+
+func (p *PgStore) CreatePolicyVersion(ctx context.Context, v *PolicyVersion) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	// two instances publishing at once race for the same number; the
+	// loser retries with the next one
+	for attempt := 0; ; attempt++ {
+		err := p.pool.QueryRow(ctx,
+			`INSERT INTO authz_policies (tenant_id, version, document, comment, created_by, created_at)
+			 SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5 FROM authz_policies WHERE tenant_id = $1
+			 RETURNING version`,
+			p.tenant, v.Document, v.Comment, v.CreatedBy, v.CreatedAt).Scan(&v.Version)
+		if err == nil {
+			return nil
+		}
+		if !isUniqueViolation(err) || attempt == 2 {
+			return fmt.Errorf("create policy version: %w", err)
+		}
+	}
+}
+
+func (p *PgStore) LatestPolicyVersion(ctx context.Context) (*PolicyVersion, error) {
+	return p.findPolicyVersion(ctx, `SELECT `+policyColumns+` FROM authz_policies WHERE tenant_id = $1 ORDER BY version DESC LIMIT 1`, p.tenant)
+}
+
+func (p *PgStore) GetPolicyVersion(ctx context.Context, version int) (*PolicyVersion, error) {
+	return p.findPolicyVersion(ctx, `SELECT `+policyColumns+` FROM authz_policies WHERE tenant_id = $1 AND version = $2`, p.tenant, version)
+}
+
+func (p *PgStore) findPolicyVersion(ctx context.Context, sql string, args ...any) (*PolicyVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var v PolicyVersion
+	if err := scanPolicyVersion(p.pool.QueryRow(ctx, sql, args...), &v); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find policy version: %w", err)
+	}
+	return &v, nil
+}
+
+func (p *PgStore) ListPolicyVersions(ctx context.Context) ([]PolicyVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, `SELECT `+policyColumns+` FROM authz_policies WHERE tenant_id = $1 ORDER BY version DESC`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list policy versions: %w", err)
+	}
+	defer rows.Close()
+	var out []PolicyVersion
+	for rows.Next() {
+		var v PolicyVersion
+		if err := scanPolicyVersion(rows, &v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
@@ -9,6 +9,7 @@ import (
 	"auth_project/internal/domain"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -38,6 +39,8 @@ import (
 // context from request handlers.
 type PgStore struct {
 	pool *pgxpool.Pool
+	// tenant scopes every query to the rows of one tenant, see ForTenant.
+	tenant string
 }
 
 // NewPgStore connects to the Postgres database using the provided DSN.
@@ -46,7 +49,13 @@ func NewPgStore(dsn string) (*PgStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &PgStore{pool: pool}, nil
+	return &PgStore{pool: pool, tenant: DefaultTenant}, nil
+}
+
+// ForTenant returns a store sharing the connection pool that reads and
+// writes the rows of the tenant only.
+func (p *PgStore) ForTenant(id string) UserStore {
+	return &PgStore{pool: p.pool, tenant: id}
 }
 
 // Close releases the connection pool.
@@ -57,15 +66,21 @@ func (p *PgStore) Close() {
 func (p *PgStore) CreateUser(ctx context.Context, u *domain.User) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
 	_, err := p.pool.Exec(
 		ctx,
-		`INSERT INTO users (id, login, email, password_hash, created_at)
-         VALUES ($1, $2, $3, $4, $5)`,
+		`INSERT INTO users (id, login, email, password_hash, created_at, roles, external_id, tenant_id)
+         VALUES ($1, $2, $3, $4, $5, COALESCE($6::text[], '{}'), NULLIF($7, ''), $8)`,
 		u.ID,
 		u.Login,
 		u.Email,
 		u.PasswordHash, //  hash
-		time.Now(),
+		u.CreatedAt,
+		u.Roles,
+		u.ExternalID,
+		p.tenant,
 	)
 	if err != nil {
 		return fmt.Errorf("create user: %w", err)
@@ -76,9 +91,9 @@ func (p *PgStore) CreateUser(ctx context.Context, u *domain.User) error {
 func (p *PgStore) FindByEmail(ctx context.Context, email string) (*domain.User, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	row := p.pool.QueryRow(ctx, `SELECT id, login, email, password_hash, created_at FROM users WHERE LOWER(email) = LOWER($1)`, email)
+	row := p.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE LOWER(email) = LOWER($1) AND tenant_id = $2`, email, p.tenant)
 	var u domain.User
-	if err := row.Scan(&u.ID, &u.Login, &u.Email, &u.PasswordHash, &u.CreatedAt); err != nil {
+	if err := scanUser(row, &u); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -87,19 +102,33 @@ func (p *PgStore) FindByEmail(ctx context.Context, email string) (*domain.User,
 	return &u, nil
 }
 
+func (p *PgStore) FindByID(ctx context.Context, id string) (*domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	var u domain.User
+	if err := scanUser(row, &u); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find by id: %w", err)
+	}
+	return &u, nil
+}
+
 func (p *PgStore) SaveRefreshToken(ctx context.Context, token string, rec RefreshRecord) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	_, err := p.pool.Exec(ctx, `INSERT INTO refresh_tokens (token, user_id, expires_at, revoked) VALUES ($1, $2, $3, $4)`, token, rec.UserID, rec.ExpiresAt, rec.Revoked)
+	_, err := p.pool.Exec(ctx, `INSERT INTO refresh_tokens (token, user_id, expires_at, revoked, amr, session_id, client_id, scope, dpop_jkt, tenant_id) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8, NULLIF($9, ''), $10)`, token, rec.UserID, rec.ExpiresAt, rec.Revoked, rec.AMR, rec.SessionID, rec.ClientID, rec.Scope, rec.JKT, p.tenant)
 	return err
 }
 
 func (p *PgStore) GetRefreshToken(ctx context.Context, token string) (*RefreshRecord, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	row := p.pool.QueryRow(ctx, `SELECT user_id, expires_at, revoked FROM refresh_tokens WHERE token = $1`, token)
+	row := p.pool.QueryRow(ctx, `SELECT user_id, expires_at, revoked, amr, COALESCE(session_id, ''), COALESCE(client_id, ''), scope, COALESCE(dpop_jkt, '') FROM refresh_tokens WHERE token = $1 AND tenant_id = $2`, token, p.tenant)
 	var rec RefreshRecord
-	if err := row.Scan(&rec.UserID, &rec.ExpiresAt, &rec.Revoked); err != nil {
+	if err := row.Scan(&rec.UserID, &rec.ExpiresAt, &rec.Revoked, &rec.AMR, &rec.SessionID, &rec.ClientID, &rec.Scope, &rec.JKT); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -109,12 +138,12 @@ func (p *PgStore) GetRefreshToken(ctx context.Context, token string) (*RefreshRe
 }
 func (p *PgStore) FindByLogin(ctx context.Context, login string) (domain.User, error) {
 	row := p.pool.QueryRow(ctx,
-		`SELECT id, login, email, password_hash, created_at
+		`SELECT `+userColumns+`
 		   FROM users
-		  WHERE login = $1`, login)
+		  WHERE login = $1 AND tenant_id = $2`, login, p.tenant)
 
 	var u domain.User
-	if err := row.Scan(&u.ID, &u.Login, &u.Email, &u.PasswordHash, &u.CreatedAt); err != nil {
+	if err := scanUser(row, &u); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return domain.User{}, nil // не найдено
 		}
@@ -125,6 +154,21 @@ func (p *PgStore) FindByLogin(ctx context.Context, login string) (domain.User, e
 func (p *PgStore) RevokeRefreshToken(ctx context.Context, token string) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	_, err := p.pool.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE token = $1`, token)
+	_, err := p.pool.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE token = $1 AND tenant_id = $2`, token, p.tenant)
 	return err
 }
+
+// userColumns is the column list read by scanUser.
+const userColumns = `id, login, email, password_hash, created_at, roles, disabled_at, email_verified, COALESCE(external_id, ''), COALESCE(citizen_id::text, ''), org_ids`
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row pgx.Row, u *domain.User) error {
+	return row.Scan(&u.ID, &u.Login, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.Roles, &u.DisabledAt, &u.EmailVerified, &u.ExternalID, &u.CitizenID, &u.OrgIDs)
+}
+
+// isUniqueViolation reports whether err is a Postgres unique_violation
+// (SQLSTATE 23505).
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,360 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrLoginTaken is returned when a login is already used by another
+// account.
+var ErrLoginTaken = errors.New("login already in use")
+
+// Group is a role managed by a provisioning client (a SCIM group). The
+// members of the group are the users holding the role DisplayName.
+type Group struct {
+	ID          string
+	DisplayName string
+	ExternalID  string
+	CreatedAt   time.Time
+}
+
+// ProvisioningStore covers the account management of provisioning
+// clients: listing, updating and deleting users, and groups.
+type ProvisioningStore interface {
+	// ListUsers returns all users ordered by creation time.
+	ListUsers(ctx context.Context) ([]domain.User, error)
+	// UsersWithRole returns the users holding role.
+	UsersWithRole(ctx context.Context, role string) ([]domain.User, error)
+	// UpdateUserProfile replaces the login, email and external id.
+	// Returns ErrLoginTaken, ErrEmailTaken or ErrNotFound.
+	UpdateUserProfile(ctx context.Context, userID, login, email, externalID string) error
+	// DeleteUser removes the user and everything that belongs to it.
+	// Returns ErrNotFound if there is none.
+	DeleteUser(ctx context.Context, userID string) error
+
+	// GetGroup returns the group; nil if not found.
+	GetGroup(ctx context.Context, id string) (*Group, error)
+	// FindGroupByName returns the group of a role; nil if not found.
+	FindGroupByName(ctx context.Context, displayName string) (*Group, error)
+	// ListGroups returns all groups ordered by creation time.
+	ListGroups(ctx context.Context) ([]Group, error)
+	// SaveGroup creates or updates a group.
+	SaveGroup(ctx context.Context, g *Group) error
+	// DeleteGroup removes a group. Returns ErrNotFound if there is none.
+	DeleteGroup(ctx context.Context, id string) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) ListUsers(ctx context.Context) ([]domain.User, error) {
+	return s.users(func(*domain.User) bool { return true }), nil
+}
+
+func (s *MemStore) UsersWithRole(ctx context.Context, role string) ([]domain.User, error) {
+	return s.users(func(u *domain.User) bool { return u.HasRole(role) }), nil
+}
+
+// users returns the users matching keep ordered by creation time.
+func (s *MemStore) users(keep func(*domain.User) bool) []domain.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]domain.User, 0, len(s.byLogin))
+	for _, u := range s.byLogin {
+		if keep(&u) {
+			out = append(out, u)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+func (s *MemStore) UpdateUserProfile(ctx context.Context, userID, login, email, externalID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldLogin, ok := s.byID[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	if other, ok := s.byLogin[login]; ok && other.ID != userID {
+		return ErrLoginTaken
+	}
+	for e, owner := range s.byEmail {
+		if strings.EqualFold(e, email) && owner != oldLogin {
+			return ErrEmailTaken
+		}
+	}
+	u := s.byLogin[oldLogin]
+	delete(s.byLogin, oldLogin)
+	delete(s.byEmail, u.Email)
+	u.Login, u.Email, u.ExternalID = login, email, externalID
+	s.byLogin[login] = u
+	s.byEmail[email] = login
+	s.byID[userID] = login
+	return nil
+}
+
+func (s *MemStore) DeleteUser(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	login, ok := s.byID[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.byEmail, s.byLogin[login].Email)
+	delete(s.byLogin, login)
+	delete(s.byID, userID)
+	delete(s.totp, userID)
+	delete(s.recoveryCodes, userID)
+	delete(s.emailChanges, userID)
+	for k, sess := range s.sessions {
+		if sess.UserID == userID {
+			delete(s.sessions, k)
+		}
+	}
+	for k, rec := range s.refresh {
+		if rec.UserID == userID {
+			delete(s.refresh, k)
+		}
+	}
+	for k, key := range s.apiKeys {
+		if key.UserID == userID {
+			delete(s.apiKeys, k)
+		}
+	}
+	for k, fi := range s.fedIdentities {
+		if fi.UserID == userID {
+			delete(s.fedIdentities, k)
+		}
+	}
+	for k, acc := range s.dirAccounts {
+		if acc.UserID == userID {
+			delete(s.dirAccounts, k)
+		}
+	}
+	for _, members := range s.groupMembers {
+		delete(members, userID)
+	}
+	s.termsAccepted = slices.DeleteFunc(s.termsAccepted, func(a TermsAcceptance) bool { return a.UserID == userID })
+	for k, inv := range s.invitations {
+		if inv.UserID == userID {
+			inv.UserID = ""
+			s.invitations[k] = inv
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) GetGroup(ctx context.Context, id string) (*Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, ok := s.groups[id]
+	if !ok {
+		return nil, nil
+	}
+	return &g, nil
+}
+
+func (s *MemStore) FindGroupByName(ctx context.Context, displayName string) (*Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, g := range s.groups {
+		if g.DisplayName == displayName {
+			return &g, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemStore) ListGroups(ctx context.Context) ([]Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *MemStore) SaveGroup(ctx context.Context, g *Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = time.Now()
+	}
+	s.groups[g.ID] = *g
+	return nil
+}
+
+func (s *MemStore) DeleteGroup(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.groups[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.groups, id)
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) ListUsers(ctx context.Context) ([]domain.User, error) {
+	return p.queryUsers(ctx, `SELECT `+userColumns+` FROM users WHERE tenant_id = $1 ORDER BY created_at, id`, p.tenant)
+}
+
+func (p *PgStore) UsersWithRole(ctx context.Context, role string) ([]domain.User, error) {
+	return p.queryUsers(ctx, `SELECT `+userColumns+` FROM users WHERE $1 = ANY(roles) AND tenant_id = $2 ORDER BY created_at, id`, role, p.tenant)
+}
+
+func (p *PgStore) queryUsers(ctx context.Context, sql string, args ...any) ([]domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	defer rows.Close()
+	var out []domain.User
+	for rows.Next() {
+		var u domain.User
+		if err := scanUser(rows, &u); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) UpdateUserProfile(ctx context.Context, userID, login, email, externalID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE users SET login = $2, email = $3, external_id = NULLIF($4, '') WHERE id = $1 AND tenant_id = $5`,
+		userID, login, email, externalID, p.tenant)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			if strings.Contains(pgErr.ConstraintName, "login") {
+				return ErrLoginTaken
+			}
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("update user profile: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PgStore) DeleteUser(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	// dependent rows are removed by ON DELETE CASCADE
+	tag, err := p.pool.Exec(ctx, `DELETE FROM users WHERE id = $1 AND tenant_id = $2`, userID, p.tenant)
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// groupColumns is the column list read by scanGroup.
+const groupColumns = `id, display_name, COALESCE(external_id, ''), created_at`
+
+func scanGroup(row pgx.Row, g *Group) error {
+	return row.Scan(&g.ID, &g.DisplayName, &g.ExternalID, &g.CreatedAt)
+}
+
+func (p *PgStore) GetGroup(ctx context.Context, id string) (*Group, error) {
+	return p.findGroup(ctx, `id = $2`, id)
+}
+
+func (p *PgStore) FindGroupByName(ctx context.Context, displayName string) (*Group, error) {
+	return p.findGroup(ctx, `display_name = $2`, displayName)
+}
+
+func (p *PgStore) findGroup(ctx context.Context, where string, arg any) (*Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx, `SELECT `+groupColumns+` FROM provisioned_groups WHERE tenant_id = $1 AND `+where, p.tenant, arg)
+	var g Group
+	if err := scanGroup(row, &g); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find group: %w", err)
+	}
+	return &g, nil
+}
+
+func (p *PgStore) ListGroups(ctx context.Context) ([]Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, `SELECT `+groupColumns+` FROM provisioned_groups WHERE tenant_id = $1 ORDER BY created_at`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list groups: %w", err)
+	}
+	defer rows.Close()
+	var out []Group
+	for rows.Next() {
+		var g Group
+		if err := scanGroup(rows, &g); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) SaveGroup(ctx context.Context, g *Group) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = time.Now()
+	}
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO provisioned_groups (id, display_name, external_id, created_at, tenant_id)
+		 VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+		 ON CONFLICT (id) DO UPDATE
+		    SET display_name = EXCLUDED.display_name, external_id = EXCLUDED.external_id`,
+		g.ID, g.DisplayName, g.ExternalID, g.CreatedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save group: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) DeleteGroup(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx, `DELETE FROM provisioned_groups WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	if err != nil {
+		return fmt.Errorf("delete group: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RecoveryCode is a single-use MFA recovery code. Only the hash produced
+// by password.Hasher is stored.
+type RecoveryCode struct {
+	ID        string
+	UserID    string
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// RecoveryCodeStore persists MFA recovery codes.
+type RecoveryCodeStore interface {
+	// ReplaceRecoveryCodes drops all codes of the user and stores the new set.
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codes []RecoveryCode) error
+	// UnusedRecoveryCodes lists codes that were not used yet.
+	UnusedRecoveryCodes(ctx context.Context, userID string) ([]RecoveryCode, error)
+	// UseRecoveryCode marks the code as used; returns false if it was used
+	// concurrently.
+	UseRecoveryCode(ctx context.Context, userID, id string) (bool, error)
+	// DeleteRecoveryCodes drops all codes of the user.
+	DeleteRecoveryCodes(ctx context.Context, userID string) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []RecoveryCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recoveryCodes[userID] = append([]RecoveryCode(nil), codes...)
+	return nil
+}
+
+func (s *MemStore) UnusedRecoveryCodes(ctx context.Context, userID string) ([]RecoveryCode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []RecoveryCode
+	for _, c := range s.recoveryCodes[userID] {
+		if c.UsedAt == nil {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemStore) UseRecoveryCode(ctx context.Context, userID, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	codes := s.recoveryCodes[userID]
+	for i := range codes {
+		if codes[i].ID == id {
+			if codes[i].UsedAt != nil {
+				return false, nil
+			}
+			now := time.Now()
+			codes[i].UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemStore) DeleteRecoveryCodes(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []RecoveryCode) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1 AND tenant_id = $2`, userID, p.tenant); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, c := range codes {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at, tenant_id) VALUES ($1, $2, $3, $4, $5)`,
+			c.ID, userID, c.CodeHash, c.CreatedAt, p.tenant); err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *PgStore) UnusedRecoveryCodes(ctx context.Context, userID string) ([]RecoveryCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		`SELECT id, user_id, code_hash, created_at, used_at
+		   FROM mfa_recovery_codes
+		  WHERE user_id = $1 AND tenant_id = $2 AND used_at IS NULL`, userID, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list recovery codes: %w", err)
+	}
+	defer rows.Close()
+	var out []RecoveryCode
+	for rows.Next() {
+		var c RecoveryCode
+		if err := rows.Scan(&c.ID, &c.UserID, &c.CodeHash, &c.CreatedAt, &c.UsedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) UseRecoveryCode(ctx context.Context, userID, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`UPDATE mfa_recovery_codes SET used_at = now()
+		  WHERE id = $1 AND user_id = $2 AND tenant_id = $3 AND used_at IS NULL`, id, userID, p.tenant)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (p *PgStore) DeleteRecoveryCodes(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1 AND tenant_id = $2`, userID, p.tenant)
+	return err
+}
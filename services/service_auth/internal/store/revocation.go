@@ -0,0 +1,266 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// jtiDenylistChannel is the Postgres NOTIFY channel used to share access
+// token revocations between service instances.
+const jtiDenylistChannel = "jti_denylist"
+
+// AccessTokenRecord tracks an issued access token so it can be revoked
+// before it expires (on logout, password change or account disable).
+// Tokens of service accounts have a ClientID and no UserID.
+type AccessTokenRecord struct {
+	JTI       string
+	UserID    string
+	SessionID string
+	ClientID  string
+	ExpiresAt time.Time
+}
+
+// RevocationStore persists issued access tokens and the jti denylist.
+type RevocationStore interface {
+	// RecordAccessToken remembers an issued access token.
+	RecordAccessToken(ctx context.Context, rec AccessTokenRecord) error
+	// ActiveAccessTokens lists unexpired tokens of the user; when
+	// sessionID is not empty only tokens of that session are returned.
+	ActiveAccessTokens(ctx context.Context, userID, sessionID string) ([]AccessTokenRecord, error)
+	// ActiveClientAccessTokens lists unexpired tokens issued to the client.
+	ActiveClientAccessTokens(ctx context.Context, clientID string) ([]AccessTokenRecord, error)
+	// DenyJTI adds jti to the denylist until expiresAt.
+	DenyJTI(ctx context.Context, jti string, expiresAt time.Time) error
+	// DeniedJTIs returns unexpired denylist entries.
+	DeniedJTIs(ctx context.Context) (map[string]time.Time, error)
+	// PurgeExpiredTokens drops expired denylist entries and token records.
+	PurgeExpiredTokens(ctx context.Context) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) RecordAccessToken(ctx context.Context, rec AccessTokenRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessTokens[rec.JTI] = rec
+	return nil
+}
+
+func (s *MemStore) ActiveAccessTokens(ctx context.Context, userID, sessionID string) ([]AccessTokenRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	var out []AccessTokenRecord
+	for _, rec := range s.accessTokens {
+		if rec.UserID == "" || rec.UserID != userID || !rec.ExpiresAt.After(now) {
+			continue
+		}
+		if sessionID != "" && rec.SessionID != sessionID {
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func (s *MemStore) ActiveClientAccessTokens(ctx context.Context, clientID string) ([]AccessTokenRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	var out []AccessTokenRecord
+	for _, rec := range s.accessTokens {
+		if rec.ClientID != "" && rec.ClientID == clientID && rec.ExpiresAt.After(now) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemStore) DenyJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deniedJTIs[jti] = expiresAt
+	return nil
+}
+
+func (s *MemStore) DeniedJTIs(ctx context.Context) (map[string]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	out := make(map[string]time.Time, len(s.deniedJTIs))
+	for jti, exp := range s.deniedJTIs {
+		if exp.After(now) {
+			out[jti] = exp
+		}
+	}
+	return out, nil
+}
+
+func (s *MemStore) PurgeExpiredTokens(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for jti, exp := range s.deniedJTIs {
+		if !exp.After(now) {
+			delete(s.deniedJTIs, jti)
+		}
+	}
+	for jti, rec := range s.accessTokens {
+		if !rec.ExpiresAt.After(now) {
+			delete(s.accessTokens, jti)
+		}
+	}
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) RecordAccessToken(ctx context.Context, rec AccessTokenRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO access_tokens (jti, user_id, session_id, client_id, expires_at, tenant_id)
+		 VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), $5, $6)`,
+		rec.JTI, rec.UserID, rec.SessionID, rec.ClientID, rec.ExpiresAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("record access token: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) ActiveAccessTokens(ctx context.Context, userID, sessionID string) ([]AccessTokenRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		`SELECT `+accessTokenColumns+`
+		   FROM access_tokens
+		  WHERE user_id = $1 AND tenant_id = $3 AND expires_at > now() AND ($2 = '' OR session_id = $2)`, userID, sessionID, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list access tokens: %w", err)
+	}
+	return scanAccessTokens(rows)
+}
+
+func (p *PgStore) ActiveClientAccessTokens(ctx context.Context, clientID string) ([]AccessTokenRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		`SELECT `+accessTokenColumns+`
+		   FROM access_tokens
+		  WHERE client_id = $1 AND tenant_id = $2 AND expires_at > now()`, clientID, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list client access tokens: %w", err)
+	}
+	return scanAccessTokens(rows)
+}
+
+// accessTokenColumns is the column list read by scanAccessTokens.
+const accessTokenColumns = `jti, COALESCE(user_id, ''), COALESCE(session_id, ''), COALESCE(client_id, ''), expires_at`
+
+func scanAccessTokens(rows pgx.Rows) ([]AccessTokenRecord, error) {
+	defer rows.Close()
+	var out []AccessTokenRecord
+	for rows.Next() {
+		var rec AccessTokenRecord
+		if err := rows.Scan(&rec.JTI, &rec.UserID, &rec.SessionID, &rec.ClientID, &rec.ExpiresAt); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// DenyJTI persists the entry and notifies other instances listening on
+// jtiDenylistChannel (see ListenJTIDenials).
+func (p *PgStore) DenyJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO revoked_jtis (jti, expires_at, tenant_id) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt, p.tenant); err != nil {
+		return fmt.Errorf("deny jti: %w", err)
+	}
+	payload := p.tenant + "|" + jti + "|" + strconv.FormatInt(expiresAt.Unix(), 10)
+	if _, err := tx.Exec(ctx, `SELECT pg_notify($1, $2)`, jtiDenylistChannel, payload); err != nil {
+		return fmt.Errorf("notify jti: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *PgStore) DeniedJTIs(ctx context.Context) (map[string]time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, `SELECT jti, expires_at FROM revoked_jtis WHERE tenant_id = $1 AND expires_at > now()`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list denied jtis: %w", err)
+	}
+	defer rows.Close()
+	out := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var exp time.Time
+		if err := rows.Scan(&jti, &exp); err != nil {
+			return nil, err
+		}
+		out[jti] = exp
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) PurgeExpiredTokens(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if _, err := p.pool.Exec(ctx, `DELETE FROM revoked_jtis WHERE tenant_id = $1 AND expires_at <= now()`, p.tenant); err != nil {
+		return err
+	}
+	_, err := p.pool.Exec(ctx, `DELETE FROM access_tokens WHERE tenant_id = $1 AND expires_at <= now()`, p.tenant)
+	return err
+}
+
+// ListenJTIDenials blocks until ctx is done, calling fn for every jti of
+// the tenant denied by any instance sharing the database. It holds one pooled
+// connection for the LISTEN.
+func (p *PgStore) ListenJTIDenials(ctx context.Context, fn func(jti string, expiresAt time.Time)) error {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, "LISTEN "+jtiDenylistChannel); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("wait for notification: %w", err)
+		}
+		tenant, rest, _ := strings.Cut(n.Payload, "|")
+		jti, ts, ok := strings.Cut(rest, "|")
+		if !ok || tenant != p.tenant {
+			continue
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			continue
+		}
+		fn(jti, time.Unix(sec, 0))
+	}
+}
@@ -0,0 +1,324 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"auth_project/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrGroupNameTaken is returned when another role group has the same name
+// (compared case-insensitively).
+var ErrGroupNameTaken = errors.New("group name already in use")
+
+// RoleGroupStore keeps role groups and their members.
+type RoleGroupStore interface {
+	// GetRoleGroup returns the group; nil if not found.
+	GetRoleGroup(ctx context.Context, id string) (*domain.RoleGroup, error)
+	// ListRoleGroups returns all groups ordered by creation time.
+	ListRoleGroups(ctx context.Context) ([]domain.RoleGroup, error)
+	// SaveRoleGroup creates or replaces a group. Returns ErrGroupNameTaken.
+	SaveRoleGroup(ctx context.Context, g *domain.RoleGroup) error
+	// DeleteRoleGroup removes a group with its memberships and drops it
+	// from the parents of other groups. Returns ErrNotFound if there is
+	// none.
+	DeleteRoleGroup(ctx context.Context, id string) error
+	// AddRoleGroupMember adds the user to the group; adding a member
+	// twice is not an error.
+	AddRoleGroupMember(ctx context.Context, groupID, userID string) error
+	// RemoveRoleGroupMember removes the user from the group. Returns
+	// ErrNotFound if the user is not a member.
+	RemoveRoleGroupMember(ctx context.Context, groupID, userID string) error
+	// RoleGroupMembers returns the ids of the direct members of the group.
+	RoleGroupMembers(ctx context.Context, groupID string) ([]string, error)
+	// UserRoleGroups returns the ids of the groups the user is a direct
+	// member of.
+	UserRoleGroups(ctx context.Context, userID string) ([]string, error)
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) GetRoleGroup(ctx context.Context, id string) (*domain.RoleGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, ok := s.roleGroups[id]
+	if !ok {
+		return nil, nil
+	}
+	return &g, nil
+}
+
+func (s *MemStore) ListRoleGroups(ctx context.Context) ([]domain.RoleGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]domain.RoleGroup, 0, len(s.roleGroups))
+	for _, g := range s.roleGroups {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+func (s *MemStore) SaveRoleGroup(ctx context.Context, g *domain.RoleGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.roleGroups {
+		if other.ID != g.ID && strings.EqualFold(other.Name, g.Name) {
+			return ErrGroupNameTaken
+		}
+	}
+	if prev, ok := s.roleGroups[g.ID]; ok {
+		g.CreatedAt = prev.CreatedAt
+	} else if g.CreatedAt.IsZero() {
+		g.CreatedAt = time.Now()
+	}
+	stored := *g
+	stored.Roles = slices.Clone(g.Roles)
+	stored.ParentIDs = slices.Clone(g.ParentIDs)
+	s.roleGroups[g.ID] = stored
+	return nil
+}
+
+func (s *MemStore) DeleteRoleGroup(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roleGroups[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.roleGroups, id)
+	delete(s.groupMembers, id)
+	for k, g := range s.roleGroups {
+		if i := slices.Index(g.ParentIDs, id); i >= 0 {
+			g.ParentIDs = slices.Delete(slices.Clone(g.ParentIDs), i, i+1)
+			s.roleGroups[k] = g
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) AddRoleGroupMember(ctx context.Context, groupID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roleGroups[groupID]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.byID[userID]; !ok {
+		return ErrNotFound
+	}
+	if s.groupMembers[groupID] == nil {
+		s.groupMembers[groupID] = make(map[string]bool)
+	}
+	s.groupMembers[groupID][userID] = true
+	return nil
+}
+
+func (s *MemStore) RemoveRoleGroupMember(ctx context.Context, groupID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.groupMembers[groupID][userID] {
+		return ErrNotFound
+	}
+	delete(s.groupMembers[groupID], userID)
+	return nil
+}
+
+func (s *MemStore) RoleGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]string, 0, len(s.groupMembers[groupID]))
+	for userID := range s.groupMembers[groupID] {
+		out = append(out, userID)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *MemStore) UserRoleGroups(ctx context.Context, userID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []string
+	for groupID, members := range s.groupMembers {
+		if members[userID] {
+			out = append(out, groupID)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+// roleGroupColumns is the column list read by scanRoleGroup.
+const roleGroupColumns = `id, name, roles, parent_ids, created_at`
+
+func scanRoleGroup(row pgx.Row, g *domain.RoleGroup) error {
+	return row.Scan(&g.ID, &g.Name, &g.Roles, &g.ParentIDs, &g.CreatedAt)
+}
+
+func (p *PgStore) GetRoleGroup(ctx context.Context, id string) (*domain.RoleGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx, `SELECT `+roleGroupColumns+` FROM role_groups WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	var g domain.RoleGroup
+	if err := scanRoleGroup(row, &g); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get role group: %w", err)
+	}
+	return &g, nil
+}
+
+func (p *PgStore) ListRoleGroups(ctx context.Context) ([]domain.RoleGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, `SELECT `+roleGroupColumns+` FROM role_groups WHERE tenant_id = $1 ORDER BY created_at, id`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list role groups: %w", err)
+	}
+	defer rows.Close()
+	var out []domain.RoleGroup
+	for rows.Next() {
+		var g domain.RoleGroup
+		if err := scanRoleGroup(rows, &g); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) SaveRoleGroup(ctx context.Context, g *domain.RoleGroup) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = time.Now()
+	}
+	err := p.pool.QueryRow(ctx,
+		`INSERT INTO role_groups (id, name, roles, parent_ids, created_at, tenant_id)
+		 VALUES ($1, $2, COALESCE($3::text[], '{}'), COALESCE($4::text[], '{}'), $5, $6)
+		 ON CONFLICT (tenant_id, id) DO UPDATE
+		    SET name = EXCLUDED.name, roles = EXCLUDED.roles, parent_ids = EXCLUDED.parent_ids
+		 RETURNING created_at`,
+		g.ID, g.Name, g.Roles, g.ParentIDs, g.CreatedAt, p.tenant).Scan(&g.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrGroupNameTaken
+		}
+		return fmt.Errorf("save role group: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) DeleteRoleGroup(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("delete role group: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	// memberships are removed by ON DELETE CASCADE
+	tag, err := tx.Exec(ctx, `DELETE FROM role_groups WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	if err != nil {
+		return fmt.Errorf("delete role group: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE role_groups SET parent_ids = array_remove(parent_ids, $1)
+		  WHERE $1 = ANY(parent_ids) AND tenant_id = $2`, id, p.tenant); err != nil {
+		return fmt.Errorf("delete role group: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *PgStore) AddRoleGroupMember(ctx context.Context, groupID, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`INSERT INTO role_group_members (group_id, user_id, tenant_id)
+		 SELECT g.id, u.id, g.tenant_id
+		   FROM role_groups g JOIN users u ON u.tenant_id = g.tenant_id
+		  WHERE g.id = $1 AND u.id = $2 AND g.tenant_id = $3
+		 ON CONFLICT DO NOTHING`,
+		groupID, userID, p.tenant)
+	if err != nil {
+		return fmt.Errorf("add role group member: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		// either already a member, or the group or user does not exist
+		var exists bool
+		err := p.pool.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM role_group_members WHERE group_id = $1 AND user_id = $2 AND tenant_id = $3)`,
+			groupID, userID, p.tenant).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("add role group member: %w", err)
+		}
+		if !exists {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
+
+func (p *PgStore) RemoveRoleGroupMember(ctx context.Context, groupID, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		`DELETE FROM role_group_members WHERE group_id = $1 AND user_id = $2 AND tenant_id = $3`,
+		groupID, userID, p.tenant)
+	if err != nil {
+		return fmt.Errorf("remove role group member: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PgStore) RoleGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	return p.queryIDs(ctx, `SELECT user_id FROM role_group_members WHERE group_id = $1 AND tenant_id = $2 ORDER BY user_id`, groupID)
+}
+
+func (p *PgStore) UserRoleGroups(ctx context.Context, userID string) ([]string, error) {
+	return p.queryIDs(ctx, `SELECT group_id FROM role_group_members WHERE user_id = $1 AND tenant_id = $2 ORDER BY group_id`, userID)
+}
+
+// queryIDs returns the single text column of the rows of sql, whose
+// parameters are id and the tenant.
+func (p *PgStore) queryIDs(ctx context.Context, sql, id string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, sql, id, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list role group members: %w", err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
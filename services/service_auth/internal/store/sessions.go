@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Session is a login on a particular device. Refresh tokens rotated from
+// the same login share its ID (the `sid` claim of access tokens).
+type Session struct {
+	ID         string
+	UserID     string
+	UserAgent  string
+	IP         string
+	DeviceName string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  *time.Time
+}
+
+// SessionStore persists login sessions.
+type SessionStore interface {
+	// CreateSession stores a new session.
+	CreateSession(ctx context.Context, sess Session) error
+	// GetSession returns the session; nil if not found.
+	GetSession(ctx context.Context, id string) (*Session, error)
+	// ListSessions returns the user's sessions that are not revoked, most
+	// recently used first.
+	ListSessions(ctx context.Context, userID string) ([]Session, error)
+	// TouchSession updates last-used time and IP on refresh.
+	TouchSession(ctx context.Context, id, ip string, at time.Time) error
+	// RevokeSession marks the session revoked together with its refresh
+	// tokens.
+	RevokeSession(ctx context.Context, id string) error
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) CreateSession(ctx context.Context, sess Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sess.ID] = &sess
+	return nil
+}
+
+func (s *MemStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *sess
+	return &c, nil
+}
+
+func (s *MemStore) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Session
+	for _, sess := range s.sessions {
+		if sess.UserID == userID && sess.RevokedAt == nil {
+			out = append(out, *sess)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastUsedAt.After(out[j].LastUsedAt) })
+	return out, nil
+}
+
+func (s *MemStore) TouchSession(ctx context.Context, id, ip string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	sess.LastUsedAt = at
+	if ip != "" {
+		sess.IP = ip
+	}
+	return nil
+}
+
+func (s *MemStore) RevokeSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	sess.RevokedAt = &now
+	for _, rec := range s.refresh {
+		if rec.SessionID == id {
+			rec.Revoked = true
+		}
+	}
+	return nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+func (p *PgStore) CreateSession(ctx context.Context, sess Session) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO sessions (id, user_id, user_agent, ip, device_name, created_at, last_used_at, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		sess.ID, sess.UserID, sess.UserAgent, sess.IP, sess.DeviceName, sess.CreatedAt, sess.LastUsedAt, p.tenant)
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	row := p.pool.QueryRow(ctx,
+		`SELECT id, user_id, user_agent, ip, device_name, created_at, last_used_at, revoked_at
+		   FROM sessions WHERE id = $1 AND tenant_id = $2`, id, p.tenant)
+	var sess Session
+	if err := row.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IP, &sess.DeviceName, &sess.CreatedAt, &sess.LastUsedAt, &sess.RevokedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get session: %w", err)
+	}
+	return &sess, nil
+}
+
+func (p *PgStore) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		`SELECT id, user_id, user_agent, ip, device_name, created_at, last_used_at, revoked_at
+		   FROM sessions
+		  WHERE user_id = $1 AND tenant_id = $2 AND revoked_at IS NULL
+		  ORDER BY last_used_at DESC`, userID, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+	var out []Session
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IP, &sess.DeviceName, &sess.CreatedAt, &sess.LastUsedAt, &sess.RevokedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, sess)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) TouchSession(ctx context.Context, id, ip string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`UPDATE sessions SET last_used_at = $2, ip = COALESCE(NULLIF($3, ''), ip) WHERE id = $1 AND tenant_id = $4`, id, at, ip, p.tenant)
+	return err
+}
+
+func (p *PgStore) RevokeSession(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = now() WHERE id = $1 AND tenant_id = $2 AND revoked_at IS NULL`, id, p.tenant)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE session_id = $1 AND tenant_id = $2`, id, p.tenant); err != nil {
+		return fmt.Errorf("revoke session tokens: %w", err)
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,35 @@
+package store
+
+// DefaultTenant is the tenant of single-tenant deployments; rows created
+// before tenants existed belong to it.
+const DefaultTenant = "default"
+
+// TenantStores is implemented by stores that keep the data of several
+// tenants apart. ForTenant returns a UserStore whose methods see and
+// change the data of that tenant only.
+type TenantStores interface {
+	ForTenant(id string) UserStore
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+// ForTenant returns the store of the tenant, creating it on first use.
+// Tenants of a MemStore share nothing; the default tenant is s itself.
+func (s *MemStore) ForTenant(id string) UserStore {
+	if id == DefaultTenant {
+		return s
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tenants == nil {
+		s.tenants = make(map[string]*MemStore)
+	}
+	t, ok := s.tenants[id]
+	if !ok {
+		t = NewMemStore()
+		s.tenants[id] = t
+	}
+	return t
+}
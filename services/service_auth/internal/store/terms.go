@@ -0,0 +1,268 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TermsVersion is a published version of the terms of service. Versions
+// are never changed; users have to accept a version at least as new as the
+// latest mandatory one.
+type TermsVersion struct {
+	Version int
+	Title   string
+	Content string
+	// URL optionally points to the published document.
+	URL         string
+	Mandatory   bool
+	PublishedBy string
+	CreatedAt   time.Time
+}
+
+// TermsAcceptance records that a user accepted a terms version.
+type TermsAcceptance struct {
+	UserID     string
+	Version    int
+	AcceptedAt time.Time
+	IP         string
+	UserAgent  string
+}
+
+// TermsStore keeps the terms of service versions and their acceptances.
+type TermsStore interface {
+	// CreateTermsVersion stores v as the next version and sets v.Version.
+	CreateTermsVersion(ctx context.Context, v *TermsVersion) error
+	// LatestTermsVersion returns the latest version, or the latest
+	// mandatory one; nil if there is none.
+	LatestTermsVersion(ctx context.Context, mandatory bool) (*TermsVersion, error)
+	// GetTermsVersion returns a version; nil if not found.
+	GetTermsVersion(ctx context.Context, version int) (*TermsVersion, error)
+	// ListTermsVersions returns all versions, newest first.
+	ListTermsVersions(ctx context.Context) ([]TermsVersion, error)
+	// SaveTermsAcceptance records an acceptance. Accepting a version again
+	// keeps the first record.
+	SaveTermsAcceptance(ctx context.Context, a TermsAcceptance) error
+	// ListTermsAcceptances returns the acceptances of a user, newest
+	// version first.
+	ListTermsAcceptances(ctx context.Context, userID string) ([]TermsAcceptance, error)
+	// AcceptedTermsVersions returns the highest version each user
+	// accepted, by user id.
+	AcceptedTermsVersions(ctx context.Context) (map[string]int, error)
+}
+
+// =====================
+// In-memory implementation
+// =====================
+
+func (s *MemStore) CreateTermsVersion(ctx context.Context, v *TermsVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v.Version = len(s.terms) + 1
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	s.terms = append(s.terms, *v)
+	return nil
+}
+
+func (s *MemStore) LatestTermsVersion(ctx context.Context, mandatory bool) (*TermsVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.terms) - 1; i >= 0; i-- {
+		if !mandatory || s.terms[i].Mandatory {
+			v := s.terms[i]
+			return &v, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemStore) GetTermsVersion(ctx context.Context, version int) (*TermsVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if version < 1 || version > len(s.terms) {
+		return nil, nil
+	}
+	v := s.terms[version-1]
+	return &v, nil
+}
+
+func (s *MemStore) ListTermsVersions(ctx context.Context) ([]TermsVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := append([]TermsVersion(nil), s.terms...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Version > out[j].Version })
+	return out, nil
+}
+
+func (s *MemStore) SaveTermsAcceptance(ctx context.Context, a TermsAcceptance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, old := range s.termsAccepted {
+		if old.UserID == a.UserID && old.Version == a.Version {
+			return nil
+		}
+	}
+	s.termsAccepted = append(s.termsAccepted, a)
+	return nil
+}
+
+func (s *MemStore) ListTermsAcceptances(ctx context.Context, userID string) ([]TermsAcceptance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []TermsAcceptance
+	for _, a := range s.termsAccepted {
+		if a.UserID == userID {
+			out = append(out, a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version > out[j].Version })
+	return out, nil
+}
+
+func (s *MemStore) AcceptedTermsVersions(ctx context.Context) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]int)
+	for _, a := range s.termsAccepted {
+		out[a.UserID] = max(out[a.UserID], a.Version)
+	}
+	return out, nil
+}
+
+// =====================
+// Postgres implementation
+// =====================
+
+// termsColumns is the column list read by scanTermsVersion.
+const termsColumns = `version, title, content, url, mandatory, published_by, created_at`
+
+func scanTermsVersion(row pgx.Row, v *TermsVersion) error {
+	return row.Scan(&v.Version, &v.Title, &v.Content, &v.URL, &v.Mandatory, &v.PublishedBy, &v.CreatedAt)
+}
+
+func (p *PgStore) CreateTermsVersion(ctx context.Context, v *TermsVersion) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	// concurrent publications race for the same number; the loser
+	// retries with the next one
+	for attempt := 0; ; attempt++ {
+		err := p.pool.QueryRow(ctx,
+			`INSERT INTO terms_versions (tenant_id, version, title, content, url, mandatory, published_by, created_at)
+			 SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6, $7 FROM terms_versions WHERE tenant_id = $1
+			 RETURNING version`,
+			p.tenant, v.Title, v.Content, v.URL, v.Mandatory, v.PublishedBy, v.CreatedAt).Scan(&v.Version)
+		if err == nil {
+			return nil
+		}
+		if !isUniqueViolation(err) || attempt == 2 {
+			return fmt.Errorf("create terms version: %w", err)
+		}
+	}
+}
+
+func (p *PgStore) LatestTermsVersion(ctx context.Context, mandatory bool) (*TermsVersion, error) {
+	return p.findTermsVersion(ctx,
+		`SELECT `+termsColumns+` FROM terms_versions WHERE tenant_id = $1 AND (mandatory OR NOT $2)
+		 ORDER BY version DESC LIMIT 1`, p.tenant, mandatory)
+}
+
+func (p *PgStore) GetTermsVersion(ctx context.Context, version int) (*TermsVersion, error) {
+	return p.findTermsVersion(ctx, `SELECT `+termsColumns+` FROM terms_versions WHERE tenant_id = $1 AND version = $2`, p.tenant, version)
+}
+
+func (p *PgStore) findTermsVersion(ctx context.Context, sql string, args ...any) (*TermsVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var v TermsVersion
+	if err := scanTermsVersion(p.pool.QueryRow(ctx, sql, args...), &v); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find terms version: %w", err)
+	}
+	return &v, nil
+}
+
+func (p *PgStore) ListTermsVersions(ctx context.Context) ([]TermsVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, `SELECT `+termsColumns+` FROM terms_versions WHERE tenant_id = $1 ORDER BY version DESC`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list terms versions: %w", err)
+	}
+	defer rows.Close()
+	var out []TermsVersion
+	for rows.Next() {
+		var v TermsVersion
+		if err := scanTermsVersion(rows, &v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) SaveTermsAcceptance(ctx context.Context, a TermsAcceptance) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO terms_acceptances (user_id, version, accepted_at, ip, user_agent, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (tenant_id, user_id, version) DO NOTHING`,
+		a.UserID, a.Version, a.AcceptedAt, a.IP, a.UserAgent, p.tenant)
+	if err != nil {
+		return fmt.Errorf("save terms acceptance: %w", err)
+	}
+	return nil
+}
+
+func (p *PgStore) ListTermsAcceptances(ctx context.Context, userID string) ([]TermsAcceptance, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		`SELECT user_id, version, accepted_at, ip, user_agent FROM terms_acceptances
+		 WHERE user_id = $1 AND tenant_id = $2 ORDER BY version DESC`, userID, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list terms acceptances: %w", err)
+	}
+	defer rows.Close()
+	var out []TermsAcceptance
+	for rows.Next() {
+		var a TermsAcceptance
+		if err := rows.Scan(&a.UserID, &a.Version, &a.AcceptedAt, &a.IP, &a.UserAgent); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (p *PgStore) AcceptedTermsVersions(ctx context.Context) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		`SELECT user_id, MAX(version) FROM terms_acceptances WHERE tenant_id = $1 GROUP BY user_id`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("accepted terms versions: %w", err)
+	}
+	defer rows.Close()
+	out := make(map[string]int)
+	for rows.Next() {
+		var userID string
+		var version int
+		if err := rows.Scan(&userID, &version); err != nil {
+			return nil, err
+		}
+		out[userID] = version
+	}
+	return out, rows.Err()
+}
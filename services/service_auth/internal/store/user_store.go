@@ -11,12 +11,28 @@ import (
 
 var ErrNotFound = errors.New("not found")
 
+// ErrEmailTaken is returned when an email is already used by another
+// account (compared case-insensitively, see uniq_users_tenant_email_lower).
+var ErrEmailTaken = errors.New("email already in use")
+
 // RefreshRecord stores information about a refresh token's validity and
 // owner. This facilitates token rotation and revocation【401677182695602†screenshot】.
 type RefreshRecord struct {
 	UserID    string
 	ExpiresAt time.Time
 	Revoked   bool
+	// AMR lists the authentication methods of the original login so that
+	// refreshed access tokens keep the same `amr` claim.
+	AMR []string
+	// SessionID links the token to the login session it was rotated from.
+	SessionID string
+	// ClientID and Scope are set for tokens issued to an OAuth client; such
+	// tokens can only be refreshed by the same client.
+	ClientID string
+	Scope    string
+	// JKT binds the token to a DPoP key (RFC 9449); refreshing it requires
+	// a proof signed with that key.
+	JKT string
 }
 
 // UserStore defines an abstraction over persistent storage for users and
@@ -30,12 +46,34 @@ type UserStore interface {
 	// FindByEmail fetches a user by email; returns nil if not found.
 	FindByLogin(ctx context.Context, login string) (domain.User, error) // <-- добавить
 	FindByEmail(ctx context.Context, email string) (*domain.User, error)
+	// FindByID fetches a user by ID; returns nil if not found.
+	FindByID(ctx context.Context, id string) (*domain.User, error)
 	// SaveRefreshToken persists a refresh token record.
 	SaveRefreshToken(ctx context.Context, token string, rec RefreshRecord) error
 	// GetRefreshToken returns the refresh token record if present.
 	GetRefreshToken(ctx context.Context, token string) (*RefreshRecord, error)
 	// RevokeRefreshToken marks a refresh token as revoked.
 	RevokeRefreshToken(ctx context.Context, token string) error
+
+	EmailChangeStore
+	MFAStore
+	RecoveryCodeStore
+	PasswordlessStore
+	SessionStore
+	AccountStore
+	RevocationStore
+	ClientStore
+	AuthorizationCodeStore
+	APIKeyStore
+	DeviceAuthorizationStore
+	FederationStore
+	DirectoryAccountStore
+	ProvisioningStore
+	OrgLinkStore
+	RoleGroupStore
+	PolicyStore
+	InvitationStore
+	TermsStore
 }
 
 // =====================
@@ -48,14 +86,62 @@ type MemStore struct {
 	mu      sync.RWMutex
 	byLogin map[string]domain.User    // login -> user (значение)
 	byEmail map[string]string         // email -> login
+	byID    map[string]string         // id -> login
 	refresh map[string]*RefreshRecord // refreshID -> запись
+
+	emailChanges  map[string]EmailChangeRecord // userID -> pending change
+	totp          map[string]TOTPRecord        // userID -> enrollment
+	mfaChallenges map[string]*MFAChallenge     // challenge hash -> challenge
+	recoveryCodes map[string][]RecoveryCode    // userID -> codes
+	passwordless  map[string]*PasswordlessChallenge
+	sessions      map[string]*Session
+	accessTokens  map[string]AccessTokenRecord // jti -> record
+	deniedJTIs    map[string]time.Time         // jti -> expiry
+	clients       map[string]domain.Client
+	authCodes     map[string]AuthorizationCode // code hash -> code
+	apiKeys       map[string]*APIKey
+	devices       map[string]*DeviceAuthorization // device code hash -> authorization
+	idps          map[string]domain.IdentityProvider
+	fedIdentities map[string]domain.FederatedIdentity // provider id + "\x00" + subject
+	fedStates     map[string]FederationState
+	dirAccounts   map[string]DirectoryAccount // domain + "\x00" + username
+	groups        map[string]Group
+	roleGroups    map[string]domain.RoleGroup
+	groupMembers  map[string]map[string]bool // role group id -> member user ids
+	policies      []PolicyVersion            // version n at index n-1
+	invitations   map[string]Invitation      // id -> invitation
+	terms         []TermsVersion             // version n at index n-1
+	termsAccepted []TermsAcceptance          // in order of acceptance
+	tenants       map[string]*MemStore       // tenant id -> store, see ForTenant
 }
 
 func NewMemStore() *MemStore {
 	return &MemStore{
 		byLogin: make(map[string]domain.User),
 		byEmail: make(map[string]string),
+		byID:    make(map[string]string),
 		refresh: make(map[string]*RefreshRecord),
+
+		emailChanges:  make(map[string]EmailChangeRecord),
+		totp:          make(map[string]TOTPRecord),
+		mfaChallenges: make(map[string]*MFAChallenge),
+		recoveryCodes: make(map[string][]RecoveryCode),
+		passwordless:  make(map[string]*PasswordlessChallenge),
+		sessions:      make(map[string]*Session),
+		accessTokens:  make(map[string]AccessTokenRecord),
+		deniedJTIs:    make(map[string]time.Time),
+		clients:       make(map[string]domain.Client),
+		authCodes:     make(map[string]AuthorizationCode),
+		apiKeys:       make(map[string]*APIKey),
+		devices:       make(map[string]*DeviceAuthorization),
+		idps:          make(map[string]domain.IdentityProvider),
+		fedIdentities: make(map[string]domain.FederatedIdentity),
+		fedStates:     make(map[string]FederationState),
+		dirAccounts:   make(map[string]DirectoryAccount),
+		groups:        make(map[string]Group),
+		roleGroups:    make(map[string]domain.RoleGroup),
+		groupMembers:  make(map[string]map[string]bool),
+		invitations:   make(map[string]Invitation),
 	}
 }
 
@@ -75,6 +161,7 @@ func (s *MemStore) CreateUser(ctx context.Context, u *domain.User) error {
 	// сохраняем копию
 	s.byLogin[u.Login] = *u
 	s.byEmail[u.Email] = u.Login
+	s.byID[u.ID] = u.Login
 	return nil
 }
 
@@ -100,6 +187,18 @@ func (s *MemStore) FindByEmail(ctx context.Context, email string) (*domain.User,
 	return &uu, nil
 }
 
+func (s *MemStore) FindByID(ctx context.Context, id string) (*domain.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	login, ok := s.byID[id]
+	if !ok {
+		return nil, nil
+	}
+	u := s.byLogin[login]
+	return &u, nil
+}
+
 func (s *MemStore) SaveRefreshToken(ctx context.Context, token string, rec RefreshRecord) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters of generated codes. They match the defaults of common
+// authenticator apps (RFC 6238 with HMAC-SHA1).
+const (
+	// Digits is the length of generated codes.
+	Digits = 6
+	// Period is the time step in seconds.
+	Period = 30
+	// Skew is the number of steps accepted before and after the current one
+	// to tolerate clock drift.
+	Skew = 1
+
+	secretLen = 20 // 160 bits, as recommended by RFC 4226
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32-encoded shared secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("totp secret: %w", err)
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// KeyURI builds the otpauth:// URI understood by authenticator apps
+// (usually rendered as a QR code).
+func KeyURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Code computes the code for the given time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", fmt.Errorf("totp secret: %w", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation, RFC 4226 §5.3
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, bin%mod), nil
+}
+
+// Step returns the time step containing t.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Validate checks code against the secret at time t, allowing Skew steps
+// of drift. It returns the matched step so callers can reject replays of
+// an already used code.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for d := int64(-Skew); d <= Skew; d++ {
+		want, err := Code(secret, now+d)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return now + d, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,90 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors, the ASCII
+// string "12345678901234567890".
+var rfcSecret = b32.EncodeToString([]byte("12345678901234567890"))
+
+// TestCodeRFC6238 checks the SHA-1 test vectors of RFC 6238, appendix B.
+// The RFC lists 8-digit codes; ours are their last Digits digits.
+func TestCodeRFC6238(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, v := range vectors {
+		got, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		if want := v.code[len(v.code)-Digits:]; got != want {
+			t.Errorf("Code at %d = %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	want, _ := Code(rfcSecret, 1)
+	got, err := Code(" "+strings.ToLower(rfcSecret)+" ", 1)
+	if err != nil || got != want {
+		t.Fatalf("Code with a lowercase secret = %q, %v; want %q", got, err, want)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Fatal("Code accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		code string
+		step int64
+		ok   bool
+	}{
+		{"current step", code(step), step, true},
+		{"previous step", code(step - 1), step - 1, true},
+		{"next step", code(step + 1), step + 1, true},
+		{"outside the skew", code(step - 2), 0, false},
+		{"surrounding spaces", " " + code(step) + "\n", step, true},
+		{"too short", code(step)[1:], 0, false},
+		{"wrong code", "000000", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.ok || got != tt.step {
+				t.Fatalf("Validate = %d, %v; want %d, %v", got, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	uri := KeyURI("OrgDirectory", "alice@example.com", "ABC")
+	want := "otpauth://totp/OrgDirectory:alice@example.com?algorithm=SHA1&digits=6&issuer=OrgDirectory&period=30&secret=ABC"
+	if uri != want {
+		t.Fatalf("KeyURI = %s, want %s", uri, want)
+	}
+}